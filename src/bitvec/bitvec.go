@@ -1,5 +1,9 @@
 package bitvec
 
+import (
+	"math/bits"
+)
+
 type Bitvec []uint64
 
 func New(size uint32) Bitvec {
@@ -29,3 +33,26 @@ func (bv Bitvec) SetBit(pos uint32) {
 func (bv Bitvec) ResetBit(pos uint32) {
 	bv[pos>>6] &= ^(uint64(1) << (pos & uint32(63)))
 }
+
+// sets the bits that are set in other, which must have the same size
+func (bv Bitvec) Or(other Bitvec) {
+	for i := 0; i < len(bv); i++ {
+		bv[i] |= other[i]
+	}
+}
+
+// resets the bits that are not set in other, which must have the same size
+func (bv Bitvec) And(other Bitvec) {
+	for i := 0; i < len(bv); i++ {
+		bv[i] &= other[i]
+	}
+}
+
+// the number of bits that are set
+func (bv Bitvec) Count() uint32 {
+	n := 0
+	for i := 0; i < len(bv); i++ {
+		n += bits.OnesCount64(bv[i])
+	}
+	return uint32(n)
+}
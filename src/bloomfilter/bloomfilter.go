@@ -2,9 +2,12 @@ package bloomfilter
 
 import (
 	"bitvec"
+	"errors"
 	"math"
 )
 
+var ErrIncompatible = errors.New("bloomfilter: filters of different sizes or numbers of hashes")
+
 const (
 	k2 = 0x9ae16a3b2f90404f
 )
@@ -97,3 +100,68 @@ func (bf Bloomfilter) CheckUint64(item uint64) bool {
 	}
 	return true
 }
+
+// FNV-1a, to fold a byte string into a uint64 before it is mixed by CityHash64
+func hashString(s string) uint64 {
+	var h uint64 = 14695981039346656037
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (bf Bloomfilter) AddString(item string) {
+	bf.AddUint64(hashString(item))
+}
+
+func (bf Bloomfilter) CheckString(item string) bool {
+	return bf.CheckUint64(hashString(item))
+}
+
+// Adds the items of other, so that the filter holds the union of the two
+// sets. The filters must have the same size and number of hashes.
+func (bf Bloomfilter) Union(other *Bloomfilter) error {
+	if !bf.compatible(other) {
+		return ErrIncompatible
+	}
+	bf.bv.Or(other.bv)
+	return nil
+}
+
+// Keeps only the bits that are set in other too. The result holds every item
+// of the intersection of the two sets, but has more false positives than a
+// filter built from the intersection.
+func (bf Bloomfilter) Intersect(other *Bloomfilter) error {
+	if !bf.compatible(other) {
+		return ErrIncompatible
+	}
+	bf.bv.And(other.bv)
+	return nil
+}
+
+func (bf Bloomfilter) compatible(other *Bloomfilter) bool {
+	return other != nil && bf.m == other.m && bf.k == other.k
+}
+
+// The probability that an item that was never added is found, estimated
+// from the fraction of bits that are set.
+func (bf Bloomfilter) EstimatedFPRate() float64 {
+	return math.Pow(float64(bf.bv.Count())/float64(bf.m), float64(bf.k))
+}
+
+// the number of distinct items added, estimated from the bits that are set
+func (bf Bloomfilter) EstimatedCount() float64 {
+	set := float64(bf.bv.Count())
+	if set >= float64(bf.m) {
+		return math.Inf(1)
+	}
+	return -float64(bf.m) / float64(bf.k) * math.Log(1-set/float64(bf.m))
+}
+
+// the expected false positive rate of a filter of 2^pt bits with k hashes,
+// once n items have been added
+func ExpectedFPRate(pt uint32, k uint32, n uint64) float64 {
+	m := math.Pow(2, float64(pt))
+	return math.Pow(1-math.Exp(-float64(k)*float64(n)/m), float64(k))
+}
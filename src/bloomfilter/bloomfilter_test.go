@@ -1,6 +1,7 @@
 package bloomfilter
 
 import (
+	"bytes"
 	"math"
 	"testing"
 )
@@ -46,3 +47,92 @@ func TestCorrect(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestCounting(t *testing.T) {
+	cbf := NewCountingPowTwo(16, 4)
+	for i := uint64(0); i < 1000; i++ {
+		cbf.AddUint64(i)
+	}
+	for i := uint64(0); i < 500; i++ {
+		cbf.RemoveUint64(i)
+	}
+	for i := uint64(500); i < 1000; i++ {
+		if !cbf.CheckUint64(i) {
+			t.Fatalf("item %d lost after other items were removed", i)
+		}
+	}
+	found := 0
+	for i := uint64(0); i < 500; i++ {
+		if cbf.CheckUint64(i) {
+			found++
+		}
+	}
+	if found > 50 {
+		t.Fatalf("%d of 500 removed items still found", found)
+	}
+
+	bf := cbf.Filter()
+	if !bf.CheckUint64(700) || bf.EstimatedCount() < 400 || bf.EstimatedCount() > 600 {
+		t.Fatalf("wrong plain filter, estimated to hold %f items", bf.EstimatedCount())
+	}
+}
+
+func TestUnionIntersect(t *testing.T) {
+	a := NewPowTwo(16, 4)
+	b := NewPowTwo(16, 4)
+	a.AddString("x")
+	a.AddString("both")
+	b.AddString("y")
+	b.AddString("both")
+
+	u := NewPowTwo(16, 4)
+	u.Union(a)
+	u.Union(b)
+	if !u.CheckString("x") || !u.CheckString("y") || !u.CheckString("both") {
+		t.Fatal("items missing from the union")
+	}
+	a.Intersect(b)
+	if !a.CheckString("both") || a.CheckString("x") {
+		t.Fatal("wrong intersection")
+	}
+	if a.Union(NewPowTwo(15, 4)) != ErrIncompatible {
+		t.Fatal("filters of different sizes merged")
+	}
+
+	if rate := u.EstimatedFPRate(); rate <= 0 || rate > ExpectedFPRate(16, 4, 10) {
+		t.Fatalf("estimated FP rate %f", rate)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	bf := NewPowTwo(10, 4)
+	bf.AddString("key")
+	var buf bytes.Buffer
+	bf.Marshal(&buf)
+	received := bf.New().(*Bloomfilter)
+	if err := received.Unmarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !received.CheckString("key") || received.m != bf.m || received.k != bf.k {
+		t.Fatal("filter changed on the wire")
+	}
+
+	cbf := NewCountingPowTwo(10, 4)
+	cbf.AddString("key")
+	cbf.AddString("key")
+	cbf.Marshal(&buf)
+	rcbf := cbf.New().(*CountingBloomfilter)
+	if err := rcbf.Unmarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rcbf.RemoveString("key")
+	if !rcbf.CheckString("key") {
+		t.Fatal("counts lost on the wire")
+	}
+
+	buf.Reset()
+	buf.Write([]byte{40, 0, 0, 0, 4, 0, 0, 0})
+	if err := received.Unmarshal(&buf); err == nil {
+		t.Fatal("oversized filter accepted")
+	}
+}
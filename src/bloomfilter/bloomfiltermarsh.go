@@ -0,0 +1,85 @@
+package bloomfilter
+
+import (
+	"encoding/binary"
+	"fastrpc"
+	"fmt"
+	"io"
+)
+
+// filters larger than 2^MAX_POWTWO bits are refused on the wire
+const MAX_POWTWO = 30
+
+const MAX_HASHES = 6 // the number of hash functions hashX derives
+
+// A filter is sent as the log of its size and its number of hashes, followed
+// by its bits (or counters).
+func (bf *Bloomfilter) Marshal(w io.Writer) {
+	var b [8]byte
+	bs := b[:8]
+	binary.LittleEndian.PutUint32(bs, bf.powtwo)
+	binary.LittleEndian.PutUint32(bs[4:], bf.k)
+	w.Write(bs)
+	for _, word := range bf.bv {
+		binary.LittleEndian.PutUint64(bs, word)
+		w.Write(bs)
+	}
+}
+
+func (bf *Bloomfilter) Unmarshal(r io.Reader) error {
+	pt, k, err := unmarshalHeader(r)
+	if err != nil {
+		return err
+	}
+	*bf = *NewPowTwo(pt, k)
+	var b [8]byte
+	bs := b[:8]
+	for i := range bf.bv {
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return err
+		}
+		bf.bv[i] = binary.LittleEndian.Uint64(bs)
+	}
+	return nil
+}
+
+func (bf *Bloomfilter) New() fastrpc.Serializable {
+	return new(Bloomfilter)
+}
+
+func (cbf *CountingBloomfilter) Marshal(w io.Writer) {
+	var b [8]byte
+	bs := b[:8]
+	binary.LittleEndian.PutUint32(bs, cbf.powtwo)
+	binary.LittleEndian.PutUint32(bs[4:], cbf.k)
+	w.Write(bs)
+	w.Write(cbf.counts)
+}
+
+func (cbf *CountingBloomfilter) Unmarshal(r io.Reader) error {
+	pt, k, err := unmarshalHeader(r)
+	if err != nil {
+		return err
+	}
+	*cbf = *NewCountingPowTwo(pt, k)
+	_, err = io.ReadFull(r, cbf.counts)
+	return err
+}
+
+func (cbf *CountingBloomfilter) New() fastrpc.Serializable {
+	return new(CountingBloomfilter)
+}
+
+func unmarshalHeader(r io.Reader) (uint32, uint32, error) {
+	var b [8]byte
+	bs := b[:8]
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return 0, 0, err
+	}
+	pt := binary.LittleEndian.Uint32(bs)
+	k := binary.LittleEndian.Uint32(bs[4:])
+	if pt > MAX_POWTWO || k > MAX_HASHES {
+		return 0, 0, fmt.Errorf("bloomfilter: bad filter of 2^%d bits and %d hashes", pt, k)
+	}
+	return pt, k, nil
+}
@@ -0,0 +1,85 @@
+package bloomfilter
+
+import (
+	"math"
+)
+
+const MAX_COUNT = math.MaxUint8
+
+// A Bloom filter that supports deletion: every position holds a counter
+// rather than a bit. A counter that reaches MAX_COUNT sticks there, since
+// decrementing it could then make an item that is still in the set vanish.
+// Removing an item that was never added breaks the filter.
+type CountingBloomfilter struct {
+	counts []uint8
+	m      uint32
+	k      uint32
+	powtwo uint32
+	mask   uint32
+}
+
+func NewCountingPowTwo(pt uint32, k uint32) *CountingBloomfilter {
+	m := uint32(math.Pow(2, float64(pt)))
+	return &CountingBloomfilter{make([]uint8, m), m, k, pt, (uint32(1) << pt) - 1}
+}
+
+func (cbf *CountingBloomfilter) AddUint64(item uint64) {
+	h64 := CityHash64(item)
+	l32 := uint32(h64)
+	h32 := uint32(h64 >> 32)
+
+	for i := uint32(0); i < cbf.k; i++ {
+		if pos := hashX(l32, h32, i) & cbf.mask; cbf.counts[pos] < MAX_COUNT {
+			cbf.counts[pos]++
+		}
+	}
+}
+
+func (cbf *CountingBloomfilter) RemoveUint64(item uint64) {
+	h64 := CityHash64(item)
+	l32 := uint32(h64)
+	h32 := uint32(h64 >> 32)
+
+	for i := uint32(0); i < cbf.k; i++ {
+		if pos := hashX(l32, h32, i) & cbf.mask; cbf.counts[pos] > 0 && cbf.counts[pos] < MAX_COUNT {
+			cbf.counts[pos]--
+		}
+	}
+}
+
+func (cbf *CountingBloomfilter) CheckUint64(item uint64) bool {
+	h64 := CityHash64(item)
+	l32 := uint32(h64)
+	h32 := uint32(h64 >> 32)
+
+	for i := uint32(0); i < cbf.k; i++ {
+		if cbf.counts[hashX(l32, h32, i)&cbf.mask] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (cbf *CountingBloomfilter) AddString(item string) {
+	cbf.AddUint64(hashString(item))
+}
+
+func (cbf *CountingBloomfilter) RemoveString(item string) {
+	cbf.RemoveUint64(hashString(item))
+}
+
+func (cbf *CountingBloomfilter) CheckString(item string) bool {
+	return cbf.CheckUint64(hashString(item))
+}
+
+// A plain filter of the same size holding the same items, e.g. to ship it to
+// another replica.
+func (cbf *CountingBloomfilter) Filter() *Bloomfilter {
+	bf := NewPowTwo(cbf.powtwo, cbf.k)
+	for pos, c := range cbf.counts {
+		if c > 0 {
+			bf.bv.SetBit(uint32(pos))
+		}
+	}
+	return bf
+}
@@ -153,8 +153,8 @@ func main() {
 		for i := 0; i < n+*eps; i++ {
 			dlog.Printf("Sending proposal %d\n", id)
 			args.ClientId = id
-			args.Command.K = state.Key(karray[i])
-			args.Command.V = state.Value(time.Now().UnixNano())
+			args.Command.K = state.IntKey(karray[i])
+			args.Command.V = state.IntValue(time.Now().UnixNano())
 			if !*fast {
 				if *noLeader {
 					leader = rarray[i]
@@ -12,14 +12,13 @@ import (
 	"masterproto"
 	"math/rand"
 	"net"
-	"net/rpc"
 	"runtime"
 	"state"
 	"strconv"
 	"time"
 )
 
-var masterAddr *string = flag.String("maddr", "", "Master address. Defaults to localhost")
+var masterAddr *string = flag.String("maddr", "", "Comma-separated master addresses (addr, or addr:port for another port than -mport), tried in turn. Defaults to localhost.")
 var masterPort *int = flag.Int("mport", 7087, "Master port.  Defaults to 7077.")
 var reqsNb *int = flag.Int("q", 5000, "Total number of requests. Defaults to 5000.")
 var writes *int = flag.Int("w", 100, "Percentage of updates (writes). Defaults to 100%.")
@@ -30,6 +29,8 @@ var procs *int = flag.Int("p", 2, "GOMAXPROCS. Defaults to 2")
 var check = flag.Bool("check", false, "Check that every expected reply was received exactly once.")
 var eps *int = flag.Int("eps", 0, "Send eps more messages per round than the client will wait for (to discount stragglers). Defaults to 0.")
 var conflicts *int = flag.Int("c", 0, "Percentage of conflicts. Defaults to 0%")
+var scans *int = flag.Int("scans", 0, "Percentage of reads that are range scans. Defaults to 0%")
+var scanLen *int = flag.Int("scanlen", 100, "Number of keys in the range read by a scan. Defaults to 100")
 var s = flag.Float64("s", 2, "Zipfian s parameter")
 var v = flag.Float64("v", 1, "Zipfian v parameter")
 
@@ -62,14 +63,14 @@ func main() {
 	if *conflicts > 100 {
 		log.Fatalf("Conflicts percentage must be between 0 and 100.\n")
 	}
-
-	master, err := rpc.DialHTTP("tcp", fmt.Sprintf("%s:%d", *masterAddr, *masterPort))
-	if err != nil {
-		log.Fatalf("Error connecting to master\n")
+	if *scans < 0 || *scans > 100 {
+		log.Fatalf("Scans percentage must be between 0 and 100.\n")
 	}
 
+	master := masterproto.NewClient(*masterAddr, *masterPort)
+
 	rlReply := new(masterproto.GetReplicaListReply)
-	err = master.Call("Master.GetReplicaList", new(masterproto.GetReplicaListArgs), rlReply)
+	err := master.Call("Master.GetReplicaList", new(masterproto.GetReplicaListArgs), rlReply)
 	if err != nil {
 		log.Fatalf("Error making the GetReplicaList RPC")
 	}
@@ -87,6 +88,8 @@ func main() {
 	karray := make([]int64, *reqsNb / *rounds + *eps)
 	// Boolean array indicating if a request is a PUT request or not
 	put := make([]bool, *reqsNb / *rounds + *eps)
+	// Boolean array indicating if a read is a SCAN starting at the key
+	scan := make([]bool, *reqsNb / *rounds + *eps)
 	// Number of requests sent to the corresponding replicas
 	perReplicaCount := make([]int, N)
 	test := make([]int, *reqsNb / *rounds + *eps)
@@ -111,6 +114,7 @@ func main() {
 				put[i] = true
 			} else {
 				put[i] = false
+				scan[i] = rand.Intn(100) < *scans
 			}
 		} else {
 			karray[i] = int64(zipf.Uint64())
@@ -148,7 +152,7 @@ func main() {
 
 	var id int32 = 0
 	done := make(chan bool, N)
-	args := genericsmrproto.Propose{id, state.Command{0, state.PUT, 0, 0}, 0}
+	args := genericsmrproto.Propose{id, state.Command{0, state.NewClientId(), state.PUT, "", "", "", nil, 0}, 0}
 
 	before_total := time.Now()
 
@@ -159,6 +163,8 @@ func main() {
 	for j := 0; j < *rounds; j++ {
 
 		n := *reqsNb / *rounds
+		// the commands of the previous rounds have been answered or given up on
+		args.Command.Acked = id
 
 		if *check {
 			rsp = make([]bool, n)
@@ -180,13 +186,18 @@ func main() {
 		for i := 0; i < n+*eps; i++ {
 			dlog.Printf("Sending proposal %d\n", id)
 			args.CommandId = id
+			args.Command.K = state.IntKey(karray[i])
+			args.Command.V = state.IntValue(int64(i))
+			args.Command.Keys = nil
 			if put[i] {
 				args.Command.Op = state.PUT
+			} else if scan[i] {
+				args.Command.Op = state.SCAN
+				args.Command.V = state.NIL
+				args.Command.Keys = []state.Key{state.IntKey(karray[i] + int64(*scanLen))}
 			} else {
 				args.Command.Op = state.GET
 			}
-			args.Command.K = state.Key(karray[i])
-			args.Command.V = state.Value(i)
 			args.Command.CommandId = id
 			//args.Timestamp = time.Now().UnixNano()
 			if !*fast {
@@ -9,13 +9,12 @@ import (
 	"masterproto"
 	"math/rand"
 	"net"
-	"net/rpc"
 	"runtime"
 	"state"
 	"time"
 )
 
-var masterAddr *string = flag.String("maddr", "", "Master address. Defaults to localhost")
+var masterAddr *string = flag.String("maddr", "", "Comma-separated master addresses (addr, or addr:port for another port than -mport), tried in turn. Defaults to localhost.")
 var masterPort *int = flag.Int("mport", 7087, "Master port.  Defaults to 7087.")
 var reqsNb *int = flag.Int("q", 5000, "Total number of requests. Defaults to 5000.")
 var noLeader *bool = flag.Bool("e", false, "Egalitarian (no leader). Defaults to false.")
@@ -24,7 +23,7 @@ var check = flag.Bool("check", false, "Check that every expected reply was recei
 var conflicts *int = flag.Int("c", -1, "Percentage of conflicts. Defaults to 0%")
 var writes *int = flag.Int("w", 100, "Percentage of commands that are writes. Defaults to 100%")
 var fastReads *int = flag.Int("fr", 0, "Percentage of reads that are fast reads. Defaults to 0%")
-var app *int = flag.Int("app", 0, "Application state: 0->Default, 1->Inventory, 2->Facebook")
+var app = flag.String("app", "default", "Application state machine of the replicas: default, inventory or facebook.")
 var s = flag.Float64("s", 2, "Zipfian s parameter")
 var v = flag.Float64("v", 1, "Zipfian v parameter")
 var barOne = flag.Bool("barOne", false, "Sent commands to all replicas except the last one.")
@@ -45,13 +44,10 @@ func main() {
 		log.Fatalf("Conflicts percentage must be between 0 and 100.\n")
 	}
 
-	master, err := rpc.DialHTTP("tcp", fmt.Sprintf("%s:%d", *masterAddr, *masterPort))
-	if err != nil {
-		log.Fatalf("Error connecting to master\n")
-	}
+	master := masterproto.NewClient(*masterAddr, *masterPort)
 
 	rlReply := new(masterproto.GetReplicaListReply)
-	err = master.Call("Master.GetReplicaList", new(masterproto.GetReplicaListArgs), rlReply)
+	err := master.Call("Master.GetReplicaList", new(masterproto.GetReplicaListArgs), rlReply)
 	if err != nil {
 		log.Fatalf("Error making the GetReplicaList RPC")
 	}
@@ -124,7 +120,7 @@ func simulatedClient(clientId int, rlReply *masterproto.GetReplicaListReply, lea
 	}
 
 	var commandId int32 = 0
-	args := genericsmrproto.Propose{commandId, state.Command{commandId, state.PUT, 0, 0}, 0}
+	args := genericsmrproto.Propose{commandId, state.Command{commandId, state.NewClientId(), state.PUT, "", "", "", nil, 0}, 0}
 	var reply genericsmrproto.ProposeReplyTS
 	n := *reqsNb
 	for i := 0; i < n; i++ {
@@ -133,15 +129,17 @@ func simulatedClient(clientId int, rlReply *masterproto.GetReplicaListReply, lea
 		}
 
 		args.Command.CommandId = commandId + int32(clientId*n)
-		args.Command.K = state.Key(karray[i])
-		if state.Application(*app) == state.INVENTORY {
-			args.Command.V = state.Value(1)
+		// one command at a time, so every earlier one has been answered
+		args.Command.Acked = args.Command.CommandId
+		args.Command.K = state.IntKey(karray[i])
+		if *app == "inventory" {
+			args.Command.V = state.IntValue(1)
 		} else {
-			args.Command.V = state.Value(args.Command.CommandId)
+			args.Command.V = state.IntValue(int64(args.Command.CommandId))
 		}
 
 		switch (*app) {
-			case 0:
+			case "default":
 				r := rand.Intn(100)
 				if r < *writes {
 					args.Command.Op = state.PUT
@@ -150,7 +148,7 @@ func simulatedClient(clientId int, rlReply *masterproto.GetReplicaListReply, lea
 				}
 
 				break
-			case 1:
+			case "inventory":
 				r := rand.Intn(100)
 				if r < *writes {
 					args.Command.Op = state.INCREMENT
@@ -164,7 +162,7 @@ func simulatedClient(clientId int, rlReply *masterproto.GetReplicaListReply, lea
 				}
 
 				break
-			case 2:
+			case "facebook":
 				r := rand.Intn(100)
 				if r < *writes {
 					args.Command.Op = state.LIKE
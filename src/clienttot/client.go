@@ -171,7 +171,7 @@ func main() {
 				}
 			}
 			args.ClientId = id
-			args.Command.K = state.Key(karray[i])
+			args.Command.K = state.IntKey(karray[i])
 			writers[leader].WriteByte(genericsmrproto.PROPOSE)
 			args.Marshal(writers[leader])
 			writers[leader].Flush()
@@ -0,0 +1,196 @@
+package epaxos
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"state"
+)
+
+// Replica 0 proposes a barrier instance every checkpointPeriod commands.
+// Once the barrier and everything it depends on have been executed, the
+// execution thread serializes the application state, together with the
+// exact set of instances whose effects it contains. With a durable log, the
+// snapshot is written to disk and the log is rewritten to hold only the
+// instances that are not covered by the snapshot.
+type checkpoint struct {
+	execedUpTo []int32      // all instances up to these have been executed
+	executed   []instanceId // instances past execedUpTo that have been executed too
+	maxSeq     int32
+	state      []byte // the serialized state.State
+}
+
+// the barriers hold a single CHECKPOINT command (see cpMarker)
+func isCheckpoint(cmds []state.Command) bool {
+	return len(cmds) == 1 && cmds[0].Op == state.CHECKPOINT
+}
+
+func checkpointFileName(id int32) string {
+	return fmt.Sprintf("checkpoint-replica%d", id)
+}
+
+func stableStoreFileName(id int32) string {
+	return fmt.Sprintf("stable-store-replica%d", id)
+}
+
+//called by the execution thread
+func (r *Replica) tryCheckpoint() {
+	for q := 0; q < r.N; q++ {
+		if r.ExecedUpTo[q] < r.pendingCheckpoint[q] {
+			return
+		}
+	}
+	r.pendingCheckpoint = nil
+	r.checkpointChan <- r.takeSnapshot()
+}
+
+func (r *Replica) handleCheckpoint(cp *checkpoint) {
+	log.Printf("Checkpoint taken at %v (%d bytes of state)\n", cp.execedUpTo, len(cp.state))
+	r.latestCheckpoint = cp
+
+	if !r.Durable {
+		return
+	}
+	if err := writeCheckpoint(checkpointFileName(r.Id), cp); err != nil {
+		log.Println("Error writing checkpoint:", err)
+		return
+	}
+	r.truncateStableStore(cp)
+}
+
+// Rewrites the stable store so that it only holds the instances that are not
+// covered by the checkpoint. The instance space always has the latest
+// version of every instance, so one metadata and one commands record per
+// instance is enough to rebuild it.
+func (r *Replica) truncateStableStore(cp *checkpoint) {
+	for q := 0; q < r.N; q++ {
+		if r.InstanceSpace[q].Forgotten(cp.execedUpTo[q] + 1) {
+			// garbage collected in the meantime, try again at the next checkpoint
+			return
+		}
+	}
+
+	name := stableStoreFileName(r.Id)
+	f, err := os.OpenFile(name+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		log.Println("Error truncating stable store:", err)
+		return
+	}
+
+	old := r.StableStore
+	r.StableStore = f
+	for q := int32(0); q < int32(r.N); q++ {
+		for i := cp.execedUpTo[q] + 1; i < r.crtInstance[q]; i++ {
+			if inst := r.InstanceSpace[q].Get(i); inst != nil {
+				r.recordInstanceMetadata(q, i, inst)
+				r.recordCommands(q, i, inst.Cmds)
+			}
+		}
+	}
+	r.sync()
+
+	if err := os.Rename(name+".tmp", name); err != nil {
+		log.Fatal("Cannot replace stable store:", err)
+	}
+	old.Close()
+}
+
+func writeCheckpoint(name string, cp *checkpoint) error {
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	binary.Write(w, binary.LittleEndian, int32(len(cp.execedUpTo)))
+	binary.Write(w, binary.LittleEndian, cp.execedUpTo)
+	binary.Write(w, binary.LittleEndian, cp.maxSeq)
+	binary.Write(w, binary.LittleEndian, int32(len(cp.executed)))
+	for _, id := range cp.executed {
+		binary.Write(w, binary.LittleEndian, []int32{id.replica, id.instance})
+	}
+	binary.Write(w, binary.LittleEndian, int64(len(cp.state)))
+	w.Write(cp.state)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	// the rename makes a new checkpoint replace the old one atomically
+	return os.Rename(name+".tmp", name)
+}
+
+// returns nil if there is no checkpoint
+func readCheckpoint(name string, n int) (*checkpoint, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rd := bufio.NewReader(f)
+
+	var count int32
+	if err := binary.Read(rd, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	if int(count) != n {
+		return nil, fmt.Errorf("checkpoint is for %d replicas, not %d", count, n)
+	}
+	cp := &checkpoint{execedUpTo: make([]int32, n)}
+	if err := binary.Read(rd, binary.LittleEndian, cp.execedUpTo); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(rd, binary.LittleEndian, &cp.maxSeq); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(rd, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	cp.executed = make([]instanceId, count)
+	for i := range cp.executed {
+		var id [2]int32
+		if err := binary.Read(rd, binary.LittleEndian, id[:]); err != nil {
+			return nil, err
+		}
+		cp.executed[i] = instanceId{id[0], id[1]}
+	}
+	var size int64
+	if err := binary.Read(rd, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	cp.state = make([]byte, size)
+	if _, err := io.ReadFull(rd, cp.state); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// Installs a checkpoint on a replica that is starting up. Instances covered
+// by the checkpoint are forgotten, so the records for them that may still be
+// in the stable store are ignored on replay.
+func (r *Replica) installCheckpoint(cp *checkpoint) {
+	if err := r.State.Unmarshal(bytes.NewReader(cp.state)); err != nil {
+		log.Fatal("Cannot restore checkpoint:", err)
+	}
+	for q := 0; q < r.N; q++ {
+		r.InstanceSpace[q].Forget(cp.execedUpTo[q])
+		r.ExecedUpTo[q] = cp.execedUpTo[q]
+		r.CommittedUpTo[q] = cp.execedUpTo[q]
+		if r.crtInstance[q] <= cp.execedUpTo[q] {
+			r.crtInstance[q] = cp.execedUpTo[q] + 1
+		}
+	}
+	if cp.maxSeq > r.maxSeq {
+		r.maxSeq = cp.maxSeq
+	}
+	r.latestCheckpoint = cp
+}
@@ -1,11 +1,13 @@
 package epaxos
 
 import (
+	"bloomfilter"
 	"dlog"
 	"epaxosproto"
 	"genericsmrproto"
 	"sort"
 	"state"
+	"sync"
 	"time"
 )
 
@@ -15,8 +17,95 @@ const (
 	BLACK
 )
 
+// The execution thread never waits inside the dependency graph: a walk that
+// reaches an instance that is missing or not committed yet gives up, and the
+// instance is remembered as blocking. When nothing can be executed, the
+// thread sleeps until the replica signals that instances got committed, and
+// only walks the graph again if one of the blocking instances is ready.
+//
+// With workers, the SCCs are executed by a pool of goroutines. An SCC is
+// handed to a worker once it does not conflict with any SCC that is still
+// running, so commands that conflict are executed in the same order as on a
+// single goroutine. The running instances count as executed for the graph
+// walk, but are only marked EXECUTED by the execution thread once their
+// worker is done with them.
+//
+// The workers still apply their commands one at a time: the State (its maps,
+// the key index of SCANs and the client sessions) is not sharded by key, so
+// every command is executed under stateMu. What runs in parallel is the rest
+// of the work on an SCC, such as replying to the clients; the state machine
+// itself gets no faster with more workers.
 type Exec struct {
-	r *Replica
+	r       *Replica
+	wakeup  chan bool           // signaled by the replica when instances get committed
+	blocked map[instanceId]bool // instances the execution is waiting for
+	scanned []int32             // instances of each replica that the last pass looked at
+	workers int                 // 0 to execute every SCC on the execution thread
+	jobs    chan []*Instance    // SCCs to be executed by the workers
+	done    chan []*Instance    // SCCs executed by the workers
+	running map[*Instance]bool  // instances handed to the workers
+	stateMu sync.Mutex          // serializes the execution of commands, since the workers share the State
+}
+
+func NewExec(r *Replica, workers int) *Exec {
+	e := &Exec{r, make(chan bool, 1), make(map[instanceId]bool), make([]int32, r.N), workers, nil, nil, make(map[*Instance]bool), sync.Mutex{}}
+	if workers > 0 {
+		e.jobs = make(chan []*Instance, workers)
+		e.done = make(chan []*Instance)
+	}
+	return e
+}
+
+// called by the replica when instances get committed (or their commands
+// arrive); several notifications may be coalesced into one wakeup
+func (e *Exec) notify() {
+	select {
+	case e.wakeup <- true:
+	default:
+	}
+}
+
+func (e *Exec) blockOn(replica int32, instance int32) {
+	e.blocked[instanceId{replica, instance}] = true
+}
+
+func (e *Exec) unblocked() bool {
+	for q := 0; q < e.r.N; q++ {
+		if e.r.crtInstance[q] > e.scanned[q] {
+			return true
+		}
+	}
+	for id := range e.blocked {
+		inst := e.r.InstanceSpace[id.replica].Get(id.instance)
+		if inst == nil {
+			if e.r.InstanceSpace[id.replica].Forgotten(id.instance) {
+				return true
+			}
+			continue
+		}
+		if inst.Status >= epaxosproto.COMMITTED && inst.Cmds != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Waits until a blocking instance is ready, a snapshot is to be installed or
+// taken, or the timeout channel fires.
+func (e *Exec) wait(timeout <-chan time.Time) {
+	for {
+		select {
+		case <-e.wakeup:
+			if e.unblocked() || len(e.r.snapshotsToInstall) > 0 || len(e.r.snapshotsToTake) > 0 {
+				return
+			}
+		case scc := <-e.done:
+			e.finish(scc)
+			return
+		case <-timeout:
+			return
+		}
+	}
 }
 
 type SCComponent struct {
@@ -25,14 +114,18 @@ type SCComponent struct {
 }
 
 func (e *Exec) executeCommand(replica int32, instance int32) bool {
-	if e.r.InstanceSpace[replica][instance] == nil {
+	if e.r.InstanceSpace[replica].Get(instance) == nil {
 		return false
 	}
-	inst := e.r.InstanceSpace[replica][instance]
+	inst := e.r.InstanceSpace[replica].Get(instance)
 	if inst.Status == epaxosproto.EXECUTED {
 		return true
 	}
-	if inst.Status != epaxosproto.COMMITTED {
+	if inst.Status != epaxosproto.COMMITTED || e.running[inst] {
+		return false
+	}
+	if inst.Cmds == nil {
+		e.blockOn(replica, instance)
 		return false
 	}
 
@@ -69,30 +162,31 @@ func (e *Exec) strongconnect(v *Instance, index *int) bool {
 	for q := int32(0); q < int32(e.r.N); q++ {
 		inst := v.Deps[q]
 		for i := e.r.ExecedUpTo[q] + 1; i <= inst; i++ {
-			for e.r.InstanceSpace[q][i] == nil || e.r.InstanceSpace[q][i].Cmds == nil || v.Cmds == nil {
-				time.Sleep(1000 * 1000)
+			w := e.r.InstanceSpace[q].Get(i)
+			if w == nil {
+				e.blockOn(q, i)
+				e.unwind(l)
+				return false
 			}
 
-			if e.r.InstanceSpace[q][i].Status == epaxosproto.EXECUTED {
+			if w.Status == epaxosproto.EXECUTED || e.running[w] {
 				continue
 			}
 
-			for e.r.InstanceSpace[q][i].Status != epaxosproto.COMMITTED {
-				time.Sleep(1000 * 1000)
+			if w.Status != epaxosproto.COMMITTED || w.Cmds == nil {
+				e.blockOn(q, i)
+				e.unwind(l)
+				return false
 			}
 
 			// Skip if the commands in the batch do not conflict
-			if !state.ConflictBatch(v.Cmds, e.r.InstanceSpace[q][i].Cmds) {
+			if !e.mayConflict(v, w) {
 				continue
 			}
 
-			w := e.r.InstanceSpace[q][i]
 			if w.Index == 0 {
 				if !e.strongconnect(w, index) {
-					for j := l; j < len(stack); j++ {
-						stack[j].Index = 0
-					}
-					stack = stack[0:l]
+					e.unwind(l)
 					return false
 				}
 				if w.Lowlink < v.Lowlink {
@@ -112,30 +206,135 @@ func (e *Exec) strongconnect(v *Instance, index *int) bool {
 
 		//execute commands in the increasing order of the Seq field
 		sort.Sort(nodeArray(list))
-		for _, w := range list {
-			for w.Cmds == nil {
-				time.Sleep(1000 * 1000)
-			}
-			for idx := 0; idx < len(w.Cmds); idx++ {
-				val := w.Cmds[idx].Execute(e.r.State)
-				dlog.Printf("Replica %d: Executed command %v with seq: %d, result: %d\n", e.r.Id, w.Cmds[idx], w.Seq, val)
-				if e.r.Dreply && w.lb != nil && w.lb.clientProposals != nil {
-					e.r.ReplyProposeTS(
-						&genericsmrproto.ProposeReplyTS{
-							TRUE,
-							w.lb.clientProposals[idx].CommandId,
-							val,
-							w.lb.clientProposals[idx].Timestamp},
-						w.lb.clientProposals[idx].Reply)
-				}
-			}
-			w.Status = epaxosproto.EXECUTED
+		if e.workers == 0 {
+			e.executeSCC(list)
+			e.finish(list)
+		} else {
+			e.dispatch(append([]*Instance(nil), list...))
 		}
 		stack = stack[0:l]
 	}
 	return true
 }
 
+func (e *Exec) executeSCC(list []*Instance) {
+	for _, w := range list {
+		for idx := 0; idx < len(w.Cmds); idx++ {
+			if w.Cmds[idx].Op == state.CHECKPOINT {
+				continue
+			}
+			e.stateMu.Lock()
+			val, kvs := w.Cmds[idx].ExecuteMulti(e.r.State)
+			e.stateMu.Unlock()
+			dlog.Printf("Replica %d: Executed command %v with seq: %d, result: %q\n", e.r.Id, w.Cmds[idx], w.Seq, val)
+			if e.r.Dreply && w.lb != nil && w.lb.clientProposals != nil {
+				// lease reads are answered from the main thread, possibly on
+				// the same connection
+				e.r.clientMutex.Lock()
+				e.r.ReplyProposeTS(
+					&genericsmrproto.ProposeReplyTS{
+						TRUE,
+						w.lb.clientProposals[idx].CommandId,
+						val,
+						w.lb.clientProposals[idx].Timestamp,
+						kvs},
+					w.lb.clientProposals[idx].Reply)
+				e.r.clientMutex.Unlock()
+			}
+		}
+	}
+}
+
+//called by the execution thread once an SCC has been executed
+func (e *Exec) finish(list []*Instance) {
+	for _, w := range list {
+		delete(e.running, w)
+		w.Status = epaxosproto.EXECUTED
+		if isCheckpoint(w.Cmds) && e.r.checkpointPeriod > 0 {
+			//checkpoint barrier (recovered no-ops have no commands at all)
+			e.r.pendingCheckpoint = w.Deps
+		}
+	}
+}
+
+/* Worker pool */
+
+func (e *Exec) startWorkers() {
+	for i := 0; i < e.workers; i++ {
+		go func() {
+			for scc := range e.jobs {
+				e.executeSCC(scc)
+				e.done <- scc
+			}
+		}()
+	}
+}
+
+// Hands an SCC to the workers, after the running SCCs it conflicts with are
+// done.
+func (e *Exec) dispatch(scc []*Instance) {
+	for e.conflictsWithRunning(scc) {
+		e.finish(<-e.done)
+	}
+	for _, w := range scc {
+		e.running[w] = true
+	}
+	for {
+		select {
+		case e.jobs <- scc:
+			return
+		case done := <-e.done:
+			e.finish(done)
+		}
+	}
+}
+
+// Tells whether the batches of two instances conflict. The Bloom filter of
+// the keys of w rules out most of the batches that touch other keys, so that
+// only the possible hits are checked pair by pair.
+func (e *Exec) mayConflict(v *Instance, w *Instance) bool {
+	if len(v.Cmds) > 1 || len(w.Cmds) > 1 {
+		if !bfMayTouch(e.filterOf(w), v.Cmds) {
+			return false
+		}
+	}
+	return e.r.State.ConflictBatch(v.Cmds, w.Cmds)
+}
+
+// the Bloom filter of the keys of a committed instance, built on first use
+func (e *Exec) filterOf(w *Instance) *bloomfilter.Bloomfilter {
+	if f := w.bfilter; f != nil && len(f.cmds) == len(w.Cmds) && (len(w.Cmds) == 0 || &f.cmds[0] == &w.Cmds[0]) {
+		return f.bf
+	}
+	w.bfilter = &batchFilter{w.Cmds, bfFromCommands(w.Cmds)}
+	return w.bfilter.bf
+}
+
+func (e *Exec) conflictsWithRunning(scc []*Instance) bool {
+	for u := range e.running {
+		for _, w := range scc {
+			if e.r.State.ConflictBatch(w.Cmds, u.Cmds) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// waits for every running SCC to be done, e.g. before taking a snapshot
+func (e *Exec) drain() {
+	for len(e.running) > 0 {
+		e.finish(<-e.done)
+	}
+}
+
+func (e *Exec) unwind(l int) {
+	for j := l; j < len(stack); j++ {
+		stack[j].Index = 0
+	}
+	stack = stack[0:l]
+}
+
 func (e *Exec) inStack(w *Instance) bool {
 	for _, u := range stack {
 		if w == u {
@@ -0,0 +1,73 @@
+package epaxos
+
+import (
+	"dlog"
+	"epaxosproto"
+	"genericsmr"
+	"log"
+)
+
+// the instances of one replica (see genericsmr.InstanceWindow)
+type InstanceWindow = genericsmr.InstanceWindow[Instance]
+
+func NewInstanceWindow() *InstanceWindow {
+	return genericsmr.NewInstanceWindow[Instance]()
+}
+
+/**********************************************************************
+
+                    GARBAGE COLLECTION OF EXECUTED INSTANCES
+
+***********************************************************************/
+
+// replicas that do not execute commands only need to have committed them
+func (r *Replica) progress() []int32 {
+	if !r.Exec {
+		return r.CommittedUpTo
+	}
+	return r.ExecedUpTo
+}
+
+func (r *Replica) bcastExecStatus() {
+	defer func() {
+		if err := recover(); err != nil {
+			dlog.Println("ExecStatus bcast failed:", err)
+		}
+	}()
+	args := &epaxosproto.ExecStatus{r.Id, copyDeps(r.progress())}
+
+	for q := int32(0); q < int32(r.N); q++ {
+		if q == r.Id || !r.Alive[q] {
+			continue
+		}
+		r.SendMsg(q, r.execStatusRPC, args)
+	}
+}
+
+func (r *Replica) handleExecStatus(es *epaxosproto.ExecStatus) {
+	if es.ReplicaId < 0 || int(es.ReplicaId) >= r.N || len(es.ExecedUpTo) != r.N {
+		return
+	}
+	r.ReportProgress(es.ReplicaId, es.ExecedUpTo)
+	r.checkLag(es)
+
+	// a replica that has been left out of garbage collection, because it was
+	// down for too long, can only catch up with a snapshot
+	for q := 0; q < r.N; q++ {
+		if r.InstanceSpace[q].Forgotten(es.ExecedUpTo[q]+1) && r.SnapshotDue(es.ReplicaId) {
+			log.Printf("Replica %d needs instances that have been forgotten, sending it a snapshot\n", es.ReplicaId)
+			r.handleSnapshotRequest(&epaxosproto.SnapshotRequest{es.ReplicaId})
+			return
+		}
+	}
+}
+
+// forgets the instances that have been executed by every replica
+func (r *Replica) collectGarbage() {
+	progress := r.progress()
+	for q := 0; q < r.N; q++ {
+		if watermark := r.GCWatermark(q, progress[q]); watermark >= 0 {
+			r.InstanceSpace[q].Forget(watermark)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package epaxos
+
+import (
+	"dlog"
+	"epaxosproto"
+	"genericsmr"
+	"genericsmrproto"
+	"state"
+	"time"
+)
+
+/**********************************************************************
+
+                            READ LEASES
+
+***********************************************************************/
+
+// With read leases, the replicas 0..N/2 (the quorum that replica 0 prefers)
+// answer FAST_READs locally, without starting an instance for them. A holder
+// keeps asking every other replica for a lease. By granting one, a replica
+// promises that, until the lease expires, it will not commit an instance as
+// its leader before the holder has acknowledged that it knows about it. A
+// holder with unexpired leases from all the other replicas thus knows about
+// every instance that has been committed, and reads locally once it has
+// executed all the instances it knows about that conflict with the read.
+//
+// A grant that follows a lapse carries the instances the grantor knew about,
+// since they may have been committed without the holder in the meantime. A
+// replica that is waiting for a holder to acknowledge an instance stops
+// renewing its lease, so a slow holder delays commits for LEASE_DURATION at
+// most.
+
+const LEASE_DURATION = 2 * 1e9 // 2 seconds
+const LEASE_GUARD = 100 * 1e6  // 100 ms, the clock drift that grantors allow for
+
+type leaseRead struct {
+	propose *genericsmr.Propose
+	upTo    []int32 // instances that must have been executed, if they conflict with the read
+}
+
+type leaseState struct {
+	holders      int     // replicas 0..holders-1 hold leases
+	until        []int64 // when the lease from each grantor expires
+	frontier     []int32 // instances the grantors knew about when granting after a lapse
+	grantedUntil []int64 // when the lease granted to each holder expires
+	acks         map[instanceId][]bool
+	waiting      map[instanceId]genericsmrproto.Ballot // decided instances waiting for the holders
+	reads        []*leaseRead                          // reads waiting for the execution
+}
+
+func newLeaseState(n int) *leaseState {
+	ls := &leaseState{n/2 + 1, make([]int64, n), make([]int32, n), make([]int64, n),
+		make(map[instanceId][]bool), make(map[instanceId]genericsmrproto.Ballot), make([]*leaseRead, 0)}
+	for q := 0; q < n; q++ {
+		ls.frontier[q] = -1
+	}
+	return ls
+}
+
+func (r *Replica) isLeaseHolder(q int32) bool {
+	return r.leases != nil && q >= 0 && int(q) < r.leases.holders
+}
+
+// called on every tick of the slow clock
+func (r *Replica) renewLeases() {
+	if r.leases == nil {
+		return
+	}
+	for id := range r.leases.waiting {
+		r.retryCommit(id)
+	}
+	if r.isLeaseHolder(r.Id) {
+		req := &epaxosproto.LeaseRequest{r.Id, time.Now().UnixNano()}
+		for q := int32(0); q < int32(r.N); q++ {
+			if q == r.Id || !r.Alive[q] {
+				continue
+			}
+			r.SendMsg(q, r.leaseRequestRPC, req)
+		}
+	}
+	r.serveLeaseReads()
+}
+
+func (r *Replica) handleLeaseRequest(req *epaxosproto.LeaseRequest) {
+	if !r.isLeaseHolder(req.HolderId) || req.HolderId == r.Id {
+		return
+	}
+	for id := range r.leases.waiting {
+		if acks := r.leases.acks[id]; acks == nil || !acks[req.HolderId] {
+			// let the lease expire, so that the commit can go ahead
+			return
+		}
+	}
+
+	now := time.Now().UnixNano()
+	grant := &epaxosproto.LeaseGrant{r.Id, req.Timestamp, nil}
+	if r.leases.grantedUntil[req.HolderId] < now {
+		// we may have committed instances that the holder does not know about
+		grant.Frontier = make([]int32, r.N)
+		for q := 0; q < r.N; q++ {
+			grant.Frontier[q] = r.crtInstance[q] - 1
+		}
+	}
+	r.leases.grantedUntil[req.HolderId] = now + LEASE_DURATION + LEASE_GUARD
+	r.SendMsg(req.HolderId, r.leaseGrantRPC, grant)
+}
+
+func (r *Replica) handleLeaseGrant(grant *epaxosproto.LeaseGrant) {
+	if !r.isLeaseHolder(r.Id) || grant.GrantorId < 0 || int(grant.GrantorId) >= r.N {
+		return
+	}
+	if len(grant.Frontier) == r.N {
+		for q := 0; q < r.N; q++ {
+			if grant.Frontier[q] > r.leases.frontier[q] {
+				r.leases.frontier[q] = grant.Frontier[q]
+			}
+		}
+	}
+	// the lease is counted from when we asked for it, since the grantor's
+	// promise cannot have started earlier
+	if until := grant.Timestamp + LEASE_DURATION; until > r.leases.until[grant.GrantorId] {
+		r.leases.until[grant.GrantorId] = until
+	}
+}
+
+func (r *Replica) holdsLease() bool {
+	now := time.Now().UnixNano()
+	for q := int32(0); q < int32(r.N); q++ {
+		if q != r.Id && r.leases.until[q] <= now {
+			return false
+		}
+	}
+	return true
+}
+
+// called by a lease holder once it knows about the commands of an instance
+func (r *Replica) sendLeaseAck(leader int32, replica int32, instance int32) {
+	if !r.isLeaseHolder(r.Id) || leader == r.Id {
+		return
+	}
+	r.SendMsg(leader, r.leaseAckRPC, &epaxosproto.LeaseAck{r.Id, replica, instance})
+}
+
+func (r *Replica) handleLeaseAck(ack *epaxosproto.LeaseAck) {
+	if r.leases == nil || ack.AcceptorId < 0 || int(ack.AcceptorId) >= r.N ||
+		ack.Replica < 0 || int(ack.Replica) >= r.N {
+		return
+	}
+	inst := r.InstanceSpace[ack.Replica].Get(ack.Instance)
+	if inst == nil || inst.lb == nil || inst.Status >= epaxosproto.COMMITTED {
+		return
+	}
+	id := instanceId{ack.Replica, ack.Instance}
+	if r.leases.acks[id] == nil {
+		r.leases.acks[id] = make([]bool, r.N)
+	}
+	r.leases.acks[id][ack.AcceptorId] = true
+	if _, waiting := r.leases.waiting[id]; waiting {
+		r.retryCommit(id)
+	}
+}
+
+// Tells whether an instance can be committed without breaking the promises
+// made to the lease holders. If not, the commit is retried when the holders
+// acknowledge the instance, or when their leases expire.
+func (r *Replica) leaseHoldersKnow(replica int32, instance int32, inst *Instance) bool {
+	if r.leases == nil {
+		return true
+	}
+	id := instanceId{replica, instance}
+	if inst.Cmds != nil && !r.conflictsWithReads(inst.Cmds) {
+		delete(r.leases.waiting, id)
+		delete(r.leases.acks, id)
+		return true
+	}
+	now := time.Now().UnixNano()
+	acks := r.leases.acks[id]
+	for q := int32(0); int(q) < r.leases.holders; q++ {
+		if q != r.Id && r.leases.grantedUntil[q] > now && (acks == nil || !acks[q]) {
+			r.leases.waiting[id] = inst.ballot
+			return false
+		}
+	}
+	delete(r.leases.waiting, id)
+	delete(r.leases.acks, id)
+	return true
+}
+
+func (r *Replica) commitPending(replica int32, instance int32) bool {
+	if r.leases == nil {
+		return false
+	}
+	_, waiting := r.leases.waiting[instanceId{replica, instance}]
+	return waiting
+}
+
+func (r *Replica) retryCommit(id instanceId) {
+	inst := r.InstanceSpace[id.replica].Get(id.instance)
+	if inst == nil || inst.lb == nil || inst.Status >= epaxosproto.COMMITTED || inst.ballot != r.leases.waiting[id] {
+		// someone else has taken over the instance
+		delete(r.leases.waiting, id)
+		delete(r.leases.acks, id)
+		return
+	}
+	r.commitAsLeader(id.replica, id.instance)
+}
+
+func (r *Replica) conflictsWithReads(cmds []state.Command) bool {
+	for i := range cmds {
+		if r.State.OperationConflict(state.READ, cmds[i].Op) {
+			return true
+		}
+	}
+	return false
+}
+
+/* Local reads */
+
+// Answers a FAST_READ locally if we hold the lease. Returns false if the read
+// has to go through an instance instead.
+func (r *Replica) readWithLease(propose *genericsmr.Propose) bool {
+	if !r.Exec || !r.isLeaseHolder(r.Id) || !r.holdsLease() {
+		return false
+	}
+	// every conflicting instance that may have been committed before now is
+	// either one we know about, or one a grantor knew about
+	lr := &leaseRead{propose, make([]int32, r.N)}
+	for q := 0; q < r.N; q++ {
+		lr.upTo[q] = r.crtInstance[q] - 1
+		if r.leases.frontier[q] > lr.upTo[q] {
+			lr.upTo[q] = r.leases.frontier[q]
+		}
+	}
+	if r.leaseReadReady(lr) {
+		r.replyLeaseRead(lr)
+	} else {
+		r.leases.reads = append(r.leases.reads, lr)
+	}
+	return true
+}
+
+func (r *Replica) serveLeaseReads() {
+	if r.leases == nil || len(r.leases.reads) == 0 {
+		return
+	}
+	waiting := r.leases.reads[:0]
+	for _, lr := range r.leases.reads {
+		if r.leaseReadReady(lr) {
+			r.replyLeaseRead(lr)
+		} else {
+			waiting = append(waiting, lr)
+		}
+	}
+	for i := len(waiting); i < len(r.leases.reads); i++ {
+		r.leases.reads[i] = nil
+	}
+	r.leases.reads = waiting
+}
+
+func (r *Replica) leaseReadReady(lr *leaseRead) bool {
+	read := []state.Command{lr.propose.Command}
+	read[0].Op = state.READ
+	for q := int32(0); q < int32(r.N); q++ {
+		for i := r.ExecedUpTo[q] + 1; i <= lr.upTo[q]; i++ {
+			inst := r.InstanceSpace[q].Get(i)
+			if inst == nil {
+				if r.InstanceSpace[q].Forgotten(i) {
+					continue
+				}
+				return false
+			}
+			if inst.Status == epaxosproto.EXECUTED {
+				continue
+			}
+			if inst.Cmds == nil || r.State.ConflictBatch(read, inst.Cmds) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (r *Replica) replyLeaseRead(lr *leaseRead) {
+	cmd := lr.propose.Command
+	// reads leave the state alone, so the client's session is not needed
+	// (and must not diverge from the other replicas' sessions)
+	cmd.ClientId = state.NO_CLIENT
+	r.exec.stateMu.Lock()
+	val := cmd.Execute(r.State)
+	r.exec.stateMu.Unlock()
+	dlog.Printf("Read %q locally under lease: %q\n", cmd.K, val)
+
+	r.clientMutex.Lock()
+	r.ReplyProposeTS(
+		&genericsmrproto.ProposeReplyTS{
+			TRUE,
+			lr.propose.CommandId,
+			val,
+			lr.propose.Timestamp,
+			nil},
+		lr.propose.Reply)
+	r.clientMutex.Unlock()
+}
@@ -0,0 +1,265 @@
+package epaxos
+
+import (
+	"bufio"
+	"encoding/binary"
+	"epaxosproto"
+	"genericsmrproto"
+	"io"
+	"log"
+	"state"
+)
+
+// Every entry in the stable store starts with a record type, followed by the
+// id of the instance (replica, instance) it refers to. Entries are only ever
+// appended, so replaying them in order rebuilds the latest known state of
+// every instance.
+const (
+	LOG_METADATA uint8 = iota
+	LOG_COMMANDS
+)
+
+const LOG_HEADER_SIZE = 9
+
+// ballot (12 bytes), status, seq and the number of deps that follow
+const LOG_METADATA_SIZE = 21
+
+//append a log entry to stable storage
+func (r *Replica) recordInstanceMetadata(replica int32, instance int32, inst *Instance) {
+	if !r.Durable {
+		return
+	}
+
+	b := make([]byte, LOG_HEADER_SIZE+LOG_METADATA_SIZE+4*len(inst.Deps))
+	b[0] = LOG_METADATA
+	binary.LittleEndian.PutUint32(b[1:5], uint32(replica))
+	binary.LittleEndian.PutUint32(b[5:9], uint32(instance))
+	l := LOG_HEADER_SIZE
+	binary.LittleEndian.PutUint32(b[l:l+4], uint32(inst.ballot.Epoch))
+	binary.LittleEndian.PutUint32(b[l+4:l+8], uint32(inst.ballot.Counter))
+	binary.LittleEndian.PutUint32(b[l+8:l+12], uint32(inst.ballot.ReplicaId))
+	b[l+12] = byte(inst.Status)
+	binary.LittleEndian.PutUint32(b[l+13:l+17], uint32(inst.Seq))
+	binary.LittleEndian.PutUint32(b[l+17:l+21], uint32(len(inst.Deps)))
+	l += LOG_METADATA_SIZE
+	for _, dep := range inst.Deps {
+		binary.LittleEndian.PutUint32(b[l:l+4], uint32(dep))
+		l += 4
+	}
+	r.StableStore.Write(b)
+}
+
+//write a sequence of commands to stable storage
+func (r *Replica) recordCommands(replica int32, instance int32, cmds []state.Command) {
+	if !r.Durable {
+		return
+	}
+
+	if cmds == nil {
+		return
+	}
+
+	w := bufio.NewWriter(r.StableStore)
+	var b [LOG_HEADER_SIZE + 4]byte
+	b[0] = LOG_COMMANDS
+	binary.LittleEndian.PutUint32(b[1:5], uint32(replica))
+	binary.LittleEndian.PutUint32(b[5:9], uint32(instance))
+	binary.LittleEndian.PutUint32(b[9:13], uint32(len(cmds)))
+	w.Write(b[:])
+	for i := 0; i < len(cmds); i++ {
+		cmds[i].Marshal(w)
+	}
+	w.Flush()
+}
+
+//sync with the stable store
+func (r *Replica) sync() {
+	if !r.Durable {
+		return
+	}
+
+	r.StableStore.Sync()
+}
+
+/**********************************************************************
+
+                    RECOVERY FROM THE STABLE STORE
+
+***********************************************************************/
+
+// counts the bytes consumed from the stable store, so that a torn record at
+// the end of the log can be cut off before we start appending to it again
+type countingReader struct {
+	rd   *bufio.Reader
+	n    int64
+	size int64 // of the whole stable store, to bound the lengths read from it
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.rd.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	c, err := cr.rd.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return c, err
+}
+
+// Rebuilds the instance space from the stable store after a restart.
+// Must be called before the replica starts talking to its peers.
+func (r *Replica) recoverFromStableStore() {
+	cp, err := readCheckpoint(checkpointFileName(r.Id), r.N)
+	if err != nil {
+		log.Fatal("Cannot read checkpoint:", err)
+	}
+	if cp != nil {
+		r.installCheckpoint(cp)
+	}
+
+	if _, err := r.StableStore.Seek(0, 0); err != nil {
+		log.Fatal("Cannot read stable store:", err)
+	}
+
+	fi, err := r.StableStore.Stat()
+	if err != nil {
+		log.Fatal("Cannot read stable store:", err)
+	}
+	cr := &countingReader{bufio.NewReader(r.StableStore), 0, fi.Size()}
+	good := int64(0)
+	records := 0
+	for {
+		if err := r.replayRecord(cr); err != nil {
+			if err != io.EOF {
+				log.Printf("Discarding incomplete log record at offset %d: %v\n", good, err)
+			}
+			break
+		}
+		good = cr.n
+		records++
+	}
+
+	if err := r.StableStore.Truncate(good); err != nil {
+		log.Fatal("Cannot truncate stable store:", err)
+	}
+
+	if records == 0 {
+		return
+	}
+
+	for q := int32(0); q < int32(r.N); q++ {
+		for i := int32(0); i < r.crtInstance[q]; i++ {
+			inst := r.InstanceSpace[q].Get(i)
+			if inst == nil {
+				continue
+			}
+			// everything that is not covered by a checkpoint gets re-executed
+			if inst.Status == epaxosproto.EXECUTED {
+				inst.Status = epaxosproto.COMMITTED
+			}
+			if inst.Seq >= r.maxSeq {
+				r.maxSeq = inst.Seq + 1
+			}
+			if inst.Cmds != nil {
+				r.updateSmartConflicts(inst.Cmds, q, i, inst.Seq)
+			}
+		}
+		r.updateCommitted(q)
+	}
+
+	// the effects of these are already part of the checkpointed state
+	if cp != nil {
+		for _, id := range cp.executed {
+			if inst := r.InstanceSpace[id.replica].Get(id.instance); inst != nil && inst.Status == epaxosproto.COMMITTED {
+				inst.Status = epaxosproto.EXECUTED
+			}
+		}
+	}
+
+	// our own instances have lost their leader bookkeeping, so they must go
+	// through explicit recovery to get committed (there may be more of them
+	// than instancesToRecover can hold before run() reads it)
+	for i := r.CommittedUpTo[r.Id] + 1; i < r.crtInstance[r.Id]; i++ {
+		inst := r.InstanceSpace[r.Id].Get(i)
+		if inst == nil || inst.Status < epaxosproto.COMMITTED {
+			r.unrecovered = append(r.unrecovered, instanceId{r.Id, i})
+		}
+	}
+
+	log.Printf("Replayed %d log records. crtInstance: %v, CommittedUpTo: %v\n", records, r.crtInstance, r.CommittedUpTo)
+}
+
+func (r *Replica) replayRecord(cr *countingReader) error {
+	var b [LOG_HEADER_SIZE + LOG_METADATA_SIZE]byte
+
+	if _, err := io.ReadFull(cr, b[:LOG_HEADER_SIZE]); err != nil {
+		return err
+	}
+	kind := b[0]
+	replica := int32(binary.LittleEndian.Uint32(b[1:5]))
+	instance := int32(binary.LittleEndian.Uint32(b[5:9]))
+	if replica < 0 || int(replica) >= r.N || instance < 0 {
+		return io.ErrUnexpectedEOF
+	}
+
+	inst := r.InstanceSpace[replica].Get(instance)
+	if inst == nil {
+		inst = &Instance{nil, genericsmrproto.InitialBallot(replica), epaxosproto.NONE, 0, r.makeDeps(0), nil, 0, 0, nil}
+	}
+
+	switch kind {
+	case LOG_METADATA:
+		bs := b[LOG_HEADER_SIZE:]
+		if _, err := io.ReadFull(cr, bs); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		inst.ballot.Epoch = int32(binary.LittleEndian.Uint32(bs[0:4]))
+		inst.ballot.Counter = int32(binary.LittleEndian.Uint32(bs[4:8]))
+		inst.ballot.ReplicaId = int32(binary.LittleEndian.Uint32(bs[8:12]))
+		inst.Status = int8(bs[12])
+		inst.Seq = int32(binary.LittleEndian.Uint32(bs[13:17]))
+		ndeps := int32(binary.LittleEndian.Uint32(bs[17:21]))
+		if ndeps < 0 || int(ndeps) > r.N {
+			return io.ErrUnexpectedEOF
+		}
+		deps := make([]byte, 4*ndeps)
+		if _, err := io.ReadFull(cr, deps); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		inst.Deps = make([]int32, ndeps)
+		for q := range inst.Deps {
+			inst.Deps[q] = int32(binary.LittleEndian.Uint32(deps[4*q : 4*q+4]))
+		}
+
+	case LOG_COMMANDS:
+		bs := b[LOG_HEADER_SIZE : LOG_HEADER_SIZE+4]
+		if _, err := io.ReadFull(cr, bs); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		count := int32(binary.LittleEndian.Uint32(bs))
+		// every command takes at least one byte, so a count larger than what
+		// is left of the log can only come from a torn or corrupted record
+		if count < 0 || int64(count) > cr.size-cr.n {
+			return io.ErrUnexpectedEOF
+		}
+		cmds := make([]state.Command, count)
+		for i := int32(0); i < count; i++ {
+			if err := cmds[i].Unmarshal(cr); err != nil {
+				return io.ErrUnexpectedEOF
+			}
+		}
+		inst.Cmds = cmds
+
+	default:
+		return io.ErrUnexpectedEOF
+	}
+
+	r.InstanceSpace[replica].Set(instance, inst)
+	if instance >= r.crtInstance[replica] {
+		r.crtInstance[replica] = instance + 1
+	}
+	return nil
+}
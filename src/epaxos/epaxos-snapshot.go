@@ -0,0 +1,246 @@
+package epaxos
+
+import (
+	"bytes"
+	"dlog"
+	"epaxosproto"
+	"genericsmrproto"
+	"log"
+	"time"
+)
+
+/**********************************************************************
+
+                    STATE TRANSFER TO LAGGING REPLICAS
+
+***********************************************************************/
+
+// A replica that learns from an ExecStatus that it is more than SNAPSHOT_LAG
+// instances behind the sender asks the sender for a snapshot, instead of
+// recovering the missing instances one at a time. The snapshot is taken by
+// the sender's execution thread, and holds the application state together
+// with every committed instance that is past the snapshot's frontier. The
+// lagging replica installs it in its execution thread, while its main thread
+// waits, and then resumes executing from the frontier.
+
+const SNAPSHOT_LAG = 10000        // instances behind a peer before asking it for a snapshot
+const SNAPSHOT_TIMEOUT = 10 * 1e9 // 10 seconds before asking again
+
+type takenSnapshot struct {
+	requester int32
+	cp        *checkpoint
+}
+
+func (r *Replica) checkLag(es *epaxosproto.ExecStatus) {
+	if !r.Exec || !r.Alive[es.ReplicaId] {
+		return
+	}
+	if r.snapshotRequested != 0 && time.Now().UnixNano()-r.snapshotRequested < SNAPSHOT_TIMEOUT {
+		return
+	}
+	for q := 0; q < r.N; q++ {
+		if es.ExecedUpTo[q]-r.ExecedUpTo[q] > SNAPSHOT_LAG {
+			log.Printf("Replica %d is ahead by %d instances of replica %d, asking it for a snapshot\n",
+				es.ReplicaId, es.ExecedUpTo[q]-r.ExecedUpTo[q], q)
+			r.snapshotRequested = time.Now().UnixNano()
+			r.SendMsg(es.ReplicaId, r.snapshotRequestRPC, &epaxosproto.SnapshotRequest{r.Id})
+			return
+		}
+	}
+}
+
+func (r *Replica) handleSnapshotRequest(req *epaxosproto.SnapshotRequest) {
+	if !r.Exec || req.ReplicaId < 0 || int(req.ReplicaId) >= r.N {
+		return
+	}
+	select {
+	case r.snapshotsToTake <- req.ReplicaId:
+		r.exec.notify()
+	default:
+		// a snapshot is being taken already, the requester will ask again
+	}
+}
+
+//called by the execution thread
+func (r *Replica) takeSnapshot() *checkpoint {
+	r.exec.drain()
+	cp := &checkpoint{copyDeps(r.ExecedUpTo), make([]instanceId, 0), r.maxSeq, nil}
+	for q := int32(0); q < int32(r.N); q++ {
+		for i := cp.execedUpTo[q] + 1; i < r.crtInstance[q]; i++ {
+			if inst := r.InstanceSpace[q].Get(i); inst != nil && inst.Status == epaxosproto.EXECUTED {
+				cp.executed = append(cp.executed, instanceId{q, i})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	r.State.Marshal(&buf)
+	cp.state = buf.Bytes()
+	return cp
+}
+
+func (r *Replica) sendSnapshot(ts *takenSnapshot) {
+	defer func() {
+		if err := recover(); err != nil {
+			dlog.Println("Snapshot send failed:", err)
+		}
+	}()
+	cp := ts.cp
+	snap := &epaxosproto.Snapshot{r.Id, cp.execedUpTo, cp.maxSeq, cp.state,
+		make([]epaxosproto.Commit, 0, len(cp.executed)), make([]epaxosproto.Commit, 0)}
+
+	executed := make(map[instanceId]bool, len(cp.executed))
+	for _, id := range cp.executed {
+		executed[id] = true
+		if inst := r.InstanceSpace[id.replica].Get(id.instance); inst != nil {
+			snap.Executed = append(snap.Executed, epaxosproto.Commit{r.Id, id.replica, id.instance, inst.Cmds, inst.Seq, inst.Deps})
+		}
+	}
+	// instances that have been executed after the snapshot was taken are
+	// sent as committed, since their effects are not part of it
+	for q := int32(0); q < int32(r.N); q++ {
+		for i := cp.execedUpTo[q] + 1; i < r.crtInstance[q]; i++ {
+			inst := r.InstanceSpace[q].Get(i)
+			if inst == nil || inst.Status < epaxosproto.COMMITTED || executed[instanceId{q, i}] {
+				continue
+			}
+			snap.Committed = append(snap.Committed, epaxosproto.Commit{r.Id, q, i, inst.Cmds, inst.Seq, inst.Deps})
+		}
+	}
+
+	log.Printf("Sending snapshot at %v to replica %d (%d bytes of state, %d instances)\n",
+		cp.execedUpTo, ts.requester, len(cp.state), len(snap.Executed)+len(snap.Committed))
+	r.SendMsg(ts.requester, r.snapshotRPC, snap)
+}
+
+func (r *Replica) handleSnapshot(snap *epaxosproto.Snapshot) {
+	r.snapshotRequested = 0
+	if !r.Exec || len(snap.ExecedUpTo) != r.N {
+		return
+	}
+	ahead := false
+	for q := 0; q < r.N; q++ {
+		if snap.ExecedUpTo[q] > r.ExecedUpTo[q] {
+			ahead = true
+		} else if r.InstanceSpace[q].Forgotten(snap.ExecedUpTo[q] + 1) {
+			// we could not execute again what we have executed past the snapshot
+			return
+		}
+	}
+	if !ahead {
+		return
+	}
+	for _, c := range snap.Executed {
+		if c.Replica < 0 || int(c.Replica) >= r.N {
+			return
+		}
+	}
+	for _, c := range snap.Committed {
+		if c.Replica < 0 || int(c.Replica) >= r.N {
+			return
+		}
+	}
+
+	// never blocks, since we wait for every snapshot to be installed
+	r.snapshotsToInstall <- snap
+	r.exec.notify()
+	// keep serving the execution thread until it gets to the snapshot
+	for {
+		select {
+		case cp := <-r.snapshotInstalled:
+			if cp == nil {
+				return
+			}
+			log.Printf("Installed snapshot at %v from replica %d\n", cp.execedUpTo, snap.ReplicaId)
+			r.handleCheckpoint(cp)
+			return
+		case cp := <-r.checkpointChan:
+			r.handleCheckpoint(cp)
+		case ts := <-r.snapshotsTaken:
+			r.sendSnapshot(ts)
+		}
+	}
+}
+
+// Installs a snapshot received from a peer. Called by the execution thread
+// while the main thread is blocked in handleSnapshot, so nothing else touches
+// the instance space in the meantime.
+func (r *Replica) installSnapshot(snap *epaxosproto.Snapshot) *checkpoint {
+	r.exec.drain()
+	if err := r.State.Unmarshal(bytes.NewReader(snap.State)); err != nil {
+		log.Println("Error installing snapshot:", err)
+		return nil
+	}
+	cp := &checkpoint{snap.ExecedUpTo, make([]instanceId, 0, len(snap.Executed)), snap.MaxSeq, snap.State}
+
+	for q := int32(0); q < int32(r.N); q++ {
+		r.InstanceSpace[q].Forget(cp.execedUpTo[q])
+		r.ExecedUpTo[q] = cp.execedUpTo[q]
+		if r.CommittedUpTo[q] < cp.execedUpTo[q] {
+			r.CommittedUpTo[q] = cp.execedUpTo[q]
+		}
+		if r.crtInstance[q] <= cp.execedUpTo[q] {
+			r.crtInstance[q] = cp.execedUpTo[q] + 1
+		}
+		// what we have executed past the frontier is not in the new state
+		for i := cp.execedUpTo[q] + 1; i < r.crtInstance[q]; i++ {
+			if inst := r.InstanceSpace[q].Get(i); inst != nil && inst.Status == epaxosproto.EXECUTED {
+				inst.Status = epaxosproto.COMMITTED
+			}
+		}
+	}
+
+	for i := range snap.Executed {
+		c := &snap.Executed[i]
+		r.installSnapshotInstance(c, epaxosproto.EXECUTED)
+		cp.executed = append(cp.executed, instanceId{c.Replica, c.Instance})
+	}
+	for i := range snap.Committed {
+		r.installSnapshotInstance(&snap.Committed[i], epaxosproto.COMMITTED)
+	}
+
+	for q := int32(0); q < int32(r.N); q++ {
+		r.updateCommitted(q)
+	}
+	if cp.maxSeq > r.maxSeq {
+		r.maxSeq = cp.maxSeq
+	}
+	r.pendingCheckpoint = nil
+	return cp
+}
+
+func (r *Replica) installSnapshotInstance(c *epaxosproto.Commit, status int8) {
+	if c.Seq >= r.maxSeq {
+		r.maxSeq = c.Seq + 1
+	}
+	if c.Instance >= r.crtInstance[c.Replica] {
+		r.crtInstance[c.Replica] = c.Instance + 1
+	}
+
+	inst := r.InstanceSpace[c.Replica].Get(c.Instance)
+	if inst == nil {
+		r.InstanceSpace[c.Replica].Set(c.Instance, &Instance{
+			c.Command,
+			genericsmrproto.InitialBallot(c.Replica),
+			status,
+			c.Seq,
+			c.Deps,
+			nil,
+			0,
+			0,
+			nil})
+		r.updateSmartConflicts(c.Command, c.Replica, c.Instance, c.Seq)
+	} else if inst.Status < epaxosproto.COMMITTED || status == epaxosproto.EXECUTED {
+		if inst.lb != nil && inst.lb.clientProposals != nil && len(c.Command) == 0 {
+			//someone committed a NO-OP, but we have proposals for this instance
+			//try in a different instance
+			for _, p := range inst.lb.clientProposals {
+				r.ProposeChan <- p
+			}
+			inst.lb = nil
+		}
+		inst.Cmds = c.Command
+		inst.Seq = c.Seq
+		inst.Deps = c.Deps
+		inst.Status = status
+	}
+}
@@ -3,12 +3,10 @@ package epaxos
 import (
 	"bloomfilter"
 	"dlog"
-	"encoding/binary"
 	"epaxosproto"
 	"fastrpc"
 	"genericsmr"
 	"genericsmrproto"
-	"io"
 	"log"
 	"math"
 	"state"
@@ -19,34 +17,23 @@ import (
 const MAX_DEPTH_DEP = 10
 const TRUE = uint8(1)
 const FALSE = uint8(0)
-const DS = 5
 const ADAPT_TIME_SEC = 10
 
-const MAX_BATCH = 1
+const MAX_BATCH = 1 // default maximum number of commands per instance
 
 const COMMIT_GRACE_PERIOD = 10 * 1e9 //10 seconds
 
 const BF_K = 4
 const BF_M_N = 32.0
 
-var bf_PT uint32
+var bf_PT uint32 // the Bloom filters have 2^bf_PT bits, enough for BatchConfig.MaxSize keys
 
-const DO_CHECKPOINTING = false
 const HT_INIT_SIZE = 200000
-const CHECKPOINT_PERIOD = 10000
+const CHECKPOINT_PERIOD = 10000 // default number of commands between checkpoints
 
-var cpMarker []state.Command
+var cpMarker = []state.Command{state.Command{0, state.NO_CLIENT, state.CHECKPOINT, "", state.NIL, state.NIL, nil, 0}}
 var cpcounter = 0
 
-var ALL_OPS = []state.Operation {
-	state.NONE,
-	state.PUT,
-	state.GET,
-	state.INCREMENT,
-	state.READ,
-	state.FAST_READ,
-}
-
 type Replica struct {
 	*genericsmr.Replica
 	prepareChan           chan fastrpc.Serializable
@@ -60,6 +47,7 @@ type Replica struct {
 	acceptReplyChan       chan fastrpc.Serializable
 	tryPreAcceptChan      chan fastrpc.Serializable
 	tryPreAcceptReplyChan chan fastrpc.Serializable
+	execStatusChan        chan fastrpc.Serializable
 	prepareRPC            uint8
 	prepareReplyRPC       uint8
 	preAcceptRPC          uint8
@@ -71,10 +59,11 @@ type Replica struct {
 	commitShortRPC        uint8
 	tryPreAcceptRPC       uint8
 	tryPreAcceptReplyRPC  uint8
-	InstanceSpace         [][]*Instance // the space of all instances (used and not yet used)
-	crtInstance           []int32       // highest active instance numbers that this replica knows about
-	CommittedUpTo         [DS]int32     // highest committed instance per replica that this replica knows about
-	ExecedUpTo            []int32       // instance up to which all commands have been executed (including iteslf)
+	execStatusRPC         uint8
+	InstanceSpace         []*InstanceWindow // the space of all instances that have not been garbage collected yet
+	crtInstance           []int32           // highest active instance numbers that this replica knows about
+	CommittedUpTo         []int32           // highest committed instance per replica that this replica knows about
+	ExecedUpTo            []int32           // instance up to which all commands have been executed (including iteslf)
 	exec                  *Exec
 	conflicts             []map[state.Key]int32
 	smartConflicts        []map[state.Operation]map[state.Key]int32
@@ -85,17 +74,62 @@ type Replica struct {
 	latestCPInstance      int32
 	clientMutex           *sync.Mutex // for synchronizing when sending replies to clients from multiple go-routines
 	instancesToRecover    chan *instanceId
+	unrecovered           []instanceId // our own instances that the log left uncommitted, recovered once run() starts
+	checkpointPeriod      int              // number of commands between checkpoints (0 disables checkpointing)
+	pendingCheckpoint     []int32          // deps of the latest executed barrier, until they have been executed too
+	checkpointChan        chan *checkpoint // snapshots taken by the execution thread
+	latestCheckpoint      *checkpoint
+	batching              BatchConfig
+	batchTarget           int // number of commands per instance in adaptive mode
+	snapshotRequestChan   chan fastrpc.Serializable
+	snapshotChan          chan fastrpc.Serializable
+	snapshotRequestRPC    uint8
+	snapshotRPC           uint8
+	snapshotRequested     int64                      // when we last asked a peer for a snapshot, 0 if we are not waiting for one
+	snapshotsToTake       chan int32                 // replicas waiting for a snapshot from the execution thread
+	snapshotsTaken        chan *takenSnapshot        // snapshots taken by the execution thread for other replicas
+	snapshotsToInstall    chan *epaxosproto.Snapshot // snapshots received from peers, for the execution thread
+	snapshotInstalled     chan *checkpoint
+	leaseRequestChan      chan fastrpc.Serializable
+	leaseGrantChan        chan fastrpc.Serializable
+	leaseAckChan          chan fastrpc.Serializable
+	leaseRequestRPC       uint8
+	leaseGrantRPC         uint8
+	leaseAckRPC           uint8
+	leases                *leaseState // nil without read leases
+	executedChan          chan bool   // signaled by the execution thread, for the reads waiting on it
+	scans                 [][]scanRange // the SCANs of each replica, which smartConflicts cannot index by key
+}
+
+// how client proposals are grouped into instances
+type BatchConfig struct {
+	MaxSize  int           // maximum number of commands per instance
+	Delay    time.Duration // time during which proposals accumulate after an instance is started, 0 to never wait
+	Adaptive bool          // grow the batches up to MaxSize under load, and shrink them when idle
 }
 
 type Instance struct {
 	Cmds           []state.Command
-	ballot         int32
+	ballot         genericsmrproto.Ballot
 	Status         int8
 	Seq            int32
-	Deps           [DS]int32
+	Deps           []int32
 	lb             *LeaderBookkeeping
 	Index, Lowlink int
-	bfilter        *bloomfilter.Bloomfilter
+	bfilter        *batchFilter // owned by the execution thread
+}
+
+// the Bloom filter of the keys of a batch of commands
+type batchFilter struct {
+	cmds []state.Command // the batch the filter was built from
+	bf   *bloomfilter.Bloomfilter
+}
+
+// a range read by a SCAN in an instance
+type scanRange struct {
+	lo, hi   state.Key
+	instance int32
+	seq      int32
 }
 
 type instanceId struct {
@@ -107,20 +141,20 @@ type RecoveryInstance struct {
 	cmds            []state.Command
 	status          int8
 	seq             int32
-	deps            [DS]int32
+	deps            []int32
 	preAcceptCount  int
 	leaderResponded bool
 }
 
 type LeaderBookkeeping struct {
 	clientProposals   []*genericsmr.Propose
-	maxRecvBallot     int32
+	maxRecvBallot     genericsmrproto.Ballot
 	prepareOKs        int
 	allEqual          bool
 	preAcceptOKs      int
 	acceptOKs         int
 	nacks             int
-	originalDeps      [DS]int32
+	originalDeps      []int32
 	committedDeps     []int32
 	recoveryInst      *RecoveryInstance
 	preparing         bool
@@ -129,7 +163,7 @@ type LeaderBookkeeping struct {
 	tpaOKs            int
 }
 
-func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply bool, beacon bool, durable bool, app state.Application) *Replica {
+func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply bool, beacon bool, durable bool, checkpointPeriod int, batching BatchConfig, execWorkers int, readLeases bool, detector genericsmr.FailureDetectorConfig, app state.StateMachine) *Replica {
 	r := &Replica{
 		genericsmr.NewReplica(id, peerAddrList, thrifty, exec, dreply, app),
 		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
@@ -143,10 +177,11 @@ func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply b
 		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE*2),
 		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
 		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-		make([][]*Instance, len(peerAddrList)),
+		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		make([]*InstanceWindow, len(peerAddrList)),
+		make([]int32, len(peerAddrList)),
 		make([]int32, len(peerAddrList)),
-		[DS]int32{-1, -1, -1, -1, -1},
 		make([]int32, len(peerAddrList)),
 		nil,
 		make([]map[state.Key]int32, len(peerAddrList)),
@@ -157,33 +192,67 @@ func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply b
 		0,
 		-1,
 		new(sync.Mutex),
-		make(chan *instanceId, genericsmr.CHAN_BUFFER_SIZE)}
+		make(chan *instanceId, genericsmr.CHAN_BUFFER_SIZE),
+		nil,
+		checkpointPeriod,
+		nil,
+		make(chan *checkpoint, 1),
+		nil,
+		batching,
+		1,
+		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
+		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
+		0, 0,
+		0,
+		make(chan int32, 1),
+		make(chan *takenSnapshot, 1),
+		make(chan *epaxosproto.Snapshot, 1),
+		make(chan *checkpoint),
+		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
+		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
+		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE*3),
+		0, 0, 0,
+		nil,
+		make(chan bool, 1),
+		make([][]scanRange, len(peerAddrList))}
 
 	r.Beacon = beacon
 	r.Durable = durable
+	r.Detector = detector
 
 	for i := 0; i < r.N; i++ {
-		r.InstanceSpace[i] = make([]*Instance, 2*1024*1024)
+		r.InstanceSpace[i] = NewInstanceWindow()
 		r.crtInstance[i] = 0
+		r.CommittedUpTo[i] = -1
 		r.ExecedUpTo[i] = -1
 		r.conflicts[i] = make(map[state.Key]int32, HT_INIT_SIZE)
 		r.smartConflicts[i] = make(map[state.Operation]map[state.Key]int32, HT_INIT_SIZE)
-		for _, op := range ALL_OPS {
+		//every operation in the application's conflict table is tracked
+		for _, op := range r.State.Machine.Conflicts().Ops() {
 			r.smartConflicts[i][op] = make(map[state.Key]int32)
 		}
 	}
 
-	for _, op := range ALL_OPS {
+	for _, op := range r.State.Machine.Conflicts().Ops() {
 		r.maxSeqPerKeyOp[op] = make(map[state.Key]int32)
 	}
 
-	for bf_PT = 1; math.Pow(2, float64(bf_PT))/float64(MAX_BATCH) < BF_M_N; {
+	if r.batching.MaxSize < 1 {
+		r.batching.MaxSize = 1
+	}
+	for bf_PT = 1; math.Pow(2, float64(bf_PT))/float64(r.batching.MaxSize) < BF_M_N; {
 		bf_PT++
 	}
 
-	r.exec = &Exec{r}
+	r.exec = NewExec(r, execWorkers)
+
+	if readLeases {
+		r.leases = newLeaseState(r.N)
+	}
 
-	cpMarker = make([]state.Command, 0)
+	if r.Durable {
+		r.recoverFromStableStore()
+	}
 
 	//register RPCs
 	r.prepareRPC = r.RegisterRPC(new(epaxosproto.Prepare), r.prepareChan)
@@ -197,50 +266,23 @@ func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply b
 	r.commitShortRPC = r.RegisterRPC(new(epaxosproto.CommitShort), r.commitShortChan)
 	r.tryPreAcceptRPC = r.RegisterRPC(new(epaxosproto.TryPreAccept), r.tryPreAcceptChan)
 	r.tryPreAcceptReplyRPC = r.RegisterRPC(new(epaxosproto.TryPreAcceptReply), r.tryPreAcceptReplyChan)
+	r.execStatusRPC = r.RegisterRPC(new(epaxosproto.ExecStatus), r.execStatusChan)
+	r.snapshotRequestRPC = r.RegisterRPC(new(epaxosproto.SnapshotRequest), r.snapshotRequestChan)
+	r.snapshotRPC = r.RegisterRPC(new(epaxosproto.Snapshot), r.snapshotChan)
+	r.leaseRequestRPC = r.RegisterRPC(new(epaxosproto.LeaseRequest), r.leaseRequestChan)
+	r.leaseGrantRPC = r.RegisterRPC(new(epaxosproto.LeaseGrant), r.leaseGrantChan)
+	r.leaseAckRPC = r.RegisterRPC(new(epaxosproto.LeaseAck), r.leaseAckChan)
 	go r.run()
 
 	return r
 }
 
-//append a log entry to stable storage
-func (r *Replica) recordInstanceMetadata(inst *Instance) {
-	if !r.Durable {
-		return
-	}
+/* RPC to be called by master */
 
-	var b [9 + DS*4]byte
-	binary.LittleEndian.PutUint32(b[0:4], uint32(inst.ballot))
-	b[4] = byte(inst.Status)
-	binary.LittleEndian.PutUint32(b[5:9], uint32(inst.Seq))
-	l := 9
-	for _, dep := range inst.Deps {
-		binary.LittleEndian.PutUint32(b[l:l+4], uint32(dep))
-		l += 4
-	}
-	r.StableStore.Write(b[:])
-}
-
-//write a sequence of commands to stable storage
-func (r *Replica) recordCommands(cmds []state.Command) {
-	if !r.Durable {
-		return
-	}
-
-	if cmds == nil {
-		return
-	}
-	for i := 0; i < len(cmds); i++ {
-		cmds[i].Marshal(io.Writer(r.StableStore))
-	}
-}
-
-//sync with the stable store
-func (r *Replica) sync() {
-	if !r.Durable {
-		return
-	}
-
-	r.StableStore.Sync()
+// reports the instances of our own instance space
+func (r *Replica) Ping(args *genericsmrproto.PingArgs, reply *genericsmrproto.PingReply) error {
+	*reply = r.PingStatus.Reply("epaxos")
+	return nil
 }
 
 /* Clock goroutine */
@@ -250,7 +292,7 @@ var slowClockChan chan bool
 
 func (r *Replica) fastClock() {
 	for !r.Shutdown {
-		time.Sleep(5 * 1e6) // 5 ms
+		time.Sleep(r.batching.Delay)
 		fastClockChan <- true
 	}
 }
@@ -292,6 +334,11 @@ var conflicted, weird, slow, happy int
 func (r *Replica) run() {
 	r.ConnectToPeers()
 
+	for _, id := range r.unrecovered {
+		r.startRecoveryForInstance(id.replica, id.instance)
+	}
+	r.unrecovered = nil
+
 	dlog.Println("Waiting for client connections")
 
 	go r.WaitForClientConnections()
@@ -313,8 +360,10 @@ func (r *Replica) run() {
 	fastClockChan = make(chan bool, 1)
 	go r.slowClock()
 
-	//Enabled when batching for 5ms
-	if MAX_BATCH > 100 {
+	r.StartFailureDetector()
+
+	//Enabled when batching for some time
+	if r.batching.Delay > 0 {
 		go r.fastClock()
 	}
 
@@ -325,6 +374,10 @@ func (r *Replica) run() {
 	onOffProposeChan := r.ProposeChan
 
 	for !r.Shutdown {
+		r.PingStatus.Publish(false, r.crtInstance[r.Id])
+		if !r.Exec {
+			r.PingStatus.PublishExecedUpTo(r.ExecedUpTo[r.Id])
+		}
 
 		select {
 
@@ -333,8 +386,10 @@ func (r *Replica) run() {
 			dlog.Printf("Proposal with op %d\n", propose.Command.Op)
 			r.handlePropose(propose)
 			//deactivate new proposals channel to prioritize the handling of other protocol messages,
-			//and to allow commands to accumulate for batching
-			//onOffProposeChan = nil
+			//and to allow commands to accumulate for batching (unless we are idle)
+			if r.batching.Delay > 0 && (!r.batching.Adaptive || r.batchTarget > 1) {
+				onOffProposeChan = nil
+			}
 			break
 
 		case <-fastClockChan:
@@ -417,6 +472,12 @@ func (r *Replica) run() {
 			r.handleTryPreAcceptReply(tryPreAcceptReply)
 			break
 
+		case execStatusS := <-r.execStatusChan:
+			execStatus := execStatusS.(*epaxosproto.ExecStatus)
+			dlog.Printf("Received ExecStatus from replica %d\n", execStatus.ReplicaId)
+			r.handleExecStatus(execStatus)
+			break
+
 		case beacon := <-r.BeaconChan:
 			dlog.Printf("Received Beacon from replica %d with timestamp %d\n", beacon.Rid, beacon.Timestamp)
 			r.ReplyBeacon(beacon)
@@ -431,13 +492,62 @@ func (r *Replica) run() {
 					r.SendBeacon(q)
 				}
 			}
+			r.bcastExecStatus()
+			r.collectGarbage()
+			r.renewLeases()
+			break
+		case <-r.HeartbeatChan:
+			r.SendHeartbeats()
+			for _, q := range r.CheckPeers() {
+				r.recoverInstancesOf(q)
+			}
 			break
+
 		case <-r.OnClientConnect:
 			log.Printf("weird %d; conflicted %d; slow %d; happy %d\n", weird, conflicted, slow, happy)
 			weird, conflicted, slow, happy = 0, 0, 0, 0
 
 		case iid := <-r.instancesToRecover:
 			r.startRecoveryForInstance(iid.replica, iid.instance)
+
+		case cp := <-r.checkpointChan:
+			r.handleCheckpoint(cp)
+
+		case snapshotRequestS := <-r.snapshotRequestChan:
+			snapshotRequest := snapshotRequestS.(*epaxosproto.SnapshotRequest)
+			dlog.Printf("Received SnapshotRequest from replica %d\n", snapshotRequest.ReplicaId)
+			r.handleSnapshotRequest(snapshotRequest)
+			break
+
+		case ts := <-r.snapshotsTaken:
+			r.sendSnapshot(ts)
+
+		case snapshotS := <-r.snapshotChan:
+			snapshot := snapshotS.(*epaxosproto.Snapshot)
+			dlog.Printf("Received Snapshot from replica %d\n", snapshot.ReplicaId)
+			r.handleSnapshot(snapshot)
+			break
+
+		case leaseRequestS := <-r.leaseRequestChan:
+			leaseRequest := leaseRequestS.(*epaxosproto.LeaseRequest)
+			dlog.Printf("Received LeaseRequest from replica %d\n", leaseRequest.HolderId)
+			r.handleLeaseRequest(leaseRequest)
+			break
+
+		case leaseGrantS := <-r.leaseGrantChan:
+			leaseGrant := leaseGrantS.(*epaxosproto.LeaseGrant)
+			dlog.Printf("Received LeaseGrant from replica %d\n", leaseGrant.GrantorId)
+			r.handleLeaseGrant(leaseGrant)
+			break
+
+		case leaseAckS := <-r.leaseAckChan:
+			leaseAck := leaseAckS.(*epaxosproto.LeaseAck)
+			dlog.Printf("Received LeaseAck for instance %d.%d\n", leaseAck.Replica, leaseAck.Instance)
+			r.handleLeaseAck(leaseAck)
+			break
+
+		case <-r.executedChan:
+			r.serveLeaseReads()
 		}
 	}
 }
@@ -447,51 +557,86 @@ func (r *Replica) run() {
 ************************************/
 
 func (r *Replica) executeCommands() {
-	const SLEEP_TIME_NS = 1e6
 	problemInstance := make([]int32, r.N)
-	timeout := make([]uint64, r.N)
+	problemSince := make([]time.Time, r.N)
 	for q := 0; q < r.N; q++ {
 		problemInstance[q] = -1
-		timeout[q] = 0
 	}
+	//wake up every now and then to start the recovery of instances that
+	//are not committed in time
+	ticker := time.NewTicker(COMMIT_GRACE_PERIOD / 10)
+	defer ticker.Stop()
+
+	r.exec.startWorkers()
 
+	executed := true
 	for !r.Shutdown {
-		executed := false
+		if !executed {
+			r.exec.wait(ticker.C)
+		}
+
+		select {
+		case snap := <-r.snapshotsToInstall:
+			r.snapshotInstalled <- r.installSnapshot(snap)
+			for q := 0; q < r.N; q++ {
+				problemInstance[q] = -1
+			}
+		case requester := <-r.snapshotsToTake:
+			r.snapshotsTaken <- &takenSnapshot{requester, r.takeSnapshot()}
+		default:
+		}
+
+		executed = false
+		r.exec.blocked = make(map[instanceId]bool)
 		for q := 0; q < r.N; q++ {
+			r.exec.scanned[q] = r.crtInstance[q]
 			inst := int32(0)
 			for inst = r.ExecedUpTo[q] + 1; inst < r.crtInstance[q]; inst++ {
-				if r.InstanceSpace[q][inst] != nil && r.InstanceSpace[q][inst].Status == epaxosproto.EXECUTED {
+				if r.InstanceSpace[q].Get(inst) != nil && r.InstanceSpace[q].Get(inst).Status == epaxosproto.EXECUTED {
 					if inst == r.ExecedUpTo[q]+1 {
 						r.ExecedUpTo[q] = inst
 					}
 					continue
 				}
-				if r.InstanceSpace[q][inst] == nil || r.InstanceSpace[q][inst].Status != epaxosproto.COMMITTED {
+				if r.InstanceSpace[q].Get(inst) == nil || r.InstanceSpace[q].Get(inst).Status != epaxosproto.COMMITTED {
+					r.exec.blockOn(int32(q), inst)
+					grace := time.Duration(COMMIT_GRACE_PERIOD)
+					if !r.Alive[q] && r.Detector.SuspectTimeout > 0 {
+						//no point in waiting for a leader that has failed
+						grace = r.Detector.SuspectTimeout
+					}
 					if inst == problemInstance[q] {
-						timeout[q] += SLEEP_TIME_NS
-						if timeout[q] >= COMMIT_GRACE_PERIOD {
+						if time.Since(problemSince[q]) >= grace {
 							r.instancesToRecover <- &instanceId{int32(q), inst}
-							timeout[q] = 0
+							problemSince[q] = time.Now()
 						}
 					} else {
 						problemInstance[q] = inst
-						timeout[q] = 0
+						problemSince[q] = time.Now()
 					}
-					if r.InstanceSpace[q][inst] == nil {
+					if r.InstanceSpace[q].Get(inst) == nil {
 						continue
 					}
 					break
 				}
 				if ok := r.exec.executeCommand(int32(q), inst); ok {
 					executed = true
-					if inst == r.ExecedUpTo[q]+1 {
+					//with workers, the instance may still be running
+					if inst == r.ExecedUpTo[q]+1 && r.InstanceSpace[q].Get(inst).Status == epaxosproto.EXECUTED {
 						r.ExecedUpTo[q] = inst
 					}
 				}
 			}
 		}
-		if !executed {
-			time.Sleep(SLEEP_TIME_NS)
+		if r.pendingCheckpoint != nil {
+			r.tryCheckpoint()
+		}
+		r.PingStatus.PublishExecedUpTo(r.ExecedUpTo[r.Id])
+		if r.leases != nil {
+			select {
+			case r.executedChan <- true:
+			default:
+			}
 		}
 		//log.Println(r.ExecedUpTo, " ", r.crtInstance)
 	}
@@ -499,20 +644,20 @@ func (r *Replica) executeCommands() {
 
 /* Ballot helper functions */
 
-func (r *Replica) makeUniqueBallot(ballot int32) int32 {
-	return (ballot << 4) | r.Id
+func (r *Replica) makeUniqueBallot(counter int32) genericsmrproto.Ballot {
+	return genericsmrproto.Ballot{0, counter, r.Id}
 }
 
-func (r *Replica) makeBallotLargerThan(ballot int32) int32 {
-	return r.makeUniqueBallot((ballot >> 4) + 1)
+func (r *Replica) makeBallotLargerThan(ballot genericsmrproto.Ballot) genericsmrproto.Ballot {
+	return ballot.NextFor(r.Id)
 }
 
-func isInitialBallot(ballot int32) bool {
-	return (ballot >> 4) == 0
+func isInitialBallot(ballot genericsmrproto.Ballot) bool {
+	return ballot.IsInitial()
 }
 
-func replicaIdFromBallot(ballot int32) int32 {
-	return ballot & 15
+func replicaIdFromBallot(ballot genericsmrproto.Ballot) int32 {
+	return ballot.ReplicaId
 }
 
 /**********************************************************************
@@ -535,7 +680,7 @@ func (r *Replica) replyTryPreAccept(replicaId int32, reply *epaxosproto.TryPreAc
 	r.SendMsg(replicaId, r.tryPreAcceptReplyRPC, reply)
 }
 
-func (r *Replica) bcastPrepare(replica int32, instance int32, ballot int32) {
+func (r *Replica) bcastPrepare(replica int32, instance int32, ballot genericsmrproto.Ballot) {
 	defer func() {
 		if err := recover(); err != nil {
 			dlog.Println("Prepare bcast failed:", err)
@@ -564,7 +709,7 @@ func (r *Replica) bcastPrepare(replica int32, instance int32, ballot int32) {
 
 var pa epaxosproto.PreAccept
 
-func (r *Replica) bcastPreAccept(replica int32, instance int32, ballot int32, cmds []state.Command, seq int32, deps [DS]int32) {
+func (r *Replica) bcastPreAccept(replica int32, instance int32, ballot genericsmrproto.Ballot, cmds []state.Command, seq int32, deps []int32) {
 	defer func() {
 		if err := recover(); err != nil {
 			dlog.Println("PreAccept bcast failed:", err)
@@ -589,17 +734,21 @@ func (r *Replica) bcastPreAccept(replica int32, instance int32, ballot int32, cm
 		if !r.Alive[r.PreferredPeerOrder[q]] {
 			continue
 		}
-		r.SendMsg(r.PreferredPeerOrder[q], r.preAcceptRPC, args)
-		sent++
 		if sent >= n {
-			break
+			//the lease holders must learn about every instance
+			if r.isLeaseHolder(r.PreferredPeerOrder[q]) {
+				r.SendMsg(r.PreferredPeerOrder[q], r.preAcceptRPC, args)
+			}
+			continue
 		}
+		r.SendMsg(r.PreferredPeerOrder[q], r.preAcceptRPC, args)
+		sent++
 	}
 }
 
 var tpa epaxosproto.TryPreAccept
 
-func (r *Replica) bcastTryPreAccept(replica int32, instance int32, ballot int32, cmds []state.Command, seq int32, deps [DS]int32) {
+func (r *Replica) bcastTryPreAccept(replica int32, instance int32, ballot genericsmrproto.Ballot, cmds []state.Command, seq int32, deps []int32) {
 	defer func() {
 		if err := recover(); err != nil {
 			dlog.Println("PreAccept bcast failed:", err)
@@ -627,7 +776,7 @@ func (r *Replica) bcastTryPreAccept(replica int32, instance int32, ballot int32,
 
 var ea epaxosproto.Accept
 
-func (r *Replica) bcastAccept(replica int32, instance int32, ballot int32, count int32, seq int32, deps [DS]int32) {
+func (r *Replica) bcastAccept(replica int32, instance int32, ballot genericsmrproto.Ballot, count int32, seq int32, deps []int32) {
 	defer func() {
 		if err := recover(); err != nil {
 			dlog.Println("Accept bcast failed:", err)
@@ -664,7 +813,7 @@ func (r *Replica) bcastAccept(replica int32, instance int32, ballot int32, count
 var ec epaxosproto.Commit
 var ecs epaxosproto.CommitShort
 
-func (r *Replica) bcastCommit(replica int32, instance int32, cmds []state.Command, seq int32, deps [DS]int32) {
+func (r *Replica) bcastCommit(replica int32, instance int32, cmds []state.Command, seq int32, deps []int32) {
 	defer func() {
 		if err := recover(); err != nil {
 			dlog.Println("Commit bcast failed:", err)
@@ -707,97 +856,154 @@ func (r *Replica) clearHashtables() {
 	for q := 0; q < r.N; q++ {
 		r.conflicts[q] = make(map[state.Key]int32, HT_INIT_SIZE)
 		r.smartConflicts[q] = make(map[state.Operation]map[state.Key]int32, HT_INIT_SIZE)
-		for _, op := range ALL_OPS {
+		for _, op := range r.State.Machine.Conflicts().Ops() {
 			r.smartConflicts[q][op] = make(map[state.Key]int32)
 		}
+		r.scans[q] = nil
 	}
 }
 
+// called every time an instance gets committed
 func (r *Replica) updateCommitted(replica int32) {
-	for r.InstanceSpace[replica][r.CommittedUpTo[replica]+1] != nil &&
-		(r.InstanceSpace[replica][r.CommittedUpTo[replica]+1].Status == epaxosproto.COMMITTED ||
-			r.InstanceSpace[replica][r.CommittedUpTo[replica]+1].Status == epaxosproto.EXECUTED) {
+	for r.InstanceSpace[replica].Get(r.CommittedUpTo[replica]+1) != nil &&
+		(r.InstanceSpace[replica].Get(r.CommittedUpTo[replica]+1).Status == epaxosproto.COMMITTED ||
+			r.InstanceSpace[replica].Get(r.CommittedUpTo[replica]+1).Status == epaxosproto.EXECUTED) {
 		r.CommittedUpTo[replica] = r.CommittedUpTo[replica] + 1
 	}
+	r.exec.notify()
 }
 
 func (r *Replica) updateSmartConflicts(cmds []state.Command, replica int32, instance int32, seq int32) {
 	for i := 0; i < len(cmds); i++ {
-		cmd := cmds[i]
-		if d, keyPresent := r.smartConflicts[replica][cmd.Op][cmd.K]; keyPresent {
-			if d < instance {
-				r.smartConflicts[replica][cmd.Op][cmd.K] = instance
-			}
-		} else {
-			r.smartConflicts[replica][cmd.Op][cmd.K] = instance
+		cmd := &cmds[i]
+		if cmd.Op == state.SCAN {
+			r.addScan(cmd, replica, instance, seq)
+			continue
 		}
-		
+		conflicts, indexed := r.smartConflicts[replica][cmd.Op]
+		if !indexed {
+			//the operation is not in the application's conflict table, so nothing conflicts with it
+			continue
+		}
+		for j := 0; j < cmd.KeyCount(); j++ {
+			k := cmd.KeyAt(j)
+			if d, keyPresent := conflicts[k]; keyPresent {
+				if d < instance {
+					conflicts[k] = instance
+				}
+			} else {
+				conflicts[k] = instance
+			}
 
-		if s, present := r.maxSeqPerKeyOp[cmds[i].Op][cmds[i].K]; present {
-			if s < seq {
-				r.maxSeqPerKeyOp[cmds[i].Op][cmds[i].K] = seq
+			if s, present := r.maxSeqPerKeyOp[cmd.Op][k]; present {
+				if s < seq {
+					r.maxSeqPerKeyOp[cmd.Op][k] = seq
+				}
+			} else {
+				r.maxSeqPerKeyOp[cmd.Op][k] = seq
 			}
-		} else {
-			r.maxSeqPerKeyOp[cmds[i].Op][cmds[i].K] = seq
 		}
 	}
 }
 
 func (r *Replica) updateConflicts(cmds []state.Command, replica int32, instance int32, seq int32) {
 	for i := 0; i < len(cmds); i++ {
-		if d, present := r.conflicts[replica][cmds[i].K]; present {
-			if d < instance {
-				r.conflicts[replica][cmds[i].K] = instance
+		for j := 0; j < cmds[i].KeyCount(); j++ {
+			k := cmds[i].KeyAt(j)
+			if d, present := r.conflicts[replica][k]; present {
+				if d < instance {
+					r.conflicts[replica][k] = instance
+				}
+			} else {
+				r.conflicts[replica][k] = instance
 			}
-		} else {
-			r.conflicts[replica][cmds[i].K] = instance
-		}
-		if s, present := r.maxSeqPerKey[cmds[i].K]; present {
-			if s < seq {
-				r.maxSeqPerKey[cmds[i].K] = seq
+			if s, present := r.maxSeqPerKey[k]; present {
+				if s < seq {
+					r.maxSeqPerKey[k] = seq
+				}
+			} else {
+				r.maxSeqPerKey[k] = seq
 			}
-		} else {
-			r.maxSeqPerKey[cmds[i].K] = seq
 		}
 	}
 }
 
-func (r *Replica) updateAttributes(cmds []state.Command, seq int32, deps [DS]int32, replica int32, instance int32) (int32, [DS]int32, bool) {
+func (r *Replica) updateAttributes(cmds []state.Command, seq int32, deps []int32, replica int32, instance int32) (int32, []int32, bool) {
 	changed := false
 	for q := 0; q < r.N; q++ {
 		if r.Id != replica && int32(q) == replica {
 			continue
 		}
 		for i := 0; i < len(cmds); i++ {
-			cmd := cmds[i]
+			cmd := &cmds[i]
 			for opType, ops := range r.smartConflicts[q] {
-				if !state.OP_CONFLICT_FUNC(cmd.Op, opType) {
+				if !r.State.OperationConflict(cmd.Op, opType) {
 					continue
 				}
 
-				if d, present := ops[cmd.K]; present {
-					if d > deps[q] {
-						deps[q] = d
-						if seq <= r.InstanceSpace[q][d].Seq {
-							seq = r.InstanceSpace[q][d].Seq + 1
+				if cmd.Op == state.SCAN {
+					//a SCAN depends on the latest conflicting instance for any key in its range
+					lo, hi := cmd.ScanRange()
+					for k, d := range ops {
+						if k >= lo && k < hi && d > deps[q] {
+							seq = r.addDep(deps, q, d, seq)
+							changed = true
+						}
+					}
+					continue
+				}
+
+				//a multi-key command depends on the latest conflicting instance for any of its keys
+				for j := 0; j < cmd.KeyCount(); j++ {
+					if d, present := ops[cmd.KeyAt(j)]; present {
+						if d > deps[q] {
+							seq = r.addDep(deps, q, d, seq)
+							changed = true
 						}
-						changed = true
 					}
 				}
 			}
+
+			//the SCANs are not in maxSeqPerKeyOp, so their seq is accounted for here
+			for _, sr := range r.scans[q] {
+				if !r.State.OperationConflict(cmd.Op, state.SCAN) || !cmd.TouchesRange(sr.lo, sr.hi) {
+					continue
+				}
+				if sr.instance > deps[q] {
+					deps[q] = sr.instance
+					changed = true
+				}
+				if seq <= sr.seq {
+					seq = sr.seq + 1
+					changed = true
+				}
+			}
 		}
 	}
 
 	for i := 0; i < len(cmds); i++ {
 		for opType, ops := range r.maxSeqPerKeyOp {
-			if !state.OP_CONFLICT_FUNC(opType, cmds[i].Op) {
+			if !r.State.OperationConflict(opType, cmds[i].Op) {
 				continue
 			}
 
-			if s, present := ops[cmds[i].K]; present {
-				if seq <= s {
-					changed = true
-					seq = s + 1
+			if cmds[i].Op == state.SCAN {
+				lo, hi := cmds[i].ScanRange()
+				for k, s := range ops {
+					if k >= lo && k < hi && seq <= s {
+						changed = true
+						seq = s + 1
+					}
+				}
+				continue
+			}
+
+			for j := 0; j < cmds[i].KeyCount(); j++ {
+				if s, present := ops[cmds[i].KeyAt(j)]; present {
+					if seq <= s {
+						changed = true
+						seq = s + 1
+					}
 				}
 			}
 		}
@@ -807,7 +1013,55 @@ func (r *Replica) updateAttributes(cmds []state.Command, seq int32, deps [DS]int
 	return seq, deps, changed
 }
 
-func (r *Replica) mergeAttributes(seq1 int32, deps1 [DS]int32, seq2 int32, deps2 [DS]int32) (int32, [DS]int32, bool) {
+func (r *Replica) addDep(deps []int32, q int, d int32, seq int32) int32 {
+	deps[q] = d
+	// garbage collected instances are still accounted for in maxSeqPerKeyOp
+	if dinst := r.InstanceSpace[q].Get(d); dinst != nil && seq <= dinst.Seq {
+		seq = dinst.Seq + 1
+	}
+	return seq
+}
+
+// Records the range of a SCAN. SCANs are kept in a list rather than indexed by
+// key, and the ones every replica has executed are dropped, since nothing can
+// be ordered before them any more. The ones only this replica has executed
+// must still be depended on, but they are folded into a single entry that
+// covers all their ranges, with the highest of their instances and seqs. This
+// only adds dependencies on executed instances, and keeps the list as short
+// as the number of SCANs still being agreed on or waiting for execution.
+func (r *Replica) addScan(cmd *state.Command, replica int32, instance int32, seq int32) {
+	var folded *scanRange
+	scans := r.scans[replica][:0]
+	for _, sr := range r.scans[replica] {
+		if sr.instance == instance || r.InstanceSpace[replica].Forgotten(sr.instance) {
+			continue
+		}
+		if inst := r.InstanceSpace[replica].Get(sr.instance); inst == nil || inst.Status != epaxosproto.EXECUTED {
+			scans = append(scans, sr)
+			continue
+		}
+		if sr.lo >= sr.hi {
+			//an empty range conflicts with nothing
+			continue
+		}
+		if folded == nil {
+			f := sr
+			folded = &f
+			continue
+		}
+		folded.lo = min(folded.lo, sr.lo)
+		folded.hi = max(folded.hi, sr.hi)
+		folded.instance = max(folded.instance, sr.instance)
+		folded.seq = max(folded.seq, sr.seq)
+	}
+	if folded != nil {
+		scans = append(scans, *folded)
+	}
+	lo, hi := cmd.ScanRange()
+	r.scans[replica] = append(scans, scanRange{lo, hi, instance, seq})
+}
+
+func (r *Replica) mergeAttributes(seq1 int32, deps1 []int32, seq2 int32, deps2 []int32) (int32, []int32, bool) {
 	equal := true
 	for q := 0; q < r.N; q++ {
 		if int32(q) == r.Id {
@@ -831,7 +1085,28 @@ func (r *Replica) mergeAttributes(seq1 int32, deps1 [DS]int32, seq2 int32, deps2
 	return seq1, deps1, equal
 }
 
-func equal(deps1 *[DS]int32, deps2 *[DS]int32) bool {
+// a dependency vector with one entry per replica, all set to val
+func (r *Replica) makeDeps(val int32) []int32 {
+	deps := make([]int32, r.N)
+	for q := 0; q < r.N; q++ {
+		deps[q] = val
+	}
+	return deps
+}
+
+func copyDeps(deps []int32) []int32 {
+	if deps == nil {
+		return nil
+	}
+	c := make([]int32, len(deps))
+	copy(c, deps)
+	return c
+}
+
+func equal(deps1 []int32, deps2 []int32) bool {
+	if len(deps1) != len(deps2) {
+		return false
+	}
 	for i := 0; i < len(deps1); i++ {
 		if deps1[i] != deps2[i] {
 			return false
@@ -840,6 +1115,8 @@ func equal(deps1 *[DS]int32, deps2 *[DS]int32) bool {
 	return true
 }
 
+// Returns nil for a batch with a SCAN, since the filter cannot hold a range
+// of keys.
 func bfFromCommands(cmds []state.Command) *bloomfilter.Bloomfilter {
 	if cmds == nil {
 		return nil
@@ -848,12 +1125,36 @@ func bfFromCommands(cmds []state.Command) *bloomfilter.Bloomfilter {
 	bf := bloomfilter.NewPowTwo(bf_PT, BF_K)
 
 	for i := 0; i < len(cmds); i++ {
-		bf.AddUint64(uint64(cmds[i].K))
+		if cmds[i].Op == state.SCAN {
+			return nil
+		}
+		for j := 0; j < cmds[i].KeyCount(); j++ {
+			bf.AddString(string(cmds[i].KeyAt(j)))
+		}
 	}
 
 	return bf
 }
 
+// Tells whether the commands may touch a key of the batch that bf was built
+// from. Only the batches for which it does need an exact conflict check.
+func bfMayTouch(bf *bloomfilter.Bloomfilter, cmds []state.Command) bool {
+	if bf == nil {
+		return true
+	}
+	for i := 0; i < len(cmds); i++ {
+		if cmds[i].Op == state.SCAN {
+			return true
+		}
+		for j := 0; j < cmds[i].KeyCount(); j++ {
+			if bf.CheckString(string(cmds[i].KeyAt(j))) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 /**********************************************************************
 
                             PHASE 1
@@ -861,13 +1162,15 @@ func bfFromCommands(cmds []state.Command) *bloomfilter.Bloomfilter {
 ***********************************************************************/
 
 func (r *Replica) handlePropose(propose *genericsmr.Propose) {
-	//TODO!! Handle client retries
-
-	batchSize := len(r.ProposeChan) + 1
-	if batchSize > MAX_BATCH {
-		batchSize = MAX_BATCH
+	if propose.Command.Op == state.FAST_READ && r.readWithLease(propose) {
+		return
 	}
 
+	//client retries are proposed again, the client's session in the
+	//state machine keeps them from being applied twice
+
+	batchSize := r.nextBatchSize()
+
 	instNo := r.crtInstance[r.Id]
 	r.crtInstance[r.Id]++
 
@@ -884,27 +1187,48 @@ func (r *Replica) handlePropose(propose *genericsmr.Propose) {
 		proposals[i] = prop
 	}
 
-	r.startPhase1(r.Id, instNo, 0, proposals, cmds, batchSize)
+	r.startPhase1(r.Id, instNo, r.makeUniqueBallot(0), proposals, cmds, batchSize)
+}
+
+// Returns the number of proposals to put in the next instance. In adaptive
+// mode, the batch size doubles every time more proposals are queued than fit
+// in a batch, and halves when they would fit in half of one, down to 1 when
+// there is a single proposal at a time.
+func (r *Replica) nextBatchSize() int {
+	queued := len(r.ProposeChan) + 1
+	limit := r.batching.MaxSize
+	if r.batching.Adaptive {
+		limit = r.batchTarget
+		if queued > r.batchTarget && r.batchTarget < r.batching.MaxSize {
+			r.batchTarget *= 2
+			if r.batchTarget > r.batching.MaxSize {
+				r.batchTarget = r.batching.MaxSize
+			}
+		} else if queued <= r.batchTarget/2 {
+			r.batchTarget /= 2
+		}
+	}
+	if queued > limit {
+		return limit
+	}
+	return queued
 }
 
-func (r *Replica) startPhase1(replica int32, instance int32, ballot int32, proposals []*genericsmr.Propose, cmds []state.Command, batchSize int) {
+func (r *Replica) startPhase1(replica int32, instance int32, ballot genericsmrproto.Ballot, proposals []*genericsmr.Propose, cmds []state.Command, batchSize int) {
 	//init command attributes
 
 	seq := int32(0)
-	var deps [DS]int32
-	for q := 0; q < r.N; q++ {
-		deps[q] = -1
-	}
+	deps := r.makeDeps(-1)
 
 	seq, deps, _ = r.updateAttributes(cmds, seq, deps, replica, instance)
-	r.InstanceSpace[r.Id][instance] = &Instance{
+	r.InstanceSpace[r.Id].Set(instance, &Instance{
 		cmds,
 		ballot,
 		epaxosproto.PREACCEPTED,
 		seq,
 		deps,
-		&LeaderBookkeeping{proposals, 0, 0, true, 0, 0, 0, deps, []int32{-1, -1, -1, -1, -1}, nil, false, false, nil, 0}, 0, 0,
-		nil}
+		&LeaderBookkeeping{proposals, genericsmrproto.NilBallot, 0, true, 0, 0, 0, copyDeps(deps), r.makeDeps(-1), nil, false, false, nil, 0}, 0, 0,
+		nil})
 
 	// r.updateConflicts(cmds, r.Id, instance, seq)
 	r.updateSmartConflicts(cmds, r.Id, instance, seq)
@@ -912,15 +1236,15 @@ func (r *Replica) startPhase1(replica int32, instance int32, ballot int32, propo
 		r.maxSeq = seq + 1
 	}
 
-	r.recordInstanceMetadata(r.InstanceSpace[r.Id][instance])
-	r.recordCommands(cmds)
+	r.recordInstanceMetadata(r.Id, instance, r.InstanceSpace[r.Id].Get(instance))
+	r.recordCommands(r.Id, instance, cmds)
 	r.sync()
 
 	r.bcastPreAccept(r.Id, instance, ballot, cmds, seq, deps)
 
 	cpcounter += batchSize
 
-	if r.Id == 0 && DO_CHECKPOINTING && cpcounter >= CHECKPOINT_PERIOD {
+	if r.Id == 0 && r.checkpointPeriod > 0 && cpcounter >= r.checkpointPeriod {
 		cpcounter = 0
 
 		//Propose a checkpoint command to act like a barrier.
@@ -929,20 +1253,21 @@ func (r *Replica) startPhase1(replica int32, instance int32, ballot int32, propo
 		instance++
 
 		r.maxSeq++
+		deps = make([]int32, r.N)
 		for q := 0; q < r.N; q++ {
 			deps[q] = r.crtInstance[q] - 1
 		}
 
-		r.InstanceSpace[r.Id][instance] = &Instance{
+		r.InstanceSpace[r.Id].Set(instance, &Instance{
 			cpMarker,
-			0,
+			r.makeUniqueBallot(0),
 			epaxosproto.PREACCEPTED,
 			r.maxSeq,
 			deps,
-			&LeaderBookkeeping{nil, 0, 0, true, 0, 0, 0, deps, nil, nil, false, false, nil, 0},
+			&LeaderBookkeeping{nil, genericsmrproto.NilBallot, 0, true, 0, 0, 0, copyDeps(deps), r.makeDeps(-1), nil, false, false, nil, 0},
 			0,
 			0,
-			nil}
+			nil})
 
 		r.latestCPReplica = r.Id
 		r.latestCPInstance = instance
@@ -950,15 +1275,19 @@ func (r *Replica) startPhase1(replica int32, instance int32, ballot int32, propo
 		//discard dependency hashtables
 		r.clearHashtables()
 
-		r.recordInstanceMetadata(r.InstanceSpace[r.Id][instance])
+		r.recordInstanceMetadata(r.Id, instance, r.InstanceSpace[r.Id].Get(instance))
+		r.recordCommands(r.Id, instance, cpMarker)
 		r.sync()
 
-		r.bcastPreAccept(r.Id, instance, 0, cpMarker, r.maxSeq, deps)
+		r.bcastPreAccept(r.Id, instance, r.makeUniqueBallot(0), cpMarker, r.maxSeq, deps)
 	}
 }
 
 func (r *Replica) handlePreAccept(preAccept *epaxosproto.PreAccept) {
-	inst := r.InstanceSpace[preAccept.LeaderId][preAccept.Instance]
+	if r.InstanceSpace[preAccept.Replica].Forgotten(preAccept.Instance) {
+		return
+	}
+	inst := r.InstanceSpace[preAccept.LeaderId].Get(preAccept.Instance)
 
 	if preAccept.Seq >= r.maxSeq {
 		r.maxSeq = preAccept.Seq + 1
@@ -967,13 +1296,17 @@ func (r *Replica) handlePreAccept(preAccept *epaxosproto.PreAccept) {
 	if inst != nil && (inst.Status == epaxosproto.COMMITTED || inst.Status == epaxosproto.ACCEPTED) {
 		//reordered handling of commit/accept and pre-accept
 		if inst.Cmds == nil {
-			r.InstanceSpace[preAccept.LeaderId][preAccept.Instance].Cmds = preAccept.Command
+			r.InstanceSpace[preAccept.LeaderId].Get(preAccept.Instance).Cmds = preAccept.Command
+			r.exec.notify()
 			// r.updateConflicts(preAccept.Command, preAccept.Replica, preAccept.Instance, preAccept.Seq)
 			r.updateSmartConflicts(preAccept.Command, preAccept.Replica, preAccept.Instance, preAccept.Seq)
 			//r.InstanceSpace[preAccept.LeaderId][preAccept.Instance].bfilter = bfFromCommands(preAccept.Command)
 		}
-		r.recordCommands(preAccept.Command)
+		r.recordCommands(preAccept.Replica, preAccept.Instance, preAccept.Command)
 		r.sync()
+		if inst.Status == epaxosproto.ACCEPTED {
+			r.sendLeaseAck(preAccept.LeaderId, preAccept.Replica, preAccept.Instance)
+		}
 		return
 	}
 
@@ -996,7 +1329,7 @@ func (r *Replica) handlePreAccept(preAccept *epaxosproto.PreAccept) {
 	}
 
 	if inst != nil {
-		if preAccept.Ballot < inst.ballot {
+		if preAccept.Ballot.LessThan(inst.ballot) {
 			r.replyPreAccept(preAccept.LeaderId,
 				&epaxosproto.PreAcceptReply{
 					preAccept.Replica,
@@ -1015,24 +1348,24 @@ func (r *Replica) handlePreAccept(preAccept *epaxosproto.PreAccept) {
 			inst.Status = status
 		}
 	} else {
-		r.InstanceSpace[preAccept.Replica][preAccept.Instance] = &Instance{
+		r.InstanceSpace[preAccept.Replica].Set(preAccept.Instance, &Instance{
 			preAccept.Command,
 			preAccept.Ballot,
 			status,
 			seq,
 			deps,
 			nil, 0, 0,
-			nil}
+			nil})
 	}
 
 	// r.updateConflicts(preAccept.Command, preAccept.Replica, preAccept.Instance, preAccept.Seq)
 	r.updateSmartConflicts(preAccept.Command, preAccept.Replica, preAccept.Instance, preAccept.Seq)
 
-	r.recordInstanceMetadata(r.InstanceSpace[preAccept.Replica][preAccept.Instance])
-	r.recordCommands(preAccept.Command)
+	r.recordInstanceMetadata(preAccept.Replica, preAccept.Instance, r.InstanceSpace[preAccept.Replica].Get(preAccept.Instance))
+	r.recordCommands(preAccept.Replica, preAccept.Instance, preAccept.Command)
 	r.sync()
 
-	if len(preAccept.Command) == 0 {
+	if isCheckpoint(preAccept.Command) {
 		//checkpoint
 		//update latest checkpoint info
 		r.latestCPReplica = preAccept.Replica
@@ -1056,19 +1389,28 @@ func (r *Replica) handlePreAccept(preAccept *epaxosproto.PreAccept) {
 		pok := &epaxosproto.PreAcceptOK{preAccept.Instance}
 		r.SendMsg(preAccept.LeaderId, r.preAcceptOKRPC, pok)
 	}
+	r.sendLeaseAck(preAccept.LeaderId, preAccept.Replica, preAccept.Instance)
 
 	dlog.Printf("I've replied to the PreAccept\n")
 }
 
 func (r *Replica) handlePreAcceptReply(pareply *epaxosproto.PreAcceptReply) {
 	dlog.Printf("Handling PreAccept reply\n")
-	inst := r.InstanceSpace[pareply.Replica][pareply.Instance]
+	if r.InstanceSpace[pareply.Replica].Forgotten(pareply.Instance) {
+		return
+	}
+	inst := r.InstanceSpace[pareply.Replica].Get(pareply.Instance)
 
 	if inst.Status != epaxosproto.PREACCEPTED {
 		// we've moved on, this is a delayed reply
 		return
 	}
 
+	if r.commitPending(pareply.Replica, pareply.Instance) {
+		// decided already, waiting for the lease holders
+		return
+	}
+
 	if inst.ballot != pareply.Ballot {
 		return
 	}
@@ -1076,7 +1418,7 @@ func (r *Replica) handlePreAcceptReply(pareply *epaxosproto.PreAcceptReply) {
 	if pareply.OK == FALSE {
 		// TODO: there is probably another active leader
 		inst.lb.nacks++
-		if pareply.Ballot > inst.lb.maxRecvBallot {
+		if pareply.Ballot.GreaterThan(inst.lb.maxRecvBallot) {
 			inst.lb.maxRecvBallot = pareply.Ballot
 		}
 		if inst.lb.nacks >= r.N/2 {
@@ -1113,27 +1455,7 @@ func (r *Replica) handlePreAcceptReply(pareply *epaxosproto.PreAcceptReply) {
 	if inst.lb.preAcceptOKs >= r.N/2 && inst.lb.allEqual && allCommitted && isInitialBallot(inst.ballot) {
 		happy++
 		dlog.Printf("Fast path for instance %d.%d\n", pareply.Replica, pareply.Instance)
-		r.InstanceSpace[pareply.Replica][pareply.Instance].Status = epaxosproto.COMMITTED
-		r.updateCommitted(pareply.Replica)
-		if inst.lb.clientProposals != nil && !r.Dreply {
-			// give clients the all clear
-			for i := 0; i < len(inst.lb.clientProposals); i++ {
-				var delt int64 = 0;//time.Now().UnixNano() - r.startTimes[inst.lb.clientProposals[i].CommandId]
-				r.ReplyProposeTS(
-					&genericsmrproto.ProposeReplyTS{
-						TRUE,
-						inst.lb.clientProposals[i].CommandId,
-						state.NIL,
-						delt},
-					inst.lb.clientProposals[i].Reply)
-				//log.Printf("FP: Decided command %d in %fms\n", inst.lb.clientProposals[i].CommandId, float64(delt) / 1000000.0)
-			}
-		}
-
-		r.recordInstanceMetadata(inst)
-		r.sync() //is this necessary here?
-
-		r.bcastCommit(pareply.Replica, pareply.Instance, inst.Cmds, inst.Seq, inst.Deps)
+		r.commitAsLeader(pareply.Replica, pareply.Instance)
 	} else if inst.lb.preAcceptOKs >= r.N/2 {
 		if !allCommitted {
 			weird++
@@ -1147,13 +1469,21 @@ func (r *Replica) handlePreAcceptReply(pareply *epaxosproto.PreAcceptReply) {
 
 func (r *Replica) handlePreAcceptOK(pareply *epaxosproto.PreAcceptOK) {
 	dlog.Printf("Handling PreAccept reply\n")
-	inst := r.InstanceSpace[r.Id][pareply.Instance]
+	if r.InstanceSpace[r.Id].Forgotten(pareply.Instance) {
+		return
+	}
+	inst := r.InstanceSpace[r.Id].Get(pareply.Instance)
 
 	if inst.Status != epaxosproto.PREACCEPTED {
 		// we've moved on, this is a delayed reply
 		return
 	}
 
+	if r.commitPending(r.Id, pareply.Instance) {
+		// decided already, waiting for the lease holders
+		return
+	}
+
 	if !isInitialBallot(inst.ballot) {
 		return
 	}
@@ -1176,27 +1506,7 @@ func (r *Replica) handlePreAcceptOK(pareply *epaxosproto.PreAcceptOK) {
 	//can we commit on the fast path?
 	if inst.lb.preAcceptOKs >= r.N/2 && inst.lb.allEqual && allCommitted && isInitialBallot(inst.ballot) {
 		happy++
-		r.InstanceSpace[r.Id][pareply.Instance].Status = epaxosproto.COMMITTED
-		r.updateCommitted(r.Id)
-		if inst.lb.clientProposals != nil && !r.Dreply {
-			// give clients the all clear
-			for i := 0; i < len(inst.lb.clientProposals); i++ {
-				var delt int64 = 0;//time.Now().UnixNano() - r.startTimes[inst.lb.clientProposals[i].CommandId]
-				r.ReplyProposeTS(
-					&genericsmrproto.ProposeReplyTS{
-						TRUE,
-						inst.lb.clientProposals[i].CommandId,
-						state.NIL,
-						delt},
-					inst.lb.clientProposals[i].Reply)
-				//log.Printf("FP: Decided command %d in %fms\n", inst.lb.clientProposals[i].CommandId, float64(delt) / 1000000.0)
-			}
-		}
-
-		r.recordInstanceMetadata(inst)
-		r.sync() //is this necessary here?
-
-		r.bcastCommit(r.Id, pareply.Instance, inst.Cmds, inst.Seq, inst.Deps)
+		r.commitAsLeader(r.Id, pareply.Instance)
 	} else if inst.lb.preAcceptOKs >= r.N/2 {
 		if !allCommitted {
 			weird++
@@ -1215,7 +1525,10 @@ func (r *Replica) handlePreAcceptOK(pareply *epaxosproto.PreAcceptOK) {
 ***********************************************************************/
 
 func (r *Replica) handleAccept(accept *epaxosproto.Accept) {
-	inst := r.InstanceSpace[accept.LeaderId][accept.Instance]
+	if r.InstanceSpace[accept.Replica].Forgotten(accept.Instance) {
+		return
+	}
+	inst := r.InstanceSpace[accept.Replica].Get(accept.Instance)
 
 	if accept.Seq >= r.maxSeq {
 		r.maxSeq = accept.Seq + 1
@@ -1225,39 +1538,31 @@ func (r *Replica) handleAccept(accept *epaxosproto.Accept) {
 		return
 	}
 
-	if accept.Instance >= r.crtInstance[accept.LeaderId] {
-		r.crtInstance[accept.LeaderId] = accept.Instance + 1
+	if accept.Instance >= r.crtInstance[accept.Replica] {
+		r.crtInstance[accept.Replica] = accept.Instance + 1
 	}
 
 	if inst != nil {
-		if accept.Ballot < inst.ballot {
+		if accept.Ballot.LessThan(inst.ballot) {
 			r.replyAccept(accept.LeaderId, &epaxosproto.AcceptReply{accept.Replica, accept.Instance, FALSE, inst.ballot})
 			return
 		}
+		inst.ballot = accept.Ballot
 		inst.Status = epaxosproto.ACCEPTED
 		inst.Seq = accept.Seq
 		inst.Deps = accept.Deps
 	} else {
-		r.InstanceSpace[accept.LeaderId][accept.Instance] = &Instance{
+		r.InstanceSpace[accept.Replica].Set(accept.Instance, &Instance{
 			nil,
 			accept.Ballot,
 			epaxosproto.ACCEPTED,
 			accept.Seq,
 			accept.Deps,
-			nil, 0, 0, nil}
-
-		if accept.Count == 0 {
-			//checkpoint
-			//update latest checkpoint info
-			r.latestCPReplica = accept.Replica
-			r.latestCPInstance = accept.Instance
+			nil, 0, 0, nil})
 
-			//discard dependency hashtables
-			r.clearHashtables()
-		}
 	}
 
-	r.recordInstanceMetadata(r.InstanceSpace[accept.Replica][accept.Instance])
+	r.recordInstanceMetadata(accept.Replica, accept.Instance, r.InstanceSpace[accept.Replica].Get(accept.Instance))
 	r.sync()
 
 	r.replyAccept(accept.LeaderId,
@@ -1266,10 +1571,16 @@ func (r *Replica) handleAccept(accept *epaxosproto.Accept) {
 			accept.Instance,
 			TRUE,
 			accept.Ballot})
+	if r.InstanceSpace[accept.Replica].Get(accept.Instance).Cmds != nil {
+		r.sendLeaseAck(accept.LeaderId, accept.Replica, accept.Instance)
+	}
 }
 
 func (r *Replica) handleAcceptReply(areply *epaxosproto.AcceptReply) {
-	inst := r.InstanceSpace[areply.Replica][areply.Instance]
+	if r.InstanceSpace[areply.Replica].Forgotten(areply.Instance) {
+		return
+	}
+	inst := r.InstanceSpace[areply.Replica].Get(areply.Instance)
 
 	if inst.Status != epaxosproto.ACCEPTED {
 		// we've move on, these are delayed replies, so just ignore
@@ -1283,7 +1594,7 @@ func (r *Replica) handleAcceptReply(areply *epaxosproto.AcceptReply) {
 	if areply.OK == FALSE {
 		// TODO: there is probably another active leader
 		inst.lb.nacks++
-		if areply.Ballot > inst.lb.maxRecvBallot {
+		if areply.Ballot.GreaterThan(inst.lb.maxRecvBallot) {
 			inst.lb.maxRecvBallot = areply.Ballot
 		}
 		if inst.lb.nacks >= r.N/2 {
@@ -1295,27 +1606,7 @@ func (r *Replica) handleAcceptReply(areply *epaxosproto.AcceptReply) {
 	inst.lb.acceptOKs++
 
 	if inst.lb.acceptOKs+1 > r.N/2 {
-		r.InstanceSpace[areply.Replica][areply.Instance].Status = epaxosproto.COMMITTED
-		r.updateCommitted(areply.Replica)
-		if inst.lb.clientProposals != nil && !r.Dreply {
-			// give clients the all clear
-			for i := 0; i < len(inst.lb.clientProposals); i++ {
-				var delt int64 = 0;//time.Now().UnixNano() - r.startTimes[inst.lb.clientProposals[i].CommandId]
-				r.ReplyProposeTS(
-					&genericsmrproto.ProposeReplyTS{
-						TRUE,
-						inst.lb.clientProposals[i].CommandId,
-						state.NIL,
-						delt},
-					inst.lb.clientProposals[i].Reply)
-				//log.Printf("SP: Decided command %d in %fms\n", inst.lb.clientProposals[i].CommandId, float64(delt) / 1000000.0)
-			}
-		}
-
-		r.recordInstanceMetadata(inst)
-		r.sync() //is this necessary here?
-
-		r.bcastCommit(areply.Replica, areply.Instance, inst.Cmds, inst.Seq, inst.Deps)
+		r.commitAsLeader(areply.Replica, areply.Instance)
 	}
 }
 
@@ -1325,8 +1616,43 @@ func (r *Replica) handleAcceptReply(areply *epaxosproto.AcceptReply) {
 
 ***********************************************************************/
 
+// Commits an instance for which this replica has gathered a quorum. With read
+// leases, the commit waits until every lease holder knows about the instance.
+func (r *Replica) commitAsLeader(replica int32, instance int32) {
+	inst := r.InstanceSpace[replica].Get(instance)
+	if !r.leaseHoldersKnow(replica, instance, inst) {
+		return
+	}
+
+	inst.Status = epaxosproto.COMMITTED
+	r.updateCommitted(replica)
+	if inst.lb.clientProposals != nil && !r.Dreply {
+		// give clients the all clear
+		for i := 0; i < len(inst.lb.clientProposals); i++ {
+			var delt int64 = 0;//time.Now().UnixNano() - r.startTimes[inst.lb.clientProposals[i].CommandId]
+			r.ReplyProposeTS(
+				&genericsmrproto.ProposeReplyTS{
+					TRUE,
+					inst.lb.clientProposals[i].CommandId,
+					state.NIL,
+					delt,
+					nil},
+				inst.lb.clientProposals[i].Reply)
+			//log.Printf("Decided command %d in %fms\n", inst.lb.clientProposals[i].CommandId, float64(delt) / 1000000.0)
+		}
+	}
+
+	r.recordInstanceMetadata(replica, instance, inst)
+	r.sync() //is this necessary here?
+
+	r.bcastCommit(replica, instance, inst.Cmds, inst.Seq, inst.Deps)
+}
+
 func (r *Replica) handleCommit(commit *epaxosproto.Commit) {
-	inst := r.InstanceSpace[commit.Replica][commit.Instance]
+	if r.InstanceSpace[commit.Replica].Forgotten(commit.Instance) {
+		return
+	}
+	inst := r.InstanceSpace[commit.Replica].Get(commit.Instance)
 
 	if commit.Seq >= r.maxSeq {
 		r.maxSeq = commit.Seq + 1
@@ -1349,20 +1675,20 @@ func (r *Replica) handleCommit(commit *epaxosproto.Commit) {
 		inst.Deps = commit.Deps
 		inst.Status = epaxosproto.COMMITTED
 	} else {
-		r.InstanceSpace[commit.Replica][int(commit.Instance)] = &Instance{
+		r.InstanceSpace[commit.Replica].Set(commit.Instance, &Instance{
 			commit.Command,
-			0,
+			genericsmrproto.InitialBallot(commit.Replica),
 			epaxosproto.COMMITTED,
 			commit.Seq,
 			commit.Deps,
 			nil,
 			0,
 			0,
-			nil}
+			nil})
 		// r.updateConflicts(commit.Command, commit.Replica, commit.Instance, commit.Seq)
 		r.updateSmartConflicts(commit.Command, commit.Replica, commit.Instance, commit.Seq)
 
-		if len(commit.Command) == 0 {
+		if isCheckpoint(commit.Command) {
 			//checkpoint
 			//update latest checkpoint info
 			r.latestCPReplica = commit.Replica
@@ -1374,12 +1700,15 @@ func (r *Replica) handleCommit(commit *epaxosproto.Commit) {
 	}
 	r.updateCommitted(commit.Replica)
 
-	r.recordInstanceMetadata(r.InstanceSpace[commit.Replica][commit.Instance])
-	r.recordCommands(commit.Command)
+	r.recordInstanceMetadata(commit.Replica, commit.Instance, r.InstanceSpace[commit.Replica].Get(commit.Instance))
+	r.recordCommands(commit.Replica, commit.Instance, commit.Command)
 }
 
 func (r *Replica) handleCommitShort(commit *epaxosproto.CommitShort) {
-	inst := r.InstanceSpace[commit.Replica][commit.Instance]
+	if r.InstanceSpace[commit.Replica].Forgotten(commit.Instance) {
+		return
+	}
+	inst := r.InstanceSpace[commit.Replica].Get(commit.Instance)
 
 	if commit.Instance >= r.crtInstance[commit.Replica] {
 		r.crtInstance[commit.Replica] = commit.Instance + 1
@@ -1397,27 +1726,17 @@ func (r *Replica) handleCommitShort(commit *epaxosproto.CommitShort) {
 		inst.Deps = commit.Deps
 		inst.Status = epaxosproto.COMMITTED
 	} else {
-		r.InstanceSpace[commit.Replica][commit.Instance] = &Instance{
+		r.InstanceSpace[commit.Replica].Set(commit.Instance, &Instance{
 			nil,
-			0,
+			genericsmrproto.InitialBallot(commit.Replica),
 			epaxosproto.COMMITTED,
 			commit.Seq,
 			commit.Deps,
-			nil, 0, 0, nil}
-
-		if commit.Count == 0 {
-			//checkpoint
-			//update latest checkpoint info
-			r.latestCPReplica = commit.Replica
-			r.latestCPInstance = commit.Instance
-
-			//discard dependency hashtables
-			r.clearHashtables()
-		}
+			nil, 0, 0, nil})
 	}
 	r.updateCommitted(commit.Replica)
 
-	r.recordInstanceMetadata(r.InstanceSpace[commit.Replica][commit.Instance])
+	r.recordInstanceMetadata(commit.Replica, commit.Instance, r.InstanceSpace[commit.Replica].Get(commit.Instance))
 }
 
 /**********************************************************************
@@ -1426,26 +1745,43 @@ func (r *Replica) handleCommitShort(commit *epaxosproto.CommitShort) {
 
 ***********************************************************************/
 
-func (r *Replica) startRecoveryForInstance(replica int32, instance int32) {
-	var nildeps [DS]int32
+// Starts recovering every instance of a command leader that is suspected to
+// have failed, without waiting for the execution to get stuck on them.
+func (r *Replica) recoverInstancesOf(replica int32) {
+	for i := r.CommittedUpTo[replica] + 1; i < r.crtInstance[replica]; i++ {
+		if inst := r.InstanceSpace[replica].Get(i); inst != nil && inst.Status >= epaxosproto.COMMITTED {
+			continue
+		}
+		select {
+		case r.instancesToRecover <- &instanceId{replica, i}:
+		default:
+			//the execution thread will get to the rest
+			return
+		}
+	}
+}
 
-	if r.InstanceSpace[replica][instance] == nil {
-		r.InstanceSpace[replica][instance] = &Instance{nil, 0, epaxosproto.NONE, 0, nildeps, nil, 0, 0, nil}
+func (r *Replica) startRecoveryForInstance(replica int32, instance int32) {
+	if r.InstanceSpace[replica].Forgotten(instance) {
+		return
+	}
+	if r.InstanceSpace[replica].Get(instance) == nil {
+		r.InstanceSpace[replica].Set(instance, &Instance{nil, genericsmrproto.InitialBallot(replica), epaxosproto.NONE, 0, r.makeDeps(0), nil, 0, 0, nil})
 	}
 
-	inst := r.InstanceSpace[replica][instance]
+	inst := r.InstanceSpace[replica].Get(instance)
 	if inst.lb == nil {
-		inst.lb = &LeaderBookkeeping{nil, -1, 0, false, 0, 0, 0, nildeps, nil, nil, true, false, nil, 0}
+		inst.lb = &LeaderBookkeeping{nil, genericsmrproto.NilBallot, 0, false, 0, 0, 0, r.makeDeps(0), nil, nil, true, false, nil, 0}
 
 	} else {
-		inst.lb = &LeaderBookkeeping{inst.lb.clientProposals, -1, 0, false, 0, 0, 0, nildeps, nil, nil, true, false, nil, 0}
+		inst.lb = &LeaderBookkeeping{inst.lb.clientProposals, genericsmrproto.NilBallot, 0, false, 0, 0, 0, r.makeDeps(0), nil, nil, true, false, nil, 0}
 	}
 
 	if inst.Status == epaxosproto.ACCEPTED {
-		inst.lb.recoveryInst = &RecoveryInstance{inst.Cmds, inst.Status, inst.Seq, inst.Deps, 0, false}
+		inst.lb.recoveryInst = &RecoveryInstance{inst.Cmds, inst.Status, inst.Seq, copyDeps(inst.Deps), 0, false}
 		inst.lb.maxRecvBallot = inst.ballot
 	} else if inst.Status >= epaxosproto.PREACCEPTED {
-		inst.lb.recoveryInst = &RecoveryInstance{inst.Cmds, inst.Status, inst.Seq, inst.Deps, 1, (r.Id == replica)}
+		inst.lb.recoveryInst = &RecoveryInstance{inst.Cmds, inst.Status, inst.Seq, copyDeps(inst.Deps), 1, (r.Id == replica)}
 	}
 
 	//compute larger ballot
@@ -1455,31 +1791,33 @@ func (r *Replica) startRecoveryForInstance(replica int32, instance int32) {
 }
 
 func (r *Replica) handlePrepare(prepare *epaxosproto.Prepare) {
-	inst := r.InstanceSpace[prepare.Replica][prepare.Instance]
+	if r.InstanceSpace[prepare.Replica].Forgotten(prepare.Instance) {
+		return
+	}
+	inst := r.InstanceSpace[prepare.Replica].Get(prepare.Instance)
 	var preply *epaxosproto.PrepareReply
-	var nildeps [DS]int32
 
 	if inst == nil {
-		r.InstanceSpace[prepare.Replica][prepare.Instance] = &Instance{
+		r.InstanceSpace[prepare.Replica].Set(prepare.Instance, &Instance{
 			nil,
 			prepare.Ballot,
 			epaxosproto.NONE,
 			0,
-			nildeps,
-			nil, 0, 0, nil}
+			r.makeDeps(0),
+			nil, 0, 0, nil})
 		preply = &epaxosproto.PrepareReply{
 			r.Id,
 			prepare.Replica,
 			prepare.Instance,
 			TRUE,
-			-1,
+			genericsmrproto.NilBallot,
 			epaxosproto.NONE,
 			nil,
 			-1,
-			nildeps}
+			r.makeDeps(0)}
 	} else {
 		ok := TRUE
-		if prepare.Ballot < inst.ballot {
+		if prepare.Ballot.LessThan(inst.ballot) {
 			ok = FALSE
 		} else {
 			inst.ballot = prepare.Ballot
@@ -1496,11 +1834,20 @@ func (r *Replica) handlePrepare(prepare *epaxosproto.Prepare) {
 			inst.Deps}
 	}
 
+	if preply.OK == TRUE {
+		//persist the promise before making it
+		r.recordInstanceMetadata(prepare.Replica, prepare.Instance, r.InstanceSpace[prepare.Replica].Get(prepare.Instance))
+		r.sync()
+	}
+
 	r.replyPrepare(prepare.LeaderId, preply)
 }
 
 func (r *Replica) handlePrepareReply(preply *epaxosproto.PrepareReply) {
-	inst := r.InstanceSpace[preply.Replica][preply.Instance]
+	if r.InstanceSpace[preply.Replica].Forgotten(preply.Instance) {
+		return
+	}
+	inst := r.InstanceSpace[preply.Replica].Get(preply.Instance)
 
 	if inst.lb == nil || !inst.lb.preparing {
 		// we've moved on -- these are delayed replies, so just ignore
@@ -1519,20 +1866,21 @@ func (r *Replica) handlePrepareReply(preply *epaxosproto.PrepareReply) {
 	inst.lb.prepareOKs++
 
 	if preply.Status == epaxosproto.COMMITTED || preply.Status == epaxosproto.EXECUTED {
-		r.InstanceSpace[preply.Replica][preply.Instance] = &Instance{
+		r.InstanceSpace[preply.Replica].Set(preply.Instance, &Instance{
 			preply.Command,
 			inst.ballot,
 			epaxosproto.COMMITTED,
 			preply.Seq,
 			preply.Deps,
-			nil, 0, 0, nil}
+			nil, 0, 0, nil})
+		r.updateCommitted(preply.Replica)
 		r.bcastCommit(preply.Replica, preply.Instance, inst.Cmds, preply.Seq, preply.Deps)
 		//TODO: check if we should send notifications to clients
 		return
 	}
 
 	if preply.Status == epaxosproto.ACCEPTED {
-		if inst.lb.recoveryInst == nil || inst.lb.maxRecvBallot < preply.Ballot {
+		if inst.lb.recoveryInst == nil || inst.lb.maxRecvBallot.LessThan(preply.Ballot) {
 			inst.lb.recoveryInst = &RecoveryInstance{preply.Command, preply.Status, preply.Seq, preply.Deps, 0, false}
 			inst.lb.maxRecvBallot = preply.Ballot
 		}
@@ -1542,7 +1890,7 @@ func (r *Replica) handlePrepareReply(preply *epaxosproto.PrepareReply) {
 		(inst.lb.recoveryInst == nil || inst.lb.recoveryInst.status < epaxosproto.ACCEPTED) {
 		if inst.lb.recoveryInst == nil {
 			inst.lb.recoveryInst = &RecoveryInstance{preply.Command, preply.Status, preply.Seq, preply.Deps, 1, false}
-		} else if preply.Seq == inst.Seq && equal(&preply.Deps, &inst.Deps) {
+		} else if preply.Seq == inst.Seq && equal(preply.Deps, inst.Deps) {
 			inst.lb.recoveryInst.preAcceptCount++
 		} else if preply.Status == epaxosproto.PREACCEPTED_EQ {
 			// If we get different ordering attributes from pre-acceptors, we must go with the ones
@@ -1586,7 +1934,7 @@ func (r *Replica) handlePrepareReply(preply *epaxosproto.PrepareReply) {
 				inst.lb.possibleQuorum[q] = true
 			}
 			if conf, q, i := r.findPreAcceptConflicts(ir.cmds, preply.Replica, preply.Instance, ir.seq, ir.deps); conf {
-				if r.InstanceSpace[q][i].Status >= epaxosproto.COMMITTED {
+				if r.InstanceSpace[q].Get(i).Status >= epaxosproto.COMMITTED {
 					//start Phase1 in the initial leader's instance
 					r.startPhase1(preply.Replica, preply.Instance, inst.ballot, inst.lb.clientProposals, ir.cmds, len(ir.cmds))
 					return
@@ -1611,24 +1959,27 @@ func (r *Replica) handlePrepareReply(preply *epaxosproto.PrepareReply) {
 		}
 	} else {
 		//try to finalize instance by proposing NO-OP
-		var noop_deps [DS]int32
+		noop_deps := r.makeDeps(0)
 		// commands that depended on this instance must look at all previous instances
 		noop_deps[preply.Replica] = preply.Instance - 1
 		inst.lb.preparing = false
-		r.InstanceSpace[preply.Replica][preply.Instance] = &Instance{
+		r.InstanceSpace[preply.Replica].Set(preply.Instance, &Instance{
 			nil,
 			inst.ballot,
 			epaxosproto.ACCEPTED,
 			0,
 			noop_deps,
-			inst.lb, 0, 0, nil}
+			inst.lb, 0, 0, nil})
 		r.bcastAccept(preply.Replica, preply.Instance, inst.ballot, 0, 0, noop_deps)
 	}
 }
 
 func (r *Replica) handleTryPreAccept(tpa *epaxosproto.TryPreAccept) {
-	inst := r.InstanceSpace[tpa.Replica][tpa.Instance]
-	if inst != nil && inst.ballot > tpa.Ballot {
+	if r.InstanceSpace[tpa.Replica].Forgotten(tpa.Instance) {
+		return
+	}
+	inst := r.InstanceSpace[tpa.Replica].Get(tpa.Instance)
+	if inst != nil && inst.ballot.GreaterThan(tpa.Ballot) {
 		// ballot number too small
 		r.replyTryPreAccept(tpa.LeaderId, &epaxosproto.TryPreAcceptReply{
 			r.Id,
@@ -1650,7 +2001,7 @@ func (r *Replica) handleTryPreAccept(tpa *epaxosproto.TryPreAccept) {
 			inst.ballot,
 			confRep,
 			confInst,
-			r.InstanceSpace[confRep][confInst].Status})
+			r.InstanceSpace[confRep].Get(confInst).Status})
 	} else {
 		// can pre-accept
 		if tpa.Instance >= r.crtInstance[tpa.Replica] {
@@ -1663,32 +2014,36 @@ func (r *Replica) handleTryPreAccept(tpa *epaxosproto.TryPreAccept) {
 			inst.Status = epaxosproto.PREACCEPTED
 			inst.ballot = tpa.Ballot
 		} else {
-			r.InstanceSpace[tpa.Replica][tpa.Instance] = &Instance{
+			r.InstanceSpace[tpa.Replica].Set(tpa.Instance, &Instance{
 				tpa.Command,
 				tpa.Ballot,
 				epaxosproto.PREACCEPTED,
 				tpa.Seq,
 				tpa.Deps,
 				nil, 0, 0,
-				nil}
+				nil})
 		}
-		r.replyTryPreAccept(tpa.LeaderId, &epaxosproto.TryPreAcceptReply{r.Id, tpa.Replica, tpa.Instance, TRUE, inst.ballot, 0, 0, 0})
+		r.recordInstanceMetadata(tpa.Replica, tpa.Instance, r.InstanceSpace[tpa.Replica].Get(tpa.Instance))
+		r.recordCommands(tpa.Replica, tpa.Instance, tpa.Command)
+		r.sync()
+		r.replyTryPreAccept(tpa.LeaderId, &epaxosproto.TryPreAcceptReply{r.Id, tpa.Replica, tpa.Instance, TRUE, tpa.Ballot, 0, 0, 0})
 	}
 }
 
-func (r *Replica) findPreAcceptConflicts(cmds []state.Command, replica int32, instance int32, seq int32, deps [DS]int32) (bool, int32, int32) {
-	inst := r.InstanceSpace[replica][instance]
+func (r *Replica) findPreAcceptConflicts(cmds []state.Command, replica int32, instance int32, seq int32, deps []int32) (bool, int32, int32) {
+	inst := r.InstanceSpace[replica].Get(instance)
 	if inst != nil && len(inst.Cmds) > 0 {
 		if inst.Status >= epaxosproto.ACCEPTED {
 			// already ACCEPTED or COMMITTED
 			// we consider this a conflict because we shouldn't regress to PRE-ACCEPTED
 			return true, replica, instance
 		}
-		if inst.Seq == tpa.Seq && equal(&inst.Deps, &tpa.Deps) {
+		if inst.Seq == tpa.Seq && equal(inst.Deps, tpa.Deps) {
 			// already PRE-ACCEPTED, no point looking for conflicts again
 			return false, replica, instance
 		}
 	}
+	bf := bfFromCommands(cmds)
 	for q := int32(0); q < int32(r.N); q++ {
 		for i := r.ExecedUpTo[q]; i < r.crtInstance[q]; i++ {
 			if replica == q && instance == i {
@@ -1700,7 +2055,7 @@ func (r *Replica) findPreAcceptConflicts(cmds []state.Command, replica int32, in
 				//the instance cannot be a dependency for itself
 				continue
 			}
-			inst := r.InstanceSpace[q][i]
+			inst := r.InstanceSpace[q].Get(i)
 			if inst == nil || inst.Cmds == nil || len(inst.Cmds) == 0 {
 				continue
 			}
@@ -1708,7 +2063,7 @@ func (r *Replica) findPreAcceptConflicts(cmds []state.Command, replica int32, in
 				// instance q.i depends on instance replica.instance, it is not a conflict
 				continue
 			}
-			if state.ConflictBatch(inst.Cmds, cmds) {
+			if bfMayTouch(bf, inst.Cmds) && r.State.ConflictBatch(inst.Cmds, cmds) {
 				if i > deps[q] ||
 					(i < deps[q] && inst.Seq >= seq && (q != replica || inst.Status > epaxosproto.PREACCEPTED_EQ)) {
 					// this is a conflict
@@ -1721,7 +2076,7 @@ func (r *Replica) findPreAcceptConflicts(cmds []state.Command, replica int32, in
 }
 
 func (r *Replica) handleTryPreAcceptReply(tpar *epaxosproto.TryPreAcceptReply) {
-	inst := r.InstanceSpace[tpar.Replica][tpar.Instance]
+	inst := r.InstanceSpace[tpar.Replica].Get(tpar.Instance)
 	if inst == nil || inst.lb == nil || !inst.lb.tryingToPreAccept || inst.lb.recoveryInst == nil {
 		return
 	}
@@ -1744,7 +2099,7 @@ func (r *Replica) handleTryPreAcceptReply(tpar *epaxosproto.TryPreAcceptReply) {
 		}
 	} else {
 		inst.lb.nacks++
-		if tpar.Ballot > inst.ballot {
+		if tpar.Ballot.GreaterThan(inst.ballot) {
 			//TODO: retry with higher ballot
 			return
 		}
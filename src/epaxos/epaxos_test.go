@@ -1,79 +1,630 @@
 package epaxos
 
 import (
+	"bufio"
+	"bytes"
 	"epaxosproto"
 	"fmt"
 	"genericsmr"
+	"genericsmrproto"
+	"io"
+	"os"
+	"path/filepath"
 	"state"
+	"sync"
 	"testing"
+	"time"
 )
 
 func initReplica() *Replica {
 	peers := make([]string, 3)
-	r := &Replica{genericsmr.NewReplica(0, peers, false, false, false),
-		make(chan *Propose, CHAN_BUFFER_SIZE),
-		make(chan *epaxosproto.Prepare, CHAN_BUFFER_SIZE),
-		make(chan *epaxosproto.PreAccept, CHAN_BUFFER_SIZE),
-		make(chan *epaxosproto.PreAccept, CHAN_BUFFER_SIZE),
-		make(chan *epaxosproto.Accept, CHAN_BUFFER_SIZE),
-		make(chan *epaxosproto.Commit, CHAN_BUFFER_SIZE),
-		make(chan *epaxosproto.PrepareReply, CHAN_BUFFER_SIZE),
-		make(chan *epaxosproto.PreAcceptReply, CHAN_BUFFER_SIZE),
-		make(chan *epaxosproto.PreAcceptOK, CHAN_BUFFER_SIZE),
-		make(chan *epaxosproto.AcceptReply, CHAN_BUFFER_SIZE),
-		make([][]*Instance, 3),
-		make([]int32, 3),
-		false,
-		0,
-		make([]int32, 3),
-		nil}
+	r := &Replica{Replica: &genericsmr.Replica{N: len(peers), Id: 0, State: state.NewState(state.DefaultMachine{})},
+		InstanceSpace: make([]*InstanceWindow, 3),
+		crtInstance:   make([]int32, 3),
+		CommittedUpTo: make([]int32, 3),
+		ExecedUpTo:    make([]int32, 3),
+		scans:         make([][]scanRange, 3)}
 
 	for i := 0; i < r.N; i++ {
-		r.InstanceSpace[i] = make([]*Instance, 1024*1024)
+		r.InstanceSpace[i] = NewInstanceWindow()
 		r.crtInstance[i] = 0
+		r.CommittedUpTo[i] = -1
 		r.ExecedUpTo[i] = -1
 	}
 
-	r.exec = &Exec{r}
+	r.exec = NewExec(r, 0)
 
 	return r
 }
 
-func (r *Replica) MakeInstance(q, i int, seq int32, deps [3]int32) {
-	command := &state.Command{state.PUT, state.Key(q), state.Value(i)}
-	r.InstanceSpace[q][i] = &Instance{command, 0, epaxosproto.COMMITTED, seq, deps, nil, 0, 0}
+func (r *Replica) MakeInstance(q, i int, seq int32, deps []int32) {
+	command := []state.Command{state.Command{int32(i), state.NO_CLIENT, state.PUT, state.IntKey(int64(q)), state.IntValue(int64(i)), state.NIL, nil, 0}}
+	r.InstanceSpace[q].Set(int32(i), &Instance{command, genericsmrproto.InitialBallot(int32(q)), epaxosproto.COMMITTED, seq, deps, nil, 0, 0, nil})
 }
 
 func TestExec(t *testing.T) {
 
 	r := initReplica()
 
-	r.MakeInstance(0, 0, 2, [3]int32{0, 0, 0})
-	r.MakeInstance(1, 0, 1, [3]int32{0, 0, 0})
-	r.MakeInstance(2, 0, 0, [3]int32{0, 0, 0})
+	r.MakeInstance(0, 0, 2, []int32{0, 0, 0})
+	r.MakeInstance(1, 0, 1, []int32{0, 0, 0})
+	r.MakeInstance(2, 0, 0, []int32{0, 0, 0})
 
-	r.MakeInstance(0, 1, 0, [3]int32{0, 1, 0})
-	r.MakeInstance(1, 1, 2, [3]int32{0, 0, 1})
-	r.MakeInstance(2, 1, 0, [3]int32{1, 0, 0})
+	r.MakeInstance(0, 1, 0, []int32{0, 1, 0})
+	r.MakeInstance(1, 1, 2, []int32{0, 0, 1})
+	r.MakeInstance(2, 1, 0, []int32{1, 0, 0})
 
-	r.MakeInstance(0, 2, 0, [3]int32{1, 1, 1})
-	r.MakeInstance(1, 2, 0, [3]int32{1, 1, 2})
-	r.MakeInstance(2, 2, 0, [3]int32{2, 1, 1})
+	r.MakeInstance(0, 2, 0, []int32{1, 1, 1})
+	r.MakeInstance(1, 2, 0, []int32{1, 1, 2})
+	r.MakeInstance(2, 2, 0, []int32{2, 1, 1})
 
-	r.MakeInstance(0, 3, 0, [3]int32{2, 2, 2})
-	r.MakeInstance(1, 3, 0, [3]int32{0, 0, 0})
-	r.MakeInstance(2, 3, 0, [3]int32{0, 0, 0})
+	r.MakeInstance(0, 3, 0, []int32{2, 2, 2})
+	r.MakeInstance(1, 3, 0, []int32{0, 0, 0})
+	r.MakeInstance(2, 3, 0, []int32{0, 0, 0})
 
-	r.MakeInstance(0, 4, 1, [3]int32{3, 5, 0})
-	r.MakeInstance(1, 4, 2, [3]int32{0, 0, 0})
-	r.MakeInstance(2, 4, 3, [3]int32{0, 0, 0})
+	r.MakeInstance(0, 4, 1, []int32{3, 5, 0})
+	r.MakeInstance(1, 4, 2, []int32{0, 0, 0})
+	r.MakeInstance(2, 4, 3, []int32{0, 0, 0})
 
-	r.MakeInstance(0, 5, 4, [3]int32{4, 5, 5})
-	r.MakeInstance(1, 5, 5, [3]int32{5, 5, 5})
-	r.MakeInstance(2, 5, 6, [3]int32{5, 0, 5})
+	r.MakeInstance(0, 5, 4, []int32{4, 5, 5})
+	r.MakeInstance(1, 5, 5, []int32{5, 5, 5})
+	r.MakeInstance(2, 5, 6, []int32{5, 0, 5})
 
 	r.exec.executeCommand(0, 5)
 	r.exec.executeCommand(0, 5)
 
-	fmt.Println("Test ended\n")
+	fmt.Println("Test ended")
+}
+
+func TestCheckpoint(t *testing.T) {
+	r := initReplica()
+	r.State.Store[state.IntKey(1)] = state.IntValue(10)
+	r.State.Store[state.IntKey(2)] = state.IntValue(20)
+	r.ExecedUpTo = []int32{4, 2, -1}
+	r.crtInstance = []int32{5, 4, 0}
+	r.MakeInstance(1, 3, 0, []int32{0, 0, 0})
+	r.InstanceSpace[1].Get(3).Status = epaxosproto.EXECUTED
+	r.pendingCheckpoint = []int32{4, 2, -1}
+	r.checkpointChan = make(chan *checkpoint, 1)
+
+	r.tryCheckpoint()
+	cp := <-r.checkpointChan
+	if len(cp.executed) != 1 || cp.executed[0] != (instanceId{1, 3}) {
+		t.Fatalf("wrong out of order instances: %v", cp.executed)
+	}
+
+	name := filepath.Join(t.TempDir(), "checkpoint")
+	if err := writeCheckpoint(name, cp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("temporary checkpoint file left behind")
+	}
+	read, err := readCheckpoint(name, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r2 := initReplica()
+	r2.installCheckpoint(read)
+	if r2.State.Store[state.IntKey(1)].Int() != 10 || r2.State.Store[state.IntKey(2)].Int() != 20 || len(r2.State.Store) != 2 {
+		t.Fatalf("wrong state restored: %v", r2.State.Store)
+	}
+	if !equal(r2.ExecedUpTo, cp.execedUpTo) || !r2.InstanceSpace[0].Forgotten(4) || r2.InstanceSpace[0].Forgotten(5) {
+		t.Fatal("wrong frontier restored")
+	}
+}
+
+func TestCheckpointBarrier(t *testing.T) {
+	r := initReplica()
+	r.checkpointPeriod = 10
+
+	// a no-op left by recovery is not a barrier
+	r.InstanceSpace[1].Set(0, &Instance{[]state.Command{}, genericsmrproto.InitialBallot(0), epaxosproto.COMMITTED, 0, []int32{-1, -1, -1}, nil, 0, 0, nil})
+	if !r.exec.executeCommand(1, 0) {
+		t.Fatal("no-op not executed")
+	}
+	if r.pendingCheckpoint != nil {
+		t.Fatal("no-op taken for a checkpoint barrier")
+	}
+
+	r.InstanceSpace[0].Set(0, &Instance{cpMarker, genericsmrproto.InitialBallot(0), epaxosproto.COMMITTED, 1, []int32{-1, 0, -1}, nil, 0, 0, nil})
+	if !r.exec.executeCommand(0, 0) {
+		t.Fatal("barrier not executed")
+	}
+	if !equal(r.pendingCheckpoint, []int32{-1, 0, -1}) {
+		t.Fatalf("barrier did not start a checkpoint: %v", r.pendingCheckpoint)
+	}
+}
+
+func TestSnapshotInstall(t *testing.T) {
+	r := initReplica()
+	r.State.Store[state.IntKey(1)] = state.IntValue(10)
+	r.ExecedUpTo = []int32{4, 2, -1}
+	r.crtInstance = []int32{5, 5, 0}
+	r.MakeInstance(1, 3, 0, []int32{0, 0, 0})
+	r.InstanceSpace[1].Get(3).Status = epaxosproto.EXECUTED
+	r.MakeInstance(1, 4, 1, []int32{4, 3, -1})
+	cp := r.takeSnapshot()
+
+	snap := &epaxosproto.Snapshot{0, cp.execedUpTo, cp.maxSeq, cp.state,
+		[]epaxosproto.Commit{{0, 1, 3, r.InstanceSpace[1].Get(3).Cmds, 0, []int32{0, 0, 0}}},
+		[]epaxosproto.Commit{{0, 1, 4, r.InstanceSpace[1].Get(4).Cmds, 1, []int32{4, 3, -1}}}}
+	var buf bytes.Buffer
+	snap.Marshal(&buf)
+	received := new(epaxosproto.Snapshot)
+	if err := received.Unmarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	r2 := initReplica()
+	r2.smartConflicts = make([]map[state.Operation]map[state.Key]int32, r2.N)
+	for q := 0; q < r2.N; q++ {
+		r2.smartConflicts[q] = make(map[state.Operation]map[state.Key]int32)
+		for _, op := range r2.State.Machine.Conflicts().Ops() {
+			r2.smartConflicts[q][op] = make(map[state.Key]int32)
+		}
+	}
+	r2.maxSeqPerKeyOp = make(map[state.Operation]map[state.Key]int32)
+	for _, op := range r2.State.Machine.Conflicts().Ops() {
+		r2.maxSeqPerKeyOp[op] = make(map[state.Key]int32)
+	}
+	r2.MakeInstance(0, 0, 0, []int32{-1, -1, -1})
+	r2.crtInstance[0] = 1
+
+	installed := r2.installSnapshot(received)
+	if installed == nil {
+		t.Fatal("snapshot not installed")
+	}
+	if r2.State.Store[state.IntKey(1)].Int() != 10 || len(r2.State.Store) != 1 {
+		t.Fatalf("wrong state installed: %v", r2.State.Store)
+	}
+	if !equal(r2.ExecedUpTo, []int32{4, 2, -1}) || !r2.InstanceSpace[0].Forgotten(0) {
+		t.Fatal("wrong frontier installed")
+	}
+	if r2.InstanceSpace[1].Get(3).Status != epaxosproto.EXECUTED || r2.InstanceSpace[1].Get(4).Status != epaxosproto.COMMITTED {
+		t.Fatal("wrong status for the instances past the frontier")
+	}
+	if !equal(r2.CommittedUpTo, []int32{4, 4, -1}) || r2.crtInstance[1] != 5 {
+		t.Fatalf("wrong committed frontier %v", r2.CommittedUpTo)
+	}
+
+	r2.exec.executeCommand(1, 4)
+	if r2.InstanceSpace[1].Get(4).Status != epaxosproto.EXECUTED || r2.State.Store[state.IntKey(1)].Int() != 4 {
+		t.Fatal("cannot execute past the snapshot")
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	r := initReplica()
+	r.State = state.NewState(state.InventoryMachine{})
+
+	cmd := state.Command{7, 42, state.INCREMENT, state.IntKey(1), state.IntValue(5), state.NIL, nil, 0}
+	r.InstanceSpace[0].Set(0, &Instance{[]state.Command{cmd}, genericsmrproto.InitialBallot(0), epaxosproto.COMMITTED, 0, []int32{-1, -1, -1}, nil, 0, 0, nil})
+	r.InstanceSpace[1].Set(0, &Instance{[]state.Command{cmd}, genericsmrproto.InitialBallot(1), epaxosproto.COMMITTED, 1, []int32{0, -1, -1}, nil, 0, 0, nil})
+	r.exec.executeCommand(0, 0)
+	r.exec.executeCommand(1, 0)
+	if r.State.Store[state.IntKey(1)].Int() != 5 {
+		t.Fatalf("retried command applied twice: %d", r.State.Store[state.IntKey(1)].Int())
+	}
+
+	var buf bytes.Buffer
+	r.State.Marshal(&buf)
+	restored := state.NewState(state.InventoryMachine{})
+	if err := restored.Unmarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if val := cmd.Execute(restored); val.Int() != 5 || restored.Store[state.IntKey(1)].Int() != 5 {
+		t.Fatalf("session lost by the snapshot: %d %d", val.Int(), restored.Store[state.IntKey(1)].Int())
+	}
+}
+
+func TestAdaptiveBatching(t *testing.T) {
+	r := initReplica()
+	r.ProposeChan = make(chan *genericsmr.Propose, 10)
+	r.batching = BatchConfig{8, 0, true}
+	r.batchTarget = 1
+	for i := 0; i < 9; i++ {
+		r.ProposeChan <- nil
+	}
+
+	for _, expected := range []int{1, 2, 4, 8, 8} {
+		if size := r.nextBatchSize(); size != expected {
+			t.Fatalf("batch of %d commands instead of %d under load", size, expected)
+		}
+	}
+	for len(r.ProposeChan) > 0 {
+		<-r.ProposeChan
+	}
+	for _, target := range []int{4, 2, 1, 1} {
+		if size := r.nextBatchSize(); size != 1 || r.batchTarget != target {
+			t.Fatalf("batches did not shrink when idle: %d, target %d instead of %d", size, r.batchTarget, target)
+		}
+	}
+}
+
+func TestExecBlocked(t *testing.T) {
+	r := initReplica()
+	r.crtInstance = []int32{1, 1, 0}
+	copy(r.exec.scanned, r.crtInstance)
+	r.MakeInstance(0, 0, 1, []int32{-1, 0, -1})
+
+	if r.exec.executeCommand(0, 0) {
+		t.Fatal("executed before its dependency was committed")
+	}
+	if !r.exec.blocked[instanceId{1, 0}] || r.exec.unblocked() {
+		t.Fatal("missing dependency not tracked")
+	}
+
+	r.MakeInstance(1, 0, 0, []int32{0, -1, -1})
+	if !r.exec.unblocked() {
+		t.Fatal("committed dependency not noticed")
+	}
+	if !r.exec.executeCommand(0, 0) || r.InstanceSpace[0].Get(0).Status != epaxosproto.EXECUTED {
+		t.Fatal("cannot execute once the dependency is committed")
+	}
+}
+
+func TestParallelExec(t *testing.T) {
+	r := initReplica()
+	r.exec = NewExec(r, 2)
+	r.exec.startWorkers()
+	defer close(r.exec.jobs)
+
+	// instances 0.0 and 0.1 write the same key, 1.0 and 2.0 other keys
+	r.MakeInstance(0, 0, 0, []int32{-1, -1, -1})
+	r.InstanceSpace[0].Get(0).Cmds[0].K = state.IntKey(5)
+	r.MakeInstance(0, 1, 1, []int32{0, -1, -1})
+	r.InstanceSpace[0].Get(1).Cmds[0].K = state.IntKey(5)
+	r.MakeInstance(1, 0, 0, []int32{-1, -1, -1})
+	r.MakeInstance(2, 0, 0, []int32{-1, -1, -1})
+
+	for _, id := range []instanceId{{0, 0}, {1, 0}, {2, 0}, {0, 1}} {
+		if !r.exec.executeCommand(id.replica, id.instance) {
+			t.Fatalf("cannot execute %v", id)
+		}
+	}
+	r.exec.drain()
+
+	for _, id := range []instanceId{{0, 0}, {1, 0}, {2, 0}, {0, 1}} {
+		if r.InstanceSpace[id.replica].Get(id.instance).Status != epaxosproto.EXECUTED {
+			t.Fatalf("%v not executed", id)
+		}
+	}
+	if r.State.Store[state.IntKey(5)].Int() != 1 || len(r.State.Store) != 3 {
+		t.Fatalf("wrong state after parallel execution: %v", r.State.Store)
+	}
+}
+
+func TestLeaseRead(t *testing.T) {
+	r := initReplica()
+	r.leases = newLeaseState(r.N)
+	r.crtInstance = []int32{0, 1, 0}
+	r.MakeInstance(1, 0, 0, []int32{-1, -1, -1})
+
+	read := func(k state.Key) *leaseRead {
+		p := &genericsmr.Propose{&genericsmrproto.Propose{Command: state.Command{0, state.NO_CLIENT, state.FAST_READ, k, state.NIL, state.NIL, nil, 0}}, nil}
+		return &leaseRead{p, []int32{-1, 0, -1}}
+	}
+	if r.leaseReadReady(read(state.IntKey(1))) {
+		t.Fatal("read ready before the conflicting write was executed")
+	}
+	if !r.leaseReadReady(read(state.IntKey(2))) {
+		t.Fatal("read waits for a write to another key")
+	}
+	r.exec.executeCommand(1, 0)
+	if !r.leaseReadReady(read(state.IntKey(1))) {
+		t.Fatal("read not ready after the write was executed")
+	}
+
+	// replica 1 holds a lease from us, so we must wait for its ack
+	r.leases.grantedUntil[1] = time.Now().UnixNano() + LEASE_DURATION
+	inst := r.InstanceSpace[1].Get(0)
+	if r.leaseHoldersKnow(1, 0, inst) || !r.commitPending(1, 0) {
+		t.Fatal("commit did not wait for the lease holder")
+	}
+	r.leases.acks[instanceId{1, 0}] = []bool{false, true, false}
+	if !r.leaseHoldersKnow(1, 0, inst) || r.commitPending(1, 0) {
+		t.Fatal("commit still waits after the lease holder acknowledged")
+	}
+}
+
+// Lease reads are answered from the main thread while the execution thread
+// answers the commands it executes, both on the client's connection (run
+// with -race).
+func TestLeaseReadWithDreply(t *testing.T) {
+	r := initReplica()
+	r.Dreply = true
+	r.clientMutex = new(sync.Mutex)
+	reply := bufio.NewWriter(io.Discard)
+
+	const n = 100
+	for i := 0; i < n; i++ {
+		r.MakeInstance(1, i, int32(i), []int32{-1, int32(i) - 1, -1})
+		r.InstanceSpace[1].Get(int32(i)).lb = &LeaderBookkeeping{clientProposals: []*genericsmr.Propose{
+			&genericsmr.Propose{&genericsmrproto.Propose{CommandId: int32(i)}, reply}}}
+	}
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < n; i++ {
+			r.exec.executeCommand(1, int32(i))
+		}
+		done <- true
+	}()
+	for i := 0; i < n; i++ {
+		p := &genericsmr.Propose{&genericsmrproto.Propose{Command: state.Command{0, state.NO_CLIENT, state.FAST_READ, state.IntKey(2), state.NIL, state.NIL, nil, 0}}, reply}
+		r.replyLeaseRead(&leaseRead{p, []int32{-1, -1, -1}})
+	}
+	<-done
+}
+
+func TestRecoverSuspected(t *testing.T) {
+	r := initReplica()
+	r.instancesToRecover = make(chan *instanceId, 10)
+	r.crtInstance = []int32{0, 4, 0}
+	r.MakeInstance(1, 0, 0, []int32{-1, -1, -1})
+	r.MakeInstance(1, 2, 0, []int32{-1, -1, -1})
+	r.InstanceSpace[1].Get(2).Status = epaxosproto.PREACCEPTED
+	r.MakeInstance(1, 3, 0, []int32{-1, -1, -1})
+	r.updateCommitted(1)
+
+	r.recoverInstancesOf(1)
+	close(r.instancesToRecover)
+	recovered := make([]int32, 0)
+	for iid := range r.instancesToRecover {
+		recovered = append(recovered, iid.instance)
+	}
+	if len(recovered) != 2 || recovered[0] != 1 || recovered[1] != 2 {
+		t.Fatalf("recovering instances %v of the suspected leader, not [1 2]", recovered)
+	}
+}
+
+func TestMultiKeyCommand(t *testing.T) {
+	r := initReplica()
+	r.conflicts = make([]map[state.Key]int32, r.N)
+	r.smartConflicts = make([]map[state.Operation]map[state.Key]int32, r.N)
+	r.maxSeqPerKeyOp = make(map[state.Operation]map[state.Key]int32)
+	for _, op := range r.State.Machine.Conflicts().Ops() {
+		r.maxSeqPerKeyOp[op] = make(map[state.Key]int32)
+	}
+	r.clearHashtables()
+
+	r.updateSmartConflicts([]state.Command{{1, state.NO_CLIENT, state.PUT, state.IntKey(5), state.IntValue(1), state.NIL, nil, 0}}, 1, 3, 4)
+	r.updateSmartConflicts([]state.Command{{2, state.NO_CLIENT, state.PUT, state.IntKey(9), state.IntValue(1), state.NIL, nil, 0}}, 2, 7, 2)
+
+	transfer := []state.Command{{3, state.NO_CLIENT, state.TRANSFER, state.IntKey(5), state.IntValue(1), state.NIL, []state.Key{state.IntKey(9)}, 0}}
+	seq, deps, _ := r.updateAttributes(transfer, 0, r.makeDeps(-1), 0, 0)
+	if deps[1] != 3 || deps[2] != 7 || seq != 5 {
+		t.Fatalf("multi-key command does not depend on both keys: seq %d, deps %v", seq, deps)
+	}
+
+	var buf bytes.Buffer
+	transfer[0].Marshal(&buf)
+	var cmd state.Command
+	if err := cmd.Unmarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !r.State.Conflict(&cmd, &state.Command{4, state.NO_CLIENT, state.GET, state.IntKey(9), state.NIL, state.NIL, nil, 0}) || cmd.KeyCount() != 2 {
+		t.Fatalf("keys lost on the wire: %v", cmd)
+	}
+}
+
+func TestScanDependencies(t *testing.T) {
+	r := initReplica()
+	r.conflicts = make([]map[state.Key]int32, r.N)
+	r.smartConflicts = make([]map[state.Operation]map[state.Key]int32, r.N)
+	r.maxSeqPerKeyOp = make(map[state.Operation]map[state.Key]int32)
+	for _, op := range r.State.Machine.Conflicts().Ops() {
+		r.maxSeqPerKeyOp[op] = make(map[state.Key]int32)
+	}
+	r.clearHashtables()
+
+	scan := state.Command{1, state.NO_CLIENT, state.SCAN, state.IntKey(4), state.NIL, state.NIL, []state.Key{state.IntKey(12)}, 0}
+	r.updateSmartConflicts([]state.Command{{1, state.NO_CLIENT, state.PUT, state.IntKey(5), state.IntValue(1), state.NIL, nil, 0}}, 1, 3, 4)
+	r.updateSmartConflicts([]state.Command{{2, state.NO_CLIENT, state.PUT, state.IntKey(20), state.IntValue(1), state.NIL, nil, 0}}, 2, 7, 2)
+	seq, deps, _ := r.updateAttributes([]state.Command{scan}, 0, r.makeDeps(-1), 0, 0)
+	if deps[1] != 3 || deps[2] != -1 || seq != 5 {
+		t.Fatalf("scan does not depend on the writes in its range: seq %d, deps %v", seq, deps)
+	}
+
+	r.updateSmartConflicts([]state.Command{scan}, 1, 4, 6)
+	seq, deps, _ = r.updateAttributes([]state.Command{{3, state.NO_CLIENT, state.PUT, state.IntKey(8), state.IntValue(1), state.NIL, nil, 0}}, 0, r.makeDeps(-1), 2, 9)
+	if deps[1] != 4 || seq != 7 {
+		t.Fatalf("write does not depend on the scan covering it: seq %d, deps %v", seq, deps)
+	}
+}
+
+func TestManyScans(t *testing.T) {
+	r := initReplica()
+	r.conflicts = make([]map[state.Key]int32, r.N)
+	r.smartConflicts = make([]map[state.Operation]map[state.Key]int32, r.N)
+	r.maxSeqPerKeyOp = make(map[state.Operation]map[state.Key]int32)
+	for _, op := range r.State.Machine.Conflicts().Ops() {
+		r.maxSeqPerKeyOp[op] = make(map[state.Key]int32)
+	}
+	r.clearHashtables()
+
+	// replica 1 runs a thousand SCANs of ten keys each, and we have executed
+	// all but the last ten of them
+	const scans = 1000
+	for i := int32(0); i < scans; i++ {
+		scan := []state.Command{{i, state.NO_CLIENT, state.SCAN, state.IntKey(int64(10 * i)), state.NIL, state.NIL, []state.Key{state.IntKey(int64(10*i + 10))}, 0}}
+		status := epaxosproto.COMMITTED
+		if i < scans-10 {
+			status = epaxosproto.EXECUTED
+		}
+		r.InstanceSpace[1].Set(i, &Instance{scan, genericsmrproto.InitialBallot(1), status, i, r.makeDeps(-1), nil, 0, 0, nil})
+		r.updateSmartConflicts(scan, 1, i, i)
+	}
+	if len(r.scans[1]) > 12 {
+		t.Fatalf("%d SCANs kept for the conflict checks", len(r.scans[1]))
+	}
+
+	put := func(k int64) (int32, []int32) {
+		seq, deps, _ := r.updateAttributes([]state.Command{{0, state.NO_CLIENT, state.PUT, state.IntKey(k), state.IntValue(1), state.NIL, nil, 0}}, 0, r.makeDeps(-1), 0, 0)
+		return seq, deps
+	}
+	// a write under an executed SCAN still depends on it, through the folded
+	// entry, and one under a pending SCAN depends on exactly that one
+	if seq, deps := put(5); deps[1] < 0 || seq < 1 {
+		t.Fatalf("write does not depend on the executed scan of its key: seq %d, deps %v", seq, deps)
+	}
+	if seq, deps := put(10*(scans-5) + 3); deps[1] != scans-5 || seq != scans-4 {
+		t.Fatalf("write does not depend on the pending scan of its key: seq %d, deps %v", seq, deps)
+	}
+	if _, deps := put(10*scans + 3); deps[1] != -1 {
+		t.Fatalf("write outside every scan has a dependency: %v", deps)
+	}
+}
+
+func TestByteStringKeyFilter(t *testing.T) {
+	cmd := state.Command{1, state.NO_CLIENT, state.CAS, state.Key("user:\x00alice"), state.Value(`{"likes": 3}`), state.Value(""), nil, 0}
+	bf := bfFromCommands([]state.Command{cmd})
+	if !bf.CheckString(string(cmd.K)) {
+		t.Fatal("key missing from the Bloom filter")
+	}
+}
+
+func TestLikeDependencies(t *testing.T) {
+	r := initReplica()
+	r.State = state.NewState(state.FacebookMachine{})
+	r.conflicts = make([]map[state.Key]int32, r.N)
+	r.smartConflicts = make([]map[state.Operation]map[state.Key]int32, r.N)
+	r.maxSeqPerKeyOp = make(map[state.Operation]map[state.Key]int32)
+	for _, op := range r.State.Machine.Conflicts().Ops() {
+		r.maxSeqPerKeyOp[op] = make(map[state.Key]int32)
+	}
+	r.clearHashtables()
+
+	// LIKEs used to be missing from the operations that were tracked
+	r.updateSmartConflicts([]state.Command{{1, state.NO_CLIENT, state.LIKE, state.IntKey(5), state.IntValue(1), state.NIL, nil, 0}}, 1, 3, 4)
+	r.updateSmartConflicts([]state.Command{{2, state.NO_CLIENT, state.PUT, state.IntKey(5), state.IntValue(1), state.NIL, nil, 0}}, 2, 7, 2)
+	read := []state.Command{{3, state.NO_CLIENT, state.READ, state.IntKey(5), state.NIL, state.NIL, nil, 0}}
+	seq, deps, _ := r.updateAttributes(read, 0, r.makeDeps(-1), 0, 0)
+	if deps[1] != 3 || deps[2] != -1 || seq != 5 {
+		t.Fatalf("wrong dependencies on a LIKE: seq %d, deps %v", seq, deps)
+	}
+}
+
+func TestBatchFilters(t *testing.T) {
+	r := initReplica()
+	defer func(pt uint32) { bf_PT = pt }(bf_PT)
+	bf_PT = 12
+
+	batch := func(first int64, ops ...state.Operation) []state.Command {
+		cmds := make([]state.Command, len(ops))
+		for i, op := range ops {
+			cmds[i] = state.Command{int32(i), state.NO_CLIENT, op, state.IntKey(first + int64(i)), state.IntValue(1), state.NIL, nil, 0}
+		}
+		return cmds
+	}
+	v := &Instance{Cmds: batch(0, state.PUT, state.PUT, state.PUT)}
+	w := &Instance{Cmds: batch(100, state.PUT, state.PUT)}
+	if r.exec.mayConflict(v, w) || w.bfilter == nil {
+		t.Fatal("batches on distinct keys conflict")
+	}
+	w.Cmds = batch(2, state.GET, state.GET)
+	if !r.exec.mayConflict(v, w) {
+		t.Fatal("stale filter used after the commands changed")
+	}
+	w.Cmds = batch(2, state.SCAN)
+	w.Cmds[0].Keys = []state.Key{state.IntKey(4)}
+	if bfFromCommands(w.Cmds) != nil || !r.exec.mayConflict(v, w) {
+		t.Fatal("a scan was checked against the filter")
+	}
+}
+
+// a durable replica whose stable store is stable-store-replica0 in the
+// current directory, as a restarted server would open it
+func durableReplica(t *testing.T) *Replica {
+	r := initReplica()
+	r.Durable = true
+	r.conflicts = make([]map[state.Key]int32, r.N)
+	r.smartConflicts = make([]map[state.Operation]map[state.Key]int32, r.N)
+	r.maxSeqPerKeyOp = make(map[state.Operation]map[state.Key]int32)
+	for _, op := range r.State.Machine.Conflicts().Ops() {
+		r.maxSeqPerKeyOp[op] = make(map[state.Key]int32)
+	}
+	r.clearHashtables()
+	f, err := os.OpenFile(stableStoreFileName(r.Id), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	r.StableStore = f
+	return r
+}
+
+func TestLogReplay(t *testing.T) {
+	t.Chdir(t.TempDir())
+	r := durableReplica(t)
+	put := []state.Command{{1, state.NO_CLIENT, state.PUT, state.IntKey(7), state.IntValue(70), state.NIL, nil, 0}}
+	committed := &Instance{put, genericsmrproto.Ballot{2, 1, 1}, epaxosproto.COMMITTED, 3, []int32{-1, -1, 0}, nil, 0, 0, nil}
+	r.recordInstanceMetadata(1, 0, committed)
+	r.recordCommands(1, 0, put)
+	accepted := &Instance{nil, genericsmrproto.InitialBallot(0), epaxosproto.ACCEPTED, 4, []int32{-1, 0, -1}, nil, 0, 0, nil}
+	r.recordInstanceMetadata(0, 0, accepted)
+	r.recordCommands(0, 0, put)
+	r.sync()
+
+	r2 := durableReplica(t)
+	r2.recoverFromStableStore()
+	inst := r2.InstanceSpace[1].Get(0)
+	if inst == nil || inst.Status != epaxosproto.COMMITTED || inst.Seq != 3 || inst.ballot != committed.ballot || !equal(inst.Deps, committed.Deps) {
+		t.Fatalf("wrong instance replayed: %+v", inst)
+	}
+	if len(inst.Cmds) != 1 || inst.Cmds[0].Op != state.PUT || inst.Cmds[0].V.Int() != 70 {
+		t.Fatalf("wrong commands replayed: %v", inst.Cmds)
+	}
+	if r2.CommittedUpTo[1] != 0 || r2.crtInstance[0] != 1 || r2.crtInstance[1] != 1 || r2.maxSeq != 5 {
+		t.Fatalf("wrong frontier replayed: crtInstance %v, CommittedUpTo %v, maxSeq %d", r2.crtInstance, r2.CommittedUpTo, r2.maxSeq)
+	}
+	if len(r2.unrecovered) != 1 || r2.unrecovered[0] != (instanceId{0, 0}) {
+		t.Fatalf("own uncommitted instance not left for recovery: %v", r2.unrecovered)
+	}
+}
+
+func TestLogTornTail(t *testing.T) {
+	t.Chdir(t.TempDir())
+	r := durableReplica(t)
+	put := []state.Command{{1, state.NO_CLIENT, state.PUT, state.IntKey(7), state.IntValue(70), state.NIL, nil, 0}}
+	r.recordInstanceMetadata(1, 0, &Instance{put, genericsmrproto.InitialBallot(1), epaxosproto.COMMITTED, 1, []int32{-1, -1, -1}, nil, 0, 0, nil})
+	r.recordCommands(1, 0, put)
+	r.sync()
+	fi, _ := r.StableStore.Stat()
+	good := fi.Size()
+
+	// a commands record cut off after its count, which claims far more
+	// commands than the log could hold
+	var b [LOG_HEADER_SIZE + 4]byte
+	b[0] = LOG_COMMANDS
+	b[1] = 1
+	b[5] = 1
+	b[9], b[10], b[11], b[12] = 0xff, 0xff, 0xff, 0x3f
+	r.StableStore.Write(b[:])
+	r.sync()
+
+	r2 := durableReplica(t)
+	r2.recoverFromStableStore()
+	if r2.InstanceSpace[1].Get(0) == nil || r2.InstanceSpace[1].Get(1) != nil || r2.crtInstance[1] != 1 {
+		t.Fatalf("wrong instances replayed: crtInstance %v", r2.crtInstance)
+	}
+	if fi, _ := r2.StableStore.Stat(); fi.Size() != good {
+		t.Fatalf("torn record not cut off: %d bytes left, %d expected", fi.Size(), good)
+	}
+
+	// a commands record that is missing the end of its last command
+	r2.recordCommands(1, 1, put)
+	r2.sync()
+	fi, _ = r2.StableStore.Stat()
+	r2.StableStore.Truncate(fi.Size() - 2)
+
+	r3 := durableReplica(t)
+	r3.recoverFromStableStore()
+	if r3.crtInstance[1] != 1 {
+		t.Fatalf("torn command replayed: crtInstance %v", r3.crtInstance)
+	}
+	if fi, _ := r3.StableStore.Stat(); fi.Size() != good {
+		t.Fatalf("torn command not cut off: %d bytes left, %d expected", fi.Size(), good)
+	}
 }
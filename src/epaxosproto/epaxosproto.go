@@ -1,6 +1,7 @@
 package epaxosproto
 
 import (
+	"genericsmrproto"
 	"state"
 )
 
@@ -8,7 +9,7 @@ type Prepare struct {
 	LeaderId int32
 	Replica  int32
 	Instance int32
-	Ballot   int32
+	Ballot   genericsmrproto.Ballot
 }
 
 type PrepareReply struct {
@@ -16,31 +17,31 @@ type PrepareReply struct {
 	Replica    int32
 	Instance   int32
 	OK         uint8
-	Ballot     int32
+	Ballot     genericsmrproto.Ballot
 	Status     int8
 	Command    []state.Command
 	Seq        int32
-	Deps       [5]int32
+	Deps       []int32
 }
 
 type PreAccept struct {
 	LeaderId int32
 	Replica  int32
 	Instance int32
-	Ballot   int32
+	Ballot   genericsmrproto.Ballot
 	Command  []state.Command
 	Seq      int32
-	Deps     [5]int32
+	Deps     []int32
 }
 
 type PreAcceptReply struct {
 	Replica       int32
 	Instance      int32
 	OK            uint8
-	Ballot        int32
+	Ballot        genericsmrproto.Ballot
 	Seq           int32
-	Deps          [5]int32
-	CommittedDeps [5]int32
+	Deps          []int32
+	CommittedDeps []int32
 }
 
 type PreAcceptOK struct {
@@ -51,17 +52,17 @@ type Accept struct {
 	LeaderId int32
 	Replica  int32
 	Instance int32
-	Ballot   int32
+	Ballot   genericsmrproto.Ballot
 	Count    int32
 	Seq      int32
-	Deps     [5]int32
+	Deps     []int32
 }
 
 type AcceptReply struct {
 	Replica  int32
 	Instance int32
 	OK       uint8
-	Ballot   int32
+	Ballot   genericsmrproto.Ballot
 }
 
 type Commit struct {
@@ -70,7 +71,7 @@ type Commit struct {
 	Instance int32
 	Command  []state.Command
 	Seq      int32
-	Deps     [5]int32
+	Deps     []int32
 }
 
 type CommitShort struct {
@@ -79,17 +80,17 @@ type CommitShort struct {
 	Instance int32
 	Count    int32
 	Seq      int32
-	Deps     [5]int32
+	Deps     []int32
 }
 
 type TryPreAccept struct {
 	LeaderId int32
 	Replica  int32
 	Instance int32
-	Ballot   int32
+	Ballot   genericsmrproto.Ballot
 	Command  []state.Command
 	Seq      int32
-	Deps     [5]int32
+	Deps     []int32
 }
 
 type TryPreAcceptReply struct {
@@ -97,12 +98,57 @@ type TryPreAcceptReply struct {
 	Replica          int32
 	Instance         int32
 	OK               uint8
-	Ballot           int32
+	Ballot           genericsmrproto.Ballot
 	ConflictReplica  int32
 	ConflictInstance int32
 	ConflictStatus   int8
 }
 
+// periodically sent to every peer, so that instances that have been
+// executed everywhere can be garbage collected
+type ExecStatus struct {
+	ReplicaId  int32
+	ExecedUpTo []int32
+}
+
+// sent by a replica that has fallen too far behind the sender of an ExecStatus
+type SnapshotRequest struct {
+	ReplicaId int32
+}
+
+// the application state after executing every instance up to ExecedUpTo,
+// plus the instances past that frontier that the requester needs to resume
+type Snapshot struct {
+	ReplicaId  int32
+	ExecedUpTo []int32
+	MaxSeq     int32
+	State      []uint8
+	Executed   []Commit // already reflected in State
+	Committed  []Commit // not reflected in State yet
+}
+
+// sent periodically by the replicas that serve FAST_READs locally
+type LeaseRequest struct {
+	HolderId  int32
+	Timestamp int64
+}
+
+// the grantor will not commit instances that the holder does not know about
+// until the lease expires; Frontier is only set if the grantor's previous
+// lease to the holder had expired
+type LeaseGrant struct {
+	GrantorId int32
+	Timestamp int64 // of the LeaseRequest
+	Frontier  []int32
+}
+
+// sent by a lease holder to the leader of an instance it has learned about
+type LeaseAck struct {
+	AcceptorId int32
+	Replica    int32
+	Instance   int32
+}
+
 const (
 	NONE int8 = iota
 	PREACCEPTED
@@ -54,9 +54,9 @@ func (p *TryPreAccept) New() fastrpc.Serializable {
 }
 
 func (t *TryPreAccept) Marshal(wire io.Writer) {
-	var b [24]byte
+	var b [12]byte
 	var bs []byte
-	bs = b[:16]
+	bs = b[:12]
 	tmp32 := t.LeaderId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -72,12 +72,8 @@ func (t *TryPreAccept) Marshal(wire io.Writer) {
 	bs[9] = byte(tmp32 >> 8)
 	bs[10] = byte(tmp32 >> 16)
 	bs[11] = byte(tmp32 >> 24)
-	tmp32 = t.Ballot
-	bs[12] = byte(tmp32)
-	bs[13] = byte(tmp32 >> 8)
-	bs[14] = byte(tmp32 >> 16)
-	bs[15] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	t.Ballot.Marshal(wire)
 	bs = b[:]
 	alen1 := int64(len(t.Command))
 	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
@@ -86,37 +82,27 @@ func (t *TryPreAccept) Marshal(wire io.Writer) {
 	for i := int64(0); i < alen1; i++ {
 		t.Command[i].Marshal(wire)
 	}
+	bs = b[:4]
 	tmp32 = t.Seq
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
 	bs[2] = byte(tmp32 >> 16)
 	bs[3] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[0]
-	bs[4] = byte(tmp32)
-	bs[5] = byte(tmp32 >> 8)
-	bs[6] = byte(tmp32 >> 16)
-	bs[7] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[1]
-	bs[8] = byte(tmp32)
-	bs[9] = byte(tmp32 >> 8)
-	bs[10] = byte(tmp32 >> 16)
-	bs[11] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[2]
-	bs[12] = byte(tmp32)
-	bs[13] = byte(tmp32 >> 8)
-	bs[14] = byte(tmp32 >> 16)
-	bs[15] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[3]
-	bs[16] = byte(tmp32)
-	bs[17] = byte(tmp32 >> 8)
-	bs[18] = byte(tmp32 >> 16)
-	bs[19] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[4]
-	bs[20] = byte(tmp32)
-	bs[21] = byte(tmp32 >> 8)
-	bs[22] = byte(tmp32 >> 16)
-	bs[23] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	bs = b[:]
+	alen2 := int64(len(t.Deps))
+	if wlen := binary.PutVarint(bs, alen2); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen2; i++ {
+		bs = b[:4]
+		tmp32 = t.Deps[i]
+		bs[0] = byte(tmp32)
+		bs[1] = byte(tmp32 >> 8)
+		bs[2] = byte(tmp32 >> 16)
+		bs[3] = byte(tmp32 >> 24)
+		wire.Write(bs)
+	}
 }
 
 func (t *TryPreAccept) Unmarshal(rr io.Reader) error {
@@ -125,16 +111,18 @@ func (t *TryPreAccept) Unmarshal(rr io.Reader) error {
 	if wire, ok = rr.(byteReader); !ok {
 		wire = bufio.NewReader(rr)
 	}
-	var b [24]byte
+	var b [12]byte
 	var bs []byte
-	bs = b[:16]
-	if _, err := io.ReadAtLeast(wire, bs, 16); err != nil {
+	bs = b[:12]
+	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
 		return err
 	}
 	t.LeaderId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Replica = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
 	t.Instance = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
-	t.Ballot = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
 	alen1, err := binary.ReadVarint(wire)
 	if err != nil {
 		return err
@@ -143,21 +131,28 @@ func (t *TryPreAccept) Unmarshal(rr io.Reader) error {
 	for i := int64(0); i < alen1; i++ {
 		t.Command[i].Unmarshal(wire)
 	}
-	bs = b[:24]
-	if _, err := io.ReadAtLeast(wire, bs, 24); err != nil {
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
 		return err
 	}
 	t.Seq = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
-	t.Deps[0] = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
-	t.Deps[1] = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
-	t.Deps[2] = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
-	t.Deps[3] = int32((uint32(bs[16]) | (uint32(bs[17]) << 8) | (uint32(bs[18]) << 16) | (uint32(bs[19]) << 24)))
-	t.Deps[4] = int32((uint32(bs[20]) | (uint32(bs[21]) << 8) | (uint32(bs[22]) << 16) | (uint32(bs[23]) << 24)))
+	alen2, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Deps = make([]int32, alen2)
+	for i := int64(0); i < alen2; i++ {
+		bs = b[:4]
+		if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+			return err
+		}
+		t.Deps[i] = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	}
 	return nil
 }
 
 func (t *PreAcceptReply) BinarySize() (nbytes int, sizeKnown bool) {
-	return 57, true
+	return 0, false
 }
 
 type PreAcceptReplyCache struct {
@@ -196,9 +191,9 @@ func (p *PreAcceptReply) New() fastrpc.Serializable {
 }
 
 func (t *PreAcceptReply) Marshal(wire io.Writer) {
-	var b [57]byte
+	var b [10]byte
 	var bs []byte
-	bs = b[:57]
+	bs = b[:9]
 	tmp32 := t.Replica
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -210,96 +205,97 @@ func (t *PreAcceptReply) Marshal(wire io.Writer) {
 	bs[6] = byte(tmp32 >> 16)
 	bs[7] = byte(tmp32 >> 24)
 	bs[8] = byte(t.OK)
-	tmp32 = t.Ballot
-	bs[9] = byte(tmp32)
-	bs[10] = byte(tmp32 >> 8)
-	bs[11] = byte(tmp32 >> 16)
-	bs[12] = byte(tmp32 >> 24)
+	wire.Write(bs)
+	t.Ballot.Marshal(wire)
+	bs = b[:4]
 	tmp32 = t.Seq
-	bs[13] = byte(tmp32)
-	bs[14] = byte(tmp32 >> 8)
-	bs[15] = byte(tmp32 >> 16)
-	bs[16] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[0]
-	bs[17] = byte(tmp32)
-	bs[18] = byte(tmp32 >> 8)
-	bs[19] = byte(tmp32 >> 16)
-	bs[20] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[1]
-	bs[21] = byte(tmp32)
-	bs[22] = byte(tmp32 >> 8)
-	bs[23] = byte(tmp32 >> 16)
-	bs[24] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[2]
-	bs[25] = byte(tmp32)
-	bs[26] = byte(tmp32 >> 8)
-	bs[27] = byte(tmp32 >> 16)
-	bs[28] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[3]
-	bs[29] = byte(tmp32)
-	bs[30] = byte(tmp32 >> 8)
-	bs[31] = byte(tmp32 >> 16)
-	bs[32] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[4]
-	bs[33] = byte(tmp32)
-	bs[34] = byte(tmp32 >> 8)
-	bs[35] = byte(tmp32 >> 16)
-	bs[36] = byte(tmp32 >> 24)
-	tmp32 = t.CommittedDeps[0]
-	bs[37] = byte(tmp32)
-	bs[38] = byte(tmp32 >> 8)
-	bs[39] = byte(tmp32 >> 16)
-	bs[40] = byte(tmp32 >> 24)
-	tmp32 = t.CommittedDeps[1]
-	bs[41] = byte(tmp32)
-	bs[42] = byte(tmp32 >> 8)
-	bs[43] = byte(tmp32 >> 16)
-	bs[44] = byte(tmp32 >> 24)
-	tmp32 = t.CommittedDeps[2]
-	bs[45] = byte(tmp32)
-	bs[46] = byte(tmp32 >> 8)
-	bs[47] = byte(tmp32 >> 16)
-	bs[48] = byte(tmp32 >> 24)
-	tmp32 = t.CommittedDeps[3]
-	bs[49] = byte(tmp32)
-	bs[50] = byte(tmp32 >> 8)
-	bs[51] = byte(tmp32 >> 16)
-	bs[52] = byte(tmp32 >> 24)
-	tmp32 = t.CommittedDeps[4]
-	bs[53] = byte(tmp32)
-	bs[54] = byte(tmp32 >> 8)
-	bs[55] = byte(tmp32 >> 16)
-	bs[56] = byte(tmp32 >> 24)
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	bs = b[:]
+	alen1 := int64(len(t.Deps))
+	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:4]
+		tmp32 = t.Deps[i]
+		bs[0] = byte(tmp32)
+		bs[1] = byte(tmp32 >> 8)
+		bs[2] = byte(tmp32 >> 16)
+		bs[3] = byte(tmp32 >> 24)
+		wire.Write(bs)
+	}
+	bs = b[:]
+	alen2 := int64(len(t.CommittedDeps))
+	if wlen := binary.PutVarint(bs, alen2); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen2; i++ {
+		bs = b[:4]
+		tmp32 = t.CommittedDeps[i]
+		bs[0] = byte(tmp32)
+		bs[1] = byte(tmp32 >> 8)
+		bs[2] = byte(tmp32 >> 16)
+		bs[3] = byte(tmp32 >> 24)
+		wire.Write(bs)
+	}
 }
 
-func (t *PreAcceptReply) Unmarshal(wire io.Reader) error {
-	var b [57]byte
+func (t *PreAcceptReply) Unmarshal(rr io.Reader) error {
+	var wire byteReader
+	var ok bool
+	if wire, ok = rr.(byteReader); !ok {
+		wire = bufio.NewReader(rr)
+	}
+	var b [10]byte
 	var bs []byte
-	bs = b[:57]
-	if _, err := io.ReadAtLeast(wire, bs, 57); err != nil {
+	bs = b[:9]
+	if _, err := io.ReadAtLeast(wire, bs, 9); err != nil {
 		return err
 	}
 	t.Replica = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Instance = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
 	t.OK = uint8(bs[8])
-	t.Ballot = int32((uint32(bs[9]) | (uint32(bs[10]) << 8) | (uint32(bs[11]) << 16) | (uint32(bs[12]) << 24)))
-	t.Seq = int32((uint32(bs[13]) | (uint32(bs[14]) << 8) | (uint32(bs[15]) << 16) | (uint32(bs[16]) << 24)))
-	t.Deps[0] = int32((uint32(bs[17]) | (uint32(bs[18]) << 8) | (uint32(bs[19]) << 16) | (uint32(bs[20]) << 24)))
-	t.Deps[1] = int32((uint32(bs[21]) | (uint32(bs[22]) << 8) | (uint32(bs[23]) << 16) | (uint32(bs[24]) << 24)))
-	t.Deps[2] = int32((uint32(bs[25]) | (uint32(bs[26]) << 8) | (uint32(bs[27]) << 16) | (uint32(bs[28]) << 24)))
-	t.Deps[3] = int32((uint32(bs[29]) | (uint32(bs[30]) << 8) | (uint32(bs[31]) << 16) | (uint32(bs[32]) << 24)))
-	t.Deps[4] = int32((uint32(bs[33]) | (uint32(bs[34]) << 8) | (uint32(bs[35]) << 16) | (uint32(bs[36]) << 24)))
-	t.CommittedDeps[0] = int32((uint32(bs[37]) | (uint32(bs[38]) << 8) | (uint32(bs[39]) << 16) | (uint32(bs[40]) << 24)))
-	t.CommittedDeps[1] = int32((uint32(bs[41]) | (uint32(bs[42]) << 8) | (uint32(bs[43]) << 16) | (uint32(bs[44]) << 24)))
-	t.CommittedDeps[2] = int32((uint32(bs[45]) | (uint32(bs[46]) << 8) | (uint32(bs[47]) << 16) | (uint32(bs[48]) << 24)))
-	t.CommittedDeps[3] = int32((uint32(bs[49]) | (uint32(bs[50]) << 8) | (uint32(bs[51]) << 16) | (uint32(bs[52]) << 24)))
-	t.CommittedDeps[4] = int32((uint32(bs[53]) | (uint32(bs[54]) << 8) | (uint32(bs[55]) << 16) | (uint32(bs[56]) << 24)))
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+		return err
+	}
+	t.Seq = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	alen1, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Deps = make([]int32, alen1)
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:4]
+		if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+			return err
+		}
+		t.Deps[i] = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	}
+	alen2, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.CommittedDeps = make([]int32, alen2)
+	for i := int64(0); i < alen2; i++ {
+		bs = b[:4]
+		if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+			return err
+		}
+		t.CommittedDeps[i] = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	}
 	return nil
 }
 
 func (t *TryPreAcceptReply) BinarySize() (nbytes int, sizeKnown bool) {
-	return 26, true
+	return 0, false
 }
 
 type TryPreAcceptReplyCache struct {
@@ -338,9 +334,9 @@ func (p *TryPreAcceptReply) New() fastrpc.Serializable {
 }
 
 func (t *TryPreAcceptReply) Marshal(wire io.Writer) {
-	var b [26]byte
+	var b [13]byte
 	var bs []byte
-	bs = b[:26]
+	bs = b[:13]
 	tmp32 := t.AcceptorId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -357,45 +353,49 @@ func (t *TryPreAcceptReply) Marshal(wire io.Writer) {
 	bs[10] = byte(tmp32 >> 16)
 	bs[11] = byte(tmp32 >> 24)
 	bs[12] = byte(t.OK)
-	tmp32 = t.Ballot
-	bs[13] = byte(tmp32)
-	bs[14] = byte(tmp32 >> 8)
-	bs[15] = byte(tmp32 >> 16)
-	bs[16] = byte(tmp32 >> 24)
+	wire.Write(bs)
+	t.Ballot.Marshal(wire)
+	bs = b[:9]
 	tmp32 = t.ConflictReplica
-	bs[17] = byte(tmp32)
-	bs[18] = byte(tmp32 >> 8)
-	bs[19] = byte(tmp32 >> 16)
-	bs[20] = byte(tmp32 >> 24)
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
 	tmp32 = t.ConflictInstance
-	bs[21] = byte(tmp32)
-	bs[22] = byte(tmp32 >> 8)
-	bs[23] = byte(tmp32 >> 16)
-	bs[24] = byte(tmp32 >> 24)
-	bs[25] = byte(t.ConflictStatus)
+	bs[4] = byte(tmp32)
+	bs[5] = byte(tmp32 >> 8)
+	bs[6] = byte(tmp32 >> 16)
+	bs[7] = byte(tmp32 >> 24)
+	bs[8] = byte(t.ConflictStatus)
 	wire.Write(bs)
 }
 
 func (t *TryPreAcceptReply) Unmarshal(wire io.Reader) error {
-	var b [26]byte
+	var b [13]byte
 	var bs []byte
-	bs = b[:26]
-	if _, err := io.ReadAtLeast(wire, bs, 26); err != nil {
+	bs = b[:13]
+	if _, err := io.ReadAtLeast(wire, bs, 13); err != nil {
 		return err
 	}
 	t.AcceptorId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Replica = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
 	t.Instance = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
 	t.OK = uint8(bs[12])
-	t.Ballot = int32((uint32(bs[13]) | (uint32(bs[14]) << 8) | (uint32(bs[15]) << 16) | (uint32(bs[16]) << 24)))
-	t.ConflictReplica = int32((uint32(bs[17]) | (uint32(bs[18]) << 8) | (uint32(bs[19]) << 16) | (uint32(bs[20]) << 24)))
-	t.ConflictInstance = int32((uint32(bs[21]) | (uint32(bs[22]) << 8) | (uint32(bs[23]) << 16) | (uint32(bs[24]) << 24)))
-	t.ConflictStatus = int8(bs[25])
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
+	bs = b[:9]
+	if _, err := io.ReadAtLeast(wire, bs, 9); err != nil {
+		return err
+	}
+	t.ConflictReplica = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.ConflictInstance = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	t.ConflictStatus = int8(bs[8])
 	return nil
 }
 
 func (t *CommitShort) BinarySize() (nbytes int, sizeKnown bool) {
-	return 40, true
+	return 0, false
 }
 
 type CommitShortCache struct {
@@ -434,9 +434,9 @@ func (p *CommitShort) New() fastrpc.Serializable {
 }
 
 func (t *CommitShort) Marshal(wire io.Writer) {
-	var b [40]byte
+	var b [20]byte
 	var bs []byte
-	bs = b[:40]
+	bs = b[:20]
 	tmp32 := t.LeaderId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -462,39 +462,33 @@ func (t *CommitShort) Marshal(wire io.Writer) {
 	bs[17] = byte(tmp32 >> 8)
 	bs[18] = byte(tmp32 >> 16)
 	bs[19] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[0]
-	bs[20] = byte(tmp32)
-	bs[21] = byte(tmp32 >> 8)
-	bs[22] = byte(tmp32 >> 16)
-	bs[23] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[1]
-	bs[24] = byte(tmp32)
-	bs[25] = byte(tmp32 >> 8)
-	bs[26] = byte(tmp32 >> 16)
-	bs[27] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[2]
-	bs[28] = byte(tmp32)
-	bs[29] = byte(tmp32 >> 8)
-	bs[30] = byte(tmp32 >> 16)
-	bs[31] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[3]
-	bs[32] = byte(tmp32)
-	bs[33] = byte(tmp32 >> 8)
-	bs[34] = byte(tmp32 >> 16)
-	bs[35] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[4]
-	bs[36] = byte(tmp32)
-	bs[37] = byte(tmp32 >> 8)
-	bs[38] = byte(tmp32 >> 16)
-	bs[39] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	bs = b[:]
+	alen1 := int64(len(t.Deps))
+	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:4]
+		tmp32 = t.Deps[i]
+		bs[0] = byte(tmp32)
+		bs[1] = byte(tmp32 >> 8)
+		bs[2] = byte(tmp32 >> 16)
+		bs[3] = byte(tmp32 >> 24)
+		wire.Write(bs)
+	}
 }
 
-func (t *CommitShort) Unmarshal(wire io.Reader) error {
-	var b [40]byte
+func (t *CommitShort) Unmarshal(rr io.Reader) error {
+	var wire byteReader
+	var ok bool
+	if wire, ok = rr.(byteReader); !ok {
+		wire = bufio.NewReader(rr)
+	}
+	var b [20]byte
 	var bs []byte
-	bs = b[:40]
-	if _, err := io.ReadAtLeast(wire, bs, 40); err != nil {
+	bs = b[:20]
+	if _, err := io.ReadAtLeast(wire, bs, 20); err != nil {
 		return err
 	}
 	t.LeaderId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
@@ -502,11 +496,18 @@ func (t *CommitShort) Unmarshal(wire io.Reader) error {
 	t.Instance = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
 	t.Count = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
 	t.Seq = int32((uint32(bs[16]) | (uint32(bs[17]) << 8) | (uint32(bs[18]) << 16) | (uint32(bs[19]) << 24)))
-	t.Deps[0] = int32((uint32(bs[20]) | (uint32(bs[21]) << 8) | (uint32(bs[22]) << 16) | (uint32(bs[23]) << 24)))
-	t.Deps[1] = int32((uint32(bs[24]) | (uint32(bs[25]) << 8) | (uint32(bs[26]) << 16) | (uint32(bs[27]) << 24)))
-	t.Deps[2] = int32((uint32(bs[28]) | (uint32(bs[29]) << 8) | (uint32(bs[30]) << 16) | (uint32(bs[31]) << 24)))
-	t.Deps[3] = int32((uint32(bs[32]) | (uint32(bs[33]) << 8) | (uint32(bs[34]) << 16) | (uint32(bs[35]) << 24)))
-	t.Deps[4] = int32((uint32(bs[36]) | (uint32(bs[37]) << 8) | (uint32(bs[38]) << 16) | (uint32(bs[39]) << 24)))
+	alen1, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Deps = make([]int32, alen1)
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:4]
+		if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+			return err
+		}
+		t.Deps[i] = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	}
 	return nil
 }
 
@@ -550,9 +551,9 @@ func (p *PreAccept) New() fastrpc.Serializable {
 }
 
 func (t *PreAccept) Marshal(wire io.Writer) {
-	var b [24]byte
+	var b [12]byte
 	var bs []byte
-	bs = b[:16]
+	bs = b[:12]
 	tmp32 := t.LeaderId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -568,12 +569,8 @@ func (t *PreAccept) Marshal(wire io.Writer) {
 	bs[9] = byte(tmp32 >> 8)
 	bs[10] = byte(tmp32 >> 16)
 	bs[11] = byte(tmp32 >> 24)
-	tmp32 = t.Ballot
-	bs[12] = byte(tmp32)
-	bs[13] = byte(tmp32 >> 8)
-	bs[14] = byte(tmp32 >> 16)
-	bs[15] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	t.Ballot.Marshal(wire)
 	bs = b[:]
 	alen1 := int64(len(t.Command))
 	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
@@ -582,37 +579,27 @@ func (t *PreAccept) Marshal(wire io.Writer) {
 	for i := int64(0); i < alen1; i++ {
 		t.Command[i].Marshal(wire)
 	}
+	bs = b[:4]
 	tmp32 = t.Seq
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
 	bs[2] = byte(tmp32 >> 16)
 	bs[3] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[0]
-	bs[4] = byte(tmp32)
-	bs[5] = byte(tmp32 >> 8)
-	bs[6] = byte(tmp32 >> 16)
-	bs[7] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[1]
-	bs[8] = byte(tmp32)
-	bs[9] = byte(tmp32 >> 8)
-	bs[10] = byte(tmp32 >> 16)
-	bs[11] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[2]
-	bs[12] = byte(tmp32)
-	bs[13] = byte(tmp32 >> 8)
-	bs[14] = byte(tmp32 >> 16)
-	bs[15] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[3]
-	bs[16] = byte(tmp32)
-	bs[17] = byte(tmp32 >> 8)
-	bs[18] = byte(tmp32 >> 16)
-	bs[19] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[4]
-	bs[20] = byte(tmp32)
-	bs[21] = byte(tmp32 >> 8)
-	bs[22] = byte(tmp32 >> 16)
-	bs[23] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	bs = b[:]
+	alen2 := int64(len(t.Deps))
+	if wlen := binary.PutVarint(bs, alen2); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen2; i++ {
+		bs = b[:4]
+		tmp32 = t.Deps[i]
+		bs[0] = byte(tmp32)
+		bs[1] = byte(tmp32 >> 8)
+		bs[2] = byte(tmp32 >> 16)
+		bs[3] = byte(tmp32 >> 24)
+		wire.Write(bs)
+	}
 }
 
 func (t *PreAccept) Unmarshal(rr io.Reader) error {
@@ -621,16 +608,18 @@ func (t *PreAccept) Unmarshal(rr io.Reader) error {
 	if wire, ok = rr.(byteReader); !ok {
 		wire = bufio.NewReader(rr)
 	}
-	var b [24]byte
+	var b [12]byte
 	var bs []byte
-	bs = b[:16]
-	if _, err := io.ReadAtLeast(wire, bs, 16); err != nil {
+	bs = b[:12]
+	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
 		return err
 	}
 	t.LeaderId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Replica = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
 	t.Instance = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
-	t.Ballot = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
 	alen1, err := binary.ReadVarint(wire)
 	if err != nil {
 		return err
@@ -639,16 +628,23 @@ func (t *PreAccept) Unmarshal(rr io.Reader) error {
 	for i := int64(0); i < alen1; i++ {
 		t.Command[i].Unmarshal(wire)
 	}
-	bs = b[:24]
-	if _, err := io.ReadAtLeast(wire, bs, 24); err != nil {
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
 		return err
 	}
 	t.Seq = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
-	t.Deps[0] = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
-	t.Deps[1] = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
-	t.Deps[2] = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
-	t.Deps[3] = int32((uint32(bs[16]) | (uint32(bs[17]) << 8) | (uint32(bs[18]) << 16) | (uint32(bs[19]) << 24)))
-	t.Deps[4] = int32((uint32(bs[20]) | (uint32(bs[21]) << 8) | (uint32(bs[22]) << 16) | (uint32(bs[23]) << 24)))
+	alen2, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Deps = make([]int32, alen2)
+	for i := int64(0); i < alen2; i++ {
+		bs = b[:4]
+		if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+			return err
+		}
+		t.Deps[i] = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	}
 	return nil
 }
 
@@ -754,9 +750,9 @@ func (p *PrepareReply) New() fastrpc.Serializable {
 }
 
 func (t *PrepareReply) Marshal(wire io.Writer) {
-	var b [24]byte
+	var b [13]byte
 	var bs []byte
-	bs = b[:18]
+	bs = b[:13]
 	tmp32 := t.AcceptorId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -773,12 +769,10 @@ func (t *PrepareReply) Marshal(wire io.Writer) {
 	bs[10] = byte(tmp32 >> 16)
 	bs[11] = byte(tmp32 >> 24)
 	bs[12] = byte(t.OK)
-	tmp32 = t.Ballot
-	bs[13] = byte(tmp32)
-	bs[14] = byte(tmp32 >> 8)
-	bs[15] = byte(tmp32 >> 16)
-	bs[16] = byte(tmp32 >> 24)
-	bs[17] = byte(t.Status)
+	wire.Write(bs)
+	t.Ballot.Marshal(wire)
+	bs = b[:1]
+	bs[0] = byte(t.Status)
 	wire.Write(bs)
 	bs = b[:]
 	alen1 := int64(len(t.Command))
@@ -788,37 +782,27 @@ func (t *PrepareReply) Marshal(wire io.Writer) {
 	for i := int64(0); i < alen1; i++ {
 		t.Command[i].Marshal(wire)
 	}
+	bs = b[:4]
 	tmp32 = t.Seq
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
 	bs[2] = byte(tmp32 >> 16)
 	bs[3] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[0]
-	bs[4] = byte(tmp32)
-	bs[5] = byte(tmp32 >> 8)
-	bs[6] = byte(tmp32 >> 16)
-	bs[7] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[1]
-	bs[8] = byte(tmp32)
-	bs[9] = byte(tmp32 >> 8)
-	bs[10] = byte(tmp32 >> 16)
-	bs[11] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[2]
-	bs[12] = byte(tmp32)
-	bs[13] = byte(tmp32 >> 8)
-	bs[14] = byte(tmp32 >> 16)
-	bs[15] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[3]
-	bs[16] = byte(tmp32)
-	bs[17] = byte(tmp32 >> 8)
-	bs[18] = byte(tmp32 >> 16)
-	bs[19] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[4]
-	bs[20] = byte(tmp32)
-	bs[21] = byte(tmp32 >> 8)
-	bs[22] = byte(tmp32 >> 16)
-	bs[23] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	bs = b[:]
+	alen2 := int64(len(t.Deps))
+	if wlen := binary.PutVarint(bs, alen2); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen2; i++ {
+		bs = b[:4]
+		tmp32 = t.Deps[i]
+		bs[0] = byte(tmp32)
+		bs[1] = byte(tmp32 >> 8)
+		bs[2] = byte(tmp32 >> 16)
+		bs[3] = byte(tmp32 >> 24)
+		wire.Write(bs)
+	}
 }
 
 func (t *PrepareReply) Unmarshal(rr io.Reader) error {
@@ -827,18 +811,24 @@ func (t *PrepareReply) Unmarshal(rr io.Reader) error {
 	if wire, ok = rr.(byteReader); !ok {
 		wire = bufio.NewReader(rr)
 	}
-	var b [24]byte
+	var b [13]byte
 	var bs []byte
-	bs = b[:18]
-	if _, err := io.ReadAtLeast(wire, bs, 18); err != nil {
+	bs = b[:13]
+	if _, err := io.ReadAtLeast(wire, bs, 13); err != nil {
 		return err
 	}
 	t.AcceptorId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Replica = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
 	t.Instance = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
 	t.OK = uint8(bs[12])
-	t.Ballot = int32((uint32(bs[13]) | (uint32(bs[14]) << 8) | (uint32(bs[15]) << 16) | (uint32(bs[16]) << 24)))
-	t.Status = int8(bs[17])
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
+	bs = b[:1]
+	if _, err := io.ReadAtLeast(wire, bs, 1); err != nil {
+		return err
+	}
+	t.Status = int8(bs[0])
 	alen1, err := binary.ReadVarint(wire)
 	if err != nil {
 		return err
@@ -847,16 +837,23 @@ func (t *PrepareReply) Unmarshal(rr io.Reader) error {
 	for i := int64(0); i < alen1; i++ {
 		t.Command[i].Unmarshal(wire)
 	}
-	bs = b[:24]
-	if _, err := io.ReadAtLeast(wire, bs, 24); err != nil {
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
 		return err
 	}
 	t.Seq = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
-	t.Deps[0] = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
-	t.Deps[1] = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
-	t.Deps[2] = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
-	t.Deps[3] = int32((uint32(bs[16]) | (uint32(bs[17]) << 8) | (uint32(bs[18]) << 16) | (uint32(bs[19]) << 24)))
-	t.Deps[4] = int32((uint32(bs[20]) | (uint32(bs[21]) << 8) | (uint32(bs[22]) << 16) | (uint32(bs[23]) << 24)))
+	alen2, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Deps = make([]int32, alen2)
+	for i := int64(0); i < alen2; i++ {
+		bs = b[:4]
+		if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+			return err
+		}
+		t.Deps[i] = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	}
 	return nil
 }
 
@@ -900,7 +897,7 @@ func (p *Commit) New() fastrpc.Serializable {
 }
 
 func (t *Commit) Marshal(wire io.Writer) {
-	var b [24]byte
+	var b [12]byte
 	var bs []byte
 	bs = b[:12]
 	tmp32 := t.LeaderId
@@ -927,37 +924,27 @@ func (t *Commit) Marshal(wire io.Writer) {
 	for i := int64(0); i < alen1; i++ {
 		t.Command[i].Marshal(wire)
 	}
+	bs = b[:4]
 	tmp32 = t.Seq
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
 	bs[2] = byte(tmp32 >> 16)
 	bs[3] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[0]
-	bs[4] = byte(tmp32)
-	bs[5] = byte(tmp32 >> 8)
-	bs[6] = byte(tmp32 >> 16)
-	bs[7] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[1]
-	bs[8] = byte(tmp32)
-	bs[9] = byte(tmp32 >> 8)
-	bs[10] = byte(tmp32 >> 16)
-	bs[11] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[2]
-	bs[12] = byte(tmp32)
-	bs[13] = byte(tmp32 >> 8)
-	bs[14] = byte(tmp32 >> 16)
-	bs[15] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[3]
-	bs[16] = byte(tmp32)
-	bs[17] = byte(tmp32 >> 8)
-	bs[18] = byte(tmp32 >> 16)
-	bs[19] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[4]
-	bs[20] = byte(tmp32)
-	bs[21] = byte(tmp32 >> 8)
-	bs[22] = byte(tmp32 >> 16)
-	bs[23] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	bs = b[:]
+	alen2 := int64(len(t.Deps))
+	if wlen := binary.PutVarint(bs, alen2); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen2; i++ {
+		bs = b[:4]
+		tmp32 = t.Deps[i]
+		bs[0] = byte(tmp32)
+		bs[1] = byte(tmp32 >> 8)
+		bs[2] = byte(tmp32 >> 16)
+		bs[3] = byte(tmp32 >> 24)
+		wire.Write(bs)
+	}
 }
 
 func (t *Commit) Unmarshal(rr io.Reader) error {
@@ -966,7 +953,7 @@ func (t *Commit) Unmarshal(rr io.Reader) error {
 	if wire, ok = rr.(byteReader); !ok {
 		wire = bufio.NewReader(rr)
 	}
-	var b [24]byte
+	var b [12]byte
 	var bs []byte
 	bs = b[:12]
 	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
@@ -983,21 +970,28 @@ func (t *Commit) Unmarshal(rr io.Reader) error {
 	for i := int64(0); i < alen1; i++ {
 		t.Command[i].Unmarshal(wire)
 	}
-	bs = b[:24]
-	if _, err := io.ReadAtLeast(wire, bs, 24); err != nil {
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
 		return err
 	}
 	t.Seq = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
-	t.Deps[0] = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
-	t.Deps[1] = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
-	t.Deps[2] = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
-	t.Deps[3] = int32((uint32(bs[16]) | (uint32(bs[17]) << 8) | (uint32(bs[18]) << 16) | (uint32(bs[19]) << 24)))
-	t.Deps[4] = int32((uint32(bs[20]) | (uint32(bs[21]) << 8) | (uint32(bs[22]) << 16) | (uint32(bs[23]) << 24)))
+	alen2, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Deps = make([]int32, alen2)
+	for i := int64(0); i < alen2; i++ {
+		bs = b[:4]
+		if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+			return err
+		}
+		t.Deps[i] = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	}
 	return nil
 }
 
 func (t *AcceptReply) BinarySize() (nbytes int, sizeKnown bool) {
-	return 13, true
+	return 0, false
 }
 
 type AcceptReplyCache struct {
@@ -1036,9 +1030,9 @@ func (p *AcceptReply) New() fastrpc.Serializable {
 }
 
 func (t *AcceptReply) Marshal(wire io.Writer) {
-	var b [13]byte
+	var b [9]byte
 	var bs []byte
-	bs = b[:13]
+	bs = b[:9]
 	tmp32 := t.Replica
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -1050,30 +1044,28 @@ func (t *AcceptReply) Marshal(wire io.Writer) {
 	bs[6] = byte(tmp32 >> 16)
 	bs[7] = byte(tmp32 >> 24)
 	bs[8] = byte(t.OK)
-	tmp32 = t.Ballot
-	bs[9] = byte(tmp32)
-	bs[10] = byte(tmp32 >> 8)
-	bs[11] = byte(tmp32 >> 16)
-	bs[12] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	t.Ballot.Marshal(wire)
 }
 
 func (t *AcceptReply) Unmarshal(wire io.Reader) error {
-	var b [13]byte
+	var b [9]byte
 	var bs []byte
-	bs = b[:13]
-	if _, err := io.ReadAtLeast(wire, bs, 13); err != nil {
+	bs = b[:9]
+	if _, err := io.ReadAtLeast(wire, bs, 9); err != nil {
 		return err
 	}
 	t.Replica = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Instance = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
 	t.OK = uint8(bs[8])
-	t.Ballot = int32((uint32(bs[9]) | (uint32(bs[10]) << 8) | (uint32(bs[11]) << 16) | (uint32(bs[12]) << 24)))
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
 	return nil
 }
 
 func (t *Accept) BinarySize() (nbytes int, sizeKnown bool) {
-	return 44, true
+	return 0, false
 }
 
 type AcceptCache struct {
@@ -1112,9 +1104,9 @@ func (p *Accept) New() fastrpc.Serializable {
 }
 
 func (t *Accept) Marshal(wire io.Writer) {
-	var b [44]byte
+	var b [12]byte
 	var bs []byte
-	bs = b[:44]
+	bs = b[:12]
 	tmp32 := t.LeaderId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -1130,72 +1122,77 @@ func (t *Accept) Marshal(wire io.Writer) {
 	bs[9] = byte(tmp32 >> 8)
 	bs[10] = byte(tmp32 >> 16)
 	bs[11] = byte(tmp32 >> 24)
-	tmp32 = t.Ballot
-	bs[12] = byte(tmp32)
-	bs[13] = byte(tmp32 >> 8)
-	bs[14] = byte(tmp32 >> 16)
-	bs[15] = byte(tmp32 >> 24)
+	wire.Write(bs)
+	t.Ballot.Marshal(wire)
+	bs = b[:8]
 	tmp32 = t.Count
-	bs[16] = byte(tmp32)
-	bs[17] = byte(tmp32 >> 8)
-	bs[18] = byte(tmp32 >> 16)
-	bs[19] = byte(tmp32 >> 24)
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
 	tmp32 = t.Seq
-	bs[20] = byte(tmp32)
-	bs[21] = byte(tmp32 >> 8)
-	bs[22] = byte(tmp32 >> 16)
-	bs[23] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[0]
-	bs[24] = byte(tmp32)
-	bs[25] = byte(tmp32 >> 8)
-	bs[26] = byte(tmp32 >> 16)
-	bs[27] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[1]
-	bs[28] = byte(tmp32)
-	bs[29] = byte(tmp32 >> 8)
-	bs[30] = byte(tmp32 >> 16)
-	bs[31] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[2]
-	bs[32] = byte(tmp32)
-	bs[33] = byte(tmp32 >> 8)
-	bs[34] = byte(tmp32 >> 16)
-	bs[35] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[3]
-	bs[36] = byte(tmp32)
-	bs[37] = byte(tmp32 >> 8)
-	bs[38] = byte(tmp32 >> 16)
-	bs[39] = byte(tmp32 >> 24)
-	tmp32 = t.Deps[4]
-	bs[40] = byte(tmp32)
-	bs[41] = byte(tmp32 >> 8)
-	bs[42] = byte(tmp32 >> 16)
-	bs[43] = byte(tmp32 >> 24)
+	bs[4] = byte(tmp32)
+	bs[5] = byte(tmp32 >> 8)
+	bs[6] = byte(tmp32 >> 16)
+	bs[7] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	bs = b[:]
+	alen1 := int64(len(t.Deps))
+	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:4]
+		tmp32 = t.Deps[i]
+		bs[0] = byte(tmp32)
+		bs[1] = byte(tmp32 >> 8)
+		bs[2] = byte(tmp32 >> 16)
+		bs[3] = byte(tmp32 >> 24)
+		wire.Write(bs)
+	}
 }
 
-func (t *Accept) Unmarshal(wire io.Reader) error {
-	var b [44]byte
+func (t *Accept) Unmarshal(rr io.Reader) error {
+	var wire byteReader
+	var ok bool
+	if wire, ok = rr.(byteReader); !ok {
+		wire = bufio.NewReader(rr)
+	}
+	var b [12]byte
 	var bs []byte
-	bs = b[:44]
-	if _, err := io.ReadAtLeast(wire, bs, 44); err != nil {
+	bs = b[:12]
+	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
 		return err
 	}
 	t.LeaderId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Replica = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
 	t.Instance = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
-	t.Ballot = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
-	t.Count = int32((uint32(bs[16]) | (uint32(bs[17]) << 8) | (uint32(bs[18]) << 16) | (uint32(bs[19]) << 24)))
-	t.Seq = int32((uint32(bs[20]) | (uint32(bs[21]) << 8) | (uint32(bs[22]) << 16) | (uint32(bs[23]) << 24)))
-	t.Deps[0] = int32((uint32(bs[24]) | (uint32(bs[25]) << 8) | (uint32(bs[26]) << 16) | (uint32(bs[27]) << 24)))
-	t.Deps[1] = int32((uint32(bs[28]) | (uint32(bs[29]) << 8) | (uint32(bs[30]) << 16) | (uint32(bs[31]) << 24)))
-	t.Deps[2] = int32((uint32(bs[32]) | (uint32(bs[33]) << 8) | (uint32(bs[34]) << 16) | (uint32(bs[35]) << 24)))
-	t.Deps[3] = int32((uint32(bs[36]) | (uint32(bs[37]) << 8) | (uint32(bs[38]) << 16) | (uint32(bs[39]) << 24)))
-	t.Deps[4] = int32((uint32(bs[40]) | (uint32(bs[41]) << 8) | (uint32(bs[42]) << 16) | (uint32(bs[43]) << 24)))
-	return nil
-}
-
-func (t *Prepare) BinarySize() (nbytes int, sizeKnown bool) {
-	return 16, true
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
+	bs = b[:8]
+	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
+		return err
+	}
+	t.Count = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Seq = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	alen1, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Deps = make([]int32, alen1)
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:4]
+		if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+			return err
+		}
+		t.Deps[i] = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	}
+	return nil
+}
+
+func (t *Prepare) BinarySize() (nbytes int, sizeKnown bool) {
+	return 0, false
 }
 
 type PrepareCache struct {
@@ -1234,9 +1231,9 @@ func (p *Prepare) New() fastrpc.Serializable {
 }
 
 func (t *Prepare) Marshal(wire io.Writer) {
-	var b [16]byte
+	var b [12]byte
 	var bs []byte
-	bs = b[:16]
+	bs = b[:12]
 	tmp32 := t.LeaderId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -1252,24 +1249,577 @@ func (t *Prepare) Marshal(wire io.Writer) {
 	bs[9] = byte(tmp32 >> 8)
 	bs[10] = byte(tmp32 >> 16)
 	bs[11] = byte(tmp32 >> 24)
-	tmp32 = t.Ballot
-	bs[12] = byte(tmp32)
-	bs[13] = byte(tmp32 >> 8)
-	bs[14] = byte(tmp32 >> 16)
-	bs[15] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	t.Ballot.Marshal(wire)
 }
 
 func (t *Prepare) Unmarshal(wire io.Reader) error {
-	var b [16]byte
+	var b [12]byte
 	var bs []byte
-	bs = b[:16]
-	if _, err := io.ReadAtLeast(wire, bs, 16); err != nil {
+	bs = b[:12]
+	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
 		return err
 	}
 	t.LeaderId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Replica = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
 	t.Instance = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
-	t.Ballot = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *ExecStatus) BinarySize() (nbytes int, sizeKnown bool) {
+	return 0, false
+}
+
+type ExecStatusCache struct {
+	mu    sync.Mutex
+	cache []*ExecStatus
+}
+
+func NewExecStatusCache() *ExecStatusCache {
+	c := &ExecStatusCache{}
+	c.cache = make([]*ExecStatus, 0)
+	return c
+}
+
+func (p *ExecStatusCache) Get() *ExecStatus {
+	var t *ExecStatus
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &ExecStatus{}
+	}
+	return t
+}
+
+func (p *ExecStatusCache) Put(t *ExecStatus) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+
+func (p *ExecStatus) New() fastrpc.Serializable {
+	return new(ExecStatus)
+}
+
+func (t *ExecStatus) Marshal(wire io.Writer) {
+	var b [10]byte
+	var bs []byte
+	bs = b[:4]
+	tmp32 := t.ReplicaId
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	wire.Write(bs)
+	bs = b[:]
+	alen1 := int64(len(t.ExecedUpTo))
+	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:4]
+		tmp32 = t.ExecedUpTo[i]
+		bs[0] = byte(tmp32)
+		bs[1] = byte(tmp32 >> 8)
+		bs[2] = byte(tmp32 >> 16)
+		bs[3] = byte(tmp32 >> 24)
+		wire.Write(bs)
+	}
+}
+
+func (t *ExecStatus) Unmarshal(rr io.Reader) error {
+	var wire byteReader
+	var ok bool
+	if wire, ok = rr.(byteReader); !ok {
+		wire = bufio.NewReader(rr)
+	}
+	var b [10]byte
+	var bs []byte
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+		return err
+	}
+	t.ReplicaId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	alen1, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.ExecedUpTo = make([]int32, alen1)
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:4]
+		if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+			return err
+		}
+		t.ExecedUpTo[i] = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	}
+	return nil
+}
+
+func (t *SnapshotRequest) BinarySize() (nbytes int, sizeKnown bool) {
+	return 4, true
+}
+
+type SnapshotRequestCache struct {
+	mu    sync.Mutex
+	cache []*SnapshotRequest
+}
+
+func NewSnapshotRequestCache() *SnapshotRequestCache {
+	c := &SnapshotRequestCache{}
+	c.cache = make([]*SnapshotRequest, 0)
+	return c
+}
+
+func (p *SnapshotRequestCache) Get() *SnapshotRequest {
+	var t *SnapshotRequest
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &SnapshotRequest{}
+	}
+	return t
+}
+
+func (p *SnapshotRequestCache) Put(t *SnapshotRequest) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+
+func (p *SnapshotRequest) New() fastrpc.Serializable {
+	return new(SnapshotRequest)
+}
+
+func (t *SnapshotRequest) Marshal(wire io.Writer) {
+	var b [4]byte
+	var bs []byte
+	bs = b[:4]
+	tmp32 := t.ReplicaId
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	wire.Write(bs)
+}
+
+func (t *SnapshotRequest) Unmarshal(wire io.Reader) error {
+	var b [4]byte
+	var bs []byte
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+		return err
+	}
+	t.ReplicaId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	return nil
+}
+
+func (t *Snapshot) BinarySize() (nbytes int, sizeKnown bool) {
+	return 0, false
+}
+
+type SnapshotCache struct {
+	mu    sync.Mutex
+	cache []*Snapshot
+}
+
+func NewSnapshotCache() *SnapshotCache {
+	c := &SnapshotCache{}
+	c.cache = make([]*Snapshot, 0)
+	return c
+}
+
+func (p *SnapshotCache) Get() *Snapshot {
+	var t *Snapshot
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &Snapshot{}
+	}
+	return t
+}
+
+func (p *SnapshotCache) Put(t *Snapshot) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+
+func (p *Snapshot) New() fastrpc.Serializable {
+	return new(Snapshot)
+}
+
+func (t *Snapshot) Marshal(wire io.Writer) {
+	var b [10]byte
+	var bs []byte
+	bs = b[:4]
+	tmp32 := t.ReplicaId
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	wire.Write(bs)
+	bs = b[:]
+	alen1 := int64(len(t.ExecedUpTo))
+	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:4]
+		tmp32 = t.ExecedUpTo[i]
+		bs[0] = byte(tmp32)
+		bs[1] = byte(tmp32 >> 8)
+		bs[2] = byte(tmp32 >> 16)
+		bs[3] = byte(tmp32 >> 24)
+		wire.Write(bs)
+	}
+	bs = b[:4]
+	tmp32 = t.MaxSeq
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	wire.Write(bs)
+	bs = b[:]
+	alen2 := int64(len(t.State))
+	if wlen := binary.PutVarint(bs, alen2); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	wire.Write(t.State)
+	bs = b[:]
+	alen3 := int64(len(t.Executed))
+	if wlen := binary.PutVarint(bs, alen3); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen3; i++ {
+		t.Executed[i].Marshal(wire)
+	}
+	bs = b[:]
+	alen4 := int64(len(t.Committed))
+	if wlen := binary.PutVarint(bs, alen4); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen4; i++ {
+		t.Committed[i].Marshal(wire)
+	}
+}
+
+func (t *Snapshot) Unmarshal(rr io.Reader) error {
+	var wire byteReader
+	var ok bool
+	if wire, ok = rr.(byteReader); !ok {
+		wire = bufio.NewReader(rr)
+	}
+	var b [10]byte
+	var bs []byte
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+		return err
+	}
+	t.ReplicaId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	alen1, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.ExecedUpTo = make([]int32, alen1)
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:4]
+		if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+			return err
+		}
+		t.ExecedUpTo[i] = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	}
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+		return err
+	}
+	t.MaxSeq = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	alen2, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.State = make([]uint8, alen2)
+	if _, err := io.ReadFull(wire, t.State); err != nil {
+		return err
+	}
+	alen3, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Executed = make([]Commit, alen3)
+	for i := int64(0); i < alen3; i++ {
+		t.Executed[i].Unmarshal(wire)
+	}
+	alen4, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Committed = make([]Commit, alen4)
+	for i := int64(0); i < alen4; i++ {
+		t.Committed[i].Unmarshal(wire)
+	}
+	return nil
+}
+
+func (t *LeaseRequest) BinarySize() (nbytes int, sizeKnown bool) {
+	return 12, true
+}
+
+type LeaseRequestCache struct {
+	mu    sync.Mutex
+	cache []*LeaseRequest
+}
+
+func NewLeaseRequestCache() *LeaseRequestCache {
+	c := &LeaseRequestCache{}
+	c.cache = make([]*LeaseRequest, 0)
+	return c
+}
+
+func (p *LeaseRequestCache) Get() *LeaseRequest {
+	var t *LeaseRequest
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &LeaseRequest{}
+	}
+	return t
+}
+
+func (p *LeaseRequestCache) Put(t *LeaseRequest) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+
+func (p *LeaseRequest) New() fastrpc.Serializable {
+	return new(LeaseRequest)
+}
+
+func (t *LeaseRequest) Marshal(wire io.Writer) {
+	var b [12]byte
+	var bs []byte
+	bs = b[:12]
+	tmp32 := t.HolderId
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	tmp64 := t.Timestamp
+	bs[4] = byte(tmp64)
+	bs[5] = byte(tmp64 >> 8)
+	bs[6] = byte(tmp64 >> 16)
+	bs[7] = byte(tmp64 >> 24)
+	bs[8] = byte(tmp64 >> 32)
+	bs[9] = byte(tmp64 >> 40)
+	bs[10] = byte(tmp64 >> 48)
+	bs[11] = byte(tmp64 >> 56)
+	wire.Write(bs)
+}
+
+func (t *LeaseRequest) Unmarshal(wire io.Reader) error {
+	var b [12]byte
+	var bs []byte
+	bs = b[:12]
+	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
+		return err
+	}
+	t.HolderId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Timestamp = int64((uint64(bs[4]) | (uint64(bs[5]) << 8) | (uint64(bs[6]) << 16) | (uint64(bs[7]) << 24) | (uint64(bs[8]) << 32) | (uint64(bs[9]) << 40) | (uint64(bs[10]) << 48) | (uint64(bs[11]) << 56)))
+	return nil
+}
+
+func (t *LeaseGrant) BinarySize() (nbytes int, sizeKnown bool) {
+	return 0, false
+}
+
+type LeaseGrantCache struct {
+	mu    sync.Mutex
+	cache []*LeaseGrant
+}
+
+func NewLeaseGrantCache() *LeaseGrantCache {
+	c := &LeaseGrantCache{}
+	c.cache = make([]*LeaseGrant, 0)
+	return c
+}
+
+func (p *LeaseGrantCache) Get() *LeaseGrant {
+	var t *LeaseGrant
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &LeaseGrant{}
+	}
+	return t
+}
+
+func (p *LeaseGrantCache) Put(t *LeaseGrant) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+
+func (p *LeaseGrant) New() fastrpc.Serializable {
+	return new(LeaseGrant)
+}
+
+func (t *LeaseGrant) Marshal(wire io.Writer) {
+	var b [12]byte
+	var bs []byte
+	bs = b[:12]
+	tmp32 := t.GrantorId
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	tmp64 := t.Timestamp
+	bs[4] = byte(tmp64)
+	bs[5] = byte(tmp64 >> 8)
+	bs[6] = byte(tmp64 >> 16)
+	bs[7] = byte(tmp64 >> 24)
+	bs[8] = byte(tmp64 >> 32)
+	bs[9] = byte(tmp64 >> 40)
+	bs[10] = byte(tmp64 >> 48)
+	bs[11] = byte(tmp64 >> 56)
+	wire.Write(bs)
+	bs = b[:]
+	alen1 := int64(len(t.Frontier))
+	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:4]
+		tmp32 = t.Frontier[i]
+		bs[0] = byte(tmp32)
+		bs[1] = byte(tmp32 >> 8)
+		bs[2] = byte(tmp32 >> 16)
+		bs[3] = byte(tmp32 >> 24)
+		wire.Write(bs)
+	}
+}
+
+func (t *LeaseGrant) Unmarshal(rr io.Reader) error {
+	var wire byteReader
+	var ok bool
+	if wire, ok = rr.(byteReader); !ok {
+		wire = bufio.NewReader(rr)
+	}
+	var b [12]byte
+	var bs []byte
+	bs = b[:12]
+	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
+		return err
+	}
+	t.GrantorId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Timestamp = int64((uint64(bs[4]) | (uint64(bs[5]) << 8) | (uint64(bs[6]) << 16) | (uint64(bs[7]) << 24) | (uint64(bs[8]) << 32) | (uint64(bs[9]) << 40) | (uint64(bs[10]) << 48) | (uint64(bs[11]) << 56)))
+	alen1, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Frontier = make([]int32, alen1)
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:4]
+		if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+			return err
+		}
+		t.Frontier[i] = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	}
+	return nil
+}
+
+func (t *LeaseAck) BinarySize() (nbytes int, sizeKnown bool) {
+	return 12, true
+}
+
+type LeaseAckCache struct {
+	mu    sync.Mutex
+	cache []*LeaseAck
+}
+
+func NewLeaseAckCache() *LeaseAckCache {
+	c := &LeaseAckCache{}
+	c.cache = make([]*LeaseAck, 0)
+	return c
+}
+
+func (p *LeaseAckCache) Get() *LeaseAck {
+	var t *LeaseAck
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &LeaseAck{}
+	}
+	return t
+}
+
+func (p *LeaseAckCache) Put(t *LeaseAck) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+
+func (p *LeaseAck) New() fastrpc.Serializable {
+	return new(LeaseAck)
+}
+
+func (t *LeaseAck) Marshal(wire io.Writer) {
+	var b [12]byte
+	var bs []byte
+	bs = b[:12]
+	tmp32 := t.AcceptorId
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	tmp32 = t.Replica
+	bs[4] = byte(tmp32)
+	bs[5] = byte(tmp32 >> 8)
+	bs[6] = byte(tmp32 >> 16)
+	bs[7] = byte(tmp32 >> 24)
+	tmp32 = t.Instance
+	bs[8] = byte(tmp32)
+	bs[9] = byte(tmp32 >> 8)
+	bs[10] = byte(tmp32 >> 16)
+	bs[11] = byte(tmp32 >> 24)
+	wire.Write(bs)
+}
+
+func (t *LeaseAck) Unmarshal(wire io.Reader) error {
+	var b [12]byte
+	var bs []byte
+	bs = b[:12]
+	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
+		return err
+	}
+	t.AcceptorId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Replica = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	t.Instance = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
 	return nil
 }
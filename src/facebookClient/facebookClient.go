@@ -130,7 +130,7 @@ func main() {
   idOffset = int32(*o)
   var id int32 = idOffset
   done := make(chan bool, N)
-  args := genericsmrproto.Propose{id, state.Command{state.INCREMENT, 0, 0}, 0}
+  args := genericsmrproto.Propose{id, state.Command{Op: state.INCREMENT}, 0}
   before_total := time.Now()
   latencies = make([]int64, *reqsNb)
   OKrsp = make([]bool, *reqsNb)
@@ -164,8 +164,8 @@ func main() {
       }
 
       ops[i] = args.Command.Op
-      args.Command.K = state.Key(karray[i])
-      args.Command.V = state.Value(i)
+      args.Command.K = state.IntKey(karray[i])
+      args.Command.V = state.IntValue(int64(i))
       leader := rarray[i]
       writers[leader].WriteByte(genericsmrproto.PROPOSE)
       args.Marshal(writers[leader])
@@ -0,0 +1,164 @@
+package genericsmr
+
+import (
+	"sync"
+	"time"
+)
+
+/**********************************************************************
+
+                    GARBAGE COLLECTION OF EXECUTED INSTANCES
+
+***********************************************************************/
+
+// Every protocol keeps the instances of each of its instance spaces (one per
+// replica in EPaxos, a single one in Paxos and Mencius) in an InstanceWindow.
+// Replicas tell each other every GC_PERIOD how far they have executed each
+// space, and forget the instances that all of them have executed, so memory
+// use is bounded by how far the slowest replica lags behind, not by how long
+// the replicas have been running.
+//
+// A peer that has not reported its progress for GC_MAX_WAIT is left out, so
+// that a replica that stays down does not hold back garbage collection for
+// everybody. If it comes back, it needs instances that the others have
+// forgotten, and gets a snapshot instead (see SnapshotDue).
+
+const WINDOW_INIT_SIZE = 1024
+const GC_PERIOD = 1e9        // 1 second
+const GC_MAX_WAIT = 30 * 1e9 // 30 seconds without a report before a peer is left out
+
+// A growable window over an instance space. Instances are cut off from the
+// front once every replica has executed them. The window is shared with the
+// execution thread, hence the lock.
+type InstanceWindow[I any] struct {
+	mu    sync.RWMutex
+	base  int32 // number of the first instance in insts
+	insts []*I
+}
+
+func NewInstanceWindow[I any]() *InstanceWindow[I] {
+	return &InstanceWindow[I]{insts: make([]*I, WINDOW_INIT_SIZE)}
+}
+
+// returns nil for instances that are not known yet or have been forgotten
+func (w *InstanceWindow[I]) Get(instance int32) *I {
+	var inst *I
+	w.mu.RLock()
+	if i := instance - w.base; i >= 0 && i < int32(len(w.insts)) {
+		inst = w.insts[i]
+	}
+	w.mu.RUnlock()
+	return inst
+}
+
+// has no effect on instances that have been forgotten
+func (w *InstanceWindow[I]) Set(instance int32, inst *I) {
+	w.mu.Lock()
+	if i := instance - w.base; i >= 0 {
+		if i >= int32(len(w.insts)) {
+			size := 2 * len(w.insts)
+			for int32(size) <= i {
+				size *= 2
+			}
+			insts := make([]*I, size)
+			copy(insts, w.insts)
+			w.insts = insts
+		}
+		w.insts[i] = inst
+	}
+	w.mu.Unlock()
+}
+
+// Messages about forgotten instances are stale: every replica has already
+// executed them, so nobody needs to hear about them again.
+func (w *InstanceWindow[I]) Forgotten(instance int32) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return instance < w.base
+}
+
+// drops every instance up to and including upTo
+func (w *InstanceWindow[I]) Forget(upTo int32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := upTo + 1 - w.base
+	if n <= 0 {
+		return
+	}
+	if n > int32(len(w.insts)) {
+		n = int32(len(w.insts))
+	}
+	size := len(w.insts) - int(n)
+	if size < WINDOW_INIT_SIZE {
+		size = WINDOW_INIT_SIZE
+	}
+	// copy rather than reslice, so that the old array can be freed
+	insts := make([]*I, size)
+	copy(insts, w.insts[n:])
+	w.insts = insts
+	w.base = upTo + 1
+}
+
+/* Progress of the peers */
+
+// Starts ticking on GCChan. The protocol's main loop is expected to send its
+// progress to its peers and collect garbage on every tick.
+func (r *Replica) StartGCClock() {
+	go func() {
+		for !r.Shutdown {
+			time.Sleep(GC_PERIOD)
+			r.GCChan <- true
+		}
+	}()
+}
+
+// records the progress that peer q has reported, for every instance space
+func (r *Replica) ReportProgress(q int32, upTo []int32) {
+	if q < 0 || int(q) >= r.N || q == r.Id {
+		return
+	}
+	r.peerProgress[q] = upTo
+	r.progressHeard[q] = time.Now().UnixNano()
+}
+
+// Returns the highest instance of the space that every member has executed,
+// given that we have executed up to own. A member that has not reported yet
+// holds back garbage collection for GC_MAX_WAIT from when we started, and one
+// that has stopped reporting for GC_MAX_WAIT from its last report.
+func (r *Replica) GCWatermark(space int, own int32) int32 {
+	now := time.Now().UnixNano()
+	watermark := own
+	for q := int32(0); q < int32(r.N); q++ {
+		if q == r.Id || !r.Config.IsMember(q) {
+			continue
+		}
+		if r.peerProgress[q] == nil {
+			if now-r.gcStarted < GC_MAX_WAIT {
+				return -1
+			}
+			continue
+		}
+		if now-r.progressHeard[q] >= GC_MAX_WAIT || space >= len(r.peerProgress[q]) {
+			continue
+		}
+		if r.peerProgress[q][space] < watermark {
+			watermark = r.peerProgress[q][space]
+		}
+	}
+	return watermark
+}
+
+// Tells whether to send a snapshot to peer q, which has reported progress
+// below the instances we have forgotten. Snapshots are pushed to a peer at
+// most once every GC_MAX_WAIT, since it takes a while to install one.
+func (r *Replica) SnapshotDue(q int32) bool {
+	if q < 0 || int(q) >= r.N || q == r.Id {
+		return false
+	}
+	now := time.Now().UnixNano()
+	if now-r.snapshotPushed[q] < GC_MAX_WAIT {
+		return false
+	}
+	r.snapshotPushed[q] = now
+	return true
+}
@@ -0,0 +1,96 @@
+package genericsmr
+
+import (
+	"genericsmrproto"
+	"testing"
+	"time"
+)
+
+type testInstance struct {
+	seq int32
+}
+
+func TestInstanceWindow(t *testing.T) {
+	w := NewInstanceWindow[testInstance]()
+	n := int32(3*WINDOW_INIT_SIZE + 7)
+	for i := int32(0); i < n; i++ {
+		w.Set(i, &testInstance{i})
+	}
+
+	w.Forget(WINDOW_INIT_SIZE)
+	if !w.Forgotten(WINDOW_INIT_SIZE) || w.Forgotten(WINDOW_INIT_SIZE+1) {
+		t.Fatal("wrong instances forgotten")
+	}
+	if w.Get(0) != nil || w.Get(WINDOW_INIT_SIZE) != nil {
+		t.Fatal("forgotten instance still present")
+	}
+	for i := int32(WINDOW_INIT_SIZE + 1); i < n; i++ {
+		if inst := w.Get(i); inst == nil || inst.seq != i {
+			t.Fatalf("lost instance %d", i)
+		}
+	}
+
+	w.Set(5, &testInstance{})
+	if w.Get(5) != nil {
+		t.Fatal("forgotten instance was brought back")
+	}
+}
+
+func newTestReplica(n int) *Replica {
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = "localhost"
+	}
+	return &Replica{
+		N:              n,
+		Id:             0,
+		Config:         genericsmrproto.NewConfig(addrs),
+		peerProgress:   make([][]int32, n),
+		progressHeard:  make([]int64, n),
+		snapshotPushed: make([]int64, n),
+		gcStarted:      time.Now().UnixNano()}
+}
+
+func TestGCWatermark(t *testing.T) {
+	r := newTestReplica(3)
+
+	r.ReportProgress(1, []int32{40})
+	if w := r.GCWatermark(0, 50); w != -1 {
+		t.Fatalf("collected up to %d before every peer reported", w)
+	}
+	r.ReportProgress(2, []int32{30})
+	if w := r.GCWatermark(0, 50); w != 30 {
+		t.Fatalf("watermark %d, expected 30", w)
+	}
+
+	// replica 2 stops reporting
+	r.progressHeard[2] -= GC_MAX_WAIT
+	if w := r.GCWatermark(0, 50); w != 40 {
+		t.Fatalf("watermark %d, expected 40 once replica 2 was left out", w)
+	}
+
+	// replica 1 never reports after a restart of ours
+	r = newTestReplica(3)
+	r.ReportProgress(2, []int32{30})
+	r.gcStarted -= GC_MAX_WAIT
+	if w := r.GCWatermark(0, 50); w != 30 {
+		t.Fatalf("watermark %d, expected 30 once replica 1 was left out", w)
+	}
+}
+
+func TestSnapshotDue(t *testing.T) {
+	r := newTestReplica(3)
+	if r.SnapshotDue(0) {
+		t.Fatal("snapshot due to ourselves")
+	}
+	if !r.SnapshotDue(1) {
+		t.Fatal("first snapshot not due")
+	}
+	if r.SnapshotDue(1) {
+		t.Fatal("snapshot pushed twice in a row")
+	}
+	r.snapshotPushed[1] -= GC_MAX_WAIT
+	if !r.SnapshotDue(1) {
+		t.Fatal("snapshot not due again after GC_MAX_WAIT")
+	}
+}
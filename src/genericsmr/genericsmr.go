@@ -12,6 +12,7 @@ import (
 	"os"
 	"rdtsc"
 	"state"
+	"sync/atomic"
 	"time"
 )
 
@@ -65,9 +66,24 @@ type Replica struct {
 	Ewma []float64
 
 	OnClientConnect chan bool
+
+	Detector      FailureDetectorConfig
+	HeartbeatChan chan bool // ticks of the failure detector
+	lastHeard     []*int64  // when each peer was last heard from
+
+	Config       genericsmrproto.Config // the replicas that are members of the cluster
+	PeerConnChan chan *PeerConn         // connections from replicas that join
+
+	GCChan         chan bool // ticks of the garbage collection clock (see gc.go)
+	peerProgress   [][]int32 // what each peer last reported having executed, by instance space
+	progressHeard  []int64   // when each peer last reported its progress
+	snapshotPushed []int64   // when we last pushed a snapshot to each peer
+	gcStarted      int64
+
+	PingStatus PingStatus // what we answer the master's pings with
 }
 
-func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply bool, app state.Application) *Replica {
+func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply bool, app state.StateMachine) *Replica {
 	r := &Replica{
 		len(peerAddrList),
 		int32(id),
@@ -77,7 +93,7 @@ func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply b
 		make([]*bufio.Writer, len(peerAddrList)),
 		make([]bool, len(peerAddrList)),
 		nil,
-		state.InitState(app),
+		state.NewState(app),
 		make(chan *Propose, CHAN_BUFFER_SIZE),
 		make(chan *Beacon, CHAN_BUFFER_SIZE),
 		false,
@@ -89,19 +105,36 @@ func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply b
 		nil,
 		make([]int32, len(peerAddrList)),
 		make(map[uint8]*RPCPair),
-		genericsmrproto.GENERIC_SMR_BEACON_REPLY + 1,
+		genericsmrproto.GENERIC_SMR_HEARTBEAT + 1,
 		make([]float64, len(peerAddrList)),
-		make(chan bool, 100)}
+		make(chan bool, 100),
+		FailureDetectorConfig{},
+		make(chan bool, 1),
+		make([]*int64, len(peerAddrList)),
+		genericsmrproto.NewConfig(peerAddrList),
+		make(chan *PeerConn, 10),
+		make(chan bool, 1),
+		make([][]int32, len(peerAddrList)),
+		make([]int64, len(peerAddrList)),
+		make([]int64, len(peerAddrList)),
+		time.Now().UnixNano(),
+		PingStatus{}}
 
 	var err error
 
-	if r.StableStore, err = os.Create(fmt.Sprintf("stable-store-replica%d", r.Id)); err != nil {
+	// The stable store is opened for appending and is intentionally never
+	// truncated on startup, so that a durable replica can rebuild its state
+	// from it after a crash. Bounding its size is up to the protocol: EPaxos
+	// rewrites it at every checkpoint (-cp, see truncateStableStore), while
+	// the Paxos and Mencius logs grow for as long as the replica runs.
+	if r.StableStore, err = os.OpenFile(fmt.Sprintf("stable-store-replica%d", r.Id), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644); err != nil {
 		log.Fatal(err)
 	}
 
 	for i := 0; i < r.N; i++ {
 		r.PreferredPeerOrder[i] = int32((int(r.Id) + 1 + i) % r.N)
 		r.Ewma[i] = 0.0
+		r.lastHeard[i] = new(int64)
 	}
 
 	return r
@@ -152,7 +185,7 @@ func (r *Replica) ConnectToPeers() {
 		if int32(rid) == r.Id {
 			continue
 		}
-		go r.replicaListener(rid, reader)
+		go r.replicaListener(rid, reader, r.lastHeard[rid])
 	}
 }
 
@@ -226,7 +259,7 @@ func (r *Replica) WaitForClientConnections() {
 	}
 }
 
-func (r *Replica) replicaListener(rid int, reader *bufio.Reader) {
+func (r *Replica) replicaListener(rid int, reader *bufio.Reader, heard *int64) {
 	var msgType uint8
 	var err error = nil
 	var gbeacon genericsmrproto.Beacon
@@ -237,9 +270,13 @@ func (r *Replica) replicaListener(rid int, reader *bufio.Reader) {
 		if msgType, err = reader.ReadByte(); err != nil {
 			break
 		}
+		atomic.StoreInt64(heard, time.Now().UnixNano())
 
 		switch uint8(msgType) {
 
+		case genericsmrproto.GENERIC_SMR_HEARTBEAT:
+			break
+
 		case genericsmrproto.GENERIC_SMR_BEACON:
 			if err = gbeacon.Unmarshal(reader); err != nil {
 				break
@@ -287,11 +324,27 @@ func (r *Replica) clientListener(conn net.Conn) {
 		case genericsmrproto.PROPOSE:
 			prop := new(genericsmrproto.Propose)
 			if err = prop.Unmarshal(reader); err != nil {
+				if err != io.EOF {
+					log.Println("Dropping a client connection:", err)
+				}
+				break
+			}
+			if prop.Command.Op == state.RECONFIGURE || prop.Command.Op == state.CHECKPOINT {
+				log.Println("Dropping a reconfiguration or checkpoint proposed by a client")
 				break
 			}
 			r.ProposeChan <- &Propose{prop, writer}
 			break
 
+		case genericsmrproto.PEER_HELLO:
+			var b [4]byte
+			if _, err = io.ReadFull(reader, b[:]); err != nil {
+				break
+			}
+			// the connection belongs to the protocol from now on
+			r.PeerConnChan <- &PeerConn{int32(binary.LittleEndian.Uint32(b[:])), conn, reader}
+			return
+
 		case genericsmrproto.READ:
 			read := new(genericsmrproto.Read)
 			if err = read.Unmarshal(reader); err != nil {
@@ -323,6 +376,10 @@ func (r *Replica) RegisterRPC(msgObj fastrpc.Serializable, notify chan fastrpc.S
 
 func (r *Replica) SendMsg(peerId int32, code uint8, msg fastrpc.Serializable) {
 	w := r.PeerWriters[peerId]
+	if w == nil {
+		// not connected yet, or removed from the configuration
+		return
+	}
 	w.WriteByte(code)
 	msg.Marshal(w)
 	w.Flush()
@@ -366,6 +423,73 @@ func (r *Replica) ReplyBeacon(beacon *Beacon) {
 	w.Flush()
 }
 
+/* Failure detection */
+
+// Peers that have not been heard from for SuspectTimeout are suspected to
+// have failed, and are not Alive until they are heard from again. Any message
+// counts, and replicas send each other a heartbeat every HeartbeatPeriod.
+type FailureDetectorConfig struct {
+	HeartbeatPeriod time.Duration // defaults to a quarter of SuspectTimeout
+	SuspectTimeout  time.Duration // 0 to never suspect peers
+}
+
+// Starts ticking on HeartbeatChan. The protocol's main loop is expected to
+// call SendHeartbeats and CheckPeers on every tick, since only it may write
+// to the peer connections.
+func (r *Replica) StartFailureDetector() {
+	if r.Detector.SuspectTimeout <= 0 {
+		return
+	}
+	if r.Detector.HeartbeatPeriod <= 0 {
+		r.Detector.HeartbeatPeriod = r.Detector.SuspectTimeout / 4
+	}
+	now := time.Now().UnixNano()
+	for q := range r.lastHeard {
+		atomic.StoreInt64(r.lastHeard[q], now)
+	}
+	go func() {
+		for !r.Shutdown {
+			time.Sleep(r.Detector.HeartbeatPeriod)
+			select {
+			case r.HeartbeatChan <- true:
+			default:
+			}
+		}
+	}()
+}
+
+func (r *Replica) SendHeartbeats() {
+	for q := int32(0); q < int32(r.N); q++ {
+		if q == r.Id || r.PeerWriters[q] == nil {
+			continue
+		}
+		w := r.PeerWriters[q]
+		w.WriteByte(genericsmrproto.GENERIC_SMR_HEARTBEAT)
+		w.Flush()
+	}
+}
+
+// Updates Alive, and returns the peers that have just become suspected.
+func (r *Replica) CheckPeers() []int32 {
+	suspected := make([]int32, 0)
+	now := time.Now().UnixNano()
+	for q := int32(0); q < int32(r.N); q++ {
+		if q == r.Id || r.Peers[q] == nil || !r.Config.IsMember(q) {
+			continue
+		}
+		heard := now-atomic.LoadInt64(r.lastHeard[q]) < int64(r.Detector.SuspectTimeout)
+		if r.Alive[q] && !heard {
+			log.Printf("Replica %d is suspected to have failed\n", q)
+			r.Alive[q] = false
+			suspected = append(suspected, q)
+		} else if !r.Alive[q] && heard {
+			log.Printf("Replica %d is alive again\n", q)
+			r.Alive[q] = true
+		}
+	}
+	return suspected
+}
+
 // updates the preferred order in which to communicate with peers according to a preferred quorum
 func (r *Replica) UpdatePreferredPeerOrder(quorum []int32) {
 	aux := make([]int32, r.N)
@@ -394,3 +518,40 @@ func (r *Replica) UpdatePreferredPeerOrder(quorum []int32) {
 
 	r.PreferredPeerOrder = aux
 }
+
+/* Progress reported to the master */
+
+// Ping is served on a goroutine of the RPC server, so it must not read the
+// fields of the protocol. The protocol publishes its progress here instead:
+// the main loop whether it is the leader and its current instance, on every
+// iteration, and the execution thread how far it has executed. Other
+// goroutines, such as those of a master instance, read it too.
+type PingStatus struct {
+	isLeader    int32
+	crtInstance int32
+	execedUpTo  int32
+}
+
+func (s *PingStatus) Publish(isLeader bool, crtInstance int32) {
+	leader := int32(0)
+	if isLeader {
+		leader = 1
+	}
+	atomic.StoreInt32(&s.isLeader, leader)
+	atomic.StoreInt32(&s.crtInstance, crtInstance)
+}
+
+func (s *PingStatus) PublishExecedUpTo(execedUpTo int32) {
+	atomic.StoreInt32(&s.execedUpTo, execedUpTo)
+}
+
+func (s *PingStatus) IsLeader() bool {
+	return atomic.LoadInt32(&s.isLeader) == 1
+}
+
+func (s *PingStatus) Reply(protocol string) genericsmrproto.PingReply {
+	return genericsmrproto.PingReply{protocol,
+		s.IsLeader(),
+		atomic.LoadInt32(&s.crtInstance),
+		atomic.LoadInt32(&s.execedUpTo)}
+}
@@ -0,0 +1,151 @@
+package genericsmr
+
+import (
+	"bufio"
+	"encoding/binary"
+	"genericsmrproto"
+	"log"
+	"net"
+	"time"
+)
+
+/* Membership changes */
+
+// A replica that joins a running cluster gets an id that no replica has had
+// before. It connects to the members by sending PEER_HELLO on their client
+// port, and they hand the connection over to the protocol through
+// PeerConnChan. The slots of every peer are grown as needed, so N is the
+// number of ids in use rather than the number of members, and protocols must
+// check Config.IsMember before sending to, or counting, a peer.
+//
+// Only the protocol's main loop may call AddPeerConn and ApplyConfig, since
+// they modify the peer slots.
+
+const JOIN_ATTEMPTS = 60 // one per second, before giving up on a member
+
+type PeerConn struct {
+	Id     int32
+	Conn   net.Conn
+	Reader *bufio.Reader
+}
+
+// Called instead of ConnectToPeers by a replica that joins the cluster. The
+// replica is not a member of r.Config until the protocol has committed a
+// configuration that includes it.
+func (r *Replica) JoinPeers() {
+	var err error
+	if r.Listener, err = net.Listen("tcp", r.PeerAddrList[r.Id]); err != nil {
+		log.Fatal("Listen error:", err)
+	}
+	for q := int32(0); q < int32(r.N); q++ {
+		if q != r.Id && r.Config.IsMember(q) {
+			go r.dialPeer(q, r.Config.Addrs[q], JOIN_ATTEMPTS)
+		}
+	}
+}
+
+// Called instead of ConnectToPeers by a replica that starts along with the
+// others, but does not wait for all of them to be up. It connects to the
+// members with a lower id as they come up, and the ones with a higher id
+// connect to it, so that each pair has a single connection. As for a replica
+// that joins, the connections reach the protocol through PeerConnChan.
+func (r *Replica) ConnectToLivePeers() {
+	var err error
+	if r.Listener, err = net.Listen("tcp", r.PeerAddrList[r.Id]); err != nil {
+		log.Fatal("Listen error:", err)
+	}
+	for q := int32(0); q < r.Id; q++ {
+		if r.Config.IsMember(q) {
+			go r.dialPeer(q, r.Config.Addrs[q], 0)
+		}
+	}
+}
+
+// makes the given number of attempts to connect, or keeps trying if it is 0
+func (r *Replica) dialPeer(q int32, addr string, attempts int) {
+	var b [5]byte
+	b[0] = genericsmrproto.PEER_HELLO
+	binary.LittleEndian.PutUint32(b[1:], uint32(r.Id))
+	for i := 0; (attempts == 0 || i < attempts) && !r.Shutdown; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			time.Sleep(1e9)
+			continue
+		}
+		if _, err := conn.Write(b[:]); err != nil {
+			conn.Close()
+			time.Sleep(1e9)
+			continue
+		}
+		r.PeerConnChan <- &PeerConn{q, conn, bufio.NewReader(conn)}
+		return
+	}
+	log.Printf("Could not connect to replica %d at %s\n", q, addr)
+}
+
+// grows the peer slots to n
+func (r *Replica) growPeers(n int) {
+	for len(r.PeerAddrList) < n {
+		r.PeerAddrList = append(r.PeerAddrList, "")
+		r.Peers = append(r.Peers, nil)
+		r.PeerReaders = append(r.PeerReaders, nil)
+		r.PeerWriters = append(r.PeerWriters, nil)
+		r.Alive = append(r.Alive, false)
+		r.Ewma = append(r.Ewma, 0.0)
+		heard := time.Now().UnixNano()
+		r.lastHeard = append(r.lastHeard, &heard)
+		r.PreferredPeerOrder = append(r.PreferredPeerOrder, int32(len(r.PreferredPeerOrder)))
+		r.peerProgress = append(r.peerProgress, nil)
+		r.progressHeard = append(r.progressHeard, 0)
+		r.snapshotPushed = append(r.snapshotPushed, 0)
+	}
+	r.N = len(r.PeerAddrList)
+}
+
+// Takes over a connection with a peer, which is refused if the peer has been
+// removed from the configuration.
+func (r *Replica) AddPeerConn(pc *PeerConn) {
+	if pc.Id < 0 || pc.Id == r.Id || int(pc.Id) >= genericsmrproto.MAX_REPLICAS || r.Config.Removed(pc.Id) {
+		log.Printf("Refusing a connection from replica %d\n", pc.Id)
+		pc.Conn.Close()
+		return
+	}
+	r.growPeers(int(pc.Id) + 1)
+	if r.Peers[pc.Id] != nil {
+		r.Peers[pc.Id].Close()
+	}
+	r.Peers[pc.Id] = pc.Conn
+	r.PeerReaders[pc.Id] = pc.Reader
+	r.PeerWriters[pc.Id] = bufio.NewWriter(pc.Conn)
+	r.Alive[pc.Id] = true
+	heard := time.Now().UnixNano()
+	r.lastHeard[pc.Id] = &heard
+	log.Printf("Connected to replica %d\n", pc.Id)
+	go r.replicaListener(int(pc.Id), pc.Reader, r.lastHeard[pc.Id])
+}
+
+// Switches to a configuration that the protocol has committed, and closes the
+// connections with the replicas that are no longer members. Returns false if
+// the configuration is not newer than the current one.
+func (r *Replica) ApplyConfig(c *genericsmrproto.Config) bool {
+	if c.Epoch <= r.Config.Epoch {
+		return false
+	}
+	r.growPeers(len(c.Addrs))
+	for q := int32(0); q < int32(len(c.Addrs)); q++ {
+		if c.Addrs[q] != "" {
+			r.PeerAddrList[q] = c.Addrs[q]
+		} else if r.Config.IsMember(q) || q >= int32(len(r.Config.Addrs)) {
+			if r.Peers[q] != nil && q != r.Id {
+				r.Peers[q].Close()
+			}
+			r.Peers[q] = nil
+			r.PeerReaders[q] = nil
+			r.PeerWriters[q] = nil
+			r.Alive[q] = false
+		}
+	}
+	r.Config = c.Copy()
+	log.Printf("Configuration %d: %d members, %v\n", c.Epoch, c.Size(), c.Addrs)
+	return true
+}
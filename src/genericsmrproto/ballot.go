@@ -0,0 +1,67 @@
+package genericsmrproto
+
+import (
+	"math"
+)
+
+// Ballots are totally ordered by (Epoch, Counter, ReplicaId). The replica id
+// makes ballots chosen by different replicas unique. When the counter would
+// overflow, the epoch is bumped instead, so a ballot can always be made larger.
+type Ballot struct {
+	Epoch     int32
+	Counter   int32
+	ReplicaId int32
+}
+
+// smaller than every ballot a replica can choose
+var NilBallot = Ballot{-1, -1, -1}
+
+// the first ballot a replica uses for a new instance
+func InitialBallot(replicaId int32) Ballot {
+	return Ballot{0, 0, replicaId}
+}
+
+func (b Ballot) IsInitial() bool {
+	return b.Epoch == 0 && b.Counter == 0
+}
+
+func (b Ballot) Compare(o Ballot) int {
+	switch {
+	case b.Epoch != o.Epoch:
+		if b.Epoch < o.Epoch {
+			return -1
+		}
+		return 1
+	case b.Counter != o.Counter:
+		if b.Counter < o.Counter {
+			return -1
+		}
+		return 1
+	case b.ReplicaId != o.ReplicaId:
+		if b.ReplicaId < o.ReplicaId {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func (b Ballot) LessThan(o Ballot) bool {
+	return b.Compare(o) < 0
+}
+
+func (b Ballot) GreaterThan(o Ballot) bool {
+	return b.Compare(o) > 0
+}
+
+// a ballot owned by replicaId that is larger than b, and than every other
+// ballot in b's round
+func (b Ballot) NextFor(replicaId int32) Ballot {
+	if b.Epoch < 0 {
+		return Ballot{0, 1, replicaId}
+	}
+	if b.Counter == math.MaxInt32 {
+		return Ballot{b.Epoch + 1, 0, replicaId}
+	}
+	return Ballot{b.Epoch, b.Counter + 1, replicaId}
+}
@@ -0,0 +1,73 @@
+package genericsmrproto
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestBallotCompare(t *testing.T) {
+	tests := []struct {
+		a, b Ballot
+		cmp  int
+	}{
+		{Ballot{1, 2, 3}, Ballot{1, 2, 3}, 0},
+		{Ballot{0, 5, 2}, Ballot{1, 0, 0}, -1}, // the epoch comes first
+		{Ballot{2, 0, 0}, Ballot{1, math.MaxInt32, 4}, 1},
+		{Ballot{1, 2, 4}, Ballot{1, 3, 0}, -1}, // then the counter
+		{Ballot{1, 4, 0}, Ballot{1, 3, 9}, 1},
+		{Ballot{1, 3, 1}, Ballot{1, 3, 2}, -1}, // then the replica id
+		{Ballot{1, 3, 2}, Ballot{1, 3, 1}, 1},
+		{NilBallot, InitialBallot(0), -1},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Compare(tt.b); got != tt.cmp {
+			t.Errorf("%v.Compare(%v) = %d, want %d", tt.a, tt.b, got, tt.cmp)
+		}
+		if tt.a.LessThan(tt.b) != (tt.cmp < 0) || tt.a.GreaterThan(tt.b) != (tt.cmp > 0) {
+			t.Errorf("LessThan or GreaterThan disagree with Compare for %v and %v", tt.a, tt.b)
+		}
+	}
+}
+
+func TestBallotNextFor(t *testing.T) {
+	tests := []struct {
+		b       Ballot
+		replica int32
+		next    Ballot
+	}{
+		{NilBallot, 2, Ballot{0, 1, 2}},
+		{InitialBallot(0), 1, Ballot{0, 1, 1}},
+		{Ballot{0, 4, 3}, 1, Ballot{0, 5, 1}},
+		{Ballot{3, 4, 1}, 3, Ballot{3, 5, 3}},
+		{Ballot{0, math.MaxInt32, 2}, 0, Ballot{1, 0, 0}}, // the counter overflows into the epoch
+	}
+	for _, tt := range tests {
+		next := tt.b.NextFor(tt.replica)
+		if next != tt.next {
+			t.Errorf("%v.NextFor(%d) = %v, want %v", tt.b, tt.replica, next, tt.next)
+		}
+		if !next.GreaterThan(tt.b) || next.IsInitial() {
+			t.Errorf("%v.NextFor(%d) = %v is not a new larger ballot", tt.b, tt.replica, next)
+		}
+	}
+}
+
+func TestBallotMarshal(t *testing.T) {
+	for _, b := range []Ballot{NilBallot, InitialBallot(4), {7, math.MaxInt32, 2}, {math.MaxInt32, 0, 0}} {
+		var buf bytes.Buffer
+		b.Marshal(&buf)
+		if n, _ := b.BinarySize(); buf.Len() != n {
+			t.Fatalf("%v marshaled to %d bytes, %d expected", b, buf.Len(), n)
+		}
+		var got Ballot
+		if err := got.Unmarshal(&buf); err != nil || got != b {
+			t.Fatalf("%v unmarshaled as %v: %v", b, got, err)
+		}
+	}
+
+	var got Ballot
+	if err := got.Unmarshal(bytes.NewReader([]byte{1, 0, 0, 0, 2})); err == nil {
+		t.Fatal("truncated ballot unmarshaled")
+	}
+}
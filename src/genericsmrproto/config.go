@@ -0,0 +1,89 @@
+package genericsmrproto
+
+import (
+	"bytes"
+	"errors"
+	"state"
+)
+
+// configurations with more replica ids are refused on the wire
+const MAX_REPLICAS = 1 << 12
+
+var ErrConfigSize = errors.New("genericsmrproto: configuration with too many replicas")
+
+// The membership of the cluster at a configuration epoch. Replica ids index
+// Addrs, and are never reused: a replica that is not a member has an empty
+// address, and a replica that has been removed cannot be added back.
+//
+// A configuration change is ordered like any other command, as a RECONFIGURE
+// command that carries the new configuration, with the next epoch.
+type Config struct {
+	Epoch int32
+	Addrs []string
+}
+
+func NewConfig(addrs []string) Config {
+	return Config{0, append([]string(nil), addrs...)}
+}
+
+func (c *Config) Copy() Config {
+	return Config{c.Epoch, append([]string(nil), c.Addrs...)}
+}
+
+func (c *Config) IsMember(q int32) bool {
+	return q >= 0 && int(q) < len(c.Addrs) && c.Addrs[q] != ""
+}
+
+// tells whether q got its id before the configuration, yet is not a member
+func (c *Config) Removed(q int32) bool {
+	return q >= 0 && int(q) < len(c.Addrs) && c.Addrs[q] == ""
+}
+
+// the number of members
+func (c *Config) Size() int {
+	n := 0
+	for _, a := range c.Addrs {
+		if a != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// the number of members that make a majority
+func (c *Config) Quorum() int {
+	return c.Size()/2 + 1
+}
+
+// Tells whether next can follow c: it has the next epoch, keeps at least one
+// member, and does not bring back replicas that have been removed.
+func (c *Config) Precedes(next *Config) bool {
+	if next.Epoch != c.Epoch+1 || next.Size() == 0 || len(next.Addrs) < len(c.Addrs) {
+		return false
+	}
+	for q := range c.Addrs {
+		if c.Addrs[q] == "" && next.Addrs[q] != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// the command that switches to the configuration
+func (c *Config) Command() state.Command {
+	var buf bytes.Buffer
+	c.Marshal(&buf)
+	return state.Command{0, state.NO_CLIENT, state.RECONFIGURE, state.IntKey(int64(c.Epoch)), state.Value(buf.Bytes()), state.NIL, nil, 0}
+}
+
+// the configuration carried by a RECONFIGURE command
+func ConfigOf(cmd *state.Command) (*Config, bool) {
+	if cmd.Op != state.RECONFIGURE {
+		return nil, false
+	}
+	c := new(Config)
+	if err := c.Unmarshal(bytes.NewReader([]byte(cmd.V))); err != nil {
+		return nil, false
+	}
+	return c, true
+}
@@ -0,0 +1,44 @@
+package genericsmrproto
+
+import (
+	"bytes"
+	"state"
+	"testing"
+)
+
+func TestConfigChange(t *testing.T) {
+	c := NewConfig([]string{"a:7070", "b:7071", "c:7072"})
+	next := c.Copy()
+	next.Epoch++
+	next.Addrs[1] = ""
+	next.Addrs = append(next.Addrs, "d:7073")
+	if !c.Precedes(&next) || next.Size() != 3 || next.Quorum() != 2 || next.IsMember(1) || !next.Removed(1) {
+		t.Fatal("wrong configuration change")
+	}
+
+	// carried through the log like any other command
+	cmd := next.Command()
+	var buf bytes.Buffer
+	cmd.Marshal(&buf)
+	var received state.Command
+	received.Unmarshal(&buf)
+	got, ok := ConfigOf(&received)
+	if !ok || got.Epoch != 1 || len(got.Addrs) != 4 || got.Addrs[3] != "d:7073" || got.IsMember(1) {
+		t.Fatalf("configuration changed on the wire: %v", got)
+	}
+
+	back := next.Copy()
+	back.Epoch++
+	back.Addrs[1] = "b:7071"
+	if next.Precedes(&back) {
+		t.Fatal("a removed replica was added back")
+	}
+	if c.Precedes(&c) {
+		t.Fatal("a configuration follows itself")
+	}
+
+	// the state machines never see a configuration change
+	if state.NewState(state.DefaultMachine{}).OperationConflict(state.RECONFIGURE, state.PUT) {
+		t.Fatal("a configuration change conflicts with commands")
+	}
+}
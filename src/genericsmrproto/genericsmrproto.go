@@ -13,6 +13,8 @@ const (
 	PROPOSE_AND_READ_REPLY
 	GENERIC_SMR_BEACON
 	GENERIC_SMR_BEACON_REPLY
+	GENERIC_SMR_HEARTBEAT // no payload
+	PEER_HELLO            // sent by a replica that joins, followed by its 4-byte id
 )
 
 type Propose struct {
@@ -31,6 +33,7 @@ type ProposeReplyTS struct {
 	CommandId int32
 	Value     state.Value
 	Timestamp int64
+	Values    []state.KeyValue // read by a SCAN
 }
 
 type Read struct {
@@ -69,7 +72,12 @@ type PingArgs struct {
 	ActAsLeader uint8
 }
 
+// what a replica knows about itself, for the status of the cluster
 type PingReply struct {
+	Protocol    string
+	IsLeader    bool  // the replica thinks it is the leader (for the protocols that have one)
+	CrtInstance int32 // the next instance the replica would start
+	ExecedUpTo  int32 // the instance up to which the replica has executed every command
 }
 
 type BeTheLeaderArgs struct {
@@ -77,3 +85,13 @@ type BeTheLeaderArgs struct {
 
 type BeTheLeaderReply struct {
 }
+
+// sent by the master to the leader, which answers once the configuration
+// has been committed
+type ReconfigureArgs struct {
+	Config Config
+}
+
+type ReconfigureReply struct {
+	Epoch int32
+}
@@ -1,7 +1,9 @@
 package genericsmrproto
 
 import (
+	"fastrpc"
 	"io"
+	"state"
 	"sync"
 )
 
@@ -70,7 +72,9 @@ func (t *Propose) Unmarshal(wire io.Reader) error {
 		return err
 	}
 	t.CommandId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
-	t.Command.Unmarshal(wire)
+	if err := t.Command.Unmarshal(wire); err != nil {
+		return err
+	}
 	bs = b[:8]
 	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
 		return err
@@ -286,7 +290,9 @@ func (t *ProposeAndRead) Unmarshal(wire io.Reader) error {
 		return err
 	}
 	t.CommandId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
-	t.Command.Unmarshal(wire)
+	if err := t.Command.Unmarshal(wire); err != nil {
+		return err
+	}
 	t.Key.Unmarshal(wire)
 	return nil
 }
@@ -350,7 +356,7 @@ func (t *Read) Unmarshal(wire io.Reader) error {
 }
 
 func (t *PingReply) BinarySize() (nbytes int, sizeKnown bool) {
-	return 0, true
+	return 0, false
 }
 
 type PingReplyCache struct {
@@ -383,9 +389,43 @@ func (p *PingReplyCache) Put(t *PingReply) {
 	p.mu.Unlock()
 }
 func (t *PingReply) Marshal(wire io.Writer) {
+	var b [9]byte
+	var bs []byte
+	protocol := state.Value(t.Protocol)
+	protocol.Marshal(wire)
+	bs = b[:9]
+	bs[0] = 0
+	if t.IsLeader {
+		bs[0] = 1
+	}
+	tmp32 := t.CrtInstance
+	bs[1] = byte(tmp32)
+	bs[2] = byte(tmp32 >> 8)
+	bs[3] = byte(tmp32 >> 16)
+	bs[4] = byte(tmp32 >> 24)
+	tmp32 = t.ExecedUpTo
+	bs[5] = byte(tmp32)
+	bs[6] = byte(tmp32 >> 8)
+	bs[7] = byte(tmp32 >> 16)
+	bs[8] = byte(tmp32 >> 24)
+	wire.Write(bs)
 }
 
 func (t *PingReply) Unmarshal(wire io.Reader) error {
+	var b [9]byte
+	var bs []byte
+	var protocol state.Value
+	if err := protocol.Unmarshal(wire); err != nil {
+		return err
+	}
+	t.Protocol = string(protocol)
+	bs = b[:9]
+	if _, err := io.ReadAtLeast(wire, bs, 9); err != nil {
+		return err
+	}
+	t.IsLeader = bs[0] != 0
+	t.CrtInstance = int32((uint32(bs[1]) | (uint32(bs[2]) << 8) | (uint32(bs[3]) << 16) | (uint32(bs[4]) << 24)))
+	t.ExecedUpTo = int32((uint32(bs[5]) | (uint32(bs[6]) << 8) | (uint32(bs[7]) << 16) | (uint32(bs[8]) << 24)))
 	return nil
 }
 
@@ -721,6 +761,16 @@ func (t *ProposeReplyTS) Marshal(wire io.Writer) {
 	bs[6] = byte(tmp64 >> 48)
 	bs[7] = byte(tmp64 >> 56)
 	wire.Write(bs)
+	bs = b[:4]
+	tmp32 = int32(len(t.Values))
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	wire.Write(bs)
+	for i := range t.Values {
+		t.Values[i].Marshal(wire)
+	}
 }
 
 func (t *ProposeReplyTS) Unmarshal(wire io.Reader) error {
@@ -738,5 +788,141 @@ func (t *ProposeReplyTS) Unmarshal(wire io.Reader) error {
 		return err
 	}
 	t.Timestamp = int64((uint64(bs[0]) | (uint64(bs[1]) << 8) | (uint64(bs[2]) << 16) | (uint64(bs[3]) << 24) | (uint64(bs[4]) << 32) | (uint64(bs[5]) << 40) | (uint64(bs[6]) << 48) | (uint64(bs[7]) << 56)))
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+		return err
+	}
+	alen := int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Values = nil
+	if alen > 0 {
+		t.Values = make([]state.KeyValue, alen)
+		for i := range t.Values {
+			if err := t.Values[i].Unmarshal(wire); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Ballot) BinarySize() (nbytes int, sizeKnown bool) {
+	return 12, true
+}
+
+type BallotCache struct {
+	mu    sync.Mutex
+	cache []*Ballot
+}
+
+func NewBallotCache() *BallotCache {
+	c := &BallotCache{}
+	c.cache = make([]*Ballot, 0)
+	return c
+}
+
+func (p *BallotCache) Get() *Ballot {
+	var t *Ballot
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &Ballot{}
+	}
+	return t
+}
+
+func (p *BallotCache) Put(t *Ballot) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+
+func (t *Ballot) Marshal(wire io.Writer) {
+	var b [12]byte
+	var bs []byte
+	bs = b[:12]
+	tmp32 := t.Epoch
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	tmp32 = t.Counter
+	bs[4] = byte(tmp32)
+	bs[5] = byte(tmp32 >> 8)
+	bs[6] = byte(tmp32 >> 16)
+	bs[7] = byte(tmp32 >> 24)
+	tmp32 = t.ReplicaId
+	bs[8] = byte(tmp32)
+	bs[9] = byte(tmp32 >> 8)
+	bs[10] = byte(tmp32 >> 16)
+	bs[11] = byte(tmp32 >> 24)
+	wire.Write(bs)
+}
+
+func (t *Ballot) Unmarshal(wire io.Reader) error {
+	var b [12]byte
+	var bs []byte
+	bs = b[:12]
+	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
+		return err
+	}
+	t.Epoch = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Counter = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	t.ReplicaId = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
+	return nil
+}
+
+func (t *Config) BinarySize() (nbytes int, sizeKnown bool) {
+	return 0, false
+}
+
+func (t *Config) New() fastrpc.Serializable {
+	return new(Config)
+}
+
+func (t *Config) Marshal(wire io.Writer) {
+	var b [8]byte
+	var bs []byte
+	bs = b[:8]
+	tmp32 := t.Epoch
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	tmp32 = int32(len(t.Addrs))
+	bs[4] = byte(tmp32)
+	bs[5] = byte(tmp32 >> 8)
+	bs[6] = byte(tmp32 >> 16)
+	bs[7] = byte(tmp32 >> 24)
+	wire.Write(bs)
+	for i := range t.Addrs {
+		addr := state.Value(t.Addrs[i])
+		addr.Marshal(wire)
+	}
+}
+
+func (t *Config) Unmarshal(wire io.Reader) error {
+	var b [8]byte
+	var bs []byte
+	bs = b[:8]
+	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
+		return err
+	}
+	t.Epoch = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	alen := int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	if alen < 0 || alen > MAX_REPLICAS {
+		return ErrConfigSize
+	}
+	t.Addrs = make([]string, alen)
+	for i := range t.Addrs {
+		var addr state.Value
+		if err := addr.Unmarshal(wire); err != nil {
+			return err
+		}
+		t.Addrs[i] = string(addr)
+	}
 	return nil
 }
@@ -35,7 +35,7 @@ type Replica struct {
 
 	fastQSize int
 
-	ballotArray    []*Ballot
+	ballots        map[int32]*Ballot // the current ballot and, briefly, its predecessor
 	commands       map[int32]*state.Command
 	commandsMutex  *sync.Mutex
 	committed      map[int32]bool
@@ -61,20 +61,20 @@ const (
 
 type Ballot struct {
 	cstruct    []int32
-	ballot     int32
+	ballot     genericsmrproto.Ballot
 	status     uint8
 	received2a bool
 	lb         *LeaderBookkeeping
 }
 
 type LeaderBookkeeping struct {
-	maxRecvBallot int32
+	maxRecvBallot genericsmrproto.Ballot
 	prepareOKs    int
 	committed     int
 	cstructs      [][]int32
 }
 
-func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply bool, app state.Application) *Replica {
+func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply bool, app state.StateMachine) *Replica {
 	r := &Replica{genericsmr.NewReplica(id, peerAddrList, thrifty, exec, dreply, app),
 		make(chan *gpaxosproto.Prepare, CHAN_BUFFER_SIZE),
 		make(chan *gpaxosproto.M_1a, CHAN_BUFFER_SIZE),
@@ -85,7 +85,7 @@ func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply b
 		false,
 		0,
 		3,
-		make([]*Ballot, 2*1024*1024),
+		make(map[int32]*Ballot),
 		make(map[int32]*state.Command, 100000),
 		new(sync.Mutex),
 		make(map[int32]bool, 100000),
@@ -155,7 +155,7 @@ func (r *Replica) handleReplicaConnection(rid int, reader *bufio.Reader) error {
 				cmd.Unmarshal(reader)
 				r.commandsMutex.Lock()
 				if _, present := r.commands[cid]; !present {
-					if cmd.Op != 0 || cmd.K != 0 || cmd.V != 0 {
+					if cmd.Op != 0 || cmd.K != "" || cmd.V != "" {
 						r.commands[cid] = cmd
 					}
 				}
@@ -218,7 +218,7 @@ func (r *Replica) replyPrepare(reply *gpaxosproto.PrepareReply, w *bufio.Writer)
 func (r *Replica) send1b(msg *gpaxosproto.M_1b, w *bufio.Writer) {
 	w.WriteByte(gpaxosproto.M1B)
 	msg.Marshal(w)
-	dummy := state.Command{0, 0, 0, 0}
+	dummy := state.Command{0, 0, 0, "", "", "", nil, 0}
 	for _, cid := range msg.Cstruct {
 		if cmd, present := r.commands[cid]; present {
 			cmd.Marshal(w)
@@ -260,6 +260,14 @@ func (r *Replica) bcast2b(msg *gpaxosproto.M_2b) {
 	}
 }
 
+/* RPC to be called by master */
+
+// reports ballot numbers, which is what instances are in Generalized Paxos
+func (r *Replica) Ping(args *genericsmrproto.PingArgs, reply *genericsmrproto.PingReply) error {
+	*reply = r.PingStatus.Reply("gpaxos")
+	return nil
+}
+
 var clockChan chan bool
 
 func (r *Replica) clock() {
@@ -301,14 +309,17 @@ func (r *Replica) run() {
 	if r.isLeader {
 		r.crtBalnum = 0
 		r.fastRound = true
-		r.ballotArray[0] = &Ballot{nil, 0, PHASE1, false, &LeaderBookkeeping{cstructs: make([][]int32, 0)}}
-		r.ballotArray[0].lb.cstructs = append(r.ballotArray[0].lb.cstructs, make([]int32, 0))
+		r.ballots[0] = &Ballot{nil, r.makeUniqueBallot(0), PHASE1, false, &LeaderBookkeeping{cstructs: make([][]int32, 0)}}
+		r.ballots[0].lb.cstructs = append(r.ballots[0].lb.cstructs, make([]int32, 0))
 		r.bcast1a(0, true)
 	}
 
 	for !r.Shutdown {
+		// there is no execution thread
+		r.PingStatus.Publish(r.isLeader, r.crtBalnum)
+		r.PingStatus.PublishExecedUpTo(r.execedUpTo)
 
-		if r.crtBalnum >= 0 && len(r.ballotArray[r.crtBalnum].cstruct) >= CMDS_PER_BALLOT {
+		if r.crtBalnum >= 0 && len(r.ballots[r.crtBalnum].cstruct) >= CMDS_PER_BALLOT {
 
 			select {
 
@@ -418,15 +429,15 @@ func (r *Replica) run() {
 	}
 }
 
-func (r *Replica) makeUniqueBallot(ballot int32) int32 {
-	return (ballot << 4) | r.Id
+func (r *Replica) makeUniqueBallot(counter int32) genericsmrproto.Ballot {
+	return genericsmrproto.Ballot{0, counter, r.Id}
 }
 
-func (r *Replica) makeBallotLargerThan(ballot int32) int32 {
-	return r.makeUniqueBallot((ballot >> 4) + 1)
+func (r *Replica) makeBallotLargerThan(ballot genericsmrproto.Ballot) genericsmrproto.Ballot {
+	return ballot.NextFor(r.Id)
 }
 
-func (r *Replica) bcastPrepare(replica int32, instance int32, ballot int32) {
+func (r *Replica) bcastPrepare(replica int32, instance int32, ballot genericsmrproto.Ballot) {
 	defer func() {
 		if err := recover(); err != nil {
 			dlog.Println("Prepare bcast failed:", err)
@@ -557,7 +568,8 @@ func (r *Replica) bcastCommit(cstruct []int32) {
 }
 
 func (r *Replica) handlePropose(propose *genericsmr.Propose) {
-	//TODO!! Handle client retries
+	//client retries are proposed again, the client's session in the
+	//state machine keeps them from being applied twice
 
 	/*    if _, duplicate := r.commands[propose.CommandId]; duplicate {
 	      log.Println("Duplicate command from client")
@@ -566,7 +578,7 @@ func (r *Replica) handlePropose(propose *genericsmr.Propose) {
 
 	if !r.isLeader && r.crtBalnum < 0 {
 		log.Println("Received request before leader 1a message")
-		r.ReplyProposeTS(&genericsmrproto.ProposeReplyTS{FALSE, -1, state.NIL, propose.Timestamp}, propose.Reply)
+		r.ReplyProposeTS(&genericsmrproto.ProposeReplyTS{FALSE, -1, state.NIL, propose.Timestamp, nil}, propose.Reply)
 		return
 	}
 
@@ -574,14 +586,14 @@ func (r *Replica) handlePropose(propose *genericsmr.Propose) {
 
 	if _, present := r.committed[propose.CommandId]; present {
 		if r.isLeader || ALL_TO_ALL {
-			r.ReplyProposeTS(&genericsmrproto.ProposeReplyTS{TRUE, propose.CommandId, state.NIL, propose.Timestamp}, propose.Reply)
+			r.ReplyProposeTS(&genericsmrproto.ProposeReplyTS{TRUE, propose.CommandId, state.NIL, propose.Timestamp, nil}, propose.Reply)
 		}
 		return
 	} else {
 		r.commandReplies[propose.CommandId] = propose
 	}
 
-	crtBallot := r.ballotArray[r.crtBalnum]
+	crtBallot := r.ballots[r.crtBalnum]
 
 	for _, cid := range crtBallot.cstruct {
 		if cid == propose.CommandId {
@@ -631,13 +643,14 @@ func (r *Replica) handle1a(msg *gpaxosproto.M_1a) {
 	}
 
 	if r.crtBalnum >= 0 {
-		r.send1b(&gpaxosproto.M_1b{r.Id, msg.Balnum, r.ballotArray[r.crtBalnum].cstruct}, r.PeerWriters[r.leaderId])
+		r.send1b(&gpaxosproto.M_1b{r.Id, msg.Balnum, r.ballots[r.crtBalnum].cstruct}, r.PeerWriters[r.leaderId])
 	} else {
 		r.send1b(&gpaxosproto.M_1b{r.Id, msg.Balnum, make([]int32, 0)}, r.PeerWriters[r.leaderId])
 	}
 
 	r.crtBalnum = msg.Balnum
-	r.ballotArray[r.crtBalnum] = &Ballot{nil, 0, PHASE1, false, &LeaderBookkeeping{cstructs: make([][]int32, r.N)}}
+	r.ballots[r.crtBalnum] = &Ballot{nil, r.makeUniqueBallot(0), PHASE1, false, &LeaderBookkeeping{cstructs: make([][]int32, r.N)}}
+	r.forgetBallotsBefore(r.crtBalnum)
 	if msg.Fast == TRUE {
 		r.fastRound = true
 	} else {
@@ -651,7 +664,7 @@ func (r *Replica) handle1b(msg *gpaxosproto.M_1b) {
 		return
 	}
 
-	crtbal := r.ballotArray[r.crtBalnum]
+	crtbal := r.ballots[r.crtBalnum]
 
 	if crtbal.status != PHASE1 {
 		//delayed 1b
@@ -692,7 +705,7 @@ func (r *Replica) handle2a(msg *gpaxosproto.M_2a) {
 		return
 	}
 
-	crtbal := r.ballotArray[r.crtBalnum]
+	crtbal := r.ballots[r.crtBalnum]
 	crtbal.received2a = true
 	crtbal.status = PHASE2
 
@@ -733,7 +746,7 @@ func (r *Replica) handle2b(msg *gpaxosproto.M_2b) {
 		return
 	}
 
-	crtbal := r.ballotArray[r.crtBalnum]
+	crtbal := r.ballots[r.crtBalnum]
 
 	if r.isLeader && crtbal.status != PHASE2 {
 		log.Println("2b before its time")
@@ -753,7 +766,7 @@ func (r *Replica) handle2b(msg *gpaxosproto.M_2b) {
 func (r *Replica) tryToLearn() {
 	var glb []int32
 	var conflict bool
-	crtbal := r.ballotArray[r.crtBalnum]
+	crtbal := r.ballots[r.crtBalnum]
 
 	if conflict, glb, _ = r.learn(false); conflict {
 		log.Println("Conflict")
@@ -767,7 +780,7 @@ func (r *Replica) tryToLearn() {
 			r.committed[cid] = true
 			crtbal.lb.committed++
 			if prop, present := r.commandReplies[cid]; present {
-				r.ReplyProposeTS(&genericsmrproto.ProposeReplyTS{TRUE, cid, state.NIL, prop.Timestamp}, prop.Reply)
+				r.ReplyProposeTS(&genericsmrproto.ProposeReplyTS{TRUE, cid, state.NIL, prop.Timestamp, nil}, prop.Reply)
 				delete(r.commandReplies, cid)
 			}
 		}
@@ -781,12 +794,23 @@ func (r *Replica) startHigherBallot() {
 	//TODO: can Phase 1 be bypassed in this situation, as an optimization?
 	r.crtBalnum++
 	r.fastRound = true
-	r.ballotArray[r.crtBalnum] = &Ballot{nil, 0, PHASE1, false, &LeaderBookkeeping{cstructs: make([][]int32, 0)}}
-	r.ballotArray[r.crtBalnum].cstruct = r.ballotArray[r.crtBalnum-1].cstruct
-	r.ballotArray[r.crtBalnum].lb.cstructs = append(r.ballotArray[r.crtBalnum].lb.cstructs, r.ballotArray[r.crtBalnum-1].cstruct)
+	r.ballots[r.crtBalnum] = &Ballot{nil, r.makeUniqueBallot(0), PHASE1, false, &LeaderBookkeeping{cstructs: make([][]int32, 0)}}
+	r.ballots[r.crtBalnum].cstruct = r.ballots[r.crtBalnum-1].cstruct
+	r.ballots[r.crtBalnum].lb.cstructs = append(r.ballots[r.crtBalnum].lb.cstructs, r.ballots[r.crtBalnum-1].cstruct)
+	r.forgetBallotsBefore(r.crtBalnum)
 	r.bcast1a(r.crtBalnum, true)
 }
 
+// Messages for any ballot other than the current one are ignored, so older
+// ballots are never looked at again once the replica has moved on.
+func (r *Replica) forgetBallotsBefore(balnum int32) {
+	for b := range r.ballots {
+		if b < balnum {
+			delete(r.ballots, b)
+		}
+	}
+}
+
 const (
 	WHITE uint8 = iota
 	GRAY
@@ -804,7 +828,7 @@ func (r *Replica) learn(getLub bool) (conflict bool, glb []int32, lub []int32) {
 		return false, nil, nil
 	}
 
-	crtbal := r.ballotArray[r.crtBalnum]
+	crtbal := r.ballots[r.crtBalnum]
 
 	if len(crtbal.lb.cstructs) == 0 {
 		return false, nil, nil
@@ -841,7 +865,7 @@ func (r *Replica) learn(getLub bool) (conflict bool, glb []int32, lub []int32) {
 					log.Println("cs[j] is nil")
 					return false, nil, nil
 				}
-				if !state.Conflict(crtCmd, r.commands[cs[j]]) {
+				if !r.State.Conflict(crtCmd, r.commands[cs[j]]) {
 					continue
 				}
 				n.outEdges[cs[j]] = n.outEdges[cs[j]] + 1
@@ -885,7 +909,7 @@ func (r *Replica) learn(getLub bool) (conflict bool, glb []int32, lub []int32) {
 	                   v := lub[j]
 	                   cu := r.commands[u]
 	                   cv := r.commands[v]
-	                   if !state.Conflict(cu, cv) {
+	                   if !r.State.Conflict(cu, cv) {
 	                       continue
 	                   }
 	                   nu := idToNode[u]
@@ -1,8 +1,8 @@
 package gpaxosproto
 
-//import (
-//    "state"
-//)
+import (
+	"genericsmrproto"
+)
 
 const (
 	PREPARE uint8 = iota
@@ -17,13 +17,13 @@ const (
 type Prepare struct {
 	LeaderId int32
 	Balnum   int32
-	Ballot   int32
+	Ballot   genericsmrproto.Ballot
 }
 
 type PrepareReply struct {
 	Balnum  int32
 	OK      uint8
-	Ballot  int32
+	Ballot  genericsmrproto.Ballot
 	Cstruct []int32
 }
 
@@ -170,7 +170,7 @@ func (t *M_1b) Unmarshal(rr io.Reader) error {
 }
 
 func (t *Prepare) BinarySize() (nbytes int, sizeKnown bool) {
-	return 12, true
+	return 0, false
 }
 
 type PrepareCache struct {
@@ -203,9 +203,9 @@ func (p *PrepareCache) Put(t *Prepare) {
 	p.mu.Unlock()
 }
 func (t *Prepare) Marshal(wire io.Writer) {
-	var b [12]byte
+	var b [8]byte
 	var bs []byte
-	bs = b[:12]
+	bs = b[:8]
 	tmp32 := t.LeaderId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -216,24 +216,22 @@ func (t *Prepare) Marshal(wire io.Writer) {
 	bs[5] = byte(tmp32 >> 8)
 	bs[6] = byte(tmp32 >> 16)
 	bs[7] = byte(tmp32 >> 24)
-	tmp32 = t.Ballot
-	bs[8] = byte(tmp32)
-	bs[9] = byte(tmp32 >> 8)
-	bs[10] = byte(tmp32 >> 16)
-	bs[11] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	t.Ballot.Marshal(wire)
 }
 
 func (t *Prepare) Unmarshal(wire io.Reader) error {
-	var b [12]byte
+	var b [8]byte
 	var bs []byte
-	bs = b[:12]
-	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
+	bs = b[:8]
+	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
 		return err
 	}
 	t.LeaderId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Balnum = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
-	t.Ballot = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -559,19 +557,15 @@ func (p *PrepareReplyCache) Put(t *PrepareReply) {
 func (t *PrepareReply) Marshal(wire io.Writer) {
 	var b [10]byte
 	var bs []byte
-	bs = b[:9]
+	bs = b[:5]
 	tmp32 := t.Balnum
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
 	bs[2] = byte(tmp32 >> 16)
 	bs[3] = byte(tmp32 >> 24)
 	bs[4] = byte(t.OK)
-	tmp32 = t.Ballot
-	bs[5] = byte(tmp32)
-	bs[6] = byte(tmp32 >> 8)
-	bs[7] = byte(tmp32 >> 16)
-	bs[8] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	t.Ballot.Marshal(wire)
 	bs = b[:]
 	alen1 := int64(len(t.Cstruct))
 	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
@@ -596,13 +590,15 @@ func (t *PrepareReply) Unmarshal(rr io.Reader) error {
 	}
 	var b [10]byte
 	var bs []byte
-	bs = b[:9]
-	if _, err := io.ReadAtLeast(wire, bs, 9); err != nil {
+	bs = b[:5]
+	if _, err := io.ReadAtLeast(wire, bs, 5); err != nil {
 		return err
 	}
 	t.Balnum = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.OK = uint8(bs[4])
-	t.Ballot = int32((uint32(bs[5]) | (uint32(bs[6]) << 8) | (uint32(bs[7]) << 16) | (uint32(bs[8]) << 24)))
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
 	alen1, err := binary.ReadVarint(wire)
 	if err != nil {
 		return err
@@ -143,7 +143,7 @@ func simulatedClient(rlReply *masterproto.GetReplicaListReply, leader int, readi
 		before := time.Now()
 
 		args.ClientId = id
-		args.Command.K = state.Key(karray[i])
+		args.Command.K = state.IntKey(karray[i])
 
 		if !*fast {
 			if *noLeader {
@@ -12,13 +12,12 @@ import (
 	"masterproto"
 	"math/rand"
 	"net"
-	"net/rpc"
 	"runtime"
 	"state"
 	"time"
 )
 
-var masterAddr *string = flag.String("maddr", "", "Master address. Defaults to localhost")
+var masterAddr *string = flag.String("maddr", "", "Comma-separated master addresses (addr, or addr:port for another port than -mport), tried in turn. Defaults to localhost.")
 var masterPort *int = flag.Int("mport", 7087, "Master port.  Defaults to 7077.")
 var reqsNb *int = flag.Int("q", 5000, "Total number of requests. Defaults to 5000.")
 var writes *int = flag.Int("w", 100, "Percentage of updates (writes). Defaults to 100%.")
@@ -60,13 +59,10 @@ func main() {
 		log.Fatalf("Conflicts percentage must be between 0 and 100.\n")
 	}
 
-	master, err := rpc.DialHTTP("tcp", fmt.Sprintf("%s:%d", *masterAddr, *masterPort))
-	if err != nil {
-		log.Fatalf("Error connecting to master\n")
-	}
+	master := masterproto.NewClient(*masterAddr, *masterPort)
 
 	rlReply := new(masterproto.GetReplicaListReply)
-	err = master.Call("Master.GetReplicaList", new(masterproto.GetReplicaListArgs), rlReply)
+	err := master.Call("Master.GetReplicaList", new(masterproto.GetReplicaListArgs), rlReply)
 	if err != nil {
 		log.Fatalf("Error making the GetReplicaList RPC")
 	}
@@ -133,12 +129,14 @@ func main() {
 	idOffset = int32(*o)
 	var id int32 = idOffset
 	done := make(chan bool, N)
-	args := genericsmrproto.Propose{id, state.Command{state.INCREMENT, 0, 0}, 0}
+	args := genericsmrproto.Propose{id, state.Command{id, state.NewClientId(), state.INCREMENT, "", "", "", nil, 0}, 0}
 	before_total := time.Now()
 	latencies = make([]int64, *reqsNb)
 	OKrsp = make([]bool, *reqsNb)
 	for j := 0; j < *rounds; j++ {
 		n := *reqsNb / *rounds
+		// the commands of the previous rounds have been answered or given up on
+		args.Command.Acked = id
 		if *check {
 			rsp = make([]bool, n)
 			for j := 0; j < n; j++ {
@@ -154,6 +152,7 @@ func main() {
 		for i := 0; i < n; i++ {
 			dlog.Printf("Sending proposal %d\n", id)
 			args.CommandId = id
+			args.Command.CommandId = id
 			if put[i] {
 				args.Command.Op = state.INCREMENT
 			} else {
@@ -166,8 +165,8 @@ func main() {
 			}
 
 			ops[i] = args.Command.Op
-			args.Command.K = state.Key(karray[i])
-			args.Command.V = state.Value(i)
+			args.Command.K = state.IntKey(karray[i])
+			args.Command.V = state.IntValue(int64(i))
 			leader := rarray[i]
 			writers[leader].WriteByte(genericsmrproto.PROPOSE)
 			args.Marshal(writers[leader])
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"dlog"
+	"errors"
+	"fmt"
+	"genericsmr"
+	"genericsmrproto"
+	"log"
+	"masterproto"
+	"net"
+	"net/rpc"
+	"os"
+	"paxos"
+	"state"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/**********************************************************************
+
+                            MASTER GROUP
+
+***********************************************************************/
+
+// With -masters, several master instances keep the registry together, as a
+// classic Paxos group: every instance runs a paxos replica, on its RPC port +
+// GROUP_PORT_OFFSET, whose state machine is the registry (see registryMachine).
+// Any instance answers GetLeader, GetReplicaList and GetConfig from the
+// registry it has installed, and forwards the RPCs that change it to the lead
+// instance, the one whose replica is the group's leader. Only the lead
+// instance pings the replicas (so it answers GetClusterStatus for the others)
+// and chooses their leader. If it fails, the
+// lowest instance that is up takes over.
+//
+// Every instance saves the registry it installs to master-registry-<id>. An
+// instance that restarts while the others run rejoins the group, and gets the
+// registry in a snapshot. If the whole group restarts, it starts once a
+// majority of the instances are up: their replicas elect a leader among
+// themselves (they suspect the others after GROUP_SUSPECT), and the lead
+// instance proposes the latest registry that the majority has saved. A change
+// is only lost if the group stopped before a majority had saved it.
+
+const GROUP_PORT_OFFSET = 1000
+const LEAD_CHECK = 1e9          // 1 second between checks that the group has a lead instance
+const LEAD_MISSES = 3           // checks without a lead instance before taking over
+const PROPOSE_TIMEOUT = 5 * 1e9 // 5 seconds
+const GROUP_SUSPECT = 2 * time.Second
+
+var errNotLead = errors.New("not the lead master instance")
+var errNoLead = errors.New("there is no lead master instance")
+var errNotRunning = errors.New("the master group has not restored the registry yet")
+var errConcurrent = errors.New("the registry has been changed by another master instance")
+
+type masterGroup struct {
+	id      int
+	addrs   []string // the RPC address of every instance
+	file    string
+	rep     *paxos.Replica
+	running bool // the registry has been installed through the group since we started (under the master's lock)
+
+	conn      net.Conn // to our replica, as a client
+	reader    *bufio.Reader
+	writer    *bufio.Writer
+	commandId int32
+
+	lock  *sync.Mutex   // for peers, and rep while we start
+	peers []*rpc.Client // to the other instances
+}
+
+/* RPCs between master instances */
+
+// Unlike those of Master, these RPCs are never forwarded.
+type Lead struct {
+	master *Master
+}
+
+type GetRegistryArgs struct {
+}
+
+type GetRegistryReply struct {
+	Running  bool
+	Lead     bool
+	Registry []byte
+}
+
+func (l *Lead) Register(args *masterproto.RegisterArgs, reply *masterproto.RegisterReply) error {
+	if !l.master.group.isLead() {
+		return errNotLead
+	}
+	return l.master.register(args, reply)
+}
+
+func (l *Lead) RemoveReplica(args *masterproto.RemoveReplicaArgs, reply *masterproto.RemoveReplicaReply) error {
+	if !l.master.group.isLead() {
+		return errNotLead
+	}
+	return l.master.removeReplica(args, reply)
+}
+
+func (l *Lead) GetClusterStatus(args *masterproto.GetClusterStatusArgs, reply *masterproto.GetClusterStatusReply) error {
+	if !l.master.group.isLead() {
+		return errNotLead
+	}
+	return l.master.getClusterStatus(args, reply)
+}
+
+func (l *Lead) GetRegistry(args *GetRegistryArgs, reply *GetRegistryReply) error {
+	master := l.master
+	master.lock.Lock()
+	reg := master.reg
+	reply.Running = master.group.running
+	master.lock.Unlock()
+	reply.Lead = reply.Running && master.group.isLead()
+
+	var buf bytes.Buffer
+	reg.marshal(&buf)
+	reply.Registry = buf.Bytes()
+	return nil
+}
+
+/* ============= */
+
+func newMasterGroup(addrs []string, id int) *masterGroup {
+	return &masterGroup{id, addrs, fmt.Sprintf("master-registry-%d", id), nil, false,
+		nil, nil, nil, 0,
+		new(sync.Mutex), make([]*rpc.Client, len(addrs))}
+}
+
+// Starts our instance of the master group, once our RPCs are being served,
+// since the other instances may be waiting for them.
+func (master *Master) startGroup() {
+	g := master.group
+	id, addrs := g.id, g.addrs
+
+	peerAddrs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			log.Fatalf("Bad master address %s: %v\n", addr, err)
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			log.Fatalf("Bad master address %s: %v\n", addr, err)
+		}
+		peerAddrs[i] = fmt.Sprintf("%s:%d", host, p+GROUP_PORT_OFFSET)
+	}
+
+	// if the others are running, we have restarted and must rejoin them
+	rejoin := false
+	for i := range addrs {
+		if i == id {
+			continue
+		}
+		reply := new(GetRegistryReply)
+		if g.call(i, "Lead.GetRegistry", new(GetRegistryArgs), reply) == nil && reply.Running {
+			rejoin = true
+		}
+	}
+	if rejoin {
+		// the snapshot has the registry, which may be older than the one we
+		// saved if the others have restarted without us
+		log.Printf("Rejoining the master group as instance %d\n", id)
+	} else if reg, err := loadRegistry(g.file); err == nil {
+		master.lock.Lock()
+		master.installLocked(&reg)
+		master.lock.Unlock()
+		log.Printf("Loaded version %d of the registry from %s\n", reg.version, g.file)
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("Error loading the registry from %s: %v\n", g.file, err)
+	}
+	if !rejoin {
+		log.Printf("Starting the master group as instance %d of %d\n", id, len(addrs))
+	}
+
+	rep := paxos.NewReplica(id, peerAddrs, false, true, true, false, rejoin, true, false,
+		genericsmr.FailureDetectorConfig{0, GROUP_SUSPECT}, registryMachine{master})
+	g.lock.Lock()
+	g.rep = rep
+	g.lock.Unlock()
+
+	if !rejoin {
+		go master.restoreRegistry()
+	}
+	go master.watchGroup()
+}
+
+// tells whether we are the lead instance
+func (g *masterGroup) isLead() bool {
+	g.lock.Lock()
+	rep := g.rep
+	g.lock.Unlock()
+	// the replica's main loop owns its fields, but publishes its leadership
+	return rep != nil && rep.PingStatus.IsLeader()
+}
+
+// Called by every instance when the whole group starts. Once the replicas
+// have elected a leader, the lead instance proposes the latest registry that a
+// majority of the instances (including itself) has saved, with a version that
+// every instance installs.
+func (master *Master) restoreRegistry() {
+	g := master.group
+	for {
+		time.Sleep(LEAD_CHECK)
+
+		master.lock.Lock()
+		best := master.reg
+		running := g.running
+		master.lock.Unlock()
+		if running {
+			// restored by the lead instance
+			return
+		}
+		if !g.isLead() {
+			continue
+		}
+
+		newest := best.version
+		heard := 1
+		for i := range g.addrs {
+			if i == g.id {
+				continue
+			}
+			reply := new(GetRegistryReply)
+			if err := g.call(i, "Lead.GetRegistry", new(GetRegistryArgs), reply); err != nil {
+				continue
+			}
+			var reg registry
+			if err := reg.unmarshal(bytes.NewReader(reply.Registry)); err != nil {
+				log.Printf("Error decoding the registry of instance %d: %v\n", i, err)
+				continue
+			}
+			heard++
+			if reg.version > newest {
+				newest = reg.version
+			}
+			if reg.version > best.version {
+				best = reg
+			}
+		}
+		if heard <= len(g.addrs)/2 {
+			continue
+		}
+
+		restored := best.copy()
+		restored.version = newest + 1
+		master.updateLock.Lock()
+		err := g.propose(&restored)
+		master.updateLock.Unlock()
+		if err == nil {
+			log.Printf("Restored version %d of the registry, from %d of %d instances\n", best.version, heard, len(g.addrs))
+			return
+		}
+		dlog.Printf("Could not restore the registry: %v\n", err)
+	}
+}
+
+// Makes us the lead instance if the group has had none for LEAD_MISSES checks,
+// and we are the lowest instance that is running.
+func (master *Master) watchGroup() {
+	g := master.group
+	misses := 0
+	for {
+		time.Sleep(LEAD_CHECK)
+		master.lock.Lock()
+		running := g.running
+		master.lock.Unlock()
+		if !running || g.isLead() {
+			misses = 0
+			continue
+		}
+
+		lead := false
+		lowest := g.id
+		for i := range g.addrs {
+			if i == g.id {
+				continue
+			}
+			reply := new(GetRegistryReply)
+			if g.call(i, "Lead.GetRegistry", new(GetRegistryArgs), reply) != nil || !reply.Running {
+				continue
+			}
+			if reply.Lead {
+				lead = true
+			}
+			if i < lowest {
+				lowest = i
+			}
+		}
+
+		if lead {
+			misses = 0
+			continue
+		}
+		misses++
+		if misses >= LEAD_MISSES && lowest == g.id {
+			log.Println("The master group has no lead instance, taking over")
+			g.rep.BeTheLeader(new(genericsmrproto.BeTheLeaderArgs), new(genericsmrproto.BeTheLeaderReply))
+			misses = 0
+		}
+	}
+}
+
+// Proposes the registry through our replica, as a client of it, and waits
+// until it has been executed. Called with the master's updateLock held.
+func (g *masterGroup) propose(reg *registry) error {
+	if g.conn == nil {
+		conn, err := net.Dial("tcp", g.rep.PeerAddrList[g.id])
+		if err != nil {
+			return err
+		}
+		g.conn = conn
+		g.reader = bufio.NewReader(conn)
+		g.writer = bufio.NewWriter(conn)
+	}
+
+	g.commandId++
+	propose := &genericsmrproto.Propose{g.commandId, reg.command(), time.Now().UnixNano()}
+	g.writer.WriteByte(genericsmrproto.PROPOSE)
+	propose.Marshal(g.writer)
+	if err := g.writer.Flush(); err != nil {
+		g.closeConn()
+		return err
+	}
+
+	g.conn.SetReadDeadline(time.Now().Add(PROPOSE_TIMEOUT))
+	reply := new(genericsmrproto.ProposeReplyTS)
+	if err := reply.Unmarshal(g.reader); err != nil {
+		// the reply may still come, so the connection cannot be reused
+		g.closeConn()
+		return err
+	}
+	if reply.OK == paxos.FALSE {
+		return errNotLead
+	}
+	if reply.CommandId != g.commandId {
+		g.closeConn()
+		return fmt.Errorf("reply to command %d instead of %d", reply.CommandId, g.commandId)
+	}
+	if reply.Value != state.OK {
+		return errConcurrent
+	}
+	return nil
+}
+
+func (g *masterGroup) closeConn() {
+	g.conn.Close()
+	g.conn = nil
+}
+
+// calls an RPC of instance i
+func (g *masterGroup) call(i int, method string, args interface{}, reply interface{}) error {
+	g.lock.Lock()
+	peer := g.peers[i]
+	g.lock.Unlock()
+
+	if peer == nil {
+		var err error
+		if peer, err = rpc.DialHTTP("tcp", g.addrs[i]); err != nil {
+			return err
+		}
+		g.lock.Lock()
+		if g.peers[i] != nil {
+			peer.Close()
+			peer = g.peers[i]
+		} else {
+			g.peers[i] = peer
+		}
+		g.lock.Unlock()
+	}
+
+	err := peer.Call(method, args, reply)
+	if _, ok := err.(rpc.ServerError); err != nil && !ok {
+		// the connection is broken
+		g.lock.Lock()
+		if g.peers[i] == peer {
+			g.peers[i] = nil
+		}
+		g.lock.Unlock()
+		peer.Close()
+	}
+	return err
+}
+
+// Makes the call at the lead instance, for an RPC that we got while not the
+// lead. The errors of the lead instance are returned as they are.
+func (g *masterGroup) forward(method string, args interface{}, reply interface{}) error {
+	for i := range g.addrs {
+		if i == g.id {
+			continue
+		}
+		err := g.call(i, method, args, reply)
+		if err == nil {
+			return nil
+		}
+		if serr, ok := err.(rpc.ServerError); ok && string(serr) != errNotLead.Error() {
+			return err
+		}
+	}
+	return errNoLead
+}
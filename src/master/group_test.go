@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"genericsmr"
+	"genericsmrproto"
+	"masterproto"
+	"net"
+	"net/http"
+	"net/rpc"
+	"paxos"
+	"state"
+	"sync"
+	"testing"
+	"time"
+)
+
+// another instance of the master group, as seen through its Lead RPCs
+type fakeInstance struct {
+	reg     registry
+	running bool
+	lead    bool
+}
+
+func (f *fakeInstance) GetRegistry(args *GetRegistryArgs, reply *GetRegistryReply) error {
+	var buf bytes.Buffer
+	f.reg.marshal(&buf)
+	reply.Running = f.running
+	reply.Lead = f.lead
+	reply.Registry = buf.Bytes()
+	return nil
+}
+
+func (f *fakeInstance) RemoveReplica(args *masterproto.RemoveReplicaArgs, reply *masterproto.RemoveReplicaReply) error {
+	if !f.lead {
+		return errNotLead
+	}
+	reply.Epoch = int32(args.ReplicaId)
+	return nil
+}
+
+func serveInstance(t *testing.T, addr string, f *fakeInstance) {
+	srv := rpc.NewServer()
+	srv.RegisterName("Lead", f)
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, mux)
+}
+
+// an address that nobody listens on
+func downAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	return l.Addr().String()
+}
+
+func testRegistry(version int64, addrs ...string) registry {
+	reg := newRegistry(len(addrs))
+	reg.version = version
+	reg.nodeList = addrs
+	reg.leader = 0
+	reg.config = genericsmrproto.NewConfig(addrs)
+	return reg
+}
+
+// instance 0 of a group of the given instances, whose replica is the leader
+// and sends every registry proposed on proposed
+func testGroupMaster(addrs []string, proposed chan registry) *Master {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go fakeReplica(conn, proposed)
+		}
+	}()
+
+	g := newMasterGroup(addrs, 0)
+	g.rep = &paxos.Replica{Replica: &genericsmr.Replica{PeerAddrList: []string{l.Addr().String()}}}
+	g.rep.PingStatus.Publish(true, 0)
+	return &Master{N: 3, lock: new(sync.Mutex), reg: newRegistry(3), updateLock: new(sync.Mutex), reconfigLock: new(sync.Mutex), group: g}
+}
+
+func fakeReplica(conn net.Conn, proposed chan registry) {
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		if _, err := reader.ReadByte(); err != nil {
+			return
+		}
+		var propose genericsmrproto.Propose
+		if err := propose.Unmarshal(reader); err != nil {
+			return
+		}
+		var reg registry
+		reg.unmarshal(bytes.NewReader([]byte(propose.Command.V)))
+		proposed <- reg
+		reply := &genericsmrproto.ProposeReplyTS{paxos.TRUE, propose.CommandId, state.OK, propose.Timestamp, nil}
+		reply.Marshal(writer)
+		writer.Flush()
+	}
+}
+
+func TestForward(t *testing.T) {
+	addrs := []string{downAddr(t), downAddr(t), downAddr(t), downAddr(t)}
+	serveInstance(t, addrs[1], &fakeInstance{running: true})
+	serveInstance(t, addrs[3], &fakeInstance{running: true, lead: true})
+	g := newMasterGroup(addrs, 0)
+
+	// instance 2 is down, and instance 1 is not the lead
+	reply := new(masterproto.RemoveReplicaReply)
+	if err := g.forward("Lead.RemoveReplica", &masterproto.RemoveReplicaArgs{5}, reply); err != nil || reply.Epoch != 5 {
+		t.Fatalf("forwarded to the wrong instance: %v", err)
+	}
+
+	// the errors of the lead instance are its own
+	if err := g.forward("Lead.Unknown", &masterproto.RemoveReplicaArgs{5}, reply); err == nil || err == errNoLead {
+		t.Fatalf("wrong error from the lead instance: %v", err)
+	}
+
+	g = newMasterGroup(addrs[:3], 0)
+	if err := g.forward("Lead.RemoveReplica", &masterproto.RemoveReplicaArgs{5}, reply); err != errNoLead {
+		t.Fatalf("forwarded with no lead instance: %v", err)
+	}
+}
+
+func TestRestoreWithMajority(t *testing.T) {
+	addrs := []string{downAddr(t), downAddr(t), downAddr(t)}
+	proposed := make(chan registry, 1)
+	master := testGroupMaster(addrs, proposed)
+	master.reg = testRegistry(3, "a:7070", "b:7071", "c:7072")
+	go master.restoreRegistry()
+
+	// alone, we are not a majority
+	select {
+	case reg := <-proposed:
+		t.Fatalf("restored version %d without a majority", reg.version)
+	case <-time.After(LEAD_CHECK * 3 / 2):
+	}
+
+	// instance 1 has saved a later registry, instance 2 stays down
+	serveInstance(t, addrs[1], &fakeInstance{testRegistry(5, "a:7070", "b:7071", "d:7073"), false, false})
+	select {
+	case reg := <-proposed:
+		if reg.version != 6 || len(reg.nodeList) != 3 || reg.nodeList[2] != "d:7073" {
+			t.Fatalf("restored version %d: %v", reg.version, reg.nodeList)
+		}
+	case <-time.After(3 * LEAD_CHECK):
+		t.Fatal("not restored by a majority")
+	}
+}
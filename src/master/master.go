@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"genericsmrproto"
@@ -9,38 +10,67 @@ import (
 	"net"
 	"net/http"
 	"net/rpc"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 var portnum *int = flag.Int("port", 7087, "Port # to listen on. Defaults to 7087")
 var numNodes *int = flag.Int("N", 3, "Number of replicas. Defaults to 3.")
+var masters *string = flag.String("masters", "", "Comma-separated addr:port of every master instance, to run a replicated master (see -id). Defaults to a single master, which keeps its state in memory.")
+var masterId *int = flag.Int("id", 0, "With -masters, the index of this master instance, which listens on the port of its entry.")
+
+var errUnchanged = errors.New("the registry is unchanged")
 
 type Master struct {
-	N        int
-	nodeList []string
-	addrList []string
-	portList []int
-	lock     *sync.Mutex
-	nodes    []*rpc.Client
-	leader   []bool
-	alive    []bool
+	N     int
+	lock  *sync.Mutex
+	reg   registry      // replicated by the master group, if there is one
+	nodes []*rpc.Client // opened by the instance that pings the replicas
+	alive []bool
+	pings []pingResult // the last successful ping of each replica
+
+	updateLock   *sync.Mutex // serializes changes to the registry
+	reconfigLock *sync.Mutex // serializes configuration changes
+	group        *masterGroup
+}
+
+type pingResult struct {
+	latency int64
+	reply   genericsmrproto.PingReply
 }
 
 func main() {
 	flag.Parse()
 
+	var addrs []string
+	if *masters != "" {
+		addrs = strings.Split(*masters, ",")
+		if *masterId < 0 || *masterId >= len(addrs) {
+			log.Fatalf("There is no master instance %d in -masters\n", *masterId)
+		}
+		_, port, err := net.SplitHostPort(addrs[*masterId])
+		if err != nil {
+			log.Fatalf("Bad master address %s: %v\n", addrs[*masterId], err)
+		}
+		if *portnum, err = strconv.Atoi(port); err != nil {
+			log.Fatalf("Bad master address %s: %v\n", addrs[*masterId], err)
+		}
+	}
+
 	log.Printf("Master starting on port %d\n", *portnum)
 	log.Printf("...waiting for %d replicas\n", *numNodes)
 
 	master := &Master{*numNodes,
-		make([]string, 0, *numNodes),
-		make([]string, 0, *numNodes),
-		make([]int, 0, *numNodes),
 		new(sync.Mutex),
-		make([]*rpc.Client, *numNodes),
-		make([]bool, *numNodes),
-		make([]bool, *numNodes)}
+		newRegistry(*numNodes),
+		make([]*rpc.Client, 0, *numNodes),
+		make([]bool, 0, *numNodes),
+		make([]pingResult, 0, *numNodes),
+		new(sync.Mutex),
+		new(sync.Mutex),
+		nil}
 
 	rpc.Register(master)
 	rpc.HandleHTTP()
@@ -49,15 +79,135 @@ func main() {
 		log.Fatal("Master listen error:", err)
 	}
 
-	go master.run()
+	if addrs == nil {
+		go master.run()
+		http.Serve(l, nil)
+		return
+	}
+
+	master.group = newMasterGroup(addrs, *masterId)
+	rpc.Register(&Lead{master})
+	go http.Serve(l, nil)
+	master.startGroup()
+	master.run()
+}
+
+// tells whether we make the changes to the registry, and ping the replicas
+func (master *Master) isLead() bool {
+	return master.group == nil || master.group.isLead()
+}
+
+// Makes a change to the registry: change is called, with the lock held, on a
+// copy of the registry, and the copy replaces the registry unless change
+// fails. With a master group, the new registry is installed once the group
+// has committed it.
+func (master *Master) update(change func(reg *registry) error) error {
+	master.updateLock.Lock()
+	defer master.updateLock.Unlock()
+
+	master.lock.Lock()
+	if master.group != nil && !master.group.running {
+		master.lock.Unlock()
+		return errNotRunning
+	}
+	reg := master.reg.copy()
+	if err := change(&reg); err != nil {
+		master.lock.Unlock()
+		if err == errUnchanged {
+			return nil
+		}
+		return err
+	}
+	reg.version++
+	if master.group == nil {
+		master.installLocked(&reg)
+		master.lock.Unlock()
+		return nil
+	}
+	master.lock.Unlock()
+	return master.group.propose(&reg)
+}
+
+// Installs a registry committed by the master group, unless it is not newer
+// than ours. Returns false if it was not installed.
+func (master *Master) install(reg *registry) bool {
+	master.lock.Lock()
+	defer master.lock.Unlock()
+	if master.group != nil {
+		master.group.running = true
+	}
+	if reg.version <= master.reg.version {
+		return false
+	}
+	master.installLocked(reg)
+	if master.group != nil {
+		if err := reg.save(master.group.file); err != nil {
+			log.Printf("Error saving the registry to %s: %v\n", master.group.file, err)
+		}
+	}
+	return true
+}
+
+// called with the lock held
+func (master *Master) installLocked(reg *registry) {
+	for len(master.nodes) < len(reg.nodeList) {
+		master.nodes = append(master.nodes, nil)
+		master.alive = append(master.alive, false)
+		master.pings = append(master.pings, pingResult{})
+	}
+	for i := range master.nodes {
+		if master.nodes[i] != nil && reg.config.Removed(int32(i)) {
+			master.nodes[i].Close()
+			master.nodes[i] = nil
+			master.alive[i] = false
+		}
+	}
+	master.reg = *reg
+}
+
+// the RPC connection to replica i, opened if need be
+func (master *Master) node(i int) *rpc.Client {
+	master.lock.Lock()
+	node := master.nodes[i]
+	addr := master.reg.nodeList[i]
+	master.lock.Unlock()
+	if node != nil {
+		return node
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	p, _ := strconv.Atoi(port)
+	node, err = rpc.DialHTTP("tcp", fmt.Sprintf("%s:%d", host, p+1000))
+	if err != nil {
+		return nil
+	}
+	master.lock.Lock()
+	defer master.lock.Unlock()
+	if master.nodes[i] != nil || master.reg.config.Removed(int32(i)) {
+		node.Close()
+		return master.nodes[i]
+	}
+	master.nodes[i] = node
+	return node
+}
 
-	http.Serve(l, nil)
+// forgets a connection that has failed, so that the next call reconnects
+func (master *Master) dropNode(i int, node *rpc.Client) {
+	master.lock.Lock()
+	if master.nodes[i] == node {
+		master.nodes[i] = nil
+	}
+	master.lock.Unlock()
+	node.Close()
 }
 
 func (master *Master) run() {
 	for true {
 		master.lock.Lock()
-		if len(master.nodeList) == master.N {
+		if len(master.reg.nodeList) >= master.N {
 			master.lock.Unlock()
 			break
 		}
@@ -66,82 +216,191 @@ func (master *Master) run() {
 	}
 	time.Sleep(2000000000)
 
-	// connect to SMR servers
-	for i := 0; i < master.N; i++ {
-		var err error
-		addr := fmt.Sprintf("%s:%d", master.addrList[i], master.portList[i]+1000)
-		master.nodes[i], err = rpc.DialHTTP("tcp", addr)
-		if err != nil {
-			log.Fatalf("Error connecting to replica %d\n", i)
+	for true {
+		if !master.isLead() {
+			time.Sleep(3000 * 1000 * 1000)
+			continue
+		}
+
+		master.lock.Lock()
+		formed := master.reg.config.Epoch >= 0
+		master.lock.Unlock()
+		if !formed {
+			// connect to SMR servers
+			for i := 0; i < master.N; i++ {
+				if master.node(i) == nil {
+					log.Fatalf("Error connecting to replica %d\n", i)
+				}
+			}
+			err := master.update(func(reg *registry) error {
+				if reg.config.Epoch >= 0 {
+					return errUnchanged
+				}
+				reg.leader = 0
+				reg.config = genericsmrproto.NewConfig(reg.nodeList)
+				return nil
+			})
+			if err != nil {
+				log.Printf("Could not record the configuration: %v\n", err)
+			}
 		}
-		master.leader[i] = false
-	}
-	master.leader[0] = true
 
-	for true {
 		time.Sleep(3000 * 1000 * 1000)
-		new_leader := false
-		for i, node := range master.nodes {
-			err := node.Call("Replica.Ping", new(genericsmrproto.PingArgs), new(genericsmrproto.PingReply))
+		master.lock.Lock()
+		config := master.reg.config.Copy()
+		leader := master.reg.leader
+		master.lock.Unlock()
+
+		// the lock is not held during the pings, so that replicas can join
+		alive := make([]bool, len(config.Addrs))
+		pings := make([]pingResult, len(config.Addrs))
+		for i := range alive {
+			if !config.IsMember(int32(i)) {
+				continue
+			}
+			node := master.node(i)
+			if node == nil {
+				continue
+			}
+			start := time.Now()
+			err := node.Call("Replica.Ping", new(genericsmrproto.PingArgs), &pings[i].reply)
 			if err != nil {
 				//log.Printf("Replica %d has failed to reply\n", i)
-				master.alive[i] = false
-				if master.leader[i] {
-					// neet to choose a new leader
-					new_leader = true
-					master.leader[i] = false
-				}
+				master.dropNode(i, node)
+				alive[i] = false
 			} else {
-				master.alive[i] = true
+				alive[i] = true
+				pings[i].latency = int64(time.Since(start))
+			}
+		}
+
+		master.lock.Lock()
+		copy(master.alive, alive)
+		for i := range alive {
+			if alive[i] {
+				master.pings[i] = pings[i]
 			}
 		}
-		if !new_leader {
+		master.lock.Unlock()
+
+		master.followLeader(leader, alive, pings)
+	}
+}
+
+// Classic Paxos replicas elect their leader themselves, so we only record the
+// one that claims to be it (a leader that has just been replaced may still
+// claim to be), and wait for an election when none does. The other protocols
+// have no election, and the leader is chosen for them.
+func (master *Master) followLeader(leader int, alive []bool, pings []pingResult) {
+	elects := false
+	claimant := -1
+	for i := range alive {
+		if !alive[i] {
 			continue
 		}
-		for i, new_master := range master.nodes {
-			if master.alive[i] {
-				err := new_master.Call("Replica.BeTheLeader", new(genericsmrproto.BeTheLeaderArgs), new(genericsmrproto.BeTheLeaderReply))
-				if err == nil {
-					master.leader[i] = true
-					log.Printf("Replica %d is the new leader.", i)
-					break
+		if pings[i].reply.Protocol == "paxos" {
+			elects = true
+		}
+		if pings[i].reply.IsLeader && (claimant < 0 || claimant == leader) {
+			claimant = i
+		}
+	}
+	if claimant >= 0 && claimant != leader {
+		master.recordLeader(claimant)
+	} else if !elects && claimant < 0 && (leader < 0 || leader >= len(alive) || !alive[leader]) {
+		// neet to choose a new leader
+		master.chooseLeader()
+	}
+}
+
+func (master *Master) recordLeader(i int) {
+	err := master.update(func(reg *registry) error {
+		if reg.leader == i {
+			return errUnchanged
+		}
+		reg.leader = i
+		return nil
+	})
+	if err != nil {
+		log.Printf("Could not record replica %d as the leader: %v\n", i, err)
+		return
+	}
+	log.Printf("Replica %d has been elected leader.", i)
+}
+
+func (master *Master) chooseLeader() {
+	master.lock.Lock()
+	nodes := append([]*rpc.Client(nil), master.nodes...)
+	alive := append([]bool(nil), master.alive...)
+	master.lock.Unlock()
+
+	for i, new_master := range nodes {
+		if alive[i] && new_master != nil {
+			err := new_master.Call("Replica.BeTheLeader", new(genericsmrproto.BeTheLeaderArgs), new(genericsmrproto.BeTheLeaderReply))
+			if err == nil {
+				err = master.update(func(reg *registry) error {
+					reg.leader = i
+					return nil
+				})
+				if err != nil {
+					log.Printf("Could not record replica %d as the leader: %v\n", i, err)
 				}
+				log.Printf("Replica %d is the new leader.", i)
+				return
 			}
 		}
 	}
+
+	if err := master.update(func(reg *registry) error {
+		if reg.leader < 0 {
+			return errUnchanged
+		}
+		reg.leader = -1
+		return nil
+	}); err != nil {
+		log.Printf("Could not record that there is no leader: %v\n", err)
+	}
 }
 
 func (master *Master) Register(args *masterproto.RegisterArgs, reply *masterproto.RegisterReply) error {
+	if !master.isLead() {
+		return master.group.forward("Lead.Register", args, reply)
+	}
+	return master.register(args, reply)
+}
 
-	master.lock.Lock()
-	defer master.lock.Unlock()
-
-	nlen := len(master.nodeList)
-	index := nlen
+func (master *Master) register(args *masterproto.RegisterArgs, reply *masterproto.RegisterReply) error {
+	if args.Join {
+		return master.registerJoin(args, reply)
+	}
 
 	addrPort := fmt.Sprintf("%s:%d", args.Addr, args.Port)
+	index := -1
 
-	for i, ap := range master.nodeList {
-		if addrPort == ap {
-			index = i
-			break
+	err := master.update(func(reg *registry) error {
+		for i, ap := range reg.nodeList {
+			if addrPort == ap {
+				index = i
+				return errUnchanged
+			}
 		}
+		if len(reg.nodeList) >= master.N {
+			return errors.New("the cluster is running already, replicas must register with Join")
+		}
+		index = len(reg.nodeList)
+		reg.nodeList = append(reg.nodeList, addrPort)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	if index == nlen {
-		master.nodeList = master.nodeList[0 : nlen+1]
-		master.nodeList[nlen] = addrPort
-		master.addrList = master.addrList[0 : nlen+1]
-		master.addrList[nlen] = args.Addr
-		master.portList = master.portList[0 : nlen+1]
-		master.portList[nlen] = args.Port
-		nlen++
-	}
-
-	if nlen == master.N {
+	master.lock.Lock()
+	defer master.lock.Unlock()
+	if len(master.reg.nodeList) >= master.N {
 		reply.Ready = true
 		reply.ReplicaId = index
-		reply.NodeList = master.nodeList
+		reply.NodeList = master.reg.nodeList
 	} else {
 		reply.Ready = false
 	}
@@ -150,12 +409,10 @@ func (master *Master) Register(args *masterproto.RegisterArgs, reply *masterprot
 }
 
 func (master *Master) GetLeader(args *masterproto.GetLeaderArgs, reply *masterproto.GetLeaderReply) error {
-	time.Sleep(4 * 1000 * 1000)
-	for i, l := range master.leader {
-		if l {
-			*reply = masterproto.GetLeaderReply{i}
-			break
-		}
+	master.lock.Lock()
+	defer master.lock.Unlock()
+	if master.reg.leader >= 0 {
+		*reply = masterproto.GetLeaderReply{master.reg.leader}
 	}
 	return nil
 }
@@ -164,11 +421,235 @@ func (master *Master) GetReplicaList(args *masterproto.GetReplicaListArgs, reply
 	master.lock.Lock()
 	defer master.lock.Unlock()
 
-	if len(master.nodeList) == master.N {
-		reply.ReplicaList = master.nodeList
+	if master.reg.config.Epoch >= 0 {
+		reply.ReplicaList = master.reg.config.Addrs
+		reply.Epoch = master.reg.config.Epoch
+		reply.Ready = true
+	} else if len(master.reg.nodeList) == master.N {
+		reply.ReplicaList = master.reg.nodeList
 		reply.Ready = true
 	} else {
 		reply.Ready = false
 	}
 	return nil
 }
+
+func (master *Master) GetConfig(args *masterproto.GetConfigArgs, reply *masterproto.GetConfigReply) error {
+	master.lock.Lock()
+	defer master.lock.Unlock()
+
+	reply.Config = master.reg.config.Copy()
+	reply.LeaderId = master.reg.leader
+	return nil
+}
+
+// Only the instance that pings the replicas knows their status, so the others
+// forward the call.
+func (master *Master) GetClusterStatus(args *masterproto.GetClusterStatusArgs, reply *masterproto.GetClusterStatusReply) error {
+	if !master.isLead() {
+		return master.group.forward("Lead.GetClusterStatus", args, reply)
+	}
+	return master.getClusterStatus(args, reply)
+}
+
+func (master *Master) getClusterStatus(args *masterproto.GetClusterStatusArgs, reply *masterproto.GetClusterStatusReply) error {
+	master.lock.Lock()
+	defer master.lock.Unlock()
+
+	reply.Epoch = master.reg.config.Epoch
+	reply.LeaderId = master.reg.leader
+	reply.Replicas = make([]masterproto.ReplicaStatus, 0, len(master.reg.config.Addrs))
+	for i, addr := range master.reg.config.Addrs {
+		if addr == "" {
+			continue
+		}
+		p := &master.pings[i]
+		reply.Replicas = append(reply.Replicas, masterproto.ReplicaStatus{i, addr, master.alive[i], p.latency,
+			p.reply.Protocol, p.reply.IsLeader, p.reply.CrtInstance, p.reply.ExecedUpTo})
+	}
+	return nil
+}
+
+func (master *Master) RemoveReplica(args *masterproto.RemoveReplicaArgs, reply *masterproto.RemoveReplicaReply) error {
+	if !master.isLead() {
+		return master.group.forward("Lead.RemoveReplica", args, reply)
+	}
+	return master.removeReplica(args, reply)
+}
+
+func (master *Master) removeReplica(args *masterproto.RemoveReplicaArgs, reply *masterproto.RemoveReplicaReply) error {
+	config, err := master.reconfigure(func(c *genericsmrproto.Config) error {
+		if !c.IsMember(int32(args.ReplicaId)) {
+			return fmt.Errorf("replica %d is not a member", args.ReplicaId)
+		}
+		c.Addrs[args.ReplicaId] = ""
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	reply.Epoch = config.Epoch
+	return nil
+}
+
+/* Membership changes */
+
+var errNotFormed = errors.New("the initial replicas have not all registered yet")
+var errNoPings = errors.New("no replica has answered a ping yet")
+
+// For a replica that joins the running cluster. The replica gets an id that
+// has never been used, and is added to the configuration in the background.
+func (master *Master) registerJoin(args *masterproto.RegisterArgs, reply *masterproto.RegisterReply) error {
+	addrPort := fmt.Sprintf("%s:%d", args.Addr, args.Port)
+	index := -1
+	added := false
+
+	master.lock.Lock()
+	err := master.reconfigurable()
+	master.lock.Unlock()
+	if err == errNoPings {
+		reply.Ready = false
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = master.update(func(reg *registry) error {
+		if reg.config.Epoch < 0 {
+			return errNotFormed
+		}
+		for i, ap := range reg.nodeList {
+			if addrPort == ap && !reg.config.Removed(int32(i)) {
+				index = i
+			}
+		}
+		if index >= 0 {
+			return errUnchanged
+		}
+		index = len(reg.nodeList)
+		reg.nodeList = append(reg.nodeList, addrPort)
+		added = true
+		return nil
+	})
+	if err == errNotFormed {
+		reply.Ready = false
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if added {
+		log.Printf("Replica %d is joining from %s\n", index, addrPort)
+		go master.join(index, args.Replaces)
+	}
+
+	// the members, for the replica to connect to, and the replica itself
+	master.lock.Lock()
+	defer master.lock.Unlock()
+	reply.Ready = true
+	reply.ReplicaId = index
+	reply.NodeList = make([]string, index+1)
+	copy(reply.NodeList, master.reg.config.Addrs)
+	reply.NodeList[index] = addrPort
+	return nil
+}
+
+func (master *Master) join(id int, replaces int) {
+	// give the replica time to connect to the members
+	time.Sleep(2000000000)
+
+	if master.node(id) == nil {
+		log.Printf("Error connecting to replica %d\n", id)
+		return
+	}
+
+	_, err := master.reconfigure(func(c *genericsmrproto.Config) error {
+		for len(c.Addrs) <= id {
+			c.Addrs = append(c.Addrs, "")
+		}
+		c.Addrs[id] = master.reg.nodeList[id]
+		if replaces >= 0 && replaces != id && c.IsMember(int32(replaces)) {
+			c.Addrs[replaces] = ""
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Could not add replica %d: %v\n", id, err)
+	}
+}
+
+// Only classic Paxos replicas change the membership of their cluster (see
+// paxos/paxos-reconfig.go); the protocol is the one they answer pings with.
+// Called with the lock held.
+func (master *Master) reconfigurable() error {
+	for i := range master.pings {
+		switch p := master.pings[i].reply.Protocol; p {
+		case "":
+			continue
+		case "paxos":
+			return nil
+		default:
+			return fmt.Errorf("%s replicas cannot change the membership of their cluster", p)
+		}
+	}
+	return errNoPings
+}
+
+// Asks the leader to commit the configuration that change makes out of the
+// current one, and records it in the registry. change is called with the lock
+// held.
+func (master *Master) reconfigure(change func(c *genericsmrproto.Config) error) (genericsmrproto.Config, error) {
+	master.reconfigLock.Lock()
+	defer master.reconfigLock.Unlock()
+
+	master.lock.Lock()
+	if master.reg.config.Epoch < 0 {
+		master.lock.Unlock()
+		return master.reg.config, errors.New("the cluster is not running yet")
+	}
+	if err := master.reconfigurable(); err != nil {
+		master.lock.Unlock()
+		return master.reg.config, err
+	}
+	config := master.reg.config.Copy()
+	config.Epoch++
+	if err := change(&config); err != nil {
+		master.lock.Unlock()
+		return config, err
+	}
+	leader := master.reg.leader
+	master.lock.Unlock()
+
+	if leader < 0 {
+		return config, errors.New("there is no leader")
+	}
+	node := master.node(leader)
+	if node == nil {
+		return config, errors.New("there is no leader")
+	}
+	err := node.Call("Replica.Reconfigure", &genericsmrproto.ReconfigureArgs{config}, new(genericsmrproto.ReconfigureReply))
+	if err != nil {
+		return config, err
+	}
+
+	new_leader := false
+	err = master.update(func(reg *registry) error {
+		reg.config = config
+		new_leader = reg.leader >= 0 && !config.IsMember(int32(reg.leader))
+		if new_leader {
+			reg.leader = -1
+		}
+		return nil
+	})
+	if err != nil {
+		return config, err
+	}
+	log.Printf("Configuration %d: %v\n", config.Epoch, config.Addrs)
+
+	if new_leader {
+		master.chooseLeader()
+	}
+	return config, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"genericsmrproto"
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync"
+	"testing"
+)
+
+// a replica that counts the times the master makes it the leader
+type fakeLeader struct {
+	lock   sync.Mutex
+	forced int
+}
+
+func (f *fakeLeader) BeTheLeader(args *genericsmrproto.BeTheLeaderArgs, reply *genericsmrproto.BeTheLeaderReply) error {
+	f.lock.Lock()
+	f.forced++
+	f.lock.Unlock()
+	return nil
+}
+
+func (f *fakeLeader) timesForced() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.forced
+}
+
+// a single master with connections to n replicas, none of them the leader
+func testMaster(t *testing.T, n int) (*Master, []*fakeLeader) {
+	master := &Master{N: n, lock: new(sync.Mutex), reg: testRegistry(1, make([]string, n)...), updateLock: new(sync.Mutex), reconfigLock: new(sync.Mutex)}
+	master.reg.leader = -1
+	replicas := make([]*fakeLeader, n)
+	for i := range replicas {
+		replicas[i] = new(fakeLeader)
+		srv := rpc.NewServer()
+		srv.RegisterName("Replica", replicas[i])
+		mux := http.NewServeMux()
+		mux.Handle(rpc.DefaultRPCPath, srv)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go http.Serve(l, mux)
+		node, err := rpc.DialHTTP("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		master.nodes = append(master.nodes, node)
+		master.alive = append(master.alive, true)
+		master.pings = append(master.pings, pingResult{})
+	}
+	return master, replicas
+}
+
+func pingsOf(protocol string, leader int, n int) []pingResult {
+	pings := make([]pingResult, n)
+	for i := range pings {
+		pings[i].reply = genericsmrproto.PingReply{protocol, i == leader, 0, -1}
+	}
+	return pings
+}
+
+func TestFollowLeader(t *testing.T) {
+	alive := []bool{true, true, true}
+
+	// Paxos replicas without a leader are left to their election
+	master, replicas := testMaster(t, 3)
+	master.followLeader(-1, alive, pingsOf("paxos", -1, 3))
+	if master.reg.leader != -1 || replicas[0].timesForced() != 0 {
+		t.Fatalf("leader %d forced on Paxos replicas", master.reg.leader)
+	}
+	// and the one they elect is recorded
+	master.followLeader(-1, alive, pingsOf("paxos", 2, 3))
+	if master.reg.leader != 2 || replicas[2].timesForced() != 0 {
+		t.Fatalf("elected leader not recorded: %d", master.reg.leader)
+	}
+
+	// a protocol without an election gets a leader from the master
+	master, replicas = testMaster(t, 3)
+	master.followLeader(-1, alive, pingsOf("mencius", -1, 3))
+	if master.reg.leader != 0 || replicas[0].timesForced() != 1 {
+		t.Fatalf("no leader chosen: %d", master.reg.leader)
+	}
+}
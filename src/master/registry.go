@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"genericsmrproto"
+	"io"
+	"log"
+	"os"
+	"state"
+)
+
+// The registry is the part of the master's state that the replicas depend on:
+// the replicas that have registered, the configuration they have committed,
+// and which of them is the leader. With a master group it is replicated, and
+// saved to a file by every master instance (liveness is not, every instance
+// finds it out for itself).
+//
+// A registry is never modified once installed: changes are made to a copy,
+// with the next version, and installed in its place.
+type registry struct {
+	version  int64                  // bumped by every change
+	nodeList []string               // the addr:port of every replica that has registered, by id
+	leader   int                    // -1 if there is none
+	config   genericsmrproto.Config // Epoch -1 until the initial replicas have formed the cluster
+}
+
+// the key of the commands that install a registry
+const REGISTRY_KEY = state.Key("registry")
+
+// registries with more replica ids are refused when decoded
+const MAX_NODES = genericsmrproto.MAX_REPLICAS
+
+var errRegistrySize = errors.New("registry with too many replicas")
+
+func newRegistry(n int) registry {
+	return registry{0, make([]string, 0, n), -1, genericsmrproto.Config{-1, nil}}
+}
+
+func (reg *registry) copy() registry {
+	return registry{reg.version, append([]string(nil), reg.nodeList...), reg.leader, reg.config.Copy()}
+}
+
+func (reg *registry) marshal(w io.Writer) {
+	var b [16]byte
+	bs := b[:16]
+	binary.LittleEndian.PutUint64(bs, uint64(reg.version))
+	binary.LittleEndian.PutUint32(bs[8:], uint32(int32(reg.leader)))
+	binary.LittleEndian.PutUint32(bs[12:], uint32(len(reg.nodeList)))
+	w.Write(bs)
+	for i := range reg.nodeList {
+		addr := state.Value(reg.nodeList[i])
+		addr.Marshal(w)
+	}
+	reg.config.Marshal(w)
+}
+
+func (reg *registry) unmarshal(r io.Reader) error {
+	var b [16]byte
+	bs := b[:16]
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return err
+	}
+	reg.version = int64(binary.LittleEndian.Uint64(bs))
+	reg.leader = int(int32(binary.LittleEndian.Uint32(bs[8:])))
+	n := binary.LittleEndian.Uint32(bs[12:])
+	if n > MAX_NODES {
+		return errRegistrySize
+	}
+	reg.nodeList = make([]string, n)
+	for i := range reg.nodeList {
+		var addr state.Value
+		if err := addr.Unmarshal(r); err != nil {
+			return err
+		}
+		reg.nodeList[i] = string(addr)
+	}
+	return reg.config.Unmarshal(r)
+}
+
+// the command that installs the registry
+func (reg *registry) command() state.Command {
+	var buf bytes.Buffer
+	reg.marshal(&buf)
+	return state.Command{0, state.NO_CLIENT, state.PUT, REGISTRY_KEY, state.Value(buf.Bytes()), state.NIL, nil, 0}
+}
+
+/* Persistence */
+
+// Replaces the file with the registry, going through a temporary file so that
+// a crash leaves either version whole.
+func (reg *registry) save(file string) error {
+	tmp := file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	reg.marshal(w)
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func loadRegistry(file string) (registry, error) {
+	var reg registry
+	f, err := os.Open(file)
+	if err != nil {
+		return reg, err
+	}
+	defer f.Close()
+	err = reg.unmarshal(bufio.NewReader(f))
+	return reg, err
+}
+
+/* The state machine of the master group */
+
+// Every command carries a whole registry, which replaces the current one if
+// it has a later version. The proposer computes it from the registry it has
+// installed, so a command proposed by an instance that has missed a change is
+// ignored, and the proposer is told so by the result.
+type registryMachine struct {
+	master *Master
+}
+
+var registryConflicts = state.NewConflictTable(state.PUT).Conflict(state.PUT, state.PUT)
+
+func (m registryMachine) Execute(c *state.Command, st *state.State) state.Value {
+	if c.Op != state.PUT || c.K != REGISTRY_KEY {
+		return state.NIL
+	}
+	var reg registry
+	if err := reg.unmarshal(bytes.NewReader([]byte(c.V))); err != nil {
+		log.Println("Error decoding a registry:", err)
+		return state.NIL
+	}
+	if !m.master.install(&reg) {
+		return state.NIL
+	}
+	return state.OK
+}
+
+func (m registryMachine) Conflicts() *state.ConflictTable {
+	return registryConflicts
+}
+
+func (m registryMachine) Snapshot(st *state.State, w io.Writer) {
+	m.master.lock.Lock()
+	reg := m.master.reg
+	m.master.lock.Unlock()
+	reg.marshal(w)
+}
+
+func (m registryMachine) Restore(st *state.State, r io.Reader) error {
+	var reg registry
+	if err := reg.unmarshal(r); err != nil {
+		return err
+	}
+	m.master.install(&reg)
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"masterproto"
+	"os"
+	"strconv"
+	"time"
+)
+
+var masterAddr *string = flag.String("maddr", "", "Comma-separated master addresses (addr, or addr:port for another port than -mport), tried in turn. Defaults to localhost.")
+var masterPort *int = flag.Int("mport", 7087, "Master port.  Defaults to 7087.")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] command\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  config       print the current configuration\n")
+	fmt.Fprintf(os.Stderr, "  status       print the health of every replica, as of the master's last ping\n")
+	fmt.Fprintf(os.Stderr, "  remove <id>  remove a replica from the configuration\n")
+	fmt.Fprintf(os.Stderr, "\nReplicas are added by starting them with -join (and -replace <id>).\n\nFlags:\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+
+	master := masterproto.NewClient(*masterAddr, *masterPort)
+	var err error
+
+	switch flag.Arg(0) {
+	case "config":
+		reply := new(masterproto.GetConfigReply)
+		if err = master.Call("Master.GetConfig", new(masterproto.GetConfigArgs), reply); err != nil {
+			log.Fatalf("Error making the GetConfig RPC: %v\n", err)
+		}
+		if reply.Config.Epoch < 0 {
+			fmt.Println("The cluster is not running yet")
+			return
+		}
+		fmt.Printf("Configuration %d, %d members\n", reply.Config.Epoch, reply.Config.Size())
+		for q, addr := range reply.Config.Addrs {
+			if addr == "" {
+				continue
+			}
+			leader := ""
+			if q == reply.LeaderId {
+				leader = " (leader)"
+			}
+			fmt.Printf("  replica %d at %s%s\n", q, addr, leader)
+		}
+
+	case "status":
+		reply := new(masterproto.GetClusterStatusReply)
+		if err = master.Call("Master.GetClusterStatus", new(masterproto.GetClusterStatusArgs), reply); err != nil {
+			log.Fatalf("Error making the GetClusterStatus RPC: %v\n", err)
+		}
+		if reply.Epoch < 0 {
+			fmt.Println("The cluster is not running yet")
+			return
+		}
+		fmt.Printf("Configuration %d, leader %d\n", reply.Epoch, reply.LeaderId)
+		fmt.Printf("%4s  %-21s  %-5s  %10s  %-8s  %-6s  %11s  %11s\n",
+			"id", "address", "alive", "latency", "protocol", "leader", "crtInstance", "execedUpTo")
+		for _, rs := range reply.Replicas {
+			alive, latency := "no", "-"
+			if rs.Alive {
+				alive = "yes"
+			}
+			if rs.Latency > 0 {
+				latency = time.Duration(rs.Latency).String()
+			}
+			leader := ""
+			if rs.IsLeader {
+				leader = "yes"
+			}
+			fmt.Printf("%4d  %-21s  %-5s  %10s  %-8s  %-6s  %11d  %11d\n",
+				rs.ReplicaId, rs.Addr, alive, latency, rs.Protocol, leader, rs.CrtInstance, rs.ExecedUpTo)
+		}
+
+	case "remove":
+		if flag.NArg() != 2 {
+			usage()
+		}
+		id, err := strconv.Atoi(flag.Arg(1))
+		if err != nil {
+			usage()
+		}
+		reply := new(masterproto.RemoveReplicaReply)
+		if err = master.Call("Master.RemoveReplica", &masterproto.RemoveReplicaArgs{id}, reply); err != nil {
+			log.Fatalf("Error removing replica %d: %v\n", id, err)
+		}
+		fmt.Printf("Removed replica %d, the configuration is now %d\n", id, reply.Epoch)
+
+	default:
+		usage()
+	}
+}
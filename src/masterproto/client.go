@@ -0,0 +1,58 @@
+package masterproto
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"strings"
+)
+
+// A connection to the master, which may be a group of instances (see
+// master/group.go), any of which answers. A call that fails because an
+// instance is down or unreachable is made again at the next one.
+type Client struct {
+	addrs []string
+	cur   int
+	conn  *rpc.Client
+}
+
+// addrs is a comma-separated list of masters, as addr:port, or addr for the
+// given port
+func NewClient(addrs string, port int) *Client {
+	c := &Client{strings.Split(addrs, ","), 0, nil}
+	for i, addr := range c.addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			c.addrs[i] = fmt.Sprintf("%s:%d", addr, port)
+		}
+	}
+	return c
+}
+
+// Tries every master once, from the one that answered last. The errors
+// returned by a master are returned as they are.
+func (c *Client) Call(method string, args interface{}, reply interface{}) error {
+	var err error
+	for tries := 0; tries < len(c.addrs); tries++ {
+		if c.conn == nil {
+			if c.conn, err = rpc.DialHTTP("tcp", c.addrs[c.cur]); err != nil {
+				c.conn = nil
+				c.cur = (c.cur + 1) % len(c.addrs)
+				continue
+			}
+		}
+		err = c.conn.Call(method, args, reply)
+		if _, ok := err.(rpc.ServerError); err == nil || ok {
+			return err
+		}
+		c.Close()
+		c.cur = (c.cur + 1) % len(c.addrs)
+	}
+	return err
+}
+
+func (c *Client) Close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
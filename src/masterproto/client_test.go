@@ -0,0 +1,68 @@
+package masterproto
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/rpc"
+	"testing"
+)
+
+type testMaster struct {
+	leader int
+}
+
+func (m *testMaster) GetLeader(args *GetLeaderArgs, reply *GetLeaderReply) error {
+	if m.leader < 0 {
+		return errors.New("there is no leader")
+	}
+	reply.LeaderId = m.leader
+	return nil
+}
+
+func serveMaster(t *testing.T, m *testMaster) string {
+	srv := rpc.NewServer()
+	srv.RegisterName("Master", m)
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, mux)
+	return l.Addr().String()
+}
+
+func TestClientFailover(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := l.Addr().String()
+	l.Close()
+	m := &testMaster{2}
+	up := serveMaster(t, m)
+
+	// the master that is down is skipped
+	c := NewClient(down+","+up, 7087)
+	reply := new(GetLeaderReply)
+	if err := c.Call("Master.GetLeader", new(GetLeaderArgs), reply); err != nil || reply.LeaderId != 2 {
+		t.Fatalf("no answer from the master that is up: %v", err)
+	}
+
+	// a master that answers with an error is not skipped
+	m.leader = -1
+	if err := c.Call("Master.GetLeader", new(GetLeaderArgs), reply); err == nil {
+		t.Fatal("error of the master lost")
+	}
+
+	c = NewClient(down, 7087)
+	if err := c.Call("Master.GetLeader", new(GetLeaderArgs), reply); err == nil {
+		t.Fatal("answer with every master down")
+	}
+
+	// -mport is the port of the masters given without one
+	if c = NewClient("localhost,127.0.0.1:1", 7087); c.addrs[0] != "localhost:7087" || c.addrs[1] != "127.0.0.1:1" {
+		t.Fatalf("wrong master addresses %v", c.addrs)
+	}
+}
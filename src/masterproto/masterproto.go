@@ -1,8 +1,14 @@
 package masterproto
 
+import (
+	"genericsmrproto"
+)
+
 type RegisterArgs struct {
-	Addr string
-	Port int
+	Addr     string
+	Port     int
+	Join     bool // the cluster is running, and the replica is to be added to it
+	Replaces int  // with Join, the id of a replica to remove in the same configuration change, or -1
 }
 
 type RegisterReply struct {
@@ -22,6 +28,44 @@ type GetReplicaListArgs struct {
 }
 
 type GetReplicaListReply struct {
-	ReplicaList []string
+	ReplicaList []string // empty for the replicas that are not members
 	Ready       bool
+	Epoch       int32
+}
+
+type GetConfigArgs struct {
+}
+
+type GetConfigReply struct {
+	Config   genericsmrproto.Config
+	LeaderId int // -1 if there is no leader
+}
+
+type RemoveReplicaArgs struct {
+	ReplicaId int
+}
+
+type RemoveReplicaReply struct {
+	Epoch int32 // of the configuration without the replica
+}
+
+type GetClusterStatusArgs struct {
+}
+
+// what the master found out about a replica at its last ping
+type ReplicaStatus struct {
+	ReplicaId   int
+	Addr        string
+	Alive       bool
+	Latency     int64  // of the last successful ping, in nanoseconds
+	Protocol    string // as reported by the replica, like the fields below
+	IsLeader    bool
+	CrtInstance int32
+	ExecedUpTo  int32
+}
+
+type GetClusterStatusReply struct {
+	Epoch    int32
+	LeaderId int             // -1 if there is no leader
+	Replicas []ReplicaStatus // the members of the configuration
 }
@@ -0,0 +1,68 @@
+package mencius
+
+import (
+	"dlog"
+	"genericsmr"
+	"log"
+	"menciusproto"
+)
+
+// the instance space (see genericsmr.InstanceWindow)
+type instanceWindow = genericsmr.InstanceWindow[Instance]
+
+func newInstanceWindow() *instanceWindow {
+	return genericsmr.NewInstanceWindow[Instance]()
+}
+
+/* Garbage collection of executed instances */
+
+// replicas that do not execute commands only need to have committed them
+func (r *Replica) progress() int32 {
+	if !r.Exec {
+		return r.blockingInstance - 1
+	}
+	return r.execedUpTo
+}
+
+// instances that have been garbage collected were executed everywhere
+func (r *Replica) isExecuted(instance int32) bool {
+	inst := r.instanceSpace.Get(instance)
+	return inst == nil || inst.status == EXECUTED
+}
+
+func (r *Replica) bcastExecStatus() {
+	defer func() {
+		if err := recover(); err != nil {
+			dlog.Println("ExecStatus bcast failed:", err)
+		}
+	}()
+	args := &menciusproto.ExecStatus{r.Id, r.progress()}
+
+	for q := int32(0); q < int32(r.N); q++ {
+		if q == r.Id || !r.Alive[q] {
+			continue
+		}
+		r.SendMsg(q, r.execStatusRPC, args)
+	}
+}
+
+func (r *Replica) handleExecStatus(es *menciusproto.ExecStatus) {
+	if es.ReplicaId < 0 || int(es.ReplicaId) >= r.N {
+		return
+	}
+	r.ReportProgress(es.ReplicaId, []int32{es.ExecedUpTo})
+
+	// Mencius has no state transfer, so a replica that has been left out of
+	// garbage collection cannot catch up. It could not have anyway: the
+	// others skip its turns while it is down, and it has no way to rejoin.
+	if r.instanceSpace.Forgotten(es.ExecedUpTo+1) && r.SnapshotDue(es.ReplicaId) {
+		log.Printf("Replica %d needs instances that have been forgotten and cannot catch up\n", es.ReplicaId)
+	}
+}
+
+// forgets the instances that have been executed by every replica
+func (r *Replica) collectGarbage() {
+	if watermark := r.GCWatermark(0, r.progress()); watermark >= 0 {
+		r.instanceSpace.Forget(watermark)
+	}
+}
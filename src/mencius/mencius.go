@@ -28,6 +28,7 @@ type Replica struct {
 	commitChan               chan fastrpc.Serializable
 	prepareReplyChan         chan fastrpc.Serializable
 	acceptReplyChan          chan fastrpc.Serializable
+	execStatusChan           chan fastrpc.Serializable
 	delayedSkipChan          chan *DelayedSkip
 	skipRPC                  uint8
 	prepareRPC               uint8
@@ -35,18 +36,20 @@ type Replica struct {
 	commitRPC                uint8
 	prepareReplyRPC          uint8
 	acceptReplyRPC           uint8
-	clockChan                chan bool   // clock
-	instanceSpace            []*Instance // the space of all instances (used and not yet used)
-	crtInstance              int32       // highest active instance number that this replica knows about
-	latestInstReady          int32       // highest instance number that is in the READY state (ready to commit)
-	latestInstCommitted      int32       // highest instance number (owned by the current replica) that was committed
-	blockingInstance         int32       // the lowest instance that could block commits
+	execStatusRPC            uint8
+	clockChan                chan bool       // clock
+	instanceSpace            *instanceWindow // the space of all instances that have not been garbage collected yet
+	crtInstance              int32           // highest active instance number that this replica knows about
+	latestInstReady          int32           // highest instance number that is in the READY state (ready to commit)
+	latestInstCommitted      int32           // highest instance number (owned by the current replica) that was committed
+	blockingInstance         int32           // the lowest instance that could block commits
 	noCommitFor              int
 	waitingToCommitSomething bool
 	Shutdown                 bool
 	skipsWaiting             int
 	counter                  int
 	skippedTo                []int32
+	execedUpTo               int32 // instance up to which all commands have been executed
 }
 
 type DelayedSkip struct {
@@ -67,20 +70,20 @@ type Instance struct {
 	skipped       bool
 	nbInstSkipped int
 	command       *state.Command
-	ballot        int32
+	ballot        genericsmrproto.Ballot
 	status        InstanceStatus
 	lb            *LeaderBookkeeping
 }
 
 type LeaderBookkeeping struct {
 	clientProposal *genericsmr.Propose
-	maxRecvBallot  int32
+	maxRecvBallot  genericsmrproto.Ballot
 	prepareOKs     int
 	acceptOKs      int
 	nacks          int
 }
 
-func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply bool, durable bool, app state.Application) *Replica {
+func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply bool, durable bool, app state.StateMachine) *Replica {
 	skippedTo := make([]int32, len(peerAddrList))
 	for i := 0; i < len(skippedTo); i++ {
 		skippedTo[i] = -1
@@ -92,10 +95,11 @@ func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply b
 		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
 		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
 		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE*4),
+		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
 		make(chan *DelayedSkip, genericsmr.CHAN_BUFFER_SIZE),
-		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0,
 		make(chan bool, 10),
-		make([]*Instance, 10*1024*1024),
+		newInstanceWindow(),
 		int32(id),
 		int32(-1),
 		int32(0),
@@ -105,7 +109,8 @@ func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply b
 		false,
 		0,
 		0,
-		skippedTo}
+		skippedTo,
+		-1}
 
 	r.Durable = durable
 
@@ -115,6 +120,7 @@ func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply b
 	r.commitRPC = r.RegisterRPC(new(menciusproto.Commit), r.commitChan)
 	r.prepareReplyRPC = r.RegisterRPC(new(menciusproto.PrepareReply), r.prepareReplyChan)
 	r.acceptReplyRPC = r.RegisterRPC(new(menciusproto.AcceptReply), r.acceptReplyChan)
+	r.execStatusRPC = r.RegisterRPC(new(menciusproto.ExecStatus), r.execStatusChan)
 
 	go r.run()
 
@@ -127,15 +133,17 @@ func (r *Replica) recordInstanceMetadata(inst *Instance) {
 		return
 	}
 
-	var b [10]byte
+	var b [18]byte
 	if inst.skipped {
 		b[0] = 1
 	} else {
 		b[0] = 0
 	}
 	binary.LittleEndian.PutUint32(b[1:5], uint32(inst.nbInstSkipped))
-	binary.LittleEndian.PutUint32(b[5:9], uint32(inst.ballot))
-	b[9] = byte(inst.status)
+	binary.LittleEndian.PutUint32(b[5:9], uint32(inst.ballot.Epoch))
+	binary.LittleEndian.PutUint32(b[9:13], uint32(inst.ballot.Counter))
+	binary.LittleEndian.PutUint32(b[13:17], uint32(inst.ballot.ReplicaId))
+	b[17] = byte(inst.status)
 	r.StableStore.Write(b[:])
 }
 
@@ -160,6 +168,13 @@ func (r *Replica) sync() {
 	r.StableStore.Sync()
 }
 
+/* RPC to be called by master */
+
+func (r *Replica) Ping(args *genericsmrproto.PingArgs, reply *genericsmrproto.PingReply) error {
+	*reply = r.PingStatus.Reply("mencius")
+	return nil
+}
+
 func (r *Replica) replyPrepare(replicaId int32, reply *menciusproto.PrepareReply) {
 	r.SendMsg(replicaId, r.prepareReplyRPC, reply)
 }
@@ -185,8 +200,13 @@ func (r *Replica) run() {
 	}
 
 	go r.clock()
+	r.StartGCClock()
 
 	for !r.Shutdown {
+		r.PingStatus.Publish(false, r.crtInstance)
+		if !r.Exec {
+			r.PingStatus.PublishExecedUpTo(r.execedUpTo)
+		}
 
 		select {
 
@@ -241,6 +261,17 @@ func (r *Replica) run() {
 			r.handleDelayedSkip(delayedSkip)
 			break
 
+		case execStatusS := <-r.execStatusChan:
+			execStatus := execStatusS.(*menciusproto.ExecStatus)
+			dlog.Printf("Received ExecStatus from replica %d\n", execStatus.ReplicaId)
+			r.handleExecStatus(execStatus)
+			break
+
+		case <-r.GCChan:
+			r.bcastExecStatus()
+			r.collectGarbage()
+			break
+
 		case <-r.clockChan:
 			if lastSeenInstance == r.blockingInstance {
 				r.noCommitFor++
@@ -265,12 +296,12 @@ func (r *Replica) clock() {
 	}
 }
 
-func (r *Replica) makeUniqueBallot(ballot int32) int32 {
-	return (ballot << 4) | r.Id
+func (r *Replica) makeUniqueBallot(counter int32) genericsmrproto.Ballot {
+	return genericsmrproto.Ballot{0, counter, r.Id}
 }
 
-func (r *Replica) makeBallotLargerThan(ballot int32) int32 {
-	return r.makeUniqueBallot((ballot >> 4) + 1)
+func (r *Replica) makeBallotLargerThan(ballot genericsmrproto.Ballot) genericsmrproto.Ballot {
+	return ballot.NextFor(r.Id)
 }
 
 var sk menciusproto.Skip
@@ -303,7 +334,7 @@ func (r *Replica) bcastSkip(startInstance int32, endInstance int32, exceptReplic
 	}
 }
 
-func (r *Replica) bcastPrepare(instance int32, ballot int32) {
+func (r *Replica) bcastPrepare(instance int32, ballot genericsmrproto.Ballot) {
 	defer func() {
 		if err := recover(); err != nil {
 			dlog.Println("Prepare bcast failed:", err)
@@ -332,7 +363,7 @@ func (r *Replica) bcastPrepare(instance int32, ballot int32) {
 
 var ma menciusproto.Accept
 
-func (r *Replica) bcastAccept(instance int32, ballot int32, skip uint8, nbInstToSkip int32, command state.Command) {
+func (r *Replica) bcastAccept(instance int32, ballot genericsmrproto.Ballot, skip uint8, nbInstToSkip int32, command state.Command) {
 	defer func() {
 		if err := recover(); err != nil {
 			dlog.Println("Accept bcast failed:", err)
@@ -364,7 +395,7 @@ func (r *Replica) bcastAccept(instance int32, ballot int32, skip uint8, nbInstTo
 			if inst > instance {
 				inst -= int32(r.N)
 			}
-			if inst < 0 || r.instanceSpace[inst] != nil {
+			if inst < 0 || r.instanceSpace.Get(inst) != nil {
 				continue
 			}
 		}
@@ -385,7 +416,7 @@ func (r *Replica) bcastAccept(instance int32, ballot int32, skip uint8, nbInstTo
 			if inst > instance {
 				inst -= int32(r.N)
 			}
-			if inst >= 0 && r.instanceSpace[inst] == nil {
+			if inst >= 0 && r.instanceSpace.Get(inst) == nil {
 				continue
 			}
 		}
@@ -431,56 +462,62 @@ func (r *Replica) handlePropose(propose *genericsmr.Propose) {
 	instNo := r.crtInstance
 	r.crtInstance += int32(r.N)
 
-	r.instanceSpace[instNo] = &Instance{false,
+	r.instanceSpace.Set(instNo, &Instance{false,
 		0,
 		&propose.Command,
-		r.makeBallotLargerThan(0),
+		r.makeUniqueBallot(1),
 		ACCEPTED,
-		&LeaderBookkeeping{propose, 0, 0, 0, 0}}
+		&LeaderBookkeeping{propose, genericsmrproto.NilBallot, 0, 0, 0}})
 
-	r.recordInstanceMetadata(r.instanceSpace[instNo])
+	r.recordInstanceMetadata(r.instanceSpace.Get(instNo))
 	r.recordCommand(&propose.Command)
 	r.sync()
 
-	r.bcastAccept(instNo, r.instanceSpace[instNo].ballot, FALSE, 0, propose.Command)
+	r.bcastAccept(instNo, r.instanceSpace.Get(instNo).ballot, FALSE, 0, propose.Command)
 	dlog.Printf("Choosing req. %d in instance %d\n", propose.CommandId, instNo)
 }
 
 func (r *Replica) handleSkip(skip *menciusproto.Skip) {
-	r.instanceSpace[skip.StartInstance] = &Instance{true,
+	if r.instanceSpace.Forgotten(skip.StartInstance) {
+		return
+	}
+	r.instanceSpace.Set(skip.StartInstance, &Instance{true,
 		int(skip.EndInstance-skip.StartInstance)/r.N + 1,
 		nil,
-		0,
+		genericsmrproto.Ballot{},
 		COMMITTED,
-		nil}
+		nil})
 	r.updateBlocking(skip.StartInstance)
 }
 
 func (r *Replica) handlePrepare(prepare *menciusproto.Prepare) {
-	inst := r.instanceSpace[prepare.Instance]
+	if r.instanceSpace.Forgotten(prepare.Instance) {
+		return
+	}
+	inst := r.instanceSpace.Get(prepare.Instance)
 
 	if inst == nil {
 		dlog.Println("Replying OK to null-instance Prepare")
 		r.replyPrepare(prepare.LeaderId, &menciusproto.PrepareReply{prepare.Instance,
 			TRUE,
-			-1,
+			genericsmrproto.NilBallot,
 			FALSE,
 			0,
-			state.Command{0, 0, 0, 0}})
+			state.Command{0, 0, 0, "", "", "", nil, 0}})
 
-		r.instanceSpace[prepare.Instance] = &Instance{false,
+		r.instanceSpace.Set(prepare.Instance, &Instance{false,
 			0,
 			nil,
 			prepare.Ballot,
 			PREPARING,
-			nil}
+			nil})
 	} else {
 		ok := TRUE
-		if prepare.Ballot < inst.ballot {
+		if prepare.Ballot.LessThan(inst.ballot) {
 			ok = FALSE
 		}
 		if inst.command == nil {
-			inst.command = &state.Command{0, 0, 0, 0}
+			inst.command = &state.Command{0, 0, 0, "", "", "", nil, 0}
 		}
 		skipped := FALSE
 		if inst.skipped {
@@ -502,9 +539,12 @@ func (r *Replica) timerHelper(ds *DelayedSkip) {
 
 func (r *Replica) handleAccept(accept *menciusproto.Accept) {
 	flush := true
-	inst := r.instanceSpace[accept.Instance]
+	if r.instanceSpace.Forgotten(accept.Instance) {
+		return
+	}
+	inst := r.instanceSpace.Get(accept.Instance)
 
-	if inst != nil && inst.ballot > accept.Ballot {
+	if inst != nil && inst.ballot.GreaterThan(accept.Ballot) {
 		r.replyAccept(accept.LeaderId, &menciusproto.AcceptReply{accept.Instance, FALSE, inst.ballot, -1, -1})
 		return
 	}
@@ -524,14 +564,14 @@ func (r *Replica) handleAccept(accept *menciusproto.Accept) {
 			r.skipsWaiting++
 			flush = false
 		}
-		r.instanceSpace[r.crtInstance] = &Instance{true,
+		r.instanceSpace.Set(r.crtInstance, &Instance{true,
 			int(skipEnd-r.crtInstance)/r.N + 1,
 			nil,
-			-1,
+			genericsmrproto.NilBallot,
 			COMMITTED,
-			nil}
+			nil})
 
-		r.recordInstanceMetadata(r.instanceSpace[r.crtInstance])
+		r.recordInstanceMetadata(r.instanceSpace.Get(r.crtInstance))
 		r.sync()
 
 		r.crtInstance = skipEnd + int32(r.N)
@@ -541,17 +581,17 @@ func (r *Replica) handleAccept(accept *menciusproto.Accept) {
 		if accept.Skip == TRUE {
 			skip = true
 		}
-		r.instanceSpace[accept.Instance] = &Instance{skip,
+		r.instanceSpace.Set(accept.Instance, &Instance{skip,
 			int(accept.NbInstancesToSkip),
 			&accept.Command,
 			accept.Ballot,
 			ACCEPTED,
-			nil}
-		r.recordInstanceMetadata(r.instanceSpace[accept.Instance])
+			nil})
+		r.recordInstanceMetadata(r.instanceSpace.Get(accept.Instance))
 		r.recordCommand(&accept.Command)
 		r.sync()
 
-		r.replyAccept(accept.LeaderId, &menciusproto.AcceptReply{accept.Instance, TRUE, -1, skipStart, skipEnd})
+		r.replyAccept(accept.LeaderId, &menciusproto.AcceptReply{accept.Instance, TRUE, genericsmrproto.NilBallot, skipStart, skipEnd})
 	} else {
 		if inst.status == COMMITTED || inst.status == EXECUTED {
 			if inst.command == nil {
@@ -599,7 +639,10 @@ func (r *Replica) handleDelayedSkip(delayedSkip *DelayedSkip) {
 }
 
 func (r *Replica) handleCommit(commit *menciusproto.Commit) {
-	inst := r.instanceSpace[commit.Instance]
+	if r.instanceSpace.Forgotten(commit.Instance) {
+		return
+	}
+	inst := r.instanceSpace.Get(commit.Instance)
 
 	dlog.Printf("Committing instance %d\n", commit.Instance)
 
@@ -608,12 +651,12 @@ func (r *Replica) handleCommit(commit *menciusproto.Commit) {
 		if commit.Skip == TRUE {
 			skip = true
 		}
-		r.instanceSpace[commit.Instance] = &Instance{skip,
+		r.instanceSpace.Set(commit.Instance, &Instance{skip,
 			int(commit.NbInstancesToSkip),
 			nil, //&commit.Command,
-			0,
+			genericsmrproto.Ballot{},
 			COMMITTED,
-			nil}
+			nil})
 	} else {
 		//inst.command = &commit.Command
 		inst.status = COMMITTED
@@ -629,7 +672,7 @@ func (r *Replica) handleCommit(commit *menciusproto.Commit) {
 		}
 	}
 
-	r.recordInstanceMetadata(r.instanceSpace[commit.Instance])
+	r.recordInstanceMetadata(r.instanceSpace.Get(commit.Instance))
 
 	if commit.Instance%int32(r.N) == r.Id%int32(r.N) {
 		if r.crtInstance < commit.Instance+commit.NbInstancesToSkip*int32(r.N) {
@@ -644,7 +687,10 @@ func (r *Replica) handleCommit(commit *menciusproto.Commit) {
 func (r *Replica) handlePrepareReply(preply *menciusproto.PrepareReply) {
 	dlog.Printf("PrepareReply for instance %d\n", preply.Instance)
 
-	inst := r.instanceSpace[preply.Instance]
+	if r.instanceSpace.Forgotten(preply.Instance) {
+		return
+	}
+	inst := r.instanceSpace.Get(preply.Instance)
 
 	if inst.status != PREPARING {
 		// we've moved on -- these are delayed replies, so just ignore
@@ -654,7 +700,7 @@ func (r *Replica) handlePrepareReply(preply *menciusproto.PrepareReply) {
 	if preply.OK == TRUE {
 		inst.lb.prepareOKs++
 
-		if preply.Ballot > inst.lb.maxRecvBallot {
+		if preply.Ballot.GreaterThan(inst.lb.maxRecvBallot) {
 			inst.command = &preply.Command
 			inst.skipped = false
 			if preply.Skip == TRUE {
@@ -676,7 +722,7 @@ func (r *Replica) handlePrepareReply(preply *menciusproto.PrepareReply) {
 	} else {
 		// TODO: there is probably another active leader
 		inst.lb.nacks++
-		if preply.Ballot > inst.lb.maxRecvBallot {
+		if preply.Ballot.GreaterThan(inst.lb.maxRecvBallot) {
 			inst.lb.maxRecvBallot = preply.Ballot
 		}
 		if inst.lb.nacks >= r.N>>1 && inst.lb != nil {
@@ -692,17 +738,20 @@ func (r *Replica) handlePrepareReply(preply *menciusproto.PrepareReply) {
 func (r *Replica) handleAcceptReply(areply *menciusproto.AcceptReply) {
 	dlog.Printf("AcceptReply for instance %d\n", areply.Instance)
 
-	inst := r.instanceSpace[areply.Instance]
+	if r.instanceSpace.Forgotten(areply.Instance) {
+		return
+	}
+	inst := r.instanceSpace.Get(areply.Instance)
 
 	if areply.OK == TRUE {
 		inst.lb.acceptOKs++
 		if areply.SkippedStartInstance > -1 {
-			r.instanceSpace[areply.SkippedStartInstance] = &Instance{true,
+			r.instanceSpace.Set(areply.SkippedStartInstance, &Instance{true,
 				int(areply.SkippedEndInstance-areply.SkippedStartInstance)/r.N + 1,
 				nil,
-				0,
+				genericsmrproto.Ballot{},
 				COMMITTED,
-				nil}
+				nil})
 			r.updateBlocking(areply.SkippedStartInstance)
 		}
 
@@ -724,10 +773,10 @@ func (r *Replica) handleAcceptReply(areply *menciusproto.AcceptReply) {
 	} else {
 		// TODO: there is probably another active leader
 		inst.lb.nacks++
-		if areply.Ballot > inst.lb.maxRecvBallot {
+		if areply.Ballot.GreaterThan(inst.lb.maxRecvBallot) {
 			inst.lb.maxRecvBallot = areply.Ballot
 		}
-		if (areply.Ballot&0x0F)%int32(r.N) == areply.Instance%int32(r.N) {
+		if areply.Ballot.ReplicaId%int32(r.N) == areply.Instance%int32(r.N) {
 			// the owner of the instance is trying to commit something, I should give up
 		}
 		if inst.lb.nacks >= r.N>>1 {
@@ -750,10 +799,10 @@ func (r *Replica) updateBlocking(instance int32) {
 		if r.blockingInstance <= r.skippedTo[int(r.blockingInstance)%r.N] {
 			continue
 		}
-		if r.instanceSpace[r.blockingInstance] == nil {
+		if r.instanceSpace.Get(r.blockingInstance) == nil {
 			return
 		}
-		inst := r.instanceSpace[r.blockingInstance]
+		inst := r.instanceSpace.Get(r.blockingInstance)
 		if inst.status == COMMITTED && inst.skipped {
 			r.skippedTo[int(r.blockingInstance)%r.N] = r.blockingInstance + int32((inst.nbInstSkipped-1)*r.N)
 			continue
@@ -770,7 +819,7 @@ func (r *Replica) updateBlocking(instance int32) {
 				if inst.lb.clientProposal != nil && !r.Dreply {
 					// give client the all clear
 					dlog.Printf("Sending ACK for req. %d\n", inst.lb.clientProposal.CommandId)
-					r.ReplyProposeTS(&genericsmrproto.ProposeReplyTS{TRUE, inst.lb.clientProposal.CommandId, state.NIL, inst.lb.clientProposal.Timestamp},
+					r.ReplyProposeTS(&genericsmrproto.ProposeReplyTS{TRUE, inst.lb.clientProposal.CommandId, state.NIL, inst.lb.clientProposal.Timestamp, nil},
 						inst.lb.clientProposal.Reply)
 				}
 				skip := FALSE
@@ -797,7 +846,6 @@ func (r *Replica) updateBlocking(instance int32) {
 }
 
 func (r *Replica) executeCommands() {
-	execedUpTo := int32(-1)
 	skippedTo := make([]int32, r.N)
 	skippedToOrig := make([]int32, r.N)
 	conflicts := make(map[state.Key]int32, 60000)
@@ -810,26 +858,29 @@ func (r *Replica) executeCommands() {
 		executed := false
 		jump := false
 		copy(skippedTo, skippedToOrig)
-		for i := execedUpTo + 1; i < r.crtInstance; i++ {
+		for i := r.execedUpTo + 1; i < r.crtInstance; i++ {
 			if i < skippedTo[i%int32(r.N)] {
 				continue
 			}
 
-			if r.instanceSpace[i] == nil {
+			if r.instanceSpace.Get(i) == nil {
 				break
 			}
 
-			if r.instanceSpace[i].status == EXECUTED {
+			if r.instanceSpace.Get(i).status == EXECUTED {
 				continue
 			}
 
-			if r.instanceSpace[i].status != COMMITTED {
-				if !r.instanceSpace[i].skipped {
-					confInst, present := conflicts[r.instanceSpace[i].command.K]
-					if present && r.instanceSpace[confInst].status != EXECUTED {
+			if r.instanceSpace.Get(i).status != COMMITTED {
+				if !r.instanceSpace.Get(i).skipped {
+					cmd := r.instanceSpace.Get(i).command
+					//the range of a SCAN is not indexed, so nothing can jump over it
+					if cmd.Op == state.SCAN || r.conflictPending(conflicts, cmd, i, false) {
 						break
 					}
-					conflicts[r.instanceSpace[i].command.K] = i
+					for j := 0; j < cmd.KeyCount(); j++ {
+						conflicts[cmd.KeyAt(j)] = i
+					}
 					jump = true
 					continue
 				} else {
@@ -837,20 +888,19 @@ func (r *Replica) executeCommands() {
 				}
 			}
 
-			if r.instanceSpace[i].skipped {
-				skippedTo[i%int32(r.N)] = i + int32(r.instanceSpace[i].nbInstSkipped*r.N)
+			if r.instanceSpace.Get(i).skipped {
+				skippedTo[i%int32(r.N)] = i + int32(r.instanceSpace.Get(i).nbInstSkipped*r.N)
 				if !jump {
 					skippedToOrig[i%int32(r.N)] = skippedTo[i%int32(r.N)]
 				}
 				continue
 			}
 
-			inst := r.instanceSpace[i]
+			inst := r.instanceSpace.Get(i)
 			for inst.command == nil {
 				time.Sleep(1000 * 1000)
 			}
-			confInst, present := conflicts[inst.command.K]
-			if present && confInst < i && r.instanceSpace[confInst].status != EXECUTED && state.Conflict(r.instanceSpace[confInst].command, inst.command) {
+			if r.conflictPending(conflicts, inst.command, i, true) {
 				break
 			}
 
@@ -858,7 +908,7 @@ func (r *Replica) executeCommands() {
 
 			if r.Dreply && inst.lb != nil && inst.lb.clientProposal != nil {
 				dlog.Printf("Sending ACK for req. %d\n", inst.lb.clientProposal.CommandId)
-				r.ReplyProposeTS(&genericsmrproto.ProposeReplyTS{TRUE, inst.lb.clientProposal.CommandId, state.NIL, inst.lb.clientProposal.Timestamp},
+				r.ReplyProposeTS(&genericsmrproto.ProposeReplyTS{TRUE, inst.lb.clientProposal.CommandId, state.NIL, inst.lb.clientProposal.Timestamp, nil},
 					inst.lb.clientProposal.Reply)
 			}
 			inst.status = EXECUTED
@@ -866,15 +916,44 @@ func (r *Replica) executeCommands() {
 			executed = true
 
 			if !jump {
-				execedUpTo = i
+				r.execedUpTo = i
 			}
 		}
+		r.PingStatus.PublishExecedUpTo(r.execedUpTo)
 		if !executed {
 			time.Sleep(1000 * 1000)
 		}
 	}
 }
 
+// Tells whether an instance that has been jumped over and not executed yet
+// touches one of the keys of cmd, or a key in its range for a SCAN (and
+// conflicts with it, if checkOp).
+func (r *Replica) conflictPending(conflicts map[state.Key]int32, cmd *state.Command, i int32, checkOp bool) bool {
+	if cmd.Op == state.SCAN {
+		lo, hi := cmd.ScanRange()
+		for k, confInst := range conflicts {
+			if k < lo || k >= hi || r.isExecuted(confInst) {
+				continue
+			}
+			if !checkOp || (confInst < i && r.State.Conflict(r.instanceSpace.Get(confInst).command, cmd)) {
+				return true
+			}
+		}
+		return false
+	}
+	for j := 0; j < cmd.KeyCount(); j++ {
+		confInst, present := conflicts[cmd.KeyAt(j)]
+		if !present || r.isExecuted(confInst) {
+			continue
+		}
+		if !checkOp || (confInst < i && r.State.Conflict(r.instanceSpace.Get(confInst).command, cmd)) {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Replica) forceCommit() {
 	//find what is the oldest un-initialized instance and try to take over
 	problemInstance := r.blockingInstance
@@ -882,14 +961,14 @@ func (r *Replica) forceCommit() {
 	//try to take over the problem instance
 	if int(problemInstance)%r.N == int(r.Id+1)%r.N {
 		log.Println("Replica", r.Id, "Trying to take over instance", problemInstance)
-		if r.instanceSpace[problemInstance] == nil {
-			r.instanceSpace[problemInstance] = &Instance{true,
+		if r.instanceSpace.Get(problemInstance) == nil {
+			r.instanceSpace.Set(problemInstance, &Instance{true,
 				NB_INST_TO_SKIP,
-				&state.Command{0, 0, 0, 0},
+				&state.Command{0, 0, 0, "", "", "", nil, 0},
 				r.makeUniqueBallot(1),
 				PREPARING,
-				&LeaderBookkeeping{nil, 0, 0, 0, 0}}
-			r.bcastPrepare(problemInstance, r.instanceSpace[problemInstance].ballot)
+				&LeaderBookkeeping{nil, genericsmrproto.NilBallot, 0, 0, 0}})
+			r.bcastPrepare(problemInstance, r.instanceSpace.Get(problemInstance).ballot)
 		} else {
 			log.Println("Not nil")
 		}
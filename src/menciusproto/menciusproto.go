@@ -1,6 +1,7 @@
 package menciusproto
 
 import (
+	"genericsmrproto"
 	"state"
 )
 
@@ -13,13 +14,13 @@ type Skip struct {
 type Prepare struct {
 	LeaderId int32
 	Instance int32
-	Ballot   int32
+	Ballot   genericsmrproto.Ballot
 }
 
 type PrepareReply struct {
 	Instance          int32
 	OK                uint8
-	Ballot            int32
+	Ballot            genericsmrproto.Ballot
 	Skip              uint8
 	NbInstancesToSkip int32
 	Command           state.Command
@@ -28,7 +29,7 @@ type PrepareReply struct {
 type Accept struct {
 	LeaderId          int32
 	Instance          int32
-	Ballot            int32
+	Ballot            genericsmrproto.Ballot
 	Skip              uint8
 	NbInstancesToSkip int32
 	Command           state.Command
@@ -37,7 +38,7 @@ type Accept struct {
 type AcceptReply struct {
 	Instance             int32
 	OK                   uint8
-	Ballot               int32
+	Ballot               genericsmrproto.Ballot
 	SkippedStartInstance int32
 	SkippedEndInstance   int32
 }
@@ -49,3 +50,10 @@ type Commit struct {
 	NbInstancesToSkip int32
 	//Command state.Command
 }
+
+// periodically sent to every peer, so that instances that have been
+// executed everywhere can be garbage collected
+type ExecStatus struct {
+	ReplicaId  int32
+	ExecedUpTo int32
+}
@@ -81,7 +81,7 @@ func (t *Prepare) New() fastrpc.Serializable {
 	return new(Prepare)
 }
 func (t *Prepare) BinarySize() (nbytes int, sizeKnown bool) {
-	return 12, true
+	return 0, false
 }
 
 type PrepareCache struct {
@@ -114,9 +114,9 @@ func (p *PrepareCache) Put(t *Prepare) {
 	p.mu.Unlock()
 }
 func (t *Prepare) Marshal(wire io.Writer) {
-	var b [12]byte
+	var b [8]byte
 	var bs []byte
-	bs = b[:12]
+	bs = b[:8]
 	tmp32 := t.LeaderId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -127,24 +127,22 @@ func (t *Prepare) Marshal(wire io.Writer) {
 	bs[5] = byte(tmp32 >> 8)
 	bs[6] = byte(tmp32 >> 16)
 	bs[7] = byte(tmp32 >> 24)
-	tmp32 = t.Ballot
-	bs[8] = byte(tmp32)
-	bs[9] = byte(tmp32 >> 8)
-	bs[10] = byte(tmp32 >> 16)
-	bs[11] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	t.Ballot.Marshal(wire)
 }
 
 func (t *Prepare) Unmarshal(wire io.Reader) error {
-	var b [12]byte
+	var b [8]byte
 	var bs []byte
-	bs = b[:12]
-	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
+	bs = b[:8]
+	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
 		return err
 	}
 	t.LeaderId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Instance = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
-	t.Ballot = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -185,43 +183,49 @@ func (p *PrepareReplyCache) Put(t *PrepareReply) {
 	p.mu.Unlock()
 }
 func (t *PrepareReply) Marshal(wire io.Writer) {
-	var b [14]byte
+	var b [5]byte
 	var bs []byte
-	bs = b[:14]
+	bs = b[:5]
 	tmp32 := t.Instance
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
 	bs[2] = byte(tmp32 >> 16)
 	bs[3] = byte(tmp32 >> 24)
 	bs[4] = byte(t.OK)
-	tmp32 = t.Ballot
-	bs[5] = byte(tmp32)
-	bs[6] = byte(tmp32 >> 8)
-	bs[7] = byte(tmp32 >> 16)
-	bs[8] = byte(tmp32 >> 24)
-	bs[9] = byte(t.Skip)
+	wire.Write(bs)
+	t.Ballot.Marshal(wire)
+	bs = b[:5]
+	bs[0] = byte(t.Skip)
 	tmp32 = t.NbInstancesToSkip
-	bs[10] = byte(tmp32)
-	bs[11] = byte(tmp32 >> 8)
-	bs[12] = byte(tmp32 >> 16)
-	bs[13] = byte(tmp32 >> 24)
+	bs[1] = byte(tmp32)
+	bs[2] = byte(tmp32 >> 8)
+	bs[3] = byte(tmp32 >> 16)
+	bs[4] = byte(tmp32 >> 24)
 	wire.Write(bs)
 	t.Command.Marshal(wire)
 }
 
 func (t *PrepareReply) Unmarshal(wire io.Reader) error {
-	var b [14]byte
+	var b [5]byte
 	var bs []byte
-	bs = b[:14]
-	if _, err := io.ReadAtLeast(wire, bs, 14); err != nil {
+	bs = b[:5]
+	if _, err := io.ReadAtLeast(wire, bs, 5); err != nil {
 		return err
 	}
 	t.Instance = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.OK = uint8(bs[4])
-	t.Ballot = int32((uint32(bs[5]) | (uint32(bs[6]) << 8) | (uint32(bs[7]) << 16) | (uint32(bs[8]) << 24)))
-	t.Skip = uint8(bs[9])
-	t.NbInstancesToSkip = int32((uint32(bs[10]) | (uint32(bs[11]) << 8) | (uint32(bs[12]) << 16) | (uint32(bs[13]) << 24)))
-	t.Command.Unmarshal(wire)
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
+	bs = b[:5]
+	if _, err := io.ReadAtLeast(wire, bs, 5); err != nil {
+		return err
+	}
+	t.Skip = uint8(bs[0])
+	t.NbInstancesToSkip = int32((uint32(bs[1]) | (uint32(bs[2]) << 8) | (uint32(bs[3]) << 16) | (uint32(bs[4]) << 24)))
+	if err := t.Command.Unmarshal(wire); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -262,9 +266,9 @@ func (p *AcceptCache) Put(t *Accept) {
 	p.mu.Unlock()
 }
 func (t *Accept) Marshal(wire io.Writer) {
-	var b [17]byte
+	var b [8]byte
 	var bs []byte
-	bs = b[:17]
+	bs = b[:8]
 	tmp32 := t.LeaderId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -275,34 +279,40 @@ func (t *Accept) Marshal(wire io.Writer) {
 	bs[5] = byte(tmp32 >> 8)
 	bs[6] = byte(tmp32 >> 16)
 	bs[7] = byte(tmp32 >> 24)
-	tmp32 = t.Ballot
-	bs[8] = byte(tmp32)
-	bs[9] = byte(tmp32 >> 8)
-	bs[10] = byte(tmp32 >> 16)
-	bs[11] = byte(tmp32 >> 24)
-	bs[12] = byte(t.Skip)
+	wire.Write(bs)
+	t.Ballot.Marshal(wire)
+	bs = b[:5]
+	bs[0] = byte(t.Skip)
 	tmp32 = t.NbInstancesToSkip
-	bs[13] = byte(tmp32)
-	bs[14] = byte(tmp32 >> 8)
-	bs[15] = byte(tmp32 >> 16)
-	bs[16] = byte(tmp32 >> 24)
+	bs[1] = byte(tmp32)
+	bs[2] = byte(tmp32 >> 8)
+	bs[3] = byte(tmp32 >> 16)
+	bs[4] = byte(tmp32 >> 24)
 	wire.Write(bs)
 	t.Command.Marshal(wire)
 }
 
 func (t *Accept) Unmarshal(wire io.Reader) error {
-	var b [17]byte
+	var b [8]byte
 	var bs []byte
-	bs = b[:17]
-	if _, err := io.ReadAtLeast(wire, bs, 17); err != nil {
+	bs = b[:8]
+	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
 		return err
 	}
 	t.LeaderId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Instance = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
-	t.Ballot = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
-	t.Skip = uint8(bs[12])
-	t.NbInstancesToSkip = int32((uint32(bs[13]) | (uint32(bs[14]) << 8) | (uint32(bs[15]) << 16) | (uint32(bs[16]) << 24)))
-	t.Command.Unmarshal(wire)
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
+	bs = b[:5]
+	if _, err := io.ReadAtLeast(wire, bs, 5); err != nil {
+		return err
+	}
+	t.Skip = uint8(bs[0])
+	t.NbInstancesToSkip = int32((uint32(bs[1]) | (uint32(bs[2]) << 8) | (uint32(bs[3]) << 16) | (uint32(bs[4]) << 24)))
+	if err := t.Command.Unmarshal(wire); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -310,7 +320,7 @@ func (t *AcceptReply) New() fastrpc.Serializable {
 	return new(AcceptReply)
 }
 func (t *AcceptReply) BinarySize() (nbytes int, sizeKnown bool) {
-	return 17, true
+	return 0, false
 }
 
 type AcceptReplyCache struct {
@@ -343,45 +353,49 @@ func (p *AcceptReplyCache) Put(t *AcceptReply) {
 	p.mu.Unlock()
 }
 func (t *AcceptReply) Marshal(wire io.Writer) {
-	var b [17]byte
+	var b [8]byte
 	var bs []byte
-	bs = b[:17]
+	bs = b[:5]
 	tmp32 := t.Instance
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
 	bs[2] = byte(tmp32 >> 16)
 	bs[3] = byte(tmp32 >> 24)
 	bs[4] = byte(t.OK)
-	tmp32 = t.Ballot
-	bs[5] = byte(tmp32)
-	bs[6] = byte(tmp32 >> 8)
-	bs[7] = byte(tmp32 >> 16)
-	bs[8] = byte(tmp32 >> 24)
+	wire.Write(bs)
+	t.Ballot.Marshal(wire)
+	bs = b[:8]
 	tmp32 = t.SkippedStartInstance
-	bs[9] = byte(tmp32)
-	bs[10] = byte(tmp32 >> 8)
-	bs[11] = byte(tmp32 >> 16)
-	bs[12] = byte(tmp32 >> 24)
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
 	tmp32 = t.SkippedEndInstance
-	bs[13] = byte(tmp32)
-	bs[14] = byte(tmp32 >> 8)
-	bs[15] = byte(tmp32 >> 16)
-	bs[16] = byte(tmp32 >> 24)
+	bs[4] = byte(tmp32)
+	bs[5] = byte(tmp32 >> 8)
+	bs[6] = byte(tmp32 >> 16)
+	bs[7] = byte(tmp32 >> 24)
 	wire.Write(bs)
 }
 
 func (t *AcceptReply) Unmarshal(wire io.Reader) error {
-	var b [17]byte
+	var b [8]byte
 	var bs []byte
-	bs = b[:17]
-	if _, err := io.ReadAtLeast(wire, bs, 17); err != nil {
+	bs = b[:5]
+	if _, err := io.ReadAtLeast(wire, bs, 5); err != nil {
 		return err
 	}
 	t.Instance = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.OK = uint8(bs[4])
-	t.Ballot = int32((uint32(bs[5]) | (uint32(bs[6]) << 8) | (uint32(bs[7]) << 16) | (uint32(bs[8]) << 24)))
-	t.SkippedStartInstance = int32((uint32(bs[9]) | (uint32(bs[10]) << 8) | (uint32(bs[11]) << 16) | (uint32(bs[12]) << 24)))
-	t.SkippedEndInstance = int32((uint32(bs[13]) | (uint32(bs[14]) << 8) | (uint32(bs[15]) << 16) | (uint32(bs[16]) << 24)))
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
+	bs = b[:8]
+	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
+		return err
+	}
+	t.SkippedStartInstance = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.SkippedEndInstance = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
 	return nil
 }
 
@@ -457,3 +471,71 @@ func (t *Commit) Unmarshal(wire io.Reader) error {
 	t.NbInstancesToSkip = int32((uint32(bs[9]) | (uint32(bs[10]) << 8) | (uint32(bs[11]) << 16) | (uint32(bs[12]) << 24)))
 	return nil
 }
+
+func (t *ExecStatus) BinarySize() (nbytes int, sizeKnown bool) {
+	return 8, true
+}
+
+type ExecStatusCache struct {
+	mu    sync.Mutex
+	cache []*ExecStatus
+}
+
+func NewExecStatusCache() *ExecStatusCache {
+	c := &ExecStatusCache{}
+	c.cache = make([]*ExecStatus, 0)
+	return c
+}
+
+func (p *ExecStatusCache) Get() *ExecStatus {
+	var t *ExecStatus
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &ExecStatus{}
+	}
+	return t
+}
+
+func (p *ExecStatusCache) Put(t *ExecStatus) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+
+func (p *ExecStatus) New() fastrpc.Serializable {
+	return new(ExecStatus)
+}
+
+func (t *ExecStatus) Marshal(wire io.Writer) {
+	var b [8]byte
+	var bs []byte
+	bs = b[:8]
+	tmp32 := t.ReplicaId
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	tmp32 = t.ExecedUpTo
+	bs[4] = byte(tmp32)
+	bs[5] = byte(tmp32 >> 8)
+	bs[6] = byte(tmp32 >> 16)
+	bs[7] = byte(tmp32 >> 24)
+	wire.Write(bs)
+}
+
+func (t *ExecStatus) Unmarshal(wire io.Reader) error {
+	var b [8]byte
+	var bs []byte
+	bs = b[:8]
+	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
+		return err
+	}
+	t.ReplicaId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.ExecedUpTo = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	return nil
+}
@@ -0,0 +1,267 @@
+package paxos
+
+import (
+	"dlog"
+	"genericsmr"
+	"genericsmrproto"
+	"log"
+	"paxosproto"
+	"state"
+	"time"
+)
+
+/**********************************************************************
+
+                           LEADER ELECTION
+
+***********************************************************************/
+
+// A replica runs for leader by preparing every instance from the first one it
+// has not committed on, with a ballot higher than any it has seen (Phase 1 for
+// all those instances at once). Acceptors that promise the ballot answer with
+// the instances from there on that they have accepted a value for. Once a
+// quorum has promised, the replica is the leader: it proposes again, with its
+// ballot, the value of the highest ballot for each of these instances, and a
+// no-op for the ones with none, before it takes new proposals. Its ballot is
+// then the default ballot of every instance it starts (Phase 2 only).
+//
+// A replica that learns of a higher ballot, in a Prepare, an Accept or a NACK,
+// follows the replica that chose it: a leader steps down, and a candidate
+// gives up.
+//
+// Replica 0 runs for leader when the cluster starts. With the failure detector
+// on (see genericsmr.FailureDetectorConfig), a member that suspects the leader
+// runs for leader after rank * SuspectTimeout, where rank is the number of
+// members with a lower id that it does not suspect, so that they do not all
+// run at once. The master can also make a replica run, with BeTheLeader, and
+// only finds out who the leader is from its pings.
+
+type election struct {
+	ballot    genericsmrproto.Ballot
+	from      int32 // the first instance prepared
+	started   int64
+	promised  []bool // by acceptor
+	promises  int
+	instances map[int32]*paxosproto.AcceptedInstance // the value to propose again, for each instance
+}
+
+func (r *Replica) startElection() {
+	if r.joining || !r.Config.IsMember(r.Id) {
+		return
+	}
+	if r.leaseGranted(r.Id) {
+		dlog.Printf("Not running for leader until the lease we have granted to replica %d expires\n", r.grantedTo)
+		return
+	}
+	if r.IsLeader {
+		r.stepDown()
+	}
+
+	ballot := r.defaultBallot.NextFor(r.Id)
+	if r.defaultBallot == genericsmrproto.NilBallot {
+		ballot = genericsmrproto.InitialBallot(r.Id)
+	}
+	r.defaultBallot = ballot
+	r.leaderId = -1
+	e := &election{ballot, r.committedUpTo + 1, time.Now().UnixNano(),
+		make([]bool, r.N), 0, make(map[int32]*paxosproto.AcceptedInstance)}
+	r.election = e
+	e.add(r.acceptedInstances(e.from))
+
+	log.Printf("Running for leader with ballot %v, from instance %d\n", ballot, e.from)
+	r.bcastPrepare(e.from, ballot, true)
+	r.checkElected()
+}
+
+// the instances from the given one on that we have accepted a value for
+func (r *Replica) acceptedInstances(from int32) []paxosproto.AcceptedInstance {
+	accepted := make([]paxosproto.AcceptedInstance, 0)
+	for i := from; i < r.crtInstance; i++ {
+		inst := r.instanceSpace.Get(i)
+		if inst == nil || inst.cmds == nil || inst.status == PREPARING {
+			continue
+		}
+		committed := FALSE
+		if inst.status == COMMITTED {
+			committed = TRUE
+		}
+		accepted = append(accepted, paxosproto.AcceptedInstance{i, committed, inst.ballot, inst.cmds})
+	}
+	return accepted
+}
+
+// keeps, for every instance, a committed value, or else the one with the
+// highest ballot
+func (e *election) add(instances []paxosproto.AcceptedInstance) {
+	for i := range instances {
+		ai := &instances[i]
+		if ai.Instance < e.from {
+			continue
+		}
+		cur := e.instances[ai.Instance]
+		if cur == nil || (cur.Committed == FALSE && (ai.Committed == TRUE || ai.Ballot.GreaterThan(cur.Ballot))) {
+			e.instances[ai.Instance] = ai
+		}
+	}
+}
+
+func (r *Replica) handlePrepareReply(preply *paxosproto.PrepareReply) {
+	e := r.election
+	if e == nil {
+		// we have been elected, or given up
+		return
+	}
+
+	if preply.OK == FALSE {
+		if preply.Ballot.GreaterThan(e.ballot) {
+			log.Printf("Replica %d has promised ballot %v, giving up on ballot %v\n", preply.AcceptorId, preply.Ballot, e.ballot)
+			r.follow(preply.Ballot)
+		}
+		return
+	}
+
+	q := preply.AcceptorId
+	if preply.Ballot != e.ballot || q < 0 || int(q) >= len(e.promised) || e.promised[q] || !r.Config.IsMember(q) {
+		return
+	}
+	e.promised[q] = true
+	e.promises++
+	e.add(preply.Instances)
+	r.checkElected()
+}
+
+func (r *Replica) checkElected() {
+	if e := r.election; e != nil && e.promises+1 >= r.Config.Quorum() {
+		r.becomeLeader()
+	}
+}
+
+func (r *Replica) becomeLeader() {
+	e := r.election
+	r.election = nil
+	r.IsLeader = true
+	r.leaderId = r.Id
+	r.leaderlessSince = 0
+
+	last := e.from - 1
+	for i := range e.instances {
+		if i > last {
+			last = i
+		}
+	}
+	if r.crtInstance <= last {
+		r.crtInstance = last + 1
+	}
+	r.recoveredUpTo = last
+	log.Printf("Elected leader with ballot %v, proposing instances %d to %d again\n", e.ballot, e.from, last)
+
+	for i := e.from; i <= last; i++ {
+		if r.instanceSpace.Forgotten(i) {
+			continue
+		}
+		inst := r.instanceSpace.Get(i)
+		if inst != nil && inst.status == COMMITTED && inst.cmds != nil {
+			// the others may not know
+			r.bcastCommit(i, inst.ballot, inst.cmds)
+			continue
+		}
+
+		// a no-op fills the instances that no acceptor in the quorum has a value for
+		cmds := []state.Command{}
+		if ai := e.instances[i]; ai != nil {
+			cmds = ai.Command
+		}
+		var proposals []*genericsmr.Propose
+		if inst != nil && inst.lb != nil && inst.lb.clientProposals != nil {
+			if sameCommands(inst.cmds, cmds) {
+				proposals = inst.lb.clientProposals
+			} else {
+				// try them in another instance
+				for j := range inst.lb.clientProposals {
+					r.ProposeChan <- inst.lb.clientProposals[j]
+				}
+			}
+		}
+
+		r.lastAcceptSent = time.Now().UnixNano()
+		r.instanceSpace.Set(i, &Instance{cmds, e.ballot, PREPARED, &LeaderBookkeeping{proposals, 0, r.lastAcceptSent}})
+		r.recordInstanceMetadata(r.instanceSpace.Get(i))
+		r.recordCommands(cmds)
+		r.sync()
+		r.bcastAccept(i, e.ballot, cmds)
+	}
+	r.updateCommittedUpTo()
+}
+
+func sameCommands(a []state.Command, b []state.Command) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ClientId != b[i].ClientId || a[i].CommandId != b[i].CommandId || a[i].Op != b[i].Op || a[i].K != b[i].K {
+			return false
+		}
+	}
+	return true
+}
+
+// Called when we learn of a ballot higher than ours, or of the leader that
+// uses it: the replica that chose it is the leader, or runs for leader.
+func (r *Replica) follow(ballot genericsmrproto.Ballot) {
+	if ballot.GreaterThan(r.defaultBallot) {
+		r.defaultBallot = ballot
+	}
+	if r.IsLeader {
+		log.Printf("Replica %d has a later ballot, stepping down\n", ballot.ReplicaId)
+		r.stepDown()
+	}
+	r.election = nil
+	r.leaderId = ballot.ReplicaId
+	r.leaderlessSince = 0
+}
+
+func (r *Replica) stepDown() {
+	r.IsLeader = false
+	r.recoveredUpTo = -1
+	r.leaseUntil = 0
+	if r.reconfig != nil {
+		r.reconfig.done <- errNotLeader
+		r.reconfig = nil
+	}
+}
+
+// Called on every tick of the failure detector.
+func (r *Replica) checkLeader() {
+	if r.IsLeader || r.joining || !r.Config.IsMember(r.Id) {
+		r.leaderlessSince = 0
+		return
+	}
+	now := time.Now().UnixNano()
+	timeout := int64(r.Detector.SuspectTimeout)
+
+	if r.election != nil {
+		if now-r.election.started > timeout {
+			log.Printf("Not elected with ballot %v after %v, running again\n", r.election.ballot, r.Detector.SuspectTimeout)
+			r.startElection()
+		}
+		return
+	}
+
+	l := r.leaderId
+	if l >= 0 && l != r.Id && r.Config.IsMember(l) && r.Alive[l] {
+		r.leaderlessSince = 0
+		return
+	}
+	if r.leaderlessSince == 0 {
+		r.leaderlessSince = now
+	}
+	rank := 0
+	for q := int32(0); q < r.Id; q++ {
+		if r.Config.IsMember(q) && r.Alive[q] {
+			rank++
+		}
+	}
+	if now-r.leaderlessSince >= int64(rank)*timeout {
+		r.startElection()
+	}
+}
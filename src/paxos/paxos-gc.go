@@ -0,0 +1,63 @@
+package paxos
+
+import (
+	"dlog"
+	"genericsmr"
+	"log"
+	"paxosproto"
+)
+
+// the instance space (see genericsmr.InstanceWindow)
+type instanceWindow = genericsmr.InstanceWindow[Instance]
+
+func newInstanceWindow() *instanceWindow {
+	return genericsmr.NewInstanceWindow[Instance]()
+}
+
+/* Garbage collection of executed instances */
+
+// replicas that do not execute commands only need to have committed them
+func (r *Replica) progress() int32 {
+	if !r.Exec {
+		return r.committedUpTo
+	}
+	return r.execedUpTo
+}
+
+func (r *Replica) bcastExecStatus() {
+	defer func() {
+		if err := recover(); err != nil {
+			dlog.Println("ExecStatus bcast failed:", err)
+		}
+	}()
+	args := &paxosproto.ExecStatus{r.Id, r.progress()}
+
+	for q := int32(0); q < int32(r.N); q++ {
+		if q == r.Id || !r.Alive[q] {
+			continue
+		}
+		r.SendMsg(q, r.execStatusRPC, args)
+	}
+}
+
+func (r *Replica) handleExecStatus(es *paxosproto.ExecStatus) {
+	if es.ReplicaId < 0 || int(es.ReplicaId) >= r.N {
+		return
+	}
+	r.ReportProgress(es.ReplicaId, []int32{es.ExecedUpTo})
+	r.checkLag(es)
+
+	// a member that has been left out of garbage collection, because it was
+	// down for too long, can only catch up with a snapshot
+	if r.instanceSpace.Forgotten(es.ExecedUpTo+1) && r.SnapshotDue(es.ReplicaId) {
+		log.Printf("Replica %d needs instances that have been forgotten, sending it a snapshot\n", es.ReplicaId)
+		r.handleSnapshotRequest(&paxosproto.SnapshotRequest{es.ReplicaId})
+	}
+}
+
+// forgets the instances that have been executed by every member
+func (r *Replica) collectGarbage() {
+	if watermark := r.GCWatermark(0, r.progress()); watermark >= 0 {
+		r.instanceSpace.Forget(watermark)
+	}
+}
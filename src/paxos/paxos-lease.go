@@ -0,0 +1,129 @@
+package paxos
+
+import (
+	"dlog"
+	"genericsmr"
+	"genericsmrproto"
+	"state"
+	"time"
+)
+
+/**********************************************************************
+
+                            LEADER LEASES
+
+***********************************************************************/
+
+// With leases, the leader answers reads locally, without starting an instance
+// for them. An acceptor that accepts an Accept grants its sender a lease: it
+// promises that, for LEASE_DURATION (+ LEASE_GUARD) from then on, it will not
+// promise any other replica that runs for leader, nor run itself. Once a
+// quorum has accepted an instance, the leader holds the lease until
+// LEASE_DURATION after it sent the Accepts. No other replica can be elected
+// before then, since its quorum would include an acceptor that has granted us
+// the lease, so every instance committed meanwhile is one that we commit.
+//
+// A read is thus answered from the state once every instance that the leader
+// had started when the read arrived has been executed. Waiting for the
+// committed ones only is not enough: without -dreply a write is acknowledged
+// as soon as a quorum accepts it, which may be before an earlier instance
+// commits and lets committedUpTo move past the write. A new leader has no
+// lease until it has committed the instances it took over, and an idle leader
+// starts empty instances to keep its lease (every LEASE_RENEW).
+
+const LEASE_DURATION = 2 * 1e9 // 2 seconds
+const LEASE_GUARD = 100 * 1e6  // 100 ms, the clock drift that grantors allow for
+const LEASE_RENEW = LEASE_DURATION / 4
+
+type leaseRead struct {
+	propose *genericsmr.Propose
+	upTo    int32 // the instances that must have been executed
+}
+
+// called once a quorum has accepted Accepts that we sent at the given time
+func (r *Replica) extendLease(sentAt int64) {
+	if r.leases && r.IsLeader && sentAt+LEASE_DURATION > r.leaseUntil {
+		r.leaseUntil = sentAt + LEASE_DURATION
+	}
+}
+
+func (r *Replica) holdsLease() bool {
+	return r.leases && r.IsLeader && r.committedUpTo >= r.recoveredUpTo && time.Now().UnixNano() < r.leaseUntil
+}
+
+// called on every tick of the clock
+func (r *Replica) renewLease() {
+	if !r.leases || !r.IsLeader || r.reconfig != nil || r.election != nil {
+		return
+	}
+	if time.Now().UnixNano()-r.lastAcceptSent >= LEASE_RENEW {
+		r.startInstance([]state.Command{}, nil)
+	}
+}
+
+// called when we accept an Accept
+func (r *Replica) grantLease(leader int32) {
+	if !r.leases || leader == r.Id {
+		return
+	}
+	r.grantedTo = leader
+	r.grantedUntil = time.Now().UnixNano() + LEASE_DURATION + LEASE_GUARD
+}
+
+// tells whether we have granted a lease, that has not expired, to a replica
+// other than q
+func (r *Replica) leaseGranted(q int32) bool {
+	return r.grantedTo != q && time.Now().UnixNano() < r.grantedUntil
+}
+
+/* Local reads */
+
+// Hands a read over to the execution thread if we hold the lease. Returns
+// false if the command has to go through an instance instead.
+func (r *Replica) readWithLease(propose *genericsmr.Propose) bool {
+	if !r.Exec || !state.IsRead(propose.Command.Op) || !r.holdsLease() {
+		return false
+	}
+	// every write we have acknowledged is in an instance we have started
+	r.leaseReads <- &leaseRead{propose, r.crtInstance - 1}
+	return true
+}
+
+//called by the execution thread, with the reads it has not answered yet
+func (r *Replica) serveLeaseReads(reads []*leaseRead) []*leaseRead {
+	for more := true; more; {
+		select {
+		case lr := <-r.leaseReads:
+			reads = append(reads, lr)
+		default:
+			more = false
+		}
+	}
+	// the reads arrive in order, so they wait for ever later instances
+	i := 0
+	for ; i < len(reads) && reads[i].upTo <= r.execedUpTo; i++ {
+		r.replyLeaseRead(reads[i])
+		reads[i] = nil
+	}
+	return reads[i:]
+}
+
+func (r *Replica) replyLeaseRead(lr *leaseRead) {
+	cmd := lr.propose.Command
+	// reads leave the state alone, so the client's session is not needed
+	// (and must not diverge from the other replicas' sessions)
+	cmd.ClientId = state.NO_CLIENT
+	val, kvs := cmd.ExecuteMulti(r.State)
+	dlog.Printf("Read %q locally under lease: %q\n", cmd.K, val)
+
+	r.clientMutex.Lock()
+	r.ReplyProposeTS(
+		&genericsmrproto.ProposeReplyTS{
+			TRUE,
+			lr.propose.CommandId,
+			val,
+			lr.propose.Timestamp,
+			kvs},
+		lr.propose.Reply)
+	r.clientMutex.Unlock()
+}
@@ -0,0 +1,238 @@
+package paxos
+
+import (
+	"bytes"
+	"dlog"
+	"errors"
+	"fmt"
+	"genericsmr"
+	"genericsmrproto"
+	"log"
+	"paxosproto"
+	"state"
+	"time"
+)
+
+/**********************************************************************
+
+                         MEMBERSHIP CHANGES
+
+***********************************************************************/
+
+// The master asks the leader to switch to a new configuration (see
+// genericsmrproto.Config). The leader waits until every instance it has
+// started is committed, and proposes the configuration as a RECONFIGURE
+// command in the next instance, i. It starts no other instance until i is
+// committed, and every replica switches to the configuration once it has
+// committed every instance up to i. The instances up to i are thus decided by
+// quorums of the old configuration, and the ones after i by quorums of the
+// new one. A replica that is removed stops.
+//
+// A replica that joins has none of the state. It asks a peer for a snapshot
+// (the application state, and the instances committed past it), as does a
+// replica that finds itself more than SNAPSHOT_LAG instances behind a peer.
+// Peers only answer once they have switched to a configuration that includes
+// the requester, so that the snapshot covers instance i.
+
+const RECONFIG_TIMEOUT = 10 * time.Second
+const SNAPSHOT_LAG = 10000                  // instances behind a peer before asking it for a snapshot
+const SNAPSHOT_TIMEOUT = 10 * 1e9           // 10 seconds before asking again
+const JOIN_RETRY = 2 * genericsmr.GC_PERIOD // before asking again while joining, since peers may not have added us yet
+
+var errNotLeader = errors.New("not the leader")
+
+type reconfigRequest struct {
+	config   genericsmrproto.Config
+	instance int32 // -1 until it has been proposed
+	done     chan error
+}
+
+type takenSnapshot struct {
+	requester  int32
+	execedUpTo int32
+	state      []byte
+}
+
+/* RPC to be called by master */
+
+func (r *Replica) Reconfigure(args *genericsmrproto.ReconfigureArgs, reply *genericsmrproto.ReconfigureReply) error {
+	req := &reconfigRequest{args.Config.Copy(), -1, make(chan error, 1)}
+	r.reconfigChan <- req
+	select {
+	case err := <-req.done:
+		if err != nil {
+			return err
+		}
+		reply.Epoch = args.Config.Epoch
+		return nil
+	case <-time.After(RECONFIG_TIMEOUT):
+		return fmt.Errorf("configuration %d not committed after %v", args.Config.Epoch, RECONFIG_TIMEOUT)
+	}
+}
+
+/* ============= */
+
+func (r *Replica) handleReconfigure(req *reconfigRequest) {
+	switch {
+	case !r.IsLeader:
+		req.done <- errNotLeader
+	case r.reconfig != nil:
+		req.done <- fmt.Errorf("configuration %d is being committed", r.reconfig.config.Epoch)
+	case !r.Config.Precedes(&req.config):
+		req.done <- fmt.Errorf("configuration %d cannot follow configuration %d", req.config.Epoch, r.Config.Epoch)
+	default:
+		r.reconfig = req
+		r.startReconfig()
+	}
+}
+
+// proposes the pending configuration once every instance we have started is
+// committed
+func (r *Replica) startReconfig() {
+	if r.reconfig == nil || r.reconfig.instance >= 0 || r.committedUpTo < r.crtInstance-1 {
+		return
+	}
+	r.reconfig.instance = r.startInstance([]state.Command{r.reconfig.config.Command()}, nil)
+	dlog.Printf("Proposing configuration %d in instance %d\n", r.reconfig.config.Epoch, r.reconfig.instance)
+}
+
+// called for every instance, in order, once every instance up to it is committed
+func (r *Replica) applyReconfigurations(instance int32, cmds []state.Command) {
+	for i := range cmds {
+		if c, ok := genericsmrproto.ConfigOf(&cmds[i]); ok {
+			r.applyConfig(c)
+		}
+	}
+	if r.reconfig != nil && r.reconfig.instance == instance {
+		if r.Config.Epoch == r.reconfig.config.Epoch {
+			r.reconfig.done <- nil
+		} else {
+			r.reconfig.done <- fmt.Errorf("instance %d was taken over by another leader", instance)
+		}
+		r.reconfig = nil
+	}
+}
+
+func (r *Replica) applyConfig(c *genericsmrproto.Config) {
+	if !r.ApplyConfig(c) {
+		return
+	}
+	if !r.Config.IsMember(r.Id) {
+		log.Println("Removed from the configuration, shutting down")
+		r.IsLeader = false
+		r.Shutdown = true
+		r.Replica.Shutdown = true
+		r.Listener.Close()
+	}
+}
+
+/* State transfer */
+
+func (r *Replica) checkLag(es *paxosproto.ExecStatus) {
+	if !r.Config.IsMember(es.ReplicaId) || (!r.joining && es.ExecedUpTo-r.progress() <= SNAPSHOT_LAG) {
+		return
+	}
+	now := time.Now().UnixNano()
+	timeout := int64(SNAPSHOT_TIMEOUT)
+	if r.joining {
+		timeout = JOIN_RETRY
+	}
+	if r.snapshotRequested != 0 && now-r.snapshotRequested < timeout {
+		return
+	}
+	log.Printf("Asking replica %d for a snapshot\n", es.ReplicaId)
+	r.snapshotRequested = now
+	r.SendMsg(es.ReplicaId, r.snapshotRequestRPC, &paxosproto.SnapshotRequest{r.Id})
+}
+
+func (r *Replica) handleSnapshotRequest(req *paxosproto.SnapshotRequest) {
+	if !r.Exec || r.joining || req.ReplicaId == r.Id || !r.Config.IsMember(req.ReplicaId) {
+		// the requester will ask again
+		return
+	}
+	select {
+	case r.snapshotsToTake <- req.ReplicaId:
+	default:
+		// a snapshot is being taken already
+	}
+}
+
+//called by the execution thread
+func (r *Replica) takeSnapshot(requester int32) *takenSnapshot {
+	var buf bytes.Buffer
+	r.State.Marshal(&buf)
+	return &takenSnapshot{requester, r.execedUpTo, buf.Bytes()}
+}
+
+func (r *Replica) sendSnapshot(ts *takenSnapshot) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Println("Snapshot send failed:", err)
+		}
+	}()
+	snap := &paxosproto.Snapshot{r.Id, ts.execedUpTo, r.Config, ts.state, make([]paxosproto.Commit, 0)}
+	for i := ts.execedUpTo + 1; i <= r.committedUpTo; i++ {
+		if inst := r.instanceSpace.Get(i); inst != nil {
+			snap.Committed = append(snap.Committed, paxosproto.Commit{r.Id, i, inst.ballot, inst.cmds})
+		}
+	}
+	log.Printf("Sending snapshot at %d to replica %d (%d bytes of state, %d instances)\n",
+		ts.execedUpTo, ts.requester, len(ts.state), len(snap.Committed))
+	r.SendMsg(ts.requester, r.snapshotRPC, snap)
+}
+
+func (r *Replica) handleSnapshot(snap *paxosproto.Snapshot) {
+	r.snapshotRequested = 0
+	if !snap.Config.IsMember(r.Id) {
+		// the sender has not switched to our configuration yet
+		return
+	}
+	if snap.ExecedUpTo <= r.progress() && snap.Config.Epoch <= r.Config.Epoch {
+		return
+	}
+
+	if r.Exec {
+		// the execution thread owns the state
+		r.snapshotsToInstall <- snap
+		for installed := false; !installed; {
+			select {
+			case ok := <-r.snapshotInstalled:
+				if !ok {
+					return
+				}
+				installed = true
+			case ts := <-r.snapshotsTaken:
+				r.sendSnapshot(ts)
+			}
+		}
+	} else if snap.ExecedUpTo > r.execedUpTo {
+		r.execedUpTo = snap.ExecedUpTo
+	}
+
+	r.instanceSpace.Forget(snap.ExecedUpTo)
+	if r.committedUpTo < snap.ExecedUpTo {
+		r.committedUpTo = snap.ExecedUpTo
+	}
+	if r.crtInstance <= snap.ExecedUpTo {
+		r.crtInstance = snap.ExecedUpTo + 1
+	}
+	r.joining = false
+	r.applyConfig(&snap.Config)
+	for i := range snap.Committed {
+		r.handleCommit(&snap.Committed[i])
+	}
+	log.Printf("Installed snapshot at %d from replica %d\n", snap.ExecedUpTo, snap.ReplicaId)
+}
+
+//called by the execution thread
+func (r *Replica) installSnapshot(snap *paxosproto.Snapshot) bool {
+	if snap.ExecedUpTo <= r.execedUpTo {
+		return true
+	}
+	if err := r.State.Unmarshal(bytes.NewReader(snap.State)); err != nil {
+		log.Println("Error installing snapshot:", err)
+		return false
+	}
+	r.execedUpTo = snap.ExecedUpTo
+	return true
+}
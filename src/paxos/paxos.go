@@ -10,6 +10,7 @@ import (
 	"log"
 	"paxosproto"
 	"state"
+	"sync"
 	"time"
 )
 
@@ -27,20 +28,48 @@ type Replica struct {
 	commitShortChan     chan fastrpc.Serializable
 	prepareReplyChan    chan fastrpc.Serializable
 	acceptReplyChan     chan fastrpc.Serializable
+	execStatusChan      chan fastrpc.Serializable
+	snapshotRequestChan chan fastrpc.Serializable
+	snapshotChan        chan fastrpc.Serializable
 	prepareRPC          uint8
 	acceptRPC           uint8
 	commitRPC           uint8
 	commitShortRPC      uint8
 	prepareReplyRPC     uint8
 	acceptReplyRPC      uint8
-	IsLeader            bool        // does this replica think it is the leader
-	instanceSpace       []*Instance // the space of all instances (used and not yet used)
-	crtInstance         int32       // highest active instance number that this replica knows about
-	defaultBallot       int32       // default ballot for new instances (0 until a Prepare(ballot, instance->infinity) from a leader)
+	execStatusRPC       uint8
+	snapshotRequestRPC  uint8
+	snapshotRPC         uint8
+	IsLeader            bool                   // does this replica think it is the leader
+	instanceSpace       *instanceWindow        // the space of all instances that have not been garbage collected yet
+	crtInstance         int32                  // highest active instance number that this replica knows about
+	defaultBallot       genericsmrproto.Ballot // default ballot for new instances (NilBallot until a Prepare(ballot, instance->infinity) from a leader)
 	Shutdown            bool
 	counter             int
 	flush               bool
 	committedUpTo       int32
+	execedUpTo          int32 // instance up to which all commands have been executed
+	joining             bool  // waiting for a snapshot, to start as a new member
+	reconfigChan        chan *reconfigRequest
+	reconfig            *reconfigRequest // the configuration being committed, at the leader
+	snapshotRequested   int64            // when we last asked a peer for a snapshot
+	snapshotsToTake     chan int32       // requesters, for the execution thread
+	snapshotsTaken      chan *takenSnapshot
+	snapshotsToInstall  chan *paxosproto.Snapshot
+	snapshotInstalled   chan bool
+	leaderId            int32     // the leader we know of, -1 if none
+	election            *election // the Phase 1 we run to become the leader
+	recoveredUpTo       int32     // the last instance that we proposed again when elected
+	leaderlessSince     int64     // when we found the leader to be suspected
+	beTheLeaderChan     chan bool
+	leases              bool  // the leader holds a lease, and answers reads locally
+	leaseUntil          int64 // when our lease expires, as the leader
+	lastAcceptSent      int64
+	grantedTo           int32 // the leader we have granted a lease to
+	grantedUntil        int64
+	leaseReads          chan *leaseRead // for the execution thread
+	clientMutex         *sync.Mutex     // for the replies sent from both threads
+	quorumStart         bool            // start once a quorum of the replicas is up, rather than all of them
 }
 
 type InstanceStatus int
@@ -54,20 +83,18 @@ const (
 
 type Instance struct {
 	cmds   []state.Command
-	ballot int32
+	ballot genericsmrproto.Ballot
 	status InstanceStatus
 	lb     *LeaderBookkeeping
 }
 
 type LeaderBookkeeping struct {
 	clientProposals []*genericsmr.Propose
-	maxRecvBallot   int32
-	prepareOKs      int
 	acceptOKs       int
-	nacks           int
+	sentAt          int64 // when we sent the Accepts, which grant us a lease once a quorum has accepted
 }
 
-func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply bool, durable bool, app state.Application) *Replica {
+func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply bool, durable bool, join bool, quorumStart bool, leases bool, detector genericsmr.FailureDetectorConfig, app state.StateMachine) *Replica {
 	r := &Replica{genericsmr.NewReplica(id, peerAddrList, thrifty, exec, dreply, app),
 		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
 		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
@@ -75,17 +102,50 @@ func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply b
 		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
 		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
 		make(chan fastrpc.Serializable, 3*genericsmr.CHAN_BUFFER_SIZE),
-		0, 0, 0, 0, 0, 0,
+		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
+		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
+		make(chan fastrpc.Serializable, genericsmr.CHAN_BUFFER_SIZE),
+		0, 0, 0, 0, 0, 0, 0, 0, 0,
 		false,
-		make([]*Instance, 15*1024*1024),
+		newInstanceWindow(),
 		0,
-		-1,
+		genericsmrproto.NilBallot,
 		false,
 		0,
 		true,
-		-1}
+		-1,
+		-1,
+		join,
+		make(chan *reconfigRequest, 10),
+		nil,
+		0,
+		make(chan int32, 1),
+		make(chan *takenSnapshot, 1),
+		make(chan *paxosproto.Snapshot, 1),
+		make(chan bool, 1),
+		0,
+		nil,
+		-1,
+		0,
+		make(chan bool, 1),
+		leases,
+		0,
+		0,
+		-1,
+		0,
+		make(chan *leaseRead, genericsmr.CHAN_BUFFER_SIZE),
+		new(sync.Mutex),
+		quorumStart}
+
+	if join {
+		// we are not a member until we have the state
+		r.Config.Epoch = -1
+		r.Config.Addrs[r.Id] = ""
+		r.leaderId = -1
+	}
 
 	r.Durable = durable
+	r.Detector = detector
 
 	r.prepareRPC = r.RegisterRPC(new(paxosproto.Prepare), r.prepareChan)
 	r.acceptRPC = r.RegisterRPC(new(paxosproto.Accept), r.acceptChan)
@@ -93,6 +153,9 @@ func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, dreply b
 	r.commitShortRPC = r.RegisterRPC(new(paxosproto.CommitShort), r.commitShortChan)
 	r.prepareReplyRPC = r.RegisterRPC(new(paxosproto.PrepareReply), r.prepareReplyChan)
 	r.acceptReplyRPC = r.RegisterRPC(new(paxosproto.AcceptReply), r.acceptReplyChan)
+	r.execStatusRPC = r.RegisterRPC(new(paxosproto.ExecStatus), r.execStatusChan)
+	r.snapshotRequestRPC = r.RegisterRPC(new(paxosproto.SnapshotRequest), r.snapshotRequestChan)
+	r.snapshotRPC = r.RegisterRPC(new(paxosproto.Snapshot), r.snapshotChan)
 
 	go r.run()
 
@@ -105,9 +168,11 @@ func (r *Replica) recordInstanceMetadata(inst *Instance) {
 		return
 	}
 
-	var b [5]byte
-	binary.LittleEndian.PutUint32(b[0:4], uint32(inst.ballot))
-	b[4] = byte(inst.status)
+	var b [13]byte
+	binary.LittleEndian.PutUint32(b[0:4], uint32(inst.ballot.Epoch))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(inst.ballot.Counter))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(inst.ballot.ReplicaId))
+	b[12] = byte(inst.status)
 	r.StableStore.Write(b[:])
 }
 
@@ -136,8 +201,18 @@ func (r *Replica) sync() {
 
 /* RPC to be called by master */
 
+// Makes us run for leader (see paxos-election.go), unless we are the leader
+// already.
 func (r *Replica) BeTheLeader(args *genericsmrproto.BeTheLeaderArgs, reply *genericsmrproto.BeTheLeaderReply) error {
-	r.IsLeader = true
+	select {
+	case r.beTheLeaderChan <- true:
+	default:
+	}
+	return nil
+}
+
+func (r *Replica) Ping(args *genericsmrproto.PingArgs, reply *genericsmrproto.PingReply) error {
+	*reply = r.PingStatus.Reply("paxos")
 	return nil
 }
 
@@ -164,7 +239,13 @@ func (r *Replica) clock() {
 
 func (r *Replica) run() {
 
-	r.ConnectToPeers()
+	if r.joining {
+		r.JoinPeers()
+	} else if r.quorumStart {
+		r.ConnectToLivePeers()
+	} else {
+		r.ConnectToPeers()
+	}
 
 	dlog.Println("Waiting for client connections")
 
@@ -174,22 +255,35 @@ func (r *Replica) run() {
 		go r.executeCommands()
 	}
 
-	if r.Id == 0 {
-		r.IsLeader = true
+	r.StartFailureDetector()
+
+	if r.Id == 0 && !r.joining {
+		r.startElection()
 	}
 
 	clockChan = make(chan bool, 1)
 	go r.clock()
 
+	r.StartGCClock()
+
 	onOffProposeChan := r.ProposeChan
 
 	for !r.Shutdown {
+		r.PingStatus.Publish(r.IsLeader, r.crtInstance)
+		if !r.Exec {
+			r.PingStatus.PublishExecedUpTo(r.execedUpTo)
+		}
 
 		select {
 
 		case <-clockChan:
 			//activate the new proposals channel
-			onOffProposeChan = r.ProposeChan
+			if r.takesProposals() {
+				onOffProposeChan = r.ProposeChan
+			} else {
+				r.startReconfig()
+			}
+			r.renewLease()
 			break
 
 		case propose := <-onOffProposeChan:
@@ -241,22 +335,77 @@ func (r *Replica) run() {
 			dlog.Printf("Received AcceptReply for instance %d\n", acceptReply.Instance)
 			r.handleAcceptReply(acceptReply)
 			break
+
+		case execStatusS := <-r.execStatusChan:
+			execStatus := execStatusS.(*paxosproto.ExecStatus)
+			dlog.Printf("Received ExecStatus from replica %d\n", execStatus.ReplicaId)
+			r.handleExecStatus(execStatus)
+			break
+
+		case <-r.GCChan:
+			r.bcastExecStatus()
+			r.collectGarbage()
+			break
+
+		case pc := <-r.PeerConnChan:
+			r.AddPeerConn(pc)
+			break
+
+		case req := <-r.reconfigChan:
+			r.handleReconfigure(req)
+			break
+
+		case snapshotRequestS := <-r.snapshotRequestChan:
+			snapshotRequest := snapshotRequestS.(*paxosproto.SnapshotRequest)
+			dlog.Printf("Received SnapshotRequest from replica %d\n", snapshotRequest.ReplicaId)
+			r.handleSnapshotRequest(snapshotRequest)
+			break
+
+		case snapshotS := <-r.snapshotChan:
+			snapshot := snapshotS.(*paxosproto.Snapshot)
+			dlog.Printf("Received Snapshot from replica %d\n", snapshot.ReplicaId)
+			r.handleSnapshot(snapshot)
+			break
+
+		case ts := <-r.snapshotsTaken:
+			r.sendSnapshot(ts)
+			break
+
+		case <-r.HeartbeatChan:
+			r.SendHeartbeats()
+			r.CheckPeers()
+			r.checkLeader()
+			break
+
+		case <-r.beTheLeaderChan:
+			if !r.IsLeader && r.election == nil {
+				r.startElection()
+			}
+			break
 		}
 	}
 }
 
-func (r *Replica) makeUniqueBallot(ballot int32) int32 {
-	return (ballot << 4) | r.Id
+// New proposals wait while a configuration is being committed, and while we
+// are being elected or have not committed the instances we took over.
+func (r *Replica) takesProposals() bool {
+	return r.reconfig == nil && r.election == nil && r.committedUpTo >= r.recoveredUpTo
 }
 
+// instances are only counted once their commands are known, since a
+// configuration change applies from the instance that follows it
 func (r *Replica) updateCommittedUpTo() {
-	for r.instanceSpace[r.committedUpTo+1] != nil &&
-		r.instanceSpace[r.committedUpTo+1].status == COMMITTED {
+	for {
+		inst := r.instanceSpace.Get(r.committedUpTo + 1)
+		if inst == nil || inst.status != COMMITTED || inst.cmds == nil {
+			break
+		}
 		r.committedUpTo++
+		r.applyReconfigurations(r.committedUpTo, inst.cmds)
 	}
 }
 
-func (r *Replica) bcastPrepare(instance int32, ballot int32, toInfinity bool) {
+func (r *Replica) bcastPrepare(instance int32, ballot genericsmrproto.Ballot, toInfinity bool) {
 	defer func() {
 		if err := recover(); err != nil {
 			log.Println("Prepare bcast failed:", err)
@@ -268,9 +417,9 @@ func (r *Replica) bcastPrepare(instance int32, ballot int32, toInfinity bool) {
 	}
 	args := &paxosproto.Prepare{r.Id, instance, ballot, ti}
 
-	n := r.N - 1
+	n := r.Config.Size() - 1
 	if r.Thrifty {
-		n = r.N >> 1
+		n = r.Config.Quorum() - 1
 	}
 	q := r.Id
 
@@ -279,7 +428,7 @@ func (r *Replica) bcastPrepare(instance int32, ballot int32, toInfinity bool) {
 		if q == r.Id {
 			break
 		}
-		if !r.Alive[q] {
+		if !r.Alive[q] || !r.Config.IsMember(q) {
 			continue
 		}
 		sent++
@@ -289,7 +438,7 @@ func (r *Replica) bcastPrepare(instance int32, ballot int32, toInfinity bool) {
 
 var pa paxosproto.Accept
 
-func (r *Replica) bcastAccept(instance int32, ballot int32, command []state.Command) {
+func (r *Replica) bcastAccept(instance int32, ballot genericsmrproto.Ballot, command []state.Command) {
 	defer func() {
 		if err := recover(); err != nil {
 			log.Println("Accept bcast failed:", err)
@@ -302,9 +451,9 @@ func (r *Replica) bcastAccept(instance int32, ballot int32, command []state.Comm
 	args := &pa
 	//args := &paxosproto.Accept{r.Id, instance, ballot, command}
 
-	n := r.N - 1
+	n := r.Config.Size() - 1
 	if r.Thrifty {
-		n = r.N >> 1
+		n = r.Config.Quorum() - 1
 	}
 	q := r.Id
 
@@ -313,7 +462,7 @@ func (r *Replica) bcastAccept(instance int32, ballot int32, command []state.Comm
 		if q == r.Id {
 			break
 		}
-		if !r.Alive[q] {
+		if !r.Alive[q] || !r.Config.IsMember(q) {
 			continue
 		}
 		sent++
@@ -324,7 +473,7 @@ func (r *Replica) bcastAccept(instance int32, ballot int32, command []state.Comm
 var pc paxosproto.Commit
 var pcs paxosproto.CommitShort
 
-func (r *Replica) bcastCommit(instance int32, ballot int32, command []state.Command) {
+func (r *Replica) bcastCommit(instance int32, ballot genericsmrproto.Ballot, command []state.Command) {
 	defer func() {
 		if err := recover(); err != nil {
 			log.Println("Commit bcast failed:", err)
@@ -343,9 +492,9 @@ func (r *Replica) bcastCommit(instance int32, ballot int32, command []state.Comm
 
 	//args := &paxosproto.Commit{r.Id, instance, command}
 
-	n := r.N - 1
+	n := r.Config.Size() - 1
 	if r.Thrifty {
-		n = r.N >> 1
+		n = r.Config.Quorum() - 1
 	}
 	q := r.Id
 	sent := 0
@@ -355,19 +504,19 @@ func (r *Replica) bcastCommit(instance int32, ballot int32, command []state.Comm
 		if q == r.Id {
 			break
 		}
-		if !r.Alive[q] {
+		if !r.Alive[q] || !r.Config.IsMember(q) {
 			continue
 		}
 		sent++
 		r.SendMsg(q, r.commitShortRPC, argsShort)
 	}
 	if r.Thrifty && q != r.Id {
-		for sent < r.N-1 {
+		for sent < r.Config.Size()-1 {
 			q = (q + 1) % int32(r.N)
 			if q == r.Id {
 				break
 			}
-			if !r.Alive[q] {
+			if !r.Alive[q] || !r.Config.IsMember(q) {
 				continue
 			}
 			sent++
@@ -378,18 +527,13 @@ func (r *Replica) bcastCommit(instance int32, ballot int32, command []state.Comm
 
 func (r *Replica) handlePropose(propose *genericsmr.Propose) {
 	if !r.IsLeader {
-		preply := &genericsmrproto.ProposeReplyTS{FALSE, -1, state.NIL, 0}
+		preply := &genericsmrproto.ProposeReplyTS{FALSE, -1, state.NIL, 0, nil}
+		r.clientMutex.Lock()
 		r.ReplyProposeTS(preply, propose.Reply)
+		r.clientMutex.Unlock()
 		return
 	}
 
-	for r.instanceSpace[r.crtInstance] != nil {
-		r.crtInstance++
-	}
-
-	instNo := r.crtInstance
-	r.crtInstance++
-
 	batchSize := len(r.ProposeChan) + 1
 
 	if batchSize > MAX_BATCH {
@@ -398,87 +542,108 @@ func (r *Replica) handlePropose(propose *genericsmr.Propose) {
 
 	dlog.Printf("Batched %d\n", batchSize)
 
-	cmds := make([]state.Command, batchSize)
-	proposals := make([]*genericsmr.Propose, batchSize)
-	cmds[0] = propose.Command
-	proposals[0] = propose
-
-	for i := 1; i < batchSize; i++ {
-		prop := <-r.ProposeChan
-		cmds[i] = prop.Command
-		proposals[i] = prop
-	}
-
-	if r.defaultBallot == -1 {
-		r.instanceSpace[instNo] = &Instance{
-			cmds,
-			r.makeUniqueBallot(0),
-			PREPARING,
-			&LeaderBookkeeping{proposals, 0, 0, 0, 0}}
-		r.bcastPrepare(instNo, r.makeUniqueBallot(0), true)
-		dlog.Printf("Classic round for instance %d\n", instNo)
-	} else {
-		r.instanceSpace[instNo] = &Instance{
-			cmds,
-			r.defaultBallot,
-			PREPARED,
-			&LeaderBookkeeping{proposals, 0, 0, 0, 0}}
-
-		r.recordInstanceMetadata(r.instanceSpace[instNo])
-		r.recordCommands(cmds)
-		r.sync()
+	cmds := make([]state.Command, 0, batchSize)
+	proposals := make([]*genericsmr.Propose, 0, batchSize)
 
-		r.bcastAccept(instNo, r.defaultBallot, cmds)
-		dlog.Printf("Fast round for instance %d\n", instNo)
+	for i := 0; i < batchSize; i++ {
+		prop := propose
+		if i > 0 {
+			prop = <-r.ProposeChan
+		}
+		if r.readWithLease(prop) {
+			continue
+		}
+		cmds = append(cmds, prop.Command)
+		proposals = append(proposals, prop)
+	}
+
+	if len(cmds) > 0 {
+		r.startInstance(cmds, proposals)
 	}
 }
 
-func (r *Replica) handlePrepare(prepare *paxosproto.Prepare) {
-	inst := r.instanceSpace[prepare.Instance]
-	var preply *paxosproto.PrepareReply
+// proposes the commands in a new instance, and returns its number
+func (r *Replica) startInstance(cmds []state.Command, proposals []*genericsmr.Propose) int32 {
+	for r.instanceSpace.Get(r.crtInstance) != nil || r.instanceSpace.Forgotten(r.crtInstance) {
+		r.crtInstance++
+	}
 
-	if inst == nil {
-		ok := TRUE
-		if r.defaultBallot > prepare.Ballot {
-			ok = FALSE
-		}
-		preply = &paxosproto.PrepareReply{prepare.Instance, ok, r.defaultBallot, make([]state.Command, 0)}
-	} else {
-		ok := TRUE
-		if prepare.Ballot < inst.ballot {
-			ok = FALSE
+	instNo := r.crtInstance
+	r.crtInstance++
+
+	// the leader has prepared every instance with its ballot when it was elected
+	r.lastAcceptSent = time.Now().UnixNano()
+	r.instanceSpace.Set(instNo, &Instance{
+		cmds,
+		r.defaultBallot,
+		PREPARED,
+		&LeaderBookkeeping{proposals, 0, r.lastAcceptSent}})
+
+	r.recordInstanceMetadata(r.instanceSpace.Get(instNo))
+	r.recordCommands(cmds)
+	r.sync()
+
+	r.bcastAccept(instNo, r.defaultBallot, cmds)
+	dlog.Printf("Fast round for instance %d\n", instNo)
+	return instNo
+}
+
+// A Prepare is for every instance from prepare.Instance on (see
+// paxos-election.go). Promising its ballot makes us follow the replica that
+// runs for leader with it.
+func (r *Replica) handlePrepare(prepare *paxosproto.Prepare) {
+	preply := &paxosproto.PrepareReply{r.Id, prepare.Instance, FALSE, r.defaultBallot, nil}
+
+	if r.leaseGranted(prepare.LeaderId) {
+		// the candidate runs again once the lease has expired
+		dlog.Printf("Not promising ballot %v to replica %d, we have granted a lease to replica %d\n", prepare.Ballot, prepare.LeaderId, r.grantedTo)
+	} else if !prepare.Ballot.LessThan(r.defaultBallot) {
+		if prepare.Ballot.GreaterThan(r.defaultBallot) {
+			r.follow(prepare.Ballot)
 		}
-		preply = &paxosproto.PrepareReply{prepare.Instance, ok, inst.ballot, inst.cmds}
+		preply.OK = TRUE
+		preply.Ballot = prepare.Ballot
+		preply.Instances = r.acceptedInstances(prepare.Instance)
 	}
 
 	r.replyPrepare(prepare.LeaderId, preply)
-
-	if prepare.ToInfinity == TRUE && prepare.Ballot > r.defaultBallot {
-		r.defaultBallot = prepare.Ballot
-	}
 }
 
 func (r *Replica) handleAccept(accept *paxosproto.Accept) {
-	inst := r.instanceSpace[accept.Instance]
+	if r.instanceSpace.Forgotten(accept.Instance) {
+		return
+	}
+	if accept.Ballot.LessThan(r.defaultBallot) {
+		// we have promised a later leader, for every instance
+		r.replyAccept(accept.LeaderId, &paxosproto.AcceptReply{accept.Instance, FALSE, r.defaultBallot})
+		return
+	}
+	if accept.Ballot.GreaterThan(r.defaultBallot) || r.leaderId != accept.LeaderId {
+		// a leader that was elected without us
+		r.follow(accept.Ballot)
+	}
+	if accept.Instance >= r.crtInstance {
+		r.crtInstance = accept.Instance + 1
+	}
+
+	inst := r.instanceSpace.Get(accept.Instance)
 	var areply *paxosproto.AcceptReply
 
 	if inst == nil {
-		if accept.Ballot < r.defaultBallot {
-			areply = &paxosproto.AcceptReply{accept.Instance, FALSE, r.defaultBallot}
-		} else {
-			r.instanceSpace[accept.Instance] = &Instance{
-				accept.Command,
-				accept.Ballot,
-				ACCEPTED,
-				nil}
-			areply = &paxosproto.AcceptReply{accept.Instance, TRUE, r.defaultBallot}
-		}
-	} else if inst.ballot > accept.Ballot {
+		r.instanceSpace.Set(accept.Instance, &Instance{
+			accept.Command,
+			accept.Ballot,
+			ACCEPTED,
+			nil})
+		areply = &paxosproto.AcceptReply{accept.Instance, TRUE, r.defaultBallot}
+	} else if inst.ballot.GreaterThan(accept.Ballot) {
 		areply = &paxosproto.AcceptReply{accept.Instance, FALSE, inst.ballot}
-	} else if inst.ballot < accept.Ballot {
+	} else if inst.ballot.LessThan(accept.Ballot) {
 		inst.cmds = accept.Command
 		inst.ballot = accept.Ballot
-		inst.status = ACCEPTED
+		if inst.status != COMMITTED {
+			inst.status = ACCEPTED
+		}
 		areply = &paxosproto.AcceptReply{accept.Instance, TRUE, inst.ballot}
 		if inst.lb != nil && inst.lb.clientProposals != nil {
 			//TODO: is this correct?
@@ -490,37 +655,47 @@ func (r *Replica) handleAccept(accept *paxosproto.Accept) {
 		}
 	} else {
 		// reordered ACCEPT
-		r.instanceSpace[accept.Instance].cmds = accept.Command
-		if r.instanceSpace[accept.Instance].status != COMMITTED {
-			r.instanceSpace[accept.Instance].status = ACCEPTED
+		r.instanceSpace.Get(accept.Instance).cmds = accept.Command
+		if r.instanceSpace.Get(accept.Instance).status != COMMITTED {
+			r.instanceSpace.Get(accept.Instance).status = ACCEPTED
 		}
 		areply = &paxosproto.AcceptReply{accept.Instance, TRUE, r.defaultBallot}
 	}
 
 	if areply.OK == TRUE {
-		r.recordInstanceMetadata(r.instanceSpace[accept.Instance])
+		r.recordInstanceMetadata(r.instanceSpace.Get(accept.Instance))
 		r.recordCommands(accept.Command)
 		r.sync()
+		r.grantLease(accept.LeaderId)
 	}
 
 	r.replyAccept(accept.LeaderId, areply)
 }
 
 func (r *Replica) handleCommit(commit *paxosproto.Commit) {
-	inst := r.instanceSpace[commit.Instance]
+	if r.instanceSpace.Forgotten(commit.Instance) {
+		return
+	}
+	if commit.Ballot.GreaterThan(r.defaultBallot) {
+		r.follow(commit.Ballot)
+	}
+	if commit.Instance >= r.crtInstance {
+		r.crtInstance = commit.Instance + 1
+	}
+	inst := r.instanceSpace.Get(commit.Instance)
 
 	dlog.Printf("Committing instance %d\n", commit.Instance)
 
 	if inst == nil {
-		r.instanceSpace[commit.Instance] = &Instance{
+		r.instanceSpace.Set(commit.Instance, &Instance{
 			commit.Command,
 			commit.Ballot,
 			COMMITTED,
-			nil}
+			nil})
 	} else {
-		r.instanceSpace[commit.Instance].cmds = commit.Command
-		r.instanceSpace[commit.Instance].status = COMMITTED
-		r.instanceSpace[commit.Instance].ballot = commit.Ballot
+		r.instanceSpace.Get(commit.Instance).cmds = commit.Command
+		r.instanceSpace.Get(commit.Instance).status = COMMITTED
+		r.instanceSpace.Get(commit.Instance).ballot = commit.Ballot
 		if inst.lb != nil && inst.lb.clientProposals != nil {
 			for i := 0; i < len(inst.lb.clientProposals); i++ {
 				r.ProposeChan <- inst.lb.clientProposals[i]
@@ -531,23 +706,32 @@ func (r *Replica) handleCommit(commit *paxosproto.Commit) {
 
 	r.updateCommittedUpTo()
 
-	r.recordInstanceMetadata(r.instanceSpace[commit.Instance])
+	r.recordInstanceMetadata(r.instanceSpace.Get(commit.Instance))
 	r.recordCommands(commit.Command)
 }
 
 func (r *Replica) handleCommitShort(commit *paxosproto.CommitShort) {
-	inst := r.instanceSpace[commit.Instance]
+	if r.instanceSpace.Forgotten(commit.Instance) {
+		return
+	}
+	if commit.Ballot.GreaterThan(r.defaultBallot) {
+		r.follow(commit.Ballot)
+	}
+	if commit.Instance >= r.crtInstance {
+		r.crtInstance = commit.Instance + 1
+	}
+	inst := r.instanceSpace.Get(commit.Instance)
 
 	dlog.Printf("Committing instance %d\n", commit.Instance)
 
 	if inst == nil {
-		r.instanceSpace[commit.Instance] = &Instance{nil,
+		r.instanceSpace.Set(commit.Instance, &Instance{nil,
 			commit.Ballot,
 			COMMITTED,
-			nil}
+			nil})
 	} else {
-		r.instanceSpace[commit.Instance].status = COMMITTED
-		r.instanceSpace[commit.Instance].ballot = commit.Ballot
+		r.instanceSpace.Get(commit.Instance).status = COMMITTED
+		r.instanceSpace.Get(commit.Instance).ballot = commit.Ballot
 		if inst.lb != nil && inst.lb.clientProposals != nil {
 			for i := 0; i < len(inst.lb.clientProposals); i++ {
 				r.ProposeChan <- inst.lb.clientProposals[i]
@@ -558,136 +742,111 @@ func (r *Replica) handleCommitShort(commit *paxosproto.CommitShort) {
 
 	r.updateCommittedUpTo()
 
-	r.recordInstanceMetadata(r.instanceSpace[commit.Instance])
+	r.recordInstanceMetadata(r.instanceSpace.Get(commit.Instance))
 }
 
-func (r *Replica) handlePrepareReply(preply *paxosproto.PrepareReply) {
-	inst := r.instanceSpace[preply.Instance]
-
-	if inst.status != PREPARING {
-		// TODO: should replies for non-current ballots be ignored?
-		// we've moved on -- these are delayed replies, so just ignore
+func (r *Replica) handleAcceptReply(areply *paxosproto.AcceptReply) {
+	if r.instanceSpace.Forgotten(areply.Instance) {
 		return
 	}
+	inst := r.instanceSpace.Get(areply.Instance)
 
-	if preply.OK == TRUE {
-		inst.lb.prepareOKs++
-
-		if preply.Ballot > inst.lb.maxRecvBallot {
-			inst.cmds = preply.Command
-			inst.lb.maxRecvBallot = preply.Ballot
-			if inst.lb.clientProposals != nil {
-				// there is already a competing command for this instance,
-				// so we put the client proposal back in the queue so that
-				// we know to try it in another instance
-				for i := 0; i < len(inst.lb.clientProposals); i++ {
-					r.ProposeChan <- inst.lb.clientProposals[i]
-				}
-				inst.lb.clientProposals = nil
-			}
-		}
-
-		if inst.lb.prepareOKs+1 > r.N>>1 {
-			inst.status = PREPARED
-			inst.lb.nacks = 0
-			if inst.ballot > r.defaultBallot {
-				r.defaultBallot = inst.ballot
-			}
-			r.recordInstanceMetadata(r.instanceSpace[preply.Instance])
-			r.sync()
-			r.bcastAccept(preply.Instance, inst.ballot, inst.cmds)
-		}
-	} else {
-		// TODO: there is probably another active leader
-		inst.lb.nacks++
-		if preply.Ballot > inst.lb.maxRecvBallot {
-			inst.lb.maxRecvBallot = preply.Ballot
-		}
-		if inst.lb.nacks >= r.N>>1 {
-			if inst.lb.clientProposals != nil {
-				// try the proposals in another instance
-				for i := 0; i < len(inst.lb.clientProposals); i++ {
-					r.ProposeChan <- inst.lb.clientProposals[i]
-				}
-				inst.lb.clientProposals = nil
-			}
-		}
-	}
-}
-
-func (r *Replica) handleAcceptReply(areply *paxosproto.AcceptReply) {
-	inst := r.instanceSpace[areply.Instance]
-
-	if inst.status != PREPARED && inst.status != ACCEPTED {
+	if inst == nil || inst.lb == nil || (inst.status != PREPARED && inst.status != ACCEPTED) {
 		// we've move on, these are delayed replies, so just ignore
 		return
 	}
 
 	if areply.OK == TRUE {
+		if !r.IsLeader || areply.Ballot != inst.ballot {
+			// a reply for a ballot that we have given up
+			return
+		}
 		inst.lb.acceptOKs++
-		if inst.lb.acceptOKs+1 > r.N>>1 {
-			inst = r.instanceSpace[areply.Instance]
+		if inst.lb.acceptOKs+1 >= r.Config.Quorum() {
+			inst = r.instanceSpace.Get(areply.Instance)
 			inst.status = COMMITTED
 			if inst.lb.clientProposals != nil && !r.Dreply {
 				// give client the all clear
+				r.clientMutex.Lock()
 				for i := 0; i < len(inst.cmds); i++ {
 					propreply := &genericsmrproto.ProposeReplyTS{
 						TRUE,
 						inst.lb.clientProposals[i].CommandId,
 						state.NIL,
-						inst.lb.clientProposals[i].Timestamp}
+						inst.lb.clientProposals[i].Timestamp,
+						nil}
 					r.ReplyProposeTS(propreply, inst.lb.clientProposals[i].Reply)
 				}
+				r.clientMutex.Unlock()
 			}
+			r.extendLease(inst.lb.sentAt)
 
-			r.recordInstanceMetadata(r.instanceSpace[areply.Instance])
+			r.recordInstanceMetadata(r.instanceSpace.Get(areply.Instance))
 			r.sync() //is this necessary?
 
-			r.updateCommittedUpTo()
-
+			// a replica that the instance removes from the configuration
+			// still has to learn about it
 			r.bcastCommit(areply.Instance, inst.ballot, inst.cmds)
+
+			r.updateCommittedUpTo()
 		}
-	} else {
-		// TODO: there is probably another active leader
-		inst.lb.nacks++
-		if areply.Ballot > inst.lb.maxRecvBallot {
-			inst.lb.maxRecvBallot = areply.Ballot
-		}
-		if inst.lb.nacks >= r.N>>1 {
-			// TODO
-		}
+	} else if areply.Ballot.GreaterThan(inst.ballot) {
+		// there is another leader, with a later ballot
+		r.follow(areply.Ballot)
 	}
 }
 
 func (r *Replica) executeCommands() {
-	i := int32(0)
+	reads := make([]*leaseRead, 0)
 	for !r.Shutdown {
-		executed := false
-
-		for i <= r.committedUpTo {
-			if r.instanceSpace[i].cmds != nil {
-				inst := r.instanceSpace[i]
-				for j := 0; j < len(inst.cmds); j++ {
-					val := inst.cmds[j].Execute(r.State)
-					if r.Dreply && inst.lb != nil && inst.lb.clientProposals != nil {
-						propreply := &genericsmrproto.ProposeReplyTS{
-							TRUE,
-							inst.lb.clientProposals[j].CommandId,
-							val,
-							inst.lb.clientProposals[j].Timestamp}
-						r.ReplyProposeTS(propreply, inst.lb.clientProposals[j].Reply)
-					}
-				}
-				i++
-				executed = true
-			} else {
-				break
-			}
+		select {
+		case q := <-r.snapshotsToTake:
+			r.snapshotsTaken <- r.takeSnapshot(q)
+		case snap := <-r.snapshotsToInstall:
+			r.snapshotInstalled <- r.installSnapshot(snap)
+		default:
 		}
 
+		executed := r.executeCommitted()
+
+		r.PingStatus.PublishExecedUpTo(r.execedUpTo)
+		reads = r.serveLeaseReads(reads)
+
 		if !executed {
 			time.Sleep(1000 * 1000)
 		}
 	}
 
 }
+
+// executes the instances committed so far, and tells whether there were any
+func (r *Replica) executeCommitted() bool {
+	executed := false
+	for r.execedUpTo < r.committedUpTo {
+		inst := r.instanceSpace.Get(r.execedUpTo + 1)
+		if inst.cmds == nil {
+			break
+		}
+		for j := 0; j < len(inst.cmds); j++ {
+			if inst.cmds[j].Op == state.RECONFIGURE {
+				// already applied by the main thread
+				continue
+			}
+			val, kvs := inst.cmds[j].ExecuteMulti(r.State)
+			if r.Dreply && inst.lb != nil && inst.lb.clientProposals != nil {
+				propreply := &genericsmrproto.ProposeReplyTS{
+					TRUE,
+					inst.lb.clientProposals[j].CommandId,
+					val,
+					inst.lb.clientProposals[j].Timestamp,
+					kvs}
+				r.clientMutex.Lock()
+				r.ReplyProposeTS(propreply, inst.lb.clientProposals[j].Reply)
+				r.clientMutex.Unlock()
+			}
+		}
+		r.execedUpTo++
+		executed = true
+	}
+	return executed
+}
@@ -0,0 +1,448 @@
+package paxos
+
+import (
+	"bufio"
+	"bytes"
+	"genericsmr"
+	"genericsmrproto"
+	"net"
+	"paxosproto"
+	"state"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a replica of a cluster of n, with no connections to its peers: messages to
+// them are dropped
+func initReplica(id int32, n int) *Replica {
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = "localhost"
+	}
+	r := &Replica{Replica: &genericsmr.Replica{
+		N:            n,
+		Id:           id,
+		PeerAddrList: addrs,
+		Peers:        make([]net.Conn, n),
+		PeerWriters:  make([]*bufio.Writer, n),
+		PeerReaders:  make([]*bufio.Reader, n),
+		Alive:        make([]bool, n),
+		State:        state.NewState(state.DefaultMachine{}),
+		ProposeChan:  make(chan *genericsmr.Propose, 10),
+		Config:       genericsmrproto.NewConfig(addrs)},
+		instanceSpace:      newInstanceWindow(),
+		defaultBallot:      genericsmrproto.NilBallot,
+		committedUpTo:      -1,
+		execedUpTo:         -1,
+		snapshotsToTake:    make(chan int32, 1),
+		snapshotsTaken:     make(chan *takenSnapshot, 1),
+		snapshotsToInstall: make(chan *paxosproto.Snapshot, 1),
+		snapshotInstalled:  make(chan bool, 1),
+		leaderId:           -1,
+		recoveredUpTo:      -1,
+		grantedTo:          -1,
+		leaseReads:         make(chan *leaseRead, 10),
+		clientMutex:        new(sync.Mutex)}
+	return r
+}
+
+func (r *Replica) lead() {
+	r.IsLeader = true
+	r.leaderId = r.Id
+	r.defaultBallot = genericsmrproto.InitialBallot(r.Id)
+}
+
+func put(k int64, v int64) state.Command {
+	return state.Command{int32(k), state.NO_CLIENT, state.PUT, state.IntKey(k), state.IntValue(v), state.NIL, nil, 0}
+}
+
+func get(st *state.State, k int64) state.Value {
+	cmd := state.Command{0, state.NO_CLIENT, state.GET, state.IntKey(k), state.NIL, state.NIL, nil, 0}
+	return cmd.Execute(st)
+}
+
+func TestReconfigure(t *testing.T) {
+	r := initReplica(0, 3)
+	r.lead()
+
+	next := r.Config.Copy()
+	next.Epoch++
+	next.Addrs[2] = ""
+	next.Addrs = append(next.Addrs, "localhost")
+	req := &reconfigRequest{next, -1, make(chan error, 1)}
+	r.handleReconfigure(req)
+	if req.instance != 0 || r.reconfig != req {
+		t.Fatal("configuration not proposed")
+	}
+
+	// one at a time
+	other := &reconfigRequest{next, -1, make(chan error, 1)}
+	r.handleReconfigure(other)
+	if err := <-other.done; err == nil {
+		t.Fatal("two configurations proposed at once")
+	}
+
+	// a quorum of the old configuration commits it
+	r.handleAcceptReply(&paxosproto.AcceptReply{0, TRUE, r.defaultBallot})
+	select {
+	case err := <-req.done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatal("configuration not committed")
+	}
+	if r.reconfig != nil || r.committedUpTo != 0 || r.Config.Epoch != 1 {
+		t.Fatalf("configuration %d applied up to %d", r.Config.Epoch, r.committedUpTo)
+	}
+	if r.N != 4 || r.Config.IsMember(2) || !r.Config.IsMember(3) || r.Config.Quorum() != 2 {
+		t.Fatalf("wrong configuration %v", r.Config.Addrs)
+	}
+
+	// a configuration that was already applied is refused
+	r.handleReconfigure(&reconfigRequest{next, -1, req.done})
+	if err := <-req.done; err == nil {
+		t.Fatal("configuration applied twice")
+	}
+}
+
+func TestRemovedReplicaShutsDown(t *testing.T) {
+	r := initReplica(2, 3)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Listener = l
+
+	next := r.Config.Copy()
+	next.Epoch++
+	next.Addrs[2] = ""
+	ballot := genericsmrproto.InitialBallot(0)
+	r.handleCommit(&paxosproto.Commit{0, 0, ballot, []state.Command{next.Command()}})
+
+	if r.Config.Epoch != 1 || !r.Shutdown || !r.Replica.Shutdown {
+		t.Fatal("removed replica still running")
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Fatal("removed replica still listening")
+	}
+}
+
+func TestJoinInstallsSnapshot(t *testing.T) {
+	// what the members have
+	st := state.NewState(state.DefaultMachine{})
+	cmd := put(1, 10)
+	cmd.Execute(st)
+	var buf bytes.Buffer
+	st.Marshal(&buf)
+
+	config := genericsmrproto.NewConfig([]string{"localhost", "localhost", "localhost", "localhost"})
+	config.Epoch = 1
+	config.Addrs[2] = ""
+	ballot := genericsmrproto.InitialBallot(0)
+	snap := &paxosproto.Snapshot{0, 9, config, buf.Bytes(),
+		[]paxosproto.Commit{paxosproto.Commit{0, 10, ballot, []state.Command{put(2, 20)}}}}
+
+	// a replica that joins as replica 3 (see NewReplica)
+	r := initReplica(3, 4)
+	r.joining = true
+	r.Config.Epoch = -1
+	r.Config.Addrs[r.Id] = ""
+	r.Exec = true
+
+	// stands in for the execution thread, which installs the snapshot
+	go func() { r.snapshotInstalled <- r.installSnapshot(<-r.snapshotsToInstall) }()
+	r.handleSnapshot(snap)
+	if r.joining || r.Config.Epoch != 1 || !r.Config.IsMember(r.Id) || r.Config.IsMember(2) {
+		t.Fatalf("joined with configuration %d: %v", r.Config.Epoch, r.Config.Addrs)
+	}
+	if r.committedUpTo != 10 || !r.instanceSpace.Forgotten(9) {
+		t.Fatalf("committed up to %d", r.committedUpTo)
+	}
+
+	// the instances past the snapshot are executed on top of it
+	if !r.executeCommitted() || r.execedUpTo != 10 {
+		t.Fatalf("executed up to %d", r.execedUpTo)
+	}
+	if get(r.State, 1).Int() != 10 || get(r.State, 2).Int() != 20 {
+		t.Fatal("wrong state after the snapshot")
+	}
+}
+
+func TestPing(t *testing.T) {
+	r := initReplica(0, 3)
+	r.lead()
+
+	// the main loop and the execution thread publish while the master pings
+	done := make(chan bool)
+	go func() {
+		for i := int32(0); i <= 100; i++ {
+			r.PingStatus.Publish(r.IsLeader, i)
+			r.PingStatus.PublishExecedUpTo(i - 1)
+		}
+		done <- true
+	}()
+	reply := new(genericsmrproto.PingReply)
+	for i := 0; i < 100; i++ {
+		r.Ping(new(genericsmrproto.PingArgs), reply)
+	}
+	<-done
+
+	r.Ping(new(genericsmrproto.PingArgs), reply)
+	if reply.Protocol != "paxos" || !reply.IsLeader || reply.CrtInstance != 100 || reply.ExecedUpTo != 99 {
+		t.Fatalf("wrong ping reply %v", *reply)
+	}
+}
+
+// captures what r sends to peer q
+func (r *Replica) capture(q int32) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	r.PeerWriters[q] = bufio.NewWriter(buf)
+	return buf
+}
+
+func readPrepareReply(t *testing.T, buf *bytes.Buffer) *paxosproto.PrepareReply {
+	preply := new(paxosproto.PrepareReply)
+	if _, err := buf.ReadByte(); err != nil {
+		t.Fatal("no reply to the Prepare")
+	}
+	if err := preply.Unmarshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	return preply
+}
+
+func TestStepDown(t *testing.T) {
+	r := initReplica(0, 3)
+	r.lead()
+	r.defaultBallot = genericsmrproto.Ballot{0, 2, 0}
+	sent := r.capture(1)
+	req := &reconfigRequest{r.Config.Copy(), -1, make(chan error, 1)}
+	r.reconfig = req
+
+	// a candidate that is behind
+	r.handlePrepare(&paxosproto.Prepare{1, 0, genericsmrproto.Ballot{0, 1, 1}, TRUE})
+	if preply := readPrepareReply(t, sent); preply.OK != FALSE || preply.Ballot != r.defaultBallot {
+		t.Fatalf("promised a lower ballot: %v", preply)
+	}
+	if !r.IsLeader || r.leaderId != 0 {
+		t.Fatal("stepped down for a lower ballot")
+	}
+
+	higher := genericsmrproto.Ballot{0, 3, 1}
+	r.handlePrepare(&paxosproto.Prepare{1, 0, higher, TRUE})
+	if preply := readPrepareReply(t, sent); preply.OK != TRUE || preply.Ballot != higher {
+		t.Fatalf("did not promise a higher ballot: %v", preply)
+	}
+	if r.IsLeader || r.leaderId != 1 || r.defaultBallot != higher {
+		t.Fatal("did not step down for a higher ballot")
+	}
+	if err := <-req.done; err != errNotLeader || r.reconfig != nil {
+		t.Fatal("configuration still pending after stepping down")
+	}
+}
+
+// replica 1, elected with the promise of replica 0 after a leader with ballot
+// {0, 1, 2}, which had replica 0 accept another value for instance 0
+func electedReplica(t *testing.T) *Replica {
+	r := initReplica(1, 3)
+	r.defaultBallot = genericsmrproto.Ballot{0, 1, 2}
+	old := genericsmrproto.InitialBallot(0)
+	r.instanceSpace.Set(0, &Instance{[]state.Command{put(1, 1)}, old, ACCEPTED, nil})
+	r.instanceSpace.Set(2, &Instance{[]state.Command{put(3, 3)}, old, ACCEPTED, nil})
+	r.crtInstance = 3
+
+	r.startElection()
+	e := r.election
+	if e == nil || e.ballot != (genericsmrproto.Ballot{0, 2, 1}) || e.from != 0 || r.IsLeader {
+		t.Fatal("not running for leader")
+	}
+	r.handlePrepareReply(&paxosproto.PrepareReply{0, 0, TRUE, e.ballot, []paxosproto.AcceptedInstance{
+		paxosproto.AcceptedInstance{0, FALSE, genericsmrproto.Ballot{0, 1, 2}, []state.Command{put(1, 100)}}}})
+	if !r.IsLeader || r.election != nil || r.leaderId != 1 {
+		t.Fatal("not elected by a quorum")
+	}
+	return r
+}
+
+func TestBecomeLeader(t *testing.T) {
+	r := electedReplica(t)
+	ballot := genericsmrproto.Ballot{0, 2, 1}
+	if r.recoveredUpTo != 2 || r.crtInstance != 3 {
+		t.Fatalf("recovering up to %d", r.recoveredUpTo)
+	}
+
+	// the value of the highest ballot, a no-op for the gap, and our own value
+	want := []state.Value{state.IntValue(100), state.NIL, state.IntValue(3)}
+	for i, v := range want {
+		inst := r.instanceSpace.Get(int32(i))
+		if inst == nil || inst.ballot != ballot || inst.status != PREPARED || inst.cmds == nil {
+			t.Fatalf("instance %d not proposed again", i)
+		}
+		if v == state.NIL && len(inst.cmds) != 0 {
+			t.Fatalf("instance %d proposed again with %d commands instead of a no-op", i, len(inst.cmds))
+		}
+		if v != state.NIL && (len(inst.cmds) != 1 || inst.cmds[0].V != v) {
+			t.Fatalf("instance %d proposed again with %d commands, instead of %d", i, len(inst.cmds), v.Int())
+		}
+	}
+}
+
+func TestProposalsWaitForRecovery(t *testing.T) {
+	r := electedReplica(t)
+	ballot := genericsmrproto.Ballot{0, 2, 1}
+	if r.takesProposals() {
+		t.Fatal("proposals taken before recovery")
+	}
+
+	r.handleAcceptReply(&paxosproto.AcceptReply{0, TRUE, ballot})
+	r.handleAcceptReply(&paxosproto.AcceptReply{2, TRUE, ballot})
+	if r.committedUpTo != 0 || r.takesProposals() {
+		t.Fatalf("proposals taken with instances up to %d committed", r.committedUpTo)
+	}
+
+	r.handleAcceptReply(&paxosproto.AcceptReply{1, TRUE, ballot})
+	if r.committedUpTo != 2 || !r.takesProposals() {
+		t.Fatalf("proposals blocked with instances up to %d committed", r.committedUpTo)
+	}
+}
+
+func TestPrepareRefusedUnderLease(t *testing.T) {
+	r := initReplica(2, 3)
+	r.leases = true
+	sent := r.capture(1)
+
+	// accepting an instance of replica 0 grants it the lease
+	leader := genericsmrproto.InitialBallot(0)
+	r.handleAccept(&paxosproto.Accept{0, 0, leader, []state.Command{put(1, 1)}})
+	if r.grantedTo != 0 || !r.leaseGranted(1) || r.leaseGranted(0) {
+		t.Fatal("lease not granted")
+	}
+
+	candidate := genericsmrproto.Ballot{0, 1, 1}
+	r.handlePrepare(&paxosproto.Prepare{1, 0, candidate, TRUE})
+	if preply := readPrepareReply(t, sent); preply.OK != FALSE {
+		t.Fatal("promised a candidate under the lease of another leader")
+	}
+	if r.leaderId != 0 || r.defaultBallot != leader {
+		t.Fatal("followed a candidate under the lease of another leader")
+	}
+	r.startElection()
+	if r.election != nil {
+		t.Fatal("ran for leader under the lease of another leader")
+	}
+
+	// once the lease has expired
+	r.grantedUntil = time.Now().UnixNano()
+	r.handlePrepare(&paxosproto.Prepare{1, 0, candidate, TRUE})
+	if preply := readPrepareReply(t, sent); preply.OK != TRUE || r.leaderId != 1 {
+		t.Fatal("candidate refused after the lease expired")
+	}
+}
+
+func TestNoLeaseBeforeRecovery(t *testing.T) {
+	r := electedReplica(t)
+	r.leases = true
+	ballot := genericsmrproto.Ballot{0, 2, 1}
+
+	// a quorum has accepted instances we sent, but we have not committed
+	// everything the previous leader may have
+	r.handleAcceptReply(&paxosproto.AcceptReply{0, TRUE, ballot})
+	r.handleAcceptReply(&paxosproto.AcceptReply{2, TRUE, ballot})
+	if r.leaseUntil <= time.Now().UnixNano() {
+		t.Fatal("lease not extended")
+	}
+	if r.holdsLease() {
+		t.Fatal("lease held before recovery")
+	}
+
+	r.handleAcceptReply(&paxosproto.AcceptReply{1, TRUE, ballot})
+	if !r.holdsLease() {
+		t.Fatal("lease not held after recovery")
+	}
+
+	r.stepDown()
+	if r.holdsLease() {
+		t.Fatal("lease held after stepping down")
+	}
+}
+
+func TestLeaseReadsWaitForExecution(t *testing.T) {
+	r := initReplica(0, 3)
+	cmd := put(5, 50)
+	cmd.Execute(r.State)
+	r.execedUpTo = 0
+
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	read := func(id int32, upTo int32) {
+		cmd := state.Command{0, state.NO_CLIENT, state.GET, state.IntKey(5), state.NIL, state.NIL, nil, 0}
+		r.leaseReads <- &leaseRead{&genericsmr.Propose{&genericsmrproto.Propose{id, cmd, 0}, w}, upTo}
+	}
+	read(1, 1)
+	read(2, 3)
+
+	reads := r.serveLeaseReads(nil)
+	if len(reads) != 2 || out.Len() != 0 {
+		t.Fatal("read answered before the instances it follows were executed")
+	}
+
+	r.execedUpTo = 2
+	reads = r.serveLeaseReads(reads)
+	if len(reads) != 1 || reads[0].upTo != 3 {
+		t.Fatalf("%d reads still waiting", len(reads))
+	}
+	reply := new(genericsmrproto.ProposeReplyTS)
+	if err := reply.Unmarshal(&out); err != nil || reply.OK != TRUE || reply.CommandId != 1 || reply.Value.Int() != 50 {
+		t.Fatal("wrong reply to a read")
+	}
+
+	r.execedUpTo = 3
+	if reads = r.serveLeaseReads(reads); len(reads) != 0 || out.Len() == 0 {
+		t.Fatal("read not answered")
+	}
+}
+
+func TestLeaseReadsWaitForAcknowledgedWrites(t *testing.T) {
+	r := initReplica(0, 3)
+	r.lead()
+	r.leases = true
+	r.Exec = true
+
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	r.startInstance([]state.Command{put(1, 10)}, nil)
+	write := put(5, 50)
+	r.startInstance([]state.Command{write}, []*genericsmr.Propose{{&genericsmrproto.Propose{7, write, 0}, w}})
+
+	// a quorum accepts the write, which is acknowledged to the client, while
+	// the instance before it still waits for its quorum
+	r.handleAcceptReply(&paxosproto.AcceptReply{1, TRUE, r.defaultBallot})
+	reply := new(genericsmrproto.ProposeReplyTS)
+	if err := reply.Unmarshal(&out); err != nil || reply.OK != TRUE || reply.CommandId != 7 {
+		t.Fatal("write not acknowledged")
+	}
+	if r.committedUpTo != -1 || !r.holdsLease() {
+		t.Fatalf("committed up to %d", r.committedUpTo)
+	}
+
+	// a read that follows the acknowledgement must see the write
+	read := state.Command{0, state.NO_CLIENT, state.GET, state.IntKey(5), state.NIL, state.NIL, nil, 0}
+	if !r.readWithLease(&genericsmr.Propose{&genericsmrproto.Propose{8, read, 0}, w}) {
+		t.Fatal("read not served under the lease")
+	}
+	reads := r.serveLeaseReads(nil)
+	if len(reads) != 1 || out.Len() != 0 {
+		t.Fatal("read answered before the acknowledged write was executed")
+	}
+
+	r.handleAcceptReply(&paxosproto.AcceptReply{0, TRUE, r.defaultBallot})
+	r.executeCommitted()
+	if reads = r.serveLeaseReads(reads); len(reads) != 0 {
+		t.Fatalf("read still waiting with instances up to %d executed", r.execedUpTo)
+	}
+	if err := reply.Unmarshal(&out); err != nil || reply.CommandId != 8 || reply.Value.Int() != 50 {
+		t.Fatal("read answered without the acknowledged write")
+	}
+}
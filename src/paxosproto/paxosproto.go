@@ -1,6 +1,7 @@
 package paxosproto
 
 import (
+	"genericsmrproto"
 	"state"
 )
 
@@ -11,39 +12,54 @@ const (
 	ACCEPT_REPLY
 	COMMIT
 	COMMIT_SHORT
+	EXEC_STATUS
+	SNAPSHOT_REQUEST
+	SNAPSHOT
 )
 
 type Prepare struct {
 	LeaderId   int32
 	Instance   int32
-	Ballot     int32
+	Ballot     genericsmrproto.Ballot
 	ToInfinity uint8
 }
 
+// Prepares are sent by a replica that runs for leader, for every instance
+// from Instance on. An acceptor that promises the ballot answers with the
+// instances from there on that it has accepted a value for, which the new
+// leader proposes again.
 type PrepareReply struct {
-	Instance int32
-	OK       uint8
-	Ballot   int32
-	Command  []state.Command
+	AcceptorId int32
+	Instance   int32
+	OK         uint8
+	Ballot     genericsmrproto.Ballot // the one prepared if OK, or the higher one promised
+	Instances  []AcceptedInstance
+}
+
+type AcceptedInstance struct {
+	Instance  int32
+	Committed uint8
+	Ballot    genericsmrproto.Ballot
+	Command   []state.Command
 }
 
 type Accept struct {
 	LeaderId int32
 	Instance int32
-	Ballot   int32
+	Ballot   genericsmrproto.Ballot
 	Command  []state.Command
 }
 
 type AcceptReply struct {
 	Instance int32
 	OK       uint8
-	Ballot   int32
+	Ballot   genericsmrproto.Ballot
 }
 
 type Commit struct {
 	LeaderId int32
 	Instance int32
-	Ballot   int32
+	Ballot   genericsmrproto.Ballot
 	Command  []state.Command
 }
 
@@ -51,5 +67,28 @@ type CommitShort struct {
 	LeaderId int32
 	Instance int32
 	Count    int32
-	Ballot   int32
+	Ballot   genericsmrproto.Ballot
+}
+
+// periodically sent to every peer, so that instances that have been
+// executed everywhere can be garbage collected
+type ExecStatus struct {
+	ReplicaId  int32
+	ExecedUpTo int32
+}
+
+// sent by a replica that joins the cluster, or lags too far behind, to a peer
+// that can send it the application state
+type SnapshotRequest struct {
+	ReplicaId int32
+}
+
+// the application state after executing every instance up to ExecedUpTo,
+// plus the instances past that frontier that the sender has committed
+type Snapshot struct {
+	ReplicaId  int32
+	ExecedUpTo int32
+	Config     genericsmrproto.Config // the sender's configuration
+	State      []uint8
+	Committed  []Commit
 }
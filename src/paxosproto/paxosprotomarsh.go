@@ -18,7 +18,7 @@ func (t *Prepare) New() fastrpc.Serializable {
 	return new(Prepare)
 }
 func (t *Prepare) BinarySize() (nbytes int, sizeKnown bool) {
-	return 13, true
+	return 0, false
 }
 
 type PrepareCache struct {
@@ -51,9 +51,9 @@ func (p *PrepareCache) Put(t *Prepare) {
 	p.mu.Unlock()
 }
 func (t *Prepare) Marshal(wire io.Writer) {
-	var b [13]byte
+	var b [8]byte
 	var bs []byte
-	bs = b[:13]
+	bs = b[:8]
 	tmp32 := t.LeaderId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -64,26 +64,30 @@ func (t *Prepare) Marshal(wire io.Writer) {
 	bs[5] = byte(tmp32 >> 8)
 	bs[6] = byte(tmp32 >> 16)
 	bs[7] = byte(tmp32 >> 24)
-	tmp32 = t.Ballot
-	bs[8] = byte(tmp32)
-	bs[9] = byte(tmp32 >> 8)
-	bs[10] = byte(tmp32 >> 16)
-	bs[11] = byte(tmp32 >> 24)
-	bs[12] = byte(t.ToInfinity)
+	wire.Write(bs)
+	t.Ballot.Marshal(wire)
+	bs = b[:1]
+	bs[0] = byte(t.ToInfinity)
 	wire.Write(bs)
 }
 
 func (t *Prepare) Unmarshal(wire io.Reader) error {
-	var b [13]byte
+	var b [8]byte
 	var bs []byte
-	bs = b[:13]
-	if _, err := io.ReadAtLeast(wire, bs, 13); err != nil {
+	bs = b[:8]
+	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
 		return err
 	}
 	t.LeaderId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Instance = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
-	t.Ballot = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
-	t.ToInfinity = uint8(bs[12])
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
+	bs = b[:1]
+	if _, err := io.ReadAtLeast(wire, bs, 1); err != nil {
+		return err
+	}
+	t.ToInfinity = uint8(bs[0])
 	return nil
 }
 
@@ -127,25 +131,26 @@ func (t *PrepareReply) Marshal(wire io.Writer) {
 	var b [10]byte
 	var bs []byte
 	bs = b[:9]
-	tmp32 := t.Instance
+	tmp32 := t.AcceptorId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
 	bs[2] = byte(tmp32 >> 16)
 	bs[3] = byte(tmp32 >> 24)
-	bs[4] = byte(t.OK)
-	tmp32 = t.Ballot
-	bs[5] = byte(tmp32)
-	bs[6] = byte(tmp32 >> 8)
-	bs[7] = byte(tmp32 >> 16)
-	bs[8] = byte(tmp32 >> 24)
+	tmp32 = t.Instance
+	bs[4] = byte(tmp32)
+	bs[5] = byte(tmp32 >> 8)
+	bs[6] = byte(tmp32 >> 16)
+	bs[7] = byte(tmp32 >> 24)
+	bs[8] = byte(t.OK)
 	wire.Write(bs)
+	t.Ballot.Marshal(wire)
 	bs = b[:]
-	alen1 := int64(len(t.Command))
+	alen1 := int64(len(t.Instances))
 	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
 		wire.Write(b[0:wlen])
 	}
 	for i := int64(0); i < alen1; i++ {
-		t.Command[i].Marshal(wire)
+		t.Instances[i].Marshal(wire)
 	}
 }
 
@@ -161,9 +166,66 @@ func (t *PrepareReply) Unmarshal(rr io.Reader) error {
 	if _, err := io.ReadAtLeast(wire, bs, 9); err != nil {
 		return err
 	}
+	t.AcceptorId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Instance = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	t.OK = uint8(bs[8])
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
+	alen1, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Instances = make([]AcceptedInstance, alen1)
+	for i := int64(0); i < alen1; i++ {
+		t.Instances[i].Unmarshal(wire)
+	}
+	return nil
+}
+
+func (t *AcceptedInstance) BinarySize() (nbytes int, sizeKnown bool) {
+	return 0, false
+}
+
+func (t *AcceptedInstance) Marshal(wire io.Writer) {
+	var b [10]byte
+	var bs []byte
+	bs = b[:5]
+	tmp32 := t.Instance
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	bs[4] = byte(t.Committed)
+	wire.Write(bs)
+	t.Ballot.Marshal(wire)
+	bs = b[:]
+	alen1 := int64(len(t.Command))
+	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen1; i++ {
+		t.Command[i].Marshal(wire)
+	}
+}
+
+func (t *AcceptedInstance) Unmarshal(rr io.Reader) error {
+	var wire byteReader
+	var ok bool
+	if wire, ok = rr.(byteReader); !ok {
+		wire = bufio.NewReader(rr)
+	}
+	var b [10]byte
+	var bs []byte
+	bs = b[:5]
+	if _, err := io.ReadAtLeast(wire, bs, 5); err != nil {
+		return err
+	}
 	t.Instance = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
-	t.OK = uint8(bs[4])
-	t.Ballot = int32((uint32(bs[5]) | (uint32(bs[6]) << 8) | (uint32(bs[7]) << 16) | (uint32(bs[8]) << 24)))
+	t.Committed = uint8(bs[4])
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
 	alen1, err := binary.ReadVarint(wire)
 	if err != nil {
 		return err
@@ -212,9 +274,9 @@ func (p *AcceptCache) Put(t *Accept) {
 	p.mu.Unlock()
 }
 func (t *Accept) Marshal(wire io.Writer) {
-	var b [12]byte
+	var b [10]byte
 	var bs []byte
-	bs = b[:12]
+	bs = b[:8]
 	tmp32 := t.LeaderId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -225,12 +287,8 @@ func (t *Accept) Marshal(wire io.Writer) {
 	bs[5] = byte(tmp32 >> 8)
 	bs[6] = byte(tmp32 >> 16)
 	bs[7] = byte(tmp32 >> 24)
-	tmp32 = t.Ballot
-	bs[8] = byte(tmp32)
-	bs[9] = byte(tmp32 >> 8)
-	bs[10] = byte(tmp32 >> 16)
-	bs[11] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	t.Ballot.Marshal(wire)
 	bs = b[:]
 	alen1 := int64(len(t.Command))
 	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
@@ -247,15 +305,17 @@ func (t *Accept) Unmarshal(rr io.Reader) error {
 	if wire, ok = rr.(byteReader); !ok {
 		wire = bufio.NewReader(rr)
 	}
-	var b [12]byte
+	var b [10]byte
 	var bs []byte
-	bs = b[:12]
-	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
+	bs = b[:8]
+	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
 		return err
 	}
 	t.LeaderId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Instance = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
-	t.Ballot = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
 	alen1, err := binary.ReadVarint(wire)
 	if err != nil {
 		return err
@@ -271,7 +331,7 @@ func (t *AcceptReply) New() fastrpc.Serializable {
 	return new(AcceptReply)
 }
 func (t *AcceptReply) BinarySize() (nbytes int, sizeKnown bool) {
-	return 9, true
+	return 0, false
 }
 
 type AcceptReplyCache struct {
@@ -304,33 +364,31 @@ func (p *AcceptReplyCache) Put(t *AcceptReply) {
 	p.mu.Unlock()
 }
 func (t *AcceptReply) Marshal(wire io.Writer) {
-	var b [9]byte
+	var b [5]byte
 	var bs []byte
-	bs = b[:9]
+	bs = b[:5]
 	tmp32 := t.Instance
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
 	bs[2] = byte(tmp32 >> 16)
 	bs[3] = byte(tmp32 >> 24)
 	bs[4] = byte(t.OK)
-	tmp32 = t.Ballot
-	bs[5] = byte(tmp32)
-	bs[6] = byte(tmp32 >> 8)
-	bs[7] = byte(tmp32 >> 16)
-	bs[8] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	t.Ballot.Marshal(wire)
 }
 
 func (t *AcceptReply) Unmarshal(wire io.Reader) error {
-	var b [9]byte
+	var b [5]byte
 	var bs []byte
-	bs = b[:9]
-	if _, err := io.ReadAtLeast(wire, bs, 9); err != nil {
+	bs = b[:5]
+	if _, err := io.ReadAtLeast(wire, bs, 5); err != nil {
 		return err
 	}
 	t.Instance = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.OK = uint8(bs[4])
-	t.Ballot = int32((uint32(bs[5]) | (uint32(bs[6]) << 8) | (uint32(bs[7]) << 16) | (uint32(bs[8]) << 24)))
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -371,9 +429,9 @@ func (p *CommitCache) Put(t *Commit) {
 	p.mu.Unlock()
 }
 func (t *Commit) Marshal(wire io.Writer) {
-	var b [12]byte
+	var b [10]byte
 	var bs []byte
-	bs = b[:12]
+	bs = b[:8]
 	tmp32 := t.LeaderId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -384,12 +442,8 @@ func (t *Commit) Marshal(wire io.Writer) {
 	bs[5] = byte(tmp32 >> 8)
 	bs[6] = byte(tmp32 >> 16)
 	bs[7] = byte(tmp32 >> 24)
-	tmp32 = t.Ballot
-	bs[8] = byte(tmp32)
-	bs[9] = byte(tmp32 >> 8)
-	bs[10] = byte(tmp32 >> 16)
-	bs[11] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	t.Ballot.Marshal(wire)
 	bs = b[:]
 	alen1 := int64(len(t.Command))
 	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
@@ -406,15 +460,17 @@ func (t *Commit) Unmarshal(rr io.Reader) error {
 	if wire, ok = rr.(byteReader); !ok {
 		wire = bufio.NewReader(rr)
 	}
-	var b [12]byte
+	var b [10]byte
 	var bs []byte
-	bs = b[:12]
-	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
+	bs = b[:8]
+	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
 		return err
 	}
 	t.LeaderId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Instance = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
-	t.Ballot = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
 	alen1, err := binary.ReadVarint(wire)
 	if err != nil {
 		return err
@@ -430,7 +486,7 @@ func (t *CommitShort) New() fastrpc.Serializable {
 	return new(CommitShort)
 }
 func (t *CommitShort) BinarySize() (nbytes int, sizeKnown bool) {
-	return 16, true
+	return 0, false
 }
 
 type CommitShortCache struct {
@@ -463,9 +519,9 @@ func (p *CommitShortCache) Put(t *CommitShort) {
 	p.mu.Unlock()
 }
 func (t *CommitShort) Marshal(wire io.Writer) {
-	var b [16]byte
+	var b [12]byte
 	var bs []byte
-	bs = b[:16]
+	bs = b[:12]
 	tmp32 := t.LeaderId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -481,24 +537,259 @@ func (t *CommitShort) Marshal(wire io.Writer) {
 	bs[9] = byte(tmp32 >> 8)
 	bs[10] = byte(tmp32 >> 16)
 	bs[11] = byte(tmp32 >> 24)
-	tmp32 = t.Ballot
-	bs[12] = byte(tmp32)
-	bs[13] = byte(tmp32 >> 8)
-	bs[14] = byte(tmp32 >> 16)
-	bs[15] = byte(tmp32 >> 24)
 	wire.Write(bs)
+	t.Ballot.Marshal(wire)
 }
 
 func (t *CommitShort) Unmarshal(wire io.Reader) error {
-	var b [16]byte
+	var b [12]byte
 	var bs []byte
-	bs = b[:16]
-	if _, err := io.ReadAtLeast(wire, bs, 16); err != nil {
+	bs = b[:12]
+	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
 		return err
 	}
 	t.LeaderId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Instance = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
 	t.Count = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
-	t.Ballot = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
+	if err := t.Ballot.Unmarshal(wire); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *ExecStatus) BinarySize() (nbytes int, sizeKnown bool) {
+	return 8, true
+}
+
+type ExecStatusCache struct {
+	mu    sync.Mutex
+	cache []*ExecStatus
+}
+
+func NewExecStatusCache() *ExecStatusCache {
+	c := &ExecStatusCache{}
+	c.cache = make([]*ExecStatus, 0)
+	return c
+}
+
+func (p *ExecStatusCache) Get() *ExecStatus {
+	var t *ExecStatus
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &ExecStatus{}
+	}
+	return t
+}
+
+func (p *ExecStatusCache) Put(t *ExecStatus) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+
+func (p *ExecStatus) New() fastrpc.Serializable {
+	return new(ExecStatus)
+}
+
+func (t *ExecStatus) Marshal(wire io.Writer) {
+	var b [8]byte
+	var bs []byte
+	bs = b[:8]
+	tmp32 := t.ReplicaId
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	tmp32 = t.ExecedUpTo
+	bs[4] = byte(tmp32)
+	bs[5] = byte(tmp32 >> 8)
+	bs[6] = byte(tmp32 >> 16)
+	bs[7] = byte(tmp32 >> 24)
+	wire.Write(bs)
+}
+
+func (t *ExecStatus) Unmarshal(wire io.Reader) error {
+	var b [8]byte
+	var bs []byte
+	bs = b[:8]
+	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
+		return err
+	}
+	t.ReplicaId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.ExecedUpTo = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	return nil
+}
+
+func (t *SnapshotRequest) BinarySize() (nbytes int, sizeKnown bool) {
+	return 4, true
+}
+
+type SnapshotRequestCache struct {
+	mu    sync.Mutex
+	cache []*SnapshotRequest
+}
+
+func NewSnapshotRequestCache() *SnapshotRequestCache {
+	c := &SnapshotRequestCache{}
+	c.cache = make([]*SnapshotRequest, 0)
+	return c
+}
+
+func (p *SnapshotRequestCache) Get() *SnapshotRequest {
+	var t *SnapshotRequest
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &SnapshotRequest{}
+	}
+	return t
+}
+
+func (p *SnapshotRequestCache) Put(t *SnapshotRequest) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+
+func (p *SnapshotRequest) New() fastrpc.Serializable {
+	return new(SnapshotRequest)
+}
+
+func (t *SnapshotRequest) Marshal(wire io.Writer) {
+	var b [4]byte
+	var bs []byte
+	bs = b[:4]
+	tmp32 := t.ReplicaId
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	wire.Write(bs)
+}
+
+func (t *SnapshotRequest) Unmarshal(wire io.Reader) error {
+	var b [4]byte
+	var bs []byte
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+		return err
+	}
+	t.ReplicaId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	return nil
+}
+
+func (t *Snapshot) BinarySize() (nbytes int, sizeKnown bool) {
+	return 0, false
+}
+
+type SnapshotCache struct {
+	mu    sync.Mutex
+	cache []*Snapshot
+}
+
+func NewSnapshotCache() *SnapshotCache {
+	c := &SnapshotCache{}
+	c.cache = make([]*Snapshot, 0)
+	return c
+}
+
+func (p *SnapshotCache) Get() *Snapshot {
+	var t *Snapshot
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &Snapshot{}
+	}
+	return t
+}
+
+func (p *SnapshotCache) Put(t *Snapshot) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+
+func (p *Snapshot) New() fastrpc.Serializable {
+	return new(Snapshot)
+}
+
+func (t *Snapshot) Marshal(wire io.Writer) {
+	var b [10]byte
+	var bs []byte
+	bs = b[:8]
+	tmp32 := t.ReplicaId
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	tmp32 = t.ExecedUpTo
+	bs[4] = byte(tmp32)
+	bs[5] = byte(tmp32 >> 8)
+	bs[6] = byte(tmp32 >> 16)
+	bs[7] = byte(tmp32 >> 24)
+	wire.Write(bs)
+	t.Config.Marshal(wire)
+	bs = b[:]
+	alen1 := int64(len(t.State))
+	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	wire.Write(t.State)
+	bs = b[:]
+	alen2 := int64(len(t.Committed))
+	if wlen := binary.PutVarint(bs, alen2); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen2; i++ {
+		t.Committed[i].Marshal(wire)
+	}
+}
+
+func (t *Snapshot) Unmarshal(rr io.Reader) error {
+	var wire byteReader
+	var ok bool
+	if wire, ok = rr.(byteReader); !ok {
+		wire = bufio.NewReader(rr)
+	}
+	var b [10]byte
+	var bs []byte
+	bs = b[:8]
+	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
+		return err
+	}
+	t.ReplicaId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.ExecedUpTo = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	if err := t.Config.Unmarshal(wire); err != nil {
+		return err
+	}
+	alen1, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.State = make([]uint8, alen1)
+	if _, err := io.ReadFull(wire, t.State); err != nil {
+		return err
+	}
+	alen2, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Committed = make([]Commit, alen2)
+	for i := int64(0); i < alen2; i++ {
+		t.Committed[i].Unmarshal(wire)
+	}
 	return nil
 }
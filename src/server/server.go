@@ -4,6 +4,7 @@ import (
 	"epaxos"
 	"flag"
 	"fmt"
+	"genericsmr"
 	"gpaxos"
 	"log"
 	"masterproto"
@@ -21,7 +22,7 @@ import (
 )
 
 var portnum *int = flag.Int("port", 7070, "Port # to listen on. Defaults to 7070")
-var masterAddr *string = flag.String("maddr", "", "Master address. Defaults to localhost.")
+var masterAddr *string = flag.String("maddr", "", "Comma-separated master addresses (addr, or addr:port for another port than -mport), tried in turn. Defaults to localhost.")
 var masterPort *int = flag.Int("mport", 7087, "Master port.  Defaults to 7087.")
 var myAddr *string = flag.String("addr", "", "Server address (this machine). Defaults to localhost.")
 var doMencius *bool = flag.Bool("m", false, "Use Mencius as the replication protocol. Defaults to false.")
@@ -34,7 +35,18 @@ var exec = flag.Bool("exec", false, "Execute commands.")
 var dreply = flag.Bool("dreply", false, "Reply to client only after command has been executed.")
 var beacon = flag.Bool("beacon", false, "Send beacons to other replicas to compare their relative speeds.")
 var durable = flag.Bool("durable", false, "Log to a stable store (i.e., a file in the current dir).")
-var app *int = flag.Int("app", 0, "Application state: 0->Default, 1->Inventory, 2->Facebook")
+var app = flag.String("app", "default", fmt.Sprintf("Application state machine, one of %v.", state.Machines()))
+var checkpoint = flag.Bool("cp", false, "Take periodic checkpoints of the application state and truncate the durable log accordingly (EPaxos only).")
+var cpPeriod *int = flag.Int("cpperiod", epaxos.CHECKPOINT_PERIOD, "Number of commands between checkpoints.")
+var batchSize *int = flag.Int("batch", epaxos.MAX_BATCH, "Maximum number of commands per EPaxos instance.")
+var batchDelay = flag.Duration("batchdelay", 0, "Time during which client commands accumulate for the next EPaxos instance. Defaults to no waiting.")
+var adaptiveBatching = flag.Bool("adaptive", false, "Grow EPaxos batches under load and shrink them when idle, up to -batch commands.")
+var execWorkers *int = flag.Int("execworkers", 0, "Number of goroutines handling non-conflicting EPaxos SCCs in parallel; the commands themselves are still applied one at a time. Defaults to 0 (execute on a single goroutine).")
+var readLeases = flag.Bool("leases", false, "Let a majority of the EPaxos replicas hold read leases, and answer FAST_READs locally, or the classic Paxos leader hold a lease, and answer reads locally.")
+var heartbeat = flag.Duration("heartbeat", 0, "Time between heartbeats to the other replicas. Defaults to a quarter of -suspect.")
+var join = flag.Bool("join", false, "Join a running cluster as a new replica, once the master has added it to the configuration (classic Paxos only).")
+var replace *int = flag.Int("replace", -1, "With -join, the id of a replica to remove from the configuration in the same change, e.g. one whose machine has failed.")
+var suspect = flag.Duration("suspect", 0, "Time without hearing from a replica before it is suspected to have failed, and the recovery of its EPaxos instances starts, or classic Paxos replicas elect another leader if it was the leader. Defaults to 0 (never suspect replicas).")
 
 func main() {
 	flag.Parse()
@@ -55,27 +67,36 @@ func main() {
 
 	log.Printf("Server starting on port %d\n", *portnum)
 
-	replicaId, nodeList := registerWithMaster(fmt.Sprintf("%s:%d", *masterAddr, *masterPort))
+	if *join && (*doEpaxos || *doMencius || *doGpaxos) {
+		log.Fatal("Only classic Paxos replicas can join a running cluster")
+	}
+
+	replicaId, nodeList := registerWithMaster(masterproto.NewClient(*masterAddr, *masterPort))
 
-	if *app > 2 {
-		*app = 0
+	machine, err := state.NewMachine(*app)
+	if err != nil {
+		log.Fatal(err)
 	}
+	log.Printf("Using the %s application\n", *app)
 
 	if *doEpaxos {
 		log.Println("Starting Egalitarian Paxos replica...")
-		rep := epaxos.NewReplica(replicaId, nodeList, *thrifty, *exec, *dreply, *beacon, *durable, state.Application(*app))
+		if !*checkpoint {
+			*cpPeriod = 0
+		}
+		rep := epaxos.NewReplica(replicaId, nodeList, *thrifty, *exec, *dreply, *beacon, *durable, *cpPeriod, epaxos.BatchConfig{*batchSize, *batchDelay, *adaptiveBatching}, *execWorkers, *readLeases, genericsmr.FailureDetectorConfig{*heartbeat, *suspect}, machine)
 		rpc.Register(rep)
 	} else if *doMencius {
 		log.Println("Starting Mencius replica...")
-		rep := mencius.NewReplica(replicaId, nodeList, *thrifty, *exec, *dreply, *durable, state.Application(*app))
+		rep := mencius.NewReplica(replicaId, nodeList, *thrifty, *exec, *dreply, *durable, machine)
 		rpc.Register(rep)
 	} else if *doGpaxos {
 		log.Println("Starting Generalized Paxos replica...")
-		rep := gpaxos.NewReplica(replicaId, nodeList, *thrifty, *exec, *dreply, state.Application(*app))
+		rep := gpaxos.NewReplica(replicaId, nodeList, *thrifty, *exec, *dreply, machine)
 		rpc.Register(rep)
 	} else {
 		log.Println("Starting classic Paxos replica...")
-		rep := paxos.NewReplica(replicaId, nodeList, *thrifty, *exec, *dreply, *durable, state.Application(*app))
+		rep := paxos.NewReplica(replicaId, nodeList, *thrifty, *exec, *dreply, *durable, *join, false, *readLeases, genericsmr.FailureDetectorConfig{*heartbeat, *suspect}, machine)
 		rpc.Register(rep)
 	}
 
@@ -89,21 +110,22 @@ func main() {
 	http.Serve(l, nil)
 }
 
-func registerWithMaster(masterAddr string) (int, []string) {
-	args := &masterproto.RegisterArgs{*myAddr, *portnum}
+func registerWithMaster(master *masterproto.Client) (int, []string) {
+	args := &masterproto.RegisterArgs{*myAddr, *portnum, *join, *replace}
 	var reply masterproto.RegisterReply
 
 	for done := false; !done; {
-		mcli, err := rpc.DialHTTP("tcp", masterAddr)
-		if err == nil {
-			err = mcli.Call("Master.Register", args, &reply)
-			if err == nil && reply.Ready == true {
-				done = true
-				break
-			}
+		err := master.Call("Master.Register", args, &reply)
+		if err == nil && reply.Ready == true {
+			done = true
+			break
+		}
+		if _, ok := err.(rpc.ServerError); ok {
+			log.Println("Could not register with the master:", err)
 		}
 		time.Sleep(1e9)
 	}
+	master.Close()
 
 	return reply.ReplicaId, reply.NodeList
 }
@@ -0,0 +1,124 @@
+package state
+
+// The conflict relation of an application, declared as a table: the
+// operations of the application, and the pairs of them that do not commute
+// when they touch a common key. A pair may be refined by a predicate on the
+// two commands, for operations that only conflict for some values. The
+// relation is symmetric, so every pair is declared once.
+//
+// Protocols that track dependencies per operation (e.g. EPaxos) take the
+// operations to index from Ops, so an operation cannot be declared without
+// being tracked.
+type ConflictTable struct {
+	ops   []Operation
+	known map[Operation]bool
+	pairs map[[2]Operation]ConflictPredicate // nil for pairs that always conflict
+}
+
+// Tells whether two commands with conflicting operations on a common key
+// really conflict. It is given the commands in the order of the operations
+// of the declared pair.
+type ConflictPredicate func(gamma *Command, delta *Command) bool
+
+func NewConflictTable(ops ...Operation) *ConflictTable {
+	t := &ConflictTable{make([]Operation, 0, len(ops)), make(map[Operation]bool), make(map[[2]Operation]ConflictPredicate)}
+	for _, op := range ops {
+		if !t.known[op] {
+			t.known[op] = true
+			t.ops = append(t.ops, op)
+		}
+	}
+	return t
+}
+
+// declares that op1 and op2 always conflict
+func (t *ConflictTable) Conflict(op1 Operation, op2 Operation) *ConflictTable {
+	return t.ConflictIf(op1, op2, nil)
+}
+
+// declares that op1 and op2 conflict when pred holds (always if pred is nil)
+func (t *ConflictTable) ConflictIf(op1 Operation, op2 Operation, pred ConflictPredicate) *ConflictTable {
+	if !t.known[op1] || !t.known[op2] {
+		panic("state: conflict declared for an operation missing from the table")
+	}
+	t.pairs[[2]Operation{op1, op2}] = pred
+	if pred == nil {
+		t.pairs[[2]Operation{op2, op1}] = nil
+	} else {
+		t.pairs[[2]Operation{op2, op1}] = func(gamma *Command, delta *Command) bool {
+			return pred(delta, gamma)
+		}
+	}
+	return t
+}
+
+// declares that op conflicts with every operation in the table (itself
+// included), except the given ones
+func (t *ConflictTable) ConflictWithAll(op Operation, except ...Operation) *ConflictTable {
+	for _, other := range t.ops {
+		excepted := false
+		for _, e := range except {
+			excepted = excepted || other == e
+		}
+		if !excepted {
+			t.Conflict(op, other)
+		}
+	}
+	return t
+}
+
+// the operations of the application, in the order they were declared
+func (t *ConflictTable) Ops() []Operation {
+	return t.ops
+}
+
+// Tells whether commands with these operations may conflict, i.e. do
+// conflict for some values.
+func (t *ConflictTable) OperationConflict(op1 Operation, op2 Operation) bool {
+	_, present := t.pairs[[2]Operation{op1, op2}]
+	return present
+}
+
+// tells whether two commands that touch a common key conflict
+func (t *ConflictTable) CommandConflict(gamma *Command, delta *Command) bool {
+	pred, present := t.pairs[[2]Operation{gamma.Op, delta.Op}]
+	return present && (pred == nil || pred(gamma, delta))
+}
+
+/* The tables of the built-in applications */
+
+// Only reads commute. Conditional updates read the key before they update
+// it, so they conflict with every other operation, as PUT does; a failed one
+// still has to be ordered with respect to the update that made it fail. Two
+// PUTs of the same value leave the same state in either order.
+var defaultConflicts = func() *ConflictTable {
+	t := NewConflictTable(NONE, PUT, GET, INCREMENT, READ, FAST_READ, LIKE, CREATE, POST,
+		TRANSFER, CAS, PUT_IF_ABSENT, PUT_IF_VERSION, GET_VERSION, SCAN)
+	for _, op := range t.Ops() {
+		if !IsRead(op) {
+			t.ConflictWithAll(op)
+		}
+	}
+	return t.ConflictIf(PUT, PUT, func(gamma *Command, delta *Command) bool {
+		return gamma.V != delta.V
+	})
+}()
+
+// Increments commute with one another, and FAST_READs are not ordered at
+// all. A TRANSFER reads the balance it moves, so it is ordered with
+// everything else.
+var inventoryConflicts = NewConflictTable(CREATE, INCREMENT, TRANSFER, READ, FAST_READ, SCAN).
+	Conflict(READ, INCREMENT).
+	Conflict(READ, CREATE).
+	Conflict(INCREMENT, CREATE).
+	Conflict(CREATE, CREATE).
+	Conflict(SCAN, INCREMENT).
+	Conflict(SCAN, CREATE).
+	ConflictWithAll(TRANSFER, FAST_READ)
+
+// Likes commute with one another, and FAST_READs are not ordered at all.
+var facebookConflicts = NewConflictTable(POST, LIKE, READ, FAST_READ).
+	Conflict(READ, LIKE).
+	Conflict(READ, POST).
+	Conflict(LIKE, POST).
+	Conflict(POST, POST)
@@ -0,0 +1,19 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestConflictTable(t *testing.T) {
+	st := NewState(DefaultMachine{})
+	put := func(v int64) *Command {
+		return &Command{0, NO_CLIENT, PUT, IntKey(1), IntValue(v), NIL, nil, 0}
+	}
+	if st.Conflict(put(1), put(1)) || !st.Conflict(put(1), put(2)) || !st.OperationConflict(PUT, PUT) {
+		t.Fatal("PUTs of the same value do not commute")
+	}
+	cas := &Command{0, NO_CLIENT, CAS, IntKey(1), IntValue(1), NIL, nil, 0}
+	if !st.Conflict(cas, put(1)) || !st.Conflict(put(1), cas) {
+		t.Fatal("the conflict relation is not symmetric")
+	}
+}
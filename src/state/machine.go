@@ -0,0 +1,114 @@
+package state
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// The application that the replicas run. Every State has its own machine, so
+// that several applications can run in one process; a machine that keeps its
+// own data (rather than in the maps of the State) must not be shared between
+// states.
+type StateMachine interface {
+	// applies the command to the state and returns its result
+	Execute(c *Command, st *State) Value
+	// the commands that must be executed in the same order everywhere, when
+	// they touch a common key
+	Conflicts() *ConflictTable
+	// serializes the application data, e.g. for a checkpoint (the client
+	// sessions are taken care of by the State)
+	Snapshot(st *State, w io.Writer)
+	// replaces the application data with one serialized by Snapshot
+	Restore(st *State, r io.Reader) error
+}
+
+/* Registry */
+
+var machinesMu sync.Mutex
+var machines = make(map[string]func() StateMachine)
+
+// Makes a state machine available by name, e.g. to the -app flag of the
+// server. Meant to be called from the init function of the package that
+// implements it; registering a name twice panics.
+func Register(name string, newMachine func() StateMachine) {
+	machinesMu.Lock()
+	defer machinesMu.Unlock()
+	if _, dup := machines[name]; dup {
+		panic("state: Register called twice for " + name)
+	}
+	machines[name] = newMachine
+}
+
+// a new instance of the state machine registered under name
+func NewMachine(name string) (StateMachine, error) {
+	machinesMu.Lock()
+	newMachine, present := machines[name]
+	machinesMu.Unlock()
+	if !present {
+		return nil, fmt.Errorf("unknown application %q (known: %v)", name, Machines())
+	}
+	return newMachine(), nil
+}
+
+// the names of the registered state machines, in order
+func Machines() []string {
+	machinesMu.Lock()
+	defer machinesMu.Unlock()
+	names := make([]string, 0, len(machines))
+	for name := range machines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register("default", func() StateMachine { return DefaultMachine{} })
+	Register("inventory", func() StateMachine { return InventoryMachine{} })
+	Register("facebook", func() StateMachine { return FacebookMachine{} })
+}
+
+/* Built-in machines */
+
+// the snapshot of the machines that keep their data in the maps of the State
+type mapsSnapshot struct{}
+
+func (mapsSnapshot) Snapshot(st *State, w io.Writer) {
+	marshalMaps(st, w)
+}
+
+func (mapsSnapshot) Restore(st *State, r io.Reader) error {
+	return unmarshalMaps(st, r)
+}
+
+type DefaultMachine struct{ mapsSnapshot }
+
+func (DefaultMachine) Execute(c *Command, st *State) Value {
+	return DefaultExecute(c, st)
+}
+
+func (DefaultMachine) Conflicts() *ConflictTable {
+	return defaultConflicts
+}
+
+type InventoryMachine struct{ mapsSnapshot }
+
+func (InventoryMachine) Execute(c *Command, st *State) Value {
+	return InventoryExecute(c, st)
+}
+
+func (InventoryMachine) Conflicts() *ConflictTable {
+	return inventoryConflicts
+}
+
+type FacebookMachine struct{ mapsSnapshot }
+
+func (FacebookMachine) Execute(c *Command, st *State) Value {
+	return FacebookExecute(c, st)
+}
+
+func (FacebookMachine) Conflicts() *ConflictTable {
+	return facebookConflicts
+}
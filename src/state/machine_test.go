@@ -0,0 +1,31 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestStateMachineRegistry(t *testing.T) {
+	if _, err := NewMachine("no-such-app"); err == nil {
+		t.Fatal("unknown application accepted")
+	}
+	m, err := NewMachine("inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two applications side by side
+	inv := NewState(m)
+	kv := NewState(DefaultMachine{})
+	incr := Command{0, NO_CLIENT, INCREMENT, IntKey(1), IntValue(3), NIL, nil, 0}
+	read := Command{0, NO_CLIENT, READ, IntKey(1), NIL, NIL, nil, 0}
+	incr.Execute(inv)
+	incr.Execute(kv)
+	if inv.Store[IntKey(1)].Int() != 3 || len(kv.Store) != 0 {
+		t.Fatal("the applications share their behavior")
+	}
+	put := Command{0, NO_CLIENT, PUT, IntKey(1), NIL, NIL, nil, 0}
+	get := Command{0, NO_CLIENT, GET, IntKey(1), NIL, NIL, nil, 0}
+	if !inv.Conflict(&incr, &read) || inv.Conflict(&put, &get) || !kv.Conflict(&put, &get) {
+		t.Fatal("the applications share their conflict relation")
+	}
+}
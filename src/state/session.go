@@ -0,0 +1,135 @@
+package state
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+)
+
+// Commands that carry a ClientId are executed at most once: the state machine
+// remembers the result of every command it has executed for a client, and a
+// retried command is answered with the remembered result instead of being
+// applied again. Since the sessions are part of the State, every replica
+// makes the same decision, and they are carried over by snapshots.
+//
+// A client tells, in the Acked field of every command, the lowest CommandId
+// it still waits for. The results of its commands below that are dropped,
+// so the results a session keeps are bounded by the commands its client has
+// outstanding. Acked is only used to trim results, never to decide whether
+// a command is executed: EPaxos may execute a client's command 6 (Acked 6)
+// before its command 5 on some replicas and after it on others, and every
+// replica must still apply both. A copy of an acknowledged command that is
+// still in flight is therefore applied again, so a client must only
+// acknowledge the commands it has stopped retrying.
+//
+// Clients that do not acknowledge (Acked 0) fall back to a sliding window:
+// results are kept for the latest SESSION_WINDOW command ids of the client,
+// which must be larger than the number of commands it ever has outstanding.
+// A retry of an older command is applied again.
+//
+// Sessions expire once SESSION_IDLE commands have been executed in other
+// sessions since their client was last heard from. The clock is the number
+// of commands executed, not the time, so that every replica expires the same
+// sessions (up to the order of concurrent commands, which is far smaller
+// than SESSION_IDLE). A client that comes back after its session expired
+// gets a new one, and its retries of older commands are applied again.
+
+const NO_CLIENT int32 = 0
+
+const SESSION_WINDOW = 1 << 16
+
+const SESSION_IDLE = 1 << 20
+
+// the expired sessions are looked for every SESSION_SWEEP commands
+const SESSION_SWEEP = SESSION_IDLE / 4
+
+type Session struct {
+	Results  map[int32]Value // by CommandId
+	Highest  int32           // highest CommandId executed for the client
+	Acked    int32           // the client has the results of all the commands below this one
+	LastUsed int64           // the State's SessionClock when the client was last heard from
+}
+
+// Picks a random id for a client that wants exactly-once semantics. The ids
+// come from crypto/rand, since clients started at the same time would
+// otherwise be likely to get the same one.
+func NewClientId() int32 {
+	var b [4]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		if id := int32(binary.LittleEndian.Uint32(b[:]) & 0x7fffffff); id != NO_CLIENT {
+			return id
+		}
+	}
+}
+
+func NewSession() *Session {
+	return &Session{make(map[int32]Value), -1, 0, 0}
+}
+
+func (st *State) executeInSession(c *Command) Value {
+	st.SessionClock++
+	s, present := st.Sessions[c.ClientId]
+	if !present {
+		s = NewSession()
+		st.Sessions[c.ClientId] = s
+	}
+	s.LastUsed = st.SessionClock
+
+	val, dup := s.Results[c.CommandId]
+	if !dup {
+		val = st.Machine.Execute(c, st)
+		s.record(c.CommandId, val)
+	}
+	s.ack(c.Acked)
+
+	if st.SessionClock%SESSION_SWEEP == 0 {
+		st.expireSessions()
+	}
+	return val
+}
+
+func (st *State) expireSessions() {
+	for client, s := range st.Sessions {
+		if st.SessionClock-s.LastUsed >= SESSION_IDLE {
+			delete(st.Sessions, client)
+		}
+	}
+}
+
+func (s *Session) record(id int32, val Value) {
+	if id > s.Highest {
+		if int64(id)-int64(s.Highest) >= SESSION_WINDOW {
+			s.Results = make(map[int32]Value)
+		} else {
+			for old := s.Highest - SESSION_WINDOW + 1; old <= id-SESSION_WINDOW; old++ {
+				delete(s.Results, old)
+			}
+		}
+		s.Highest = id
+	}
+	// the client does not wait for the result of an acknowledged command
+	if id > s.Highest-SESSION_WINDOW && id >= s.Acked {
+		s.Results[id] = val
+	}
+}
+
+// drops the results of the commands below acked
+func (s *Session) ack(acked int32) {
+	if acked <= s.Acked {
+		return
+	}
+	if int64(acked)-int64(s.Acked) <= int64(len(s.Results)) {
+		for id := s.Acked; id < acked; id++ {
+			delete(s.Results, id)
+		}
+	} else {
+		for id := range s.Results {
+			if id < acked {
+				delete(s.Results, id)
+			}
+		}
+	}
+	s.Acked = acked
+}
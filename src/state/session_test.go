@@ -0,0 +1,125 @@
+package state
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSessionAck(t *testing.T) {
+	st := NewState(InventoryMachine{})
+	incr := func(id int32, acked int32) Value {
+		cmd := Command{id, 42, INCREMENT, IntKey(1), IntValue(1), NIL, nil, acked}
+		return cmd.Execute(st)
+	}
+
+	for id := int32(0); id < 10; id++ {
+		incr(id, 0)
+	}
+	if len(st.Sessions[42].Results) != 10 {
+		t.Fatalf("%d results kept, expected 10", len(st.Sessions[42].Results))
+	}
+
+	// the client has the results of the commands below 8
+	incr(10, 8)
+	if s := st.Sessions[42]; len(s.Results) != 3 || s.Acked != 8 {
+		t.Fatalf("%d results kept up to %d, expected 3 up to 8", len(s.Results), s.Acked)
+	}
+
+	// Acked only trims results: a stale copy of an acknowledged command is
+	// applied again, and its result is not kept
+	incr(3, 0)
+	if s := st.Sessions[42]; st.Store[IntKey(1)].Int() != 12 || len(s.Results) != 3 {
+		t.Fatalf("stale command: store %d, %d results kept", st.Store[IntKey(1)].Int(), len(s.Results))
+	}
+	// and a retry of an outstanding one is answered from the session
+	if val := incr(9, 8); val.Int() != 10 || st.Store[IntKey(1)].Int() != 12 {
+		t.Fatalf("retried command applied again: %d %d", val.Int(), st.Store[IntKey(1)].Int())
+	}
+
+	var buf bytes.Buffer
+	st.Marshal(&buf)
+	restored := NewState(InventoryMachine{})
+	if err := restored.Unmarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if s := restored.Sessions[42]; s.Acked != 8 || len(s.Results) != 3 {
+		t.Fatal("acknowledgement lost by the snapshot")
+	}
+}
+
+// Replicas may execute a client's commands in different orders, and must
+// still end up in the same state.
+func TestSessionAckReordered(t *testing.T) {
+	five := Command{5, 42, INCREMENT, IntKey(1), IntValue(1), NIL, nil, 5}
+	six := Command{6, 42, INCREMENT, IntKey(2), IntValue(1), NIL, nil, 6}
+
+	a := NewState(InventoryMachine{})
+	five.Execute(a)
+	six.Execute(a)
+	b := NewState(InventoryMachine{})
+	six.Execute(b)
+	five.Execute(b)
+
+	for _, st := range []*State{a, b} {
+		if st.Store[IntKey(1)].Int() != 1 || st.Store[IntKey(2)].Int() != 1 {
+			t.Fatalf("command skipped: %v", st.Store)
+		}
+		if s := st.Sessions[42]; s.Acked != 6 || len(s.Results) != 1 || s.Highest != 6 {
+			t.Fatalf("session %+v", s)
+		}
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	st := NewState(InventoryMachine{})
+	(&Command{0, 7, INCREMENT, IntKey(1), IntValue(1), NIL, nil, 0}).Execute(st)
+	(&Command{0, 8, INCREMENT, IntKey(1), IntValue(1), NIL, nil, 0}).Execute(st)
+
+	// client 8 keeps sending commands, client 7 has gone away
+	for id := int32(1); int64(id) < SESSION_IDLE+SESSION_SWEEP; id++ {
+		(&Command{id, 8, INCREMENT, IntKey(1), IntValue(1), NIL, nil, id}).Execute(st)
+	}
+	if _, present := st.Sessions[7]; present {
+		t.Fatal("idle session not expired")
+	}
+	if s, present := st.Sessions[8]; !present || len(s.Results) != 1 {
+		t.Fatal("active session expired or not trimmed")
+	}
+
+	var buf bytes.Buffer
+	st.Marshal(&buf)
+	restored := NewState(InventoryMachine{})
+	if err := restored.Unmarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if restored.SessionClock != st.SessionClock || restored.Sessions[8].LastUsed != st.Sessions[8].LastUsed {
+		t.Fatal("session clock lost by the snapshot")
+	}
+}
+
+func TestCommandAckMarshal(t *testing.T) {
+	for _, cmd := range []Command{
+		{3, 42, PUT, IntKey(1), IntValue(2), NIL, nil, 2},
+		{3, NO_CLIENT, PUT, IntKey(1), IntValue(2), NIL, nil, 0}} {
+		var buf bytes.Buffer
+		cmd.Marshal(&buf)
+		var read Command
+		if err := read.Unmarshal(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if read.ClientId != cmd.ClientId || read.Acked != cmd.Acked || read.K != cmd.K || buf.Len() != 0 {
+			t.Fatalf("command read back as %v", read)
+		}
+	}
+}
+
+func TestNewClientId(t *testing.T) {
+	seen := make(map[int32]bool)
+	for i := 0; i < 1000; i++ {
+		id := NewClientId()
+		if id == NO_CLIENT || id < 0 || seen[id] {
+			t.Fatalf("bad or repeated client id %d", id)
+		}
+		seen[id] = true
+	}
+}
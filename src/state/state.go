@@ -1,7 +1,8 @@
 package state
 
 import (
-	"log"
+	"encoding/binary"
+	"sort"
 )
 
 type Operation uint8
@@ -16,65 +17,146 @@ const (
 	LIKE
 	CREATE
 	POST
+	TRANSFER       // moves V from K to Keys[0], atomically
+	CAS            // sets K to V if it holds Cond
+	PUT_IF_ABSENT  // sets K to V if it holds nothing
+	PUT_IF_VERSION // sets K to V if it is at version Cond
+	GET_VERSION
+	SCAN        // reads the keys in [K, Keys[0]), at most V.Int() of them if it is positive
+	RECONFIGURE // changes the membership of the cluster (see genericsmrproto.Config), never executed by the state machine
+	CHECKPOINT  // a barrier after which EPaxos replicas checkpoint their state, never executed by the state machine
 )
 
-type Application uint8
-const (
-	DEFAULT Application = iota
-	INVENTORY
-	FACEBOOK
-)
+// Keys and values are arbitrary byte strings, of at most MAX_SIZE bytes.
+// They are strings rather than byte slices so that they can index maps, and
+// be compared with == and < (keys are ordered byte-wise, e.g. for a SCAN).
+// Numbers, such as the counters of the inventory or the versions returned by
+// the conditional updates, are encoded in 8 bytes by IntKey and IntValue.
+type Key string
+type Value string
 
-type Key int64
-type Value int64
+const MAX_SIZE = 1 << 20
 
-const NIL Value = 0
-const OK Value = 1
+const NIL Value = ""
+const OK Value = "\x80\x00\x00\x00\x00\x00\x00\x01" // IntValue(1)
 
-type State struct {
-	Store map[Key]Value
-	FBStore map[Key]map[Value]bool
+// Encodes a number big-endian with the sign bit flipped, so that keys made
+// from numbers are ordered as the numbers are.
+func IntKey(i int64) Key {
+	return Key(encodeInt(i))
 }
 
-var EXECUTE_FUNC ExecuteFunction
-var OP_CONFLICT_FUNC OperationConflict
+func IntValue(i int64) Value {
+	return Value(encodeInt(i))
+}
 
-func InitState(app Application) *State {
-	switch app {
-		case DEFAULT:
-			log.Printf("Using Default Application")
-			OP_CONFLICT_FUNC = DefaultOperationConflict
-			EXECUTE_FUNC = DefaultExecute
-			break
-		case INVENTORY:
-			log.Printf("Using Inventory Application")
-			OP_CONFLICT_FUNC = InventoryOperationConflict
-			EXECUTE_FUNC = InventoryExecute
-			break
-		case FACEBOOK:
-			log.Printf("Using Facebook Application")
-			OP_CONFLICT_FUNC = FacebookOperationConflict
-			EXECUTE_FUNC = FacebookExecute
-			break
+// the number encoded by IntKey, 0 for a key that is not 8 bytes long
+func (k Key) Int() int64 {
+	return decodeInt(string(k))
+}
+
+// the number encoded by IntValue, 0 for a value that is not 8 bytes long (so
+// NIL reads as 0)
+func (v Value) Int() int64 {
+	return decodeInt(string(v))
+}
+
+func encodeInt(i int64) string {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(i)^(1<<63))
+	return string(b[:])
+}
+
+func decodeInt(s string) int64 {
+	if len(s) != 8 {
+		return 0
 	}
+	return int64(binary.BigEndian.Uint64([]byte(s)) ^ (1 << 63))
+}
+
+type State struct {
+	Machine      StateMachine
+	Store        map[Key]Value
+	FBStore      map[Key]map[Value]bool
+	Sessions     map[int32]*Session
+	SessionClock int64         // number of commands executed in sessions, by which idle sessions expire
+	Versions     map[Key]int64 // bumped by every update in the DEFAULT application, 0 for keys never set
+	keys         []Key         // the keys of Store in order, rebuilt for a SCAN when keys have been added
+}
+
+type KeyValue struct {
+	K Key
+	V Value
+}
 
-	return &State{make(map[Key]Value), make(map[Key]map[Value]bool)}
+// an empty state for the commands of machine
+func NewState(machine StateMachine) *State {
+	return &State{machine, make(map[Key]Value), make(map[Key]map[Value]bool), make(map[int32]*Session), 0, make(map[Key]int64), nil}
 }
 
-type ConflictFunction func(gamma *Command, delta *Command) bool
-type ExecuteFunction func(gamma *Command, st *State) Value
-type OperationConflict func(op1 Operation, op2 Operation) bool
 type Command struct {
 	CommandId int32
-	Op Operation
-	K  Key
-	V  Value
+	ClientId  int32 // NO_CLIENT if the client does not need exactly-once semantics
+	Op        Operation
+	K         Key
+	V         Value
+	Cond      Value // the expected value of a CAS, or the expected version of a PUT_IF_VERSION
+	Keys      []Key // the other keys of a multi-key command (at most MAX_KEYS-1)
+	Acked     int32 // the client has the results of all its commands with a lower CommandId (see session.go)
+}
+
+const MAX_KEYS = 256
+
+// the number of keys the command touches
+func (c *Command) KeyCount() int {
+	return 1 + len(c.Keys)
+}
+
+// the i-th key the command touches, starting with K
+func (c *Command) KeyAt(i int) Key {
+	if i == 0 {
+		return c.K
+	}
+	return c.Keys[i-1]
+}
+
+// The range read by a SCAN. Since a SCAN also carries its end in Keys, the
+// conflict detection for it must not go through KeyCount and KeyAt.
+func (c *Command) ScanRange() (Key, Key) {
+	if len(c.Keys) == 0 {
+		return c.K, c.K
+	}
+	return c.K, c.Keys[0]
+}
+
+// tells whether one of the keys the command touches is in [lo, hi)
+func (c *Command) TouchesRange(lo Key, hi Key) bool {
+	if c.Op == SCAN {
+		clo, chi := c.ScanRange()
+		return clo < hi && lo < chi
+	}
+	for i := 0; i < c.KeyCount(); i++ {
+		if k := c.KeyAt(i); k >= lo && k < hi {
+			return true
+		}
+	}
+	return false
+}
+
+// tells whether the operation only updates the key under some condition
+func IsConditional(op Operation) bool {
+	return op == CAS || op == PUT_IF_ABSENT || op == PUT_IF_VERSION
+}
+
+// tells whether the operation leaves the state alone
+func IsRead(op Operation) bool {
+	return op == GET || op == READ || op == FAST_READ || op == GET_VERSION || op == SCAN
 }
 
-func ConflictBatch(batch1 []Command, batch2 []Command) bool {
+func (st *State) ConflictBatch(batch1 []Command, batch2 []Command) bool {
 	for i := 0; i < len(batch1); i++ {
 		for j := 0; j < len(batch2); j++ {
-			if Conflict(&batch1[i], &batch2[j]) {
+			if st.Conflict(&batch1[i], &batch2[j]) {
 				return true
 			}
 		}
@@ -82,26 +164,92 @@ func ConflictBatch(batch1 []Command, batch2 []Command) bool {
 	return false
 }
 
+// Executes the command, and also returns the key-value pairs read by a SCAN
+// (whose value is the number of pairs).
+func (c *Command) ExecuteMulti(st *State) (Value, []KeyValue) {
+	if c.Op != SCAN {
+		return c.Execute(st), nil
+	}
+	lo, hi := c.ScanRange()
+	kvs := st.Scan(lo, hi, int(c.V.Int()))
+	return IntValue(int64(len(kvs))), kvs
+}
+
+// Returns the pairs in [lo, hi) in key order, at most limit of them if limit
+// is positive. Keys are never removed from the Store, so the index of its
+// keys is out of date exactly when the sizes differ.
+func (st *State) Scan(lo Key, hi Key, limit int) []KeyValue {
+	if len(st.keys) != len(st.Store) {
+		st.keys = make([]Key, 0, len(st.Store))
+		for k := range st.Store {
+			st.keys = append(st.keys, k)
+		}
+		sort.Sort(keyArray(st.keys))
+	}
+	kvs := make([]KeyValue, 0)
+	for i := sort.Search(len(st.keys), func(i int) bool { return st.keys[i] >= lo }); i < len(st.keys) && st.keys[i] < hi; i++ {
+		if limit > 0 && len(kvs) >= limit {
+			break
+		}
+		kvs = append(kvs, KeyValue{st.keys[i], st.Store[st.keys[i]]})
+	}
+	return kvs
+}
+
+type keyArray []Key
+
+func (ka keyArray) Len() int           { return len(ka) }
+func (ka keyArray) Less(i, j int) bool { return ka[i] < ka[j] }
+func (ka keyArray) Swap(i, j int)      { ka[i], ka[j] = ka[j], ka[i] }
+
 func (c *Command) Execute(st *State) Value {
-	return EXECUTE_FUNC(c, st)
+	if c.ClientId == NO_CLIENT {
+		return st.Machine.Execute(c, st)
+	}
+	return st.executeInSession(c)
+}
+
+// Tells whether commands with these operations may conflict on a common key,
+// whatever their values.
+func (st *State) OperationConflict(op1 Operation, op2 Operation) bool {
+	return st.Machine.Conflicts().OperationConflict(op1, op2)
 }
 
-func Conflict(gamma *Command, delta *Command) bool {
+// tells whether the commands must be ordered, under the conflict relation of
+// the state's machine
+func (st *State) Conflict(gamma *Command, delta *Command) bool {
+	if gamma.Op == SCAN {
+		lo, hi := gamma.ScanRange()
+		return delta.TouchesRange(lo, hi) && st.Machine.Conflicts().CommandConflict(gamma, delta)
+	}
+	if delta.Op == SCAN {
+		lo, hi := delta.ScanRange()
+		return gamma.TouchesRange(lo, hi) && st.Machine.Conflicts().CommandConflict(gamma, delta)
+	}
 	if gamma.K == delta.K {
-		return OP_CONFLICT_FUNC(gamma.Op, delta.Op)
+		return st.Machine.Conflicts().CommandConflict(gamma, delta)
+	}
+	if len(gamma.Keys) == 0 && len(delta.Keys) == 0 {
+		return false
 	}
 
+	for i := 0; i < gamma.KeyCount(); i++ {
+		for j := 0; j < delta.KeyCount(); j++ {
+			if gamma.KeyAt(i) == delta.KeyAt(j) {
+				return st.Machine.Conflicts().CommandConflict(gamma, delta)
+			}
+		}
+	}
 	return false
 }
 
-func DefaultOperationConflict(op1 Operation, op2 Operation) bool {
-	return true
-}
-
+// The conditional updates return the new version of the key if they succeed,
+// and NIL if they fail (so, like the result of a GET, the client only gets it
+// when replies are sent after execution).
 func DefaultExecute(gamma *Command, st *State) Value {
 	switch gamma.Op {
 	case PUT:
-		st.Store[gamma.K] = gamma.V
+		st.put(gamma.K, gamma.V)
 		return gamma.V
 
 	case GET:
@@ -109,6 +257,24 @@ func DefaultExecute(gamma *Command, st *State) Value {
 			return val
 		}
 
+	case CAS:
+		if st.Store[gamma.K] == gamma.Cond {
+			return st.put(gamma.K, gamma.V)
+		}
+
+	case PUT_IF_ABSENT:
+		if _, present := st.Store[gamma.K]; !present {
+			return st.put(gamma.K, gamma.V)
+		}
+
+	case PUT_IF_VERSION:
+		if st.Versions[gamma.K] == gamma.Cond.Int() {
+			return st.put(gamma.K, gamma.V)
+		}
+
+	case GET_VERSION:
+		return IntValue(st.Versions[gamma.K])
+
 	default:
 		break
 	}
@@ -116,33 +282,36 @@ func DefaultExecute(gamma *Command, st *State) Value {
 	return NIL
 }
 
-// Conflicts only when a read and an increment are occuring on the same
-// key
-func InventoryOperationConflict(op1 Operation, op2 Operation) bool {
-	return (op1 == READ && op2 == INCREMENT) || (op1 == INCREMENT && op2 == READ) ||
-				 (op1 == READ && op2 == CREATE) || (op1 == CREATE && op2 == READ) ||
-				 (op1 == INCREMENT && op2 == CREATE) || (op1 == CREATE && op2 == INCREMENT) ||
-				 (op1 == CREATE && op2 == CREATE)
+// sets a key and returns its new version
+func (st *State) put(k Key, v Value) Value {
+	st.Store[k] = v
+	st.Versions[k]++
+	return IntValue(st.Versions[k])
 }
 
 func InventoryExecute(gamma *Command, st *State) Value {
 	switch gamma.Op {
 	case CREATE:
 		if _, present := st.Store[gamma.K]; !present {
-			st.Store[gamma.K] = 0
+			st.Store[gamma.K] = IntValue(0)
 			return OK
 		}
 
 		break
 	case INCREMENT:
-		if val, present := st.Store[gamma.K]; present {
-			st.Store[gamma.K] = gamma.V + val
-		} else {
-			st.Store[gamma.K] = gamma.V
-		}
+		st.Store[gamma.K] = IntValue(st.Store[gamma.K].Int() + gamma.V.Int())
 
 		return st.Store[gamma.K]
 
+	case TRANSFER:
+		amount := gamma.V.Int()
+		if len(gamma.Keys) != 1 || st.Store[gamma.K].Int() < amount {
+			break
+		}
+		st.Store[gamma.K] = IntValue(st.Store[gamma.K].Int() - amount)
+		st.Store[gamma.Keys[0]] = IntValue(st.Store[gamma.Keys[0]].Int() + amount)
+		return OK
+
 	case READ:
 		if val, present := st.Store[gamma.K]; present {
 			return val
@@ -164,13 +333,6 @@ func InventoryExecute(gamma *Command, st *State) Value {
 	return NIL
 }
 
-func FacebookOperationConflict(op1 Operation, op2 Operation) bool {
-	return (op1 == READ && op2 == LIKE) || (op1 == LIKE && op2 == READ) ||
-				 (op1 == READ && op2 == POST) || (op1 == POST && op2 == READ) ||
-				 (op1 == LIKE && op2 == POST) || (op1 == POST && op2 == LIKE) ||
-				 (op1 == POST && op2 == POST)
-}
-
 func FacebookExecute(gamma *Command, st *State) Value {
 	switch gamma.Op {
 		case POST:
@@ -193,13 +355,13 @@ func FacebookExecute(gamma *Command, st *State) Value {
 			return OK;
 		case FAST_READ:
 			if innermap, present := st.FBStore[gamma.K]; present {
-				return Value(len(innermap))
+				return IntValue(int64(len(innermap)))
 			}
 
 			break
 		case READ:
 			if innermap, present := st.FBStore[gamma.K]; present {
-				return Value(len(innermap))
+				return IntValue(int64(len(innermap)))
 			}
 
 			break
@@ -0,0 +1,69 @@
+package state
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestConditionalPut(t *testing.T) {
+	st := NewState(DefaultMachine{})
+	run := func(op Operation, v, cond int64) int64 {
+		cmd := Command{0, NO_CLIENT, op, IntKey(1), IntValue(v), IntValue(cond), nil, 0}
+		if op == PUT_IF_ABSENT || op == GET || op == GET_VERSION {
+			cmd.Cond = NIL
+		}
+		val := cmd.Execute(st)
+		if val == NIL {
+			return -1
+		}
+		return val.Int()
+	}
+
+	if run(PUT_IF_ABSENT, 10, 0) != 1 || run(PUT_IF_ABSENT, 11, 0) != -1 {
+		t.Fatal("PUT_IF_ABSENT")
+	}
+	if run(CAS, 12, 11) != -1 || run(CAS, 12, 10) != 2 {
+		t.Fatal("CAS")
+	}
+	if run(PUT_IF_VERSION, 13, 1) != -1 || run(PUT_IF_VERSION, 13, 2) != 3 {
+		t.Fatal("PUT_IF_VERSION")
+	}
+
+	var buf bytes.Buffer
+	st.Marshal(&buf)
+	st = NewState(DefaultMachine{})
+	if err := st.Unmarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if run(GET, 0, 0) != 13 || run(GET_VERSION, 0, 0) != 3 {
+		t.Fatal("versions lost by the snapshot")
+	}
+	if st.OperationConflict(GET, GET_VERSION) || !st.OperationConflict(GET, CAS) {
+		t.Fatal("wrong conflicts for conditional updates")
+	}
+}
+
+func TestScan(t *testing.T) {
+	st := NewState(DefaultMachine{})
+	for _, k := range []int64{7, 3, 12, 5} {
+		put := Command{0, NO_CLIENT, PUT, IntKey(k), IntValue(k * 10), NIL, nil, 0}
+		put.Execute(st)
+	}
+	scan := Command{1, NO_CLIENT, SCAN, IntKey(4), NIL, NIL, []Key{IntKey(12)}, 0}
+	val, kvs := scan.ExecuteMulti(st)
+	if val.Int() != 2 || len(kvs) != 2 || kvs[0] != (KeyValue{IntKey(5), IntValue(50)}) ||
+		kvs[1] != (KeyValue{IntKey(7), IntValue(70)}) {
+		t.Fatalf("wrong scan of [4, 12): %d %v", val.Int(), kvs)
+	}
+	put := Command{0, NO_CLIENT, PUT, IntKey(6), IntValue(60), NIL, nil, 0}
+	put.Execute(st)
+	scan.V = IntValue(1)
+	if _, kvs = scan.ExecuteMulti(st); len(kvs) != 1 || kvs[0].K != IntKey(5) {
+		t.Fatalf("scan limit not applied: %v", kvs)
+	}
+
+	if !st.Conflict(&scan, &put) || st.Conflict(&scan, &Command{0, NO_CLIENT, PUT, IntKey(12), NIL, NIL, nil, 0}) ||
+		st.Conflict(&scan, &Command{0, NO_CLIENT, GET, IntKey(6), NIL, NIL, nil, 0}) {
+		t.Fatal("wrong conflicts for a scan")
+	}
+}
@@ -2,6 +2,7 @@ package state
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 )
 
@@ -11,14 +12,27 @@ func (t *Command) Marshal(w io.Writer) {
 	bs = b[:4]
 	binary.LittleEndian.PutUint32(bs, uint32(t.CommandId))
 	w.Write(bs)
+	binary.LittleEndian.PutUint32(bs, uint32(t.ClientId))
+	w.Write(bs)
+	if t.ClientId != NO_CLIENT {
+		binary.LittleEndian.PutUint32(bs, uint32(t.Acked))
+		w.Write(bs)
+	}
 	bs = b[:1]
 	b[0] = byte(t.Op)
 	w.Write(bs)
-	bs = b[:8]
-	binary.LittleEndian.PutUint64(bs, uint64(t.K))
-	w.Write(bs)
-	binary.LittleEndian.PutUint64(bs, uint64(t.V))
+	t.K.Marshal(w)
+	t.V.Marshal(w)
+	if IsConditional(t.Op) {
+		t.Cond.Marshal(w)
+	}
+	// a varint, so that a command with too many keys is refused by the
+	// receiver instead of garbling the stream
+	bs = b[:binary.PutUvarint(b[:], uint64(len(t.Keys)))]
 	w.Write(bs)
+	for i := range t.Keys {
+		t.Keys[i].Marshal(w)
+	}
 }
 
 func (t *Command) Unmarshal(r io.Reader) error {
@@ -29,53 +43,314 @@ func (t *Command) Unmarshal(r io.Reader) error {
 		return err
 	}
 	t.CommandId = int32(binary.LittleEndian.Uint32(bs))
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return err
+	}
+	t.ClientId = int32(binary.LittleEndian.Uint32(bs))
+	t.Acked = 0
+	if t.ClientId != NO_CLIENT {
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return err
+		}
+		t.Acked = int32(binary.LittleEndian.Uint32(bs))
+	}
 	bs = b[:1]
 	if _, err := io.ReadFull(r, bs); err != nil {
 		return err
 	}
 	t.Op = Operation(b[0])
-	bs = b[:8]
-	if _, err := io.ReadFull(r, bs); err != nil {
+	if err := t.K.Unmarshal(r); err != nil {
 		return err
 	}
-	t.K = Key(binary.LittleEndian.Uint64(bs))
-	if _, err := io.ReadFull(r, bs); err != nil {
+	if err := t.V.Unmarshal(r); err != nil {
 		return err
 	}
-	t.V = Value(binary.LittleEndian.Uint64(bs))
+	t.Cond = NIL
+	if IsConditional(t.Op) {
+		if err := t.Cond.Unmarshal(r); err != nil {
+			return err
+		}
+	}
+	n, err := readUvarint(r)
+	if err != nil {
+		return err
+	}
+	if n >= MAX_KEYS {
+		return fmt.Errorf("command with %d keys, more than %d", n+1, MAX_KEYS)
+	}
+	t.Keys = nil
+	if n > 0 {
+		t.Keys = make([]Key, n)
+		for i := range t.Keys {
+			if err := t.Keys[i].Unmarshal(r); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
+func (t *KeyValue) Marshal(w io.Writer) {
+	t.K.Marshal(w)
+	t.V.Marshal(w)
+}
+
+func (t *KeyValue) Unmarshal(r io.Reader) error {
+	if err := t.K.Unmarshal(r); err != nil {
+		return err
+	}
+	return t.V.Unmarshal(r)
+}
+
+// Keys and values are sent as a 4-byte length followed by the bytes.
 func (t *Key) Marshal(w io.Writer) {
-	var b [8]byte
-	bs := b[:8]
-	binary.LittleEndian.PutUint64(bs, uint64(*t))
-	w.Write(bs)
+	marshalBytes(w, string(*t))
 }
 
 func (t *Value) Marshal(w io.Writer) {
+	marshalBytes(w, string(*t))
+}
+
+func (t *Key) Unmarshal(r io.Reader) error {
+	s, err := unmarshalBytes(r)
+	*t = Key(s)
+	return err
+}
+
+func (t *Value) Unmarshal(r io.Reader) error {
+	s, err := unmarshalBytes(r)
+	*t = Value(s)
+	return err
+}
+
+func readUvarint(r io.Reader) (uint64, error) {
+	if br, ok := r.(io.ByteReader); ok {
+		return binary.ReadUvarint(br)
+	}
+	return binary.ReadUvarint(byteReader{r})
+}
+
+type byteReader struct {
+	r io.Reader
+}
+
+func (br byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(br.r, b[:])
+	return b[0], err
+}
+
+func marshalBytes(w io.Writer, s string) {
+	var b [4]byte
+	bs := b[:4]
+	binary.LittleEndian.PutUint32(bs, uint32(len(s)))
+	w.Write(bs)
+	io.WriteString(w, s)
+}
+
+func unmarshalBytes(r io.Reader) (string, error) {
+	var b [4]byte
+	bs := b[:4]
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return "", err
+	}
+	n := binary.LittleEndian.Uint32(bs)
+	if n == 0 {
+		return "", nil
+	}
+	if n > MAX_SIZE {
+		return "", fmt.Errorf("key or value of %d bytes, larger than %d", n, MAX_SIZE)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// Serializes the whole application state, e.g. for a checkpoint: the client
+// sessions and their clock, followed by whatever the state machine writes in
+// its snapshot.
+func (st *State) Marshal(w io.Writer) {
 	var b [8]byte
 	bs := b[:8]
-	binary.LittleEndian.PutUint64(bs, uint64(*t))
+	binary.LittleEndian.PutUint64(bs, uint64(st.SessionClock))
 	w.Write(bs)
+	binary.LittleEndian.PutUint64(bs, uint64(len(st.Sessions)))
+	w.Write(bs)
+	for client, session := range st.Sessions {
+		bs = b[:4]
+		binary.LittleEndian.PutUint32(bs, uint32(client))
+		w.Write(bs)
+		binary.LittleEndian.PutUint32(bs, uint32(session.Highest))
+		w.Write(bs)
+		binary.LittleEndian.PutUint32(bs, uint32(session.Acked))
+		w.Write(bs)
+		bs = b[:8]
+		binary.LittleEndian.PutUint64(bs, uint64(session.LastUsed))
+		w.Write(bs)
+		binary.LittleEndian.PutUint64(bs, uint64(len(session.Results)))
+		w.Write(bs)
+		for id, v := range session.Results {
+			bs = b[:4]
+			binary.LittleEndian.PutUint32(bs, uint32(id))
+			w.Write(bs)
+			v.Marshal(w)
+		}
+	}
+	st.Machine.Snapshot(st, w)
 }
 
-func (t *Key) Unmarshal(r io.Reader) error {
+// Replaces the application state with one serialized by Marshal.
+func (st *State) Unmarshal(r io.Reader) error {
 	var b [8]byte
 	bs := b[:8]
 	if _, err := io.ReadFull(r, bs); err != nil {
 		return err
 	}
-	*t = Key(binary.LittleEndian.Uint64(bs))
+	clock := int64(binary.LittleEndian.Uint64(bs))
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return err
+	}
+	n := binary.LittleEndian.Uint64(bs)
+	sessions := make(map[int32]*Session, n)
+	for i := uint64(0); i < n; i++ {
+		bs = b[:4]
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return err
+		}
+		client := int32(binary.LittleEndian.Uint32(bs))
+		session := NewSession()
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return err
+		}
+		session.Highest = int32(binary.LittleEndian.Uint32(bs))
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return err
+		}
+		session.Acked = int32(binary.LittleEndian.Uint32(bs))
+		bs = b[:8]
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return err
+		}
+		session.LastUsed = int64(binary.LittleEndian.Uint64(bs))
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return err
+		}
+		m := binary.LittleEndian.Uint64(bs)
+		for j := uint64(0); j < m; j++ {
+			bs = b[:4]
+			if _, err := io.ReadFull(r, bs); err != nil {
+				return err
+			}
+			id := int32(binary.LittleEndian.Uint32(bs))
+			var v Value
+			if err := v.Unmarshal(r); err != nil {
+				return err
+			}
+			session.Results[id] = v
+		}
+		sessions[client] = session
+	}
+	if err := st.Machine.Restore(st, r); err != nil {
+		return err
+	}
+	st.Sessions = sessions
+	st.SessionClock = clock
 	return nil
 }
 
-func (t *Value) Unmarshal(r io.Reader) error {
+// The snapshot of the built-in state machines, which keep their data in the
+// maps of the State.
+func marshalMaps(st *State, w io.Writer) {
+	var b [8]byte
+	bs := b[:8]
+	binary.LittleEndian.PutUint64(bs, uint64(len(st.Store)))
+	w.Write(bs)
+	for k, v := range st.Store {
+		k.Marshal(w)
+		v.Marshal(w)
+	}
+	binary.LittleEndian.PutUint64(bs, uint64(len(st.FBStore)))
+	w.Write(bs)
+	for k, vals := range st.FBStore {
+		k.Marshal(w)
+		binary.LittleEndian.PutUint64(bs, uint64(len(vals)))
+		w.Write(bs)
+		for v := range vals {
+			v.Marshal(w)
+		}
+	}
+	binary.LittleEndian.PutUint64(bs, uint64(len(st.Versions)))
+	w.Write(bs)
+	for k, ver := range st.Versions {
+		k.Marshal(w)
+		binary.LittleEndian.PutUint64(bs, uint64(ver))
+		w.Write(bs)
+	}
+}
+
+func unmarshalMaps(st *State, r io.Reader) error {
 	var b [8]byte
 	bs := b[:8]
 	if _, err := io.ReadFull(r, bs); err != nil {
 		return err
 	}
-	*t = Value(binary.LittleEndian.Uint64(bs))
+	n := binary.LittleEndian.Uint64(bs)
+	store := make(map[Key]Value, n)
+	for i := uint64(0); i < n; i++ {
+		var k Key
+		var v Value
+		if err := k.Unmarshal(r); err != nil {
+			return err
+		}
+		if err := v.Unmarshal(r); err != nil {
+			return err
+		}
+		store[k] = v
+	}
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return err
+	}
+	n = binary.LittleEndian.Uint64(bs)
+	fbStore := make(map[Key]map[Value]bool, n)
+	for i := uint64(0); i < n; i++ {
+		var k Key
+		if err := k.Unmarshal(r); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return err
+		}
+		m := binary.LittleEndian.Uint64(bs)
+		vals := make(map[Value]bool, m)
+		for j := uint64(0); j < m; j++ {
+			var v Value
+			if err := v.Unmarshal(r); err != nil {
+				return err
+			}
+			vals[v] = true
+		}
+		fbStore[k] = vals
+	}
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return err
+	}
+	n = binary.LittleEndian.Uint64(bs)
+	versions := make(map[Key]int64, n)
+	for i := uint64(0); i < n; i++ {
+		var k Key
+		if err := k.Unmarshal(r); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return err
+		}
+		versions[k] = int64(binary.LittleEndian.Uint64(bs))
+	}
+	st.Store = store
+	st.FBStore = fbStore
+	st.Versions = versions
+	st.keys = nil
 	return nil
 }
@@ -0,0 +1,55 @@
+package state
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCommandKeysMarshal(t *testing.T) {
+	keys := make([]Key, MAX_KEYS)
+	for i := range keys {
+		keys[i] = IntKey(int64(i))
+	}
+
+	// more than 255 other keys used to wrap around in a single byte
+	cmd := Command{1, NO_CLIENT, TRANSFER, IntKey(-1), IntValue(1), NIL, keys[:MAX_KEYS-1], 0}
+	var buf bytes.Buffer
+	cmd.Marshal(&buf)
+	seven := IntKey(7)
+	seven.Marshal(&buf)
+	var read Command
+	if err := read.Unmarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var next Key
+	if err := next.Unmarshal(&buf); err != nil || len(read.Keys) != MAX_KEYS-1 || read.Keys[MAX_KEYS-2] != keys[MAX_KEYS-2] || next != IntKey(7) {
+		t.Fatalf("command with %d keys read back with %d", len(cmd.Keys), len(read.Keys))
+	}
+
+	cmd.Keys = keys
+	buf.Reset()
+	cmd.Marshal(&buf)
+	if err := read.Unmarshal(&buf); err == nil {
+		t.Fatalf("command with %d keys accepted", cmd.KeyCount())
+	}
+}
+
+func TestByteStringKeys(t *testing.T) {
+	cmd := Command{1, NO_CLIENT, CAS, Key("user:\x00alice"), Value(`{"likes": 3}`), Value(""), nil, 0}
+	var buf bytes.Buffer
+	cmd.Marshal(&buf)
+	var back Command
+	if err := back.Unmarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if back.K != cmd.K || back.V != cmd.V || back.Cond != cmd.Cond {
+		t.Fatalf("byte strings lost on the wire: %q", back)
+	}
+
+	if !(IntKey(-1) < IntKey(0) && IntKey(0) < IntKey(255) && IntKey(255) < IntKey(256)) {
+		t.Fatal("keys made from numbers are out of order")
+	}
+	if IntValue(-42).Int() != -42 || NIL.Int() != 0 {
+		t.Fatal("wrong numeric values")
+	}
+}